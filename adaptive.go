@@ -0,0 +1,122 @@
+package ehc
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// AdaptiveConfig bounds the automatic tuning enabled by
+// WithAdaptiveResolution.
+type AdaptiveConfig struct {
+	// MinResolution and MaxResolution bound the expiry resolution that may
+	// be chosen. The resolution starts at MinResolution, which may be zero
+	// for exact per-increment expiry while load is light.
+	MinResolution time.Duration
+	MaxResolution time.Duration
+
+	// TargetRate is the number of expiry timers plus new keys per second
+	// that the EHC tries to stay under. Above it the resolution is doubled;
+	// below a quarter of it the resolution is halved.
+	TargetRate float64
+
+	// Interval is how often the rate is evaluated. It defaults to one second.
+	Interval time.Duration
+
+	// Logf receives a line describing each adjustment. It defaults to
+	// log.Printf.
+	Logf func(format string, args ...interface{})
+}
+
+// adaptive tracks the increment rate and key churn of a timer-mode EHC and
+// adjusts its expiry resolution to match.
+type adaptive struct {
+	// these are kept first so their 64-bit atomics stay aligned
+	resolution int64
+	timers     int64
+	newKeys    int64
+	nextEval   int64
+
+	cfg AdaptiveConfig
+}
+
+func newAdaptive(cfg AdaptiveConfig, now time.Time) *adaptive {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Logf == nil {
+		cfg.Logf = log.Printf
+	}
+	return &adaptive{
+		resolution: int64(cfg.MinResolution),
+		nextEval:   now.Add(cfg.Interval).UnixNano(),
+		cfg:        cfg,
+	}
+}
+
+// currentResolution returns the resolution to use for new expirations.
+func (a *adaptive) currentResolution() time.Duration {
+	return time.Duration(atomic.LoadInt64(&a.resolution))
+}
+
+// observe records a new timer or key, re-evaluating the resolution if the
+// interval has elapsed.
+func (a *adaptive) observe(counter *int64, now time.Time) {
+	atomic.AddInt64(counter, 1)
+
+	next := atomic.LoadInt64(&a.nextEval)
+	if now.UnixNano() < next {
+		return
+	}
+	// only one caller gets to run the evaluation
+	if !atomic.CompareAndSwapInt64(&a.nextEval, next, now.Add(a.cfg.Interval).UnixNano()) {
+		return
+	}
+	a.evaluate(now.Sub(time.Unix(0, next).Add(-a.cfg.Interval)))
+}
+
+// evaluate adjusts the resolution based on the activity seen over elapsed.
+func (a *adaptive) evaluate(elapsed time.Duration) {
+	timers := float64(atomic.SwapInt64(&a.timers, 0)) / elapsed.Seconds()
+	newKeys := float64(atomic.SwapInt64(&a.newKeys, 0)) / elapsed.Seconds()
+	rate := timers + newKeys
+
+	old := a.currentResolution()
+	res := old
+	switch {
+	case rate > a.cfg.TargetRate:
+		res *= 2
+		if res == 0 {
+			res = a.cfg.MaxResolution / 64
+			if res == 0 {
+				res = a.cfg.MaxResolution
+			}
+		}
+		if res > a.cfg.MaxResolution {
+			res = a.cfg.MaxResolution
+		}
+	case rate < a.cfg.TargetRate/4:
+		res /= 2
+		if res < a.cfg.MinResolution {
+			res = a.cfg.MinResolution
+		}
+	}
+	if res == old {
+		return
+	}
+
+	atomic.StoreInt64(&a.resolution, int64(res))
+	a.cfg.Logf("ehc: expiry resolution %v -> %v (%.0f timers/s, %.0f new keys/s)", old, res, timers, newKeys)
+}
+
+// WithAdaptiveResolution lets a timer-mode EHC tune its own expiry resolution
+// (see WithResolution) from the observed increment rate and key churn, within
+// the bounds of cfg. Under overload, expirations are batched more coarsely so
+// that accuracy degrades gracefully instead of the process.
+//
+// It takes precedence over WithResolution.
+func WithAdaptiveResolution(cfg AdaptiveConfig) Option {
+	return func(c *config) {
+		c.adaptive = &cfg
+	}
+}
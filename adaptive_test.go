@@ -0,0 +1,67 @@
+package ehc
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEHC_AdaptiveResolution(t *testing.T) {
+	var mu sync.Mutex
+	var logged []string
+	e := NewEHC(50*time.Millisecond, WithAdaptiveResolution(AdaptiveConfig{
+		MaxResolution: 8 * time.Millisecond,
+		TargetRate:    1000,
+		Interval:      5 * time.Millisecond,
+		Logf: func(format string, args ...interface{}) {
+			mu.Lock()
+			logged = append(logged, fmt.Sprintf(format, args...))
+			mu.Unlock()
+		},
+	}))
+
+	// a burst well over the target rate coarsens the resolution
+	for i := 0; i < 200; i++ {
+		e.Count(i % 5)
+	}
+	time.Sleep(6 * time.Millisecond)
+	e.Count("trigger")
+
+	res := e.currentResolution()
+	if res <= 0 || res > 8*time.Millisecond {
+		t.Fatalf("resolution after burst = %v, want within (0, 8ms]", res)
+	}
+
+	// going quiet refines it again
+	time.Sleep(60 * time.Millisecond)
+	e.Count("trigger")
+	if got := e.currentResolution(); got >= res {
+		t.Errorf("resolution after quiet period = %v, want below %v", got, res)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(logged) < 2 {
+		t.Errorf("logged %d adjustments, want at least 2: %q", len(logged), logged)
+	}
+}
+
+func TestEHC_AdaptiveResolution_ManualClock(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(1000, 0), WithAdaptiveResolution(AdaptiveConfig{
+		MaxResolution: time.Second,
+		TargetRate:    10,
+		Interval:      time.Second,
+		Logf:          func(string, ...interface{}) {},
+	}))
+
+	// the rate is measured against the EHC's clock, not the wall clock
+	for i := 0; i < 100; i++ {
+		e.Count(i)
+	}
+	e.Tick(time.Second)
+	e.Count("trigger")
+	if res := e.currentResolution(); res <= 0 {
+		t.Errorf("resolution after burst = %v, want coarsened", res)
+	}
+}
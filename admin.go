@@ -0,0 +1,124 @@
+package ehc
+
+// Expire drops all of key's counts at once, as if its window had passed,
+// e.g. to reset a customer's limit, and reports whether it had any. Its
+// pending expirations are cancelled, and in the default timer mode the key
+// is removed from the map, which OnExpire is told of, unless reservations
+// still hold it there; they can be committed or cancelled as usual.
+//
+// It doesn't lift a block (see Unblock), and counts already passed on to the
+// global total of WithGlobalLimit or to the overflow sketch of WithMaxMemory
+// stay there. With a custom ExpiryStrategy it does nothing.
+func (e *EHC) Expire(key interface{}) bool {
+	had := e.expire(key)
+	e.audit("", "Expire", key, "")
+	return had
+}
+
+func (e *EHC) expire(key interface{}) bool {
+	e.valueLock.RLock()
+	key, ok := e.normalizeKey(key)
+	if !ok || e.closed {
+		e.valueLock.RUnlock()
+		return false
+	}
+	if e.expiry != nil {
+		f, ok := e.expiry.(forgetter)
+		had := ok && e.expiry.Value(key, e.now()) != 0
+		if ok {
+			f.forget(key)
+		}
+		e.valueLock.RUnlock()
+		return had
+	}
+	c := e.lookup(key)
+	e.valueLock.RUnlock()
+	if c == nil {
+		return false
+	}
+
+	had := c.clear()
+	e.remove(c)
+	return had
+}
+
+// ExpireAll drops every count of every key at once, as Expire does for one,
+// leaving the EHC as good as new but for outstanding reservations and the
+// features kept apart from the counts, such as blocks.
+func (e *EHC) ExpireAll() {
+	e.expireAll()
+	e.audit("", "ExpireAll", nil, "")
+}
+
+func (e *EHC) expireAll() {
+	e.valueLock.RLock()
+	if e.closed {
+		e.valueLock.RUnlock()
+		return
+	}
+	if e.expiry != nil {
+		e.valueLock.RUnlock()
+
+		e.valueLock.Lock()
+		defer e.valueLock.Unlock()
+		if !e.closed {
+			e.expiry = e.config.newExpiry(e.window, e.now())
+		}
+		return
+	}
+	var counters []*counter
+	e.rangeCounters(func(_ interface{}, c *counter) bool {
+		counters = append(counters, c)
+		return true
+	})
+	e.valueLock.RUnlock()
+
+	for _, c := range counters {
+		c.clear()
+		e.remove(c)
+	}
+}
+
+// clear cancels all of c's pending retractions and takes back their counts,
+// reporting whether there were any.
+func (c *counter) clear() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total int64
+	for _, r := range c.pending {
+		r.done = true
+		c.parent.wheel.cancel(r)
+		total += r.count
+	}
+	had := len(c.pending) > 0
+	c.pending = nil
+	c.addCount(-total)
+	return had
+}
+
+// forgetter is implemented by the built-in strategies, which can drop a key.
+type forgetter interface {
+	// forget drops all of key's counts.
+	forget(key interface{})
+}
+
+func (b *buckets) forget(key interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.rings, key)
+}
+
+func (g *generations) forget(key interface{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i := range g.slots {
+		delete(g.slots[i].counts, key)
+	}
+}
+
+func (d *decay) forget(key interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.keys, key)
+}
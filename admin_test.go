@@ -0,0 +1,74 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_Expire(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	var expired []interface{}
+	e.OnExpire(func(key interface{}) {
+		expired = append(expired, key)
+	})
+	e.CountMultiple("a", 3)
+	e.Tick(time.Second)
+	e.Count("a")
+	e.Count("b")
+
+	if !e.Expire("a") {
+		t.Error("EHC.Expire(a) = false, want true")
+	}
+	if e.Expire("missing") {
+		t.Error("EHC.Expire(missing) = true, want false")
+	}
+	if _, ok := e.Get("a"); ok || e.value("b") != 1 {
+		t.Errorf("after EHC.Expire(a), a is still there or b = %d", e.value("b"))
+	}
+	if len(expired) != 1 || expired[0] != "a" {
+		t.Errorf("OnExpire was told of %v, want a", expired)
+	}
+
+	// the cancelled expirations don't take anything from new counts
+	e.Count("a")
+	e.Tick(59 * time.Second)
+	if v := e.value("a"); v != 1 {
+		t.Errorf("count of a = %d after its old expirations came due, want 1", v)
+	}
+
+	// a reservation keeps the key, which it can still be committed to
+	r, _ := e.Reserve("b", 1, 10)
+	e.Expire("b")
+	r.Commit()
+	if v := e.value("b"); v != 1 {
+		t.Errorf("count of b = %d after committing across Expire, want 1", v)
+	}
+}
+
+func TestEHC_ExpireAll(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"timer":       nil,
+		"buckets":     {WithBuckets(4)},
+		"generations": {WithGenerations(4)},
+		"decay":       {WithExponentialDecay(0)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := NewManualEHC(time.Minute, time.Unix(0, 0), opts...)
+			e.CountMultiple("a", 2)
+			e.Count("b")
+			if !e.Expire("a") || e.value("a") != 0 || e.value("b") != 1 {
+				t.Errorf("EHC.Expire(a) left a = %d and b = %d, want 0 and 1", e.value("a"), e.value("b"))
+			}
+
+			e.Count("c")
+			e.ExpireAll()
+			if s := e.Snapshot(); len(s) != 0 {
+				t.Errorf("EHC.Snapshot() = %v after ExpireAll, want empty", s)
+			}
+			e.Count("a")
+			if v := e.value("a"); v != 1 {
+				t.Errorf("count of a = %d counted after ExpireAll, want 1", v)
+			}
+		})
+	}
+}
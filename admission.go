@@ -0,0 +1,266 @@
+package ehc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Fairness shares a limit across all the keys of an AdmissionQueue.
+type Fairness struct {
+	// Key is counted once for every admission, on top of the admitted
+	// key, so that all keys draw on its limit.
+	Key interface{}
+	// Limit is the most admissions Key allows within the window.
+	Limit int64
+	// Weight, if set, returns the share of Key's limit a key gets
+	// relative to the others while they are all waiting. Weights that
+	// aren't positive count as 1, as does every key if Weight is nil.
+	Weight func(key interface{}) float64
+}
+
+// AdmissionQueue admits callers to keys of an EHC, counting each admission
+// once against a per-key limit like WaitAllow. Callers waiting on the same
+// key are queued and admitted one by one in the order they arrived, as
+// capacity expires, rather than all waking up to race for it.
+//
+// With Fairness, admissions also draw on a limit shared by every key, and
+// when that is what holds callers back, the keys waiting take turns at it in
+// proportion to their weights, so a busy key can't starve the others.
+type AdmissionQueue struct {
+	e     *EHC
+	limit int64
+	fair  *Fairness
+
+	mu     sync.Mutex
+	queues map[interface{}]*admissionKey
+	// vtime is the virtual time of the latest admission; each admission
+	// moves its key's virtual time on by the inverse of its weight, and
+	// the key with the earliest one goes next.
+	vtime float64
+	seq   int64
+	timer Timer
+	// wakeAt is when the timer is set to fire, or zero if it isn't.
+	wakeAt time.Time
+}
+
+// admissionKey is the callers waiting on one key.
+type admissionKey struct {
+	key     interface{}
+	weight  float64
+	vtime   float64
+	seq     int64
+	waiters []*admissionWaiter
+}
+
+// admissionWaiter is one caller of Wait.
+type admissionWaiter struct {
+	ready chan error
+	// done is set, under the queue's mutex, once the waiter has been
+	// admitted or failed.
+	done bool
+}
+
+// NewAdmissionQueue returns an AdmissionQueue admitting up to limit callers
+// per key within e's window, and sharing fair.Limit across keys if fair
+// isn't nil.
+func NewAdmissionQueue(e *EHC, limit int64, fair *Fairness) *AdmissionQueue {
+	return &AdmissionQueue{
+		e:      e,
+		limit:  limit,
+		fair:   fair,
+		queues: map[interface{}]*admissionKey{},
+	}
+}
+
+// Wait blocks until the caller is admitted to key, which counts it, or ctx
+// ends, in which case it returns the context's error and leaves the queue.
+// Like WaitAllow, it returns ErrClosed if the EHC is closed, and
+// ErrNeverAllowed if the key, or the shared key, can never be admitted.
+func (q *AdmissionQueue) Wait(ctx context.Context, key interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w := &admissionWaiter{ready: make(chan error, 1)}
+
+	q.mu.Lock()
+	k := q.queues[key]
+	if k == nil {
+		// a key that was idle starts at the current virtual time, so
+		// it can't claim turns it didn't wait for
+		q.seq++
+		k = &admissionKey{key: key, weight: q.weight(key), vtime: q.vtime, seq: q.seq}
+		q.queues[key] = k
+	}
+	k.waiters = append(k.waiters, w)
+	q.dispatchLocked()
+	q.mu.Unlock()
+
+	var err error
+	select {
+	case err = <-w.ready:
+		return err
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-q.e.done:
+		err = ErrClosed
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if w.done {
+		// admitted, or failed, just as it gave up
+		return <-w.ready
+	}
+	for i, other := range k.waiters {
+		if other == w {
+			k.waiters = append(k.waiters[:i], k.waiters[i+1:]...)
+			break
+		}
+	}
+	if len(k.waiters) == 0 {
+		delete(q.queues, key)
+	}
+	return err
+}
+
+// Queued returns the number of callers waiting on key.
+func (q *AdmissionQueue) Queued(key interface{}) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if k := q.queues[key]; k != nil {
+		return len(k.waiters)
+	}
+	return 0
+}
+
+func (q *AdmissionQueue) weight(key interface{}) float64 {
+	if q.fair == nil || q.fair.Weight == nil {
+		return 1
+	}
+	if w := q.fair.Weight(key); w > 0 {
+		return w
+	}
+	return 1
+}
+
+// dispatchLocked admits as many waiters as the limits allow, in turn, and
+// arms the timer for when more can be. q.mu must be held.
+func (q *AdmissionQueue) dispatchLocked() {
+	var next time.Time
+	later := func(at time.Time) {
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+
+	// keys held back by their own limit sit out the rest of the round
+	stuck := map[*admissionKey]bool{}
+	for {
+		k := q.nextLocked(stuck)
+		if k == nil {
+			break
+		}
+		at, shared, err := q.admit(k.key)
+		switch {
+		case err == ErrClosed:
+			q.failLocked(err)
+			return
+		case err != nil:
+			for _, w := range k.waiters {
+				q.finishLocked(w, err)
+			}
+			delete(q.queues, k.key)
+			continue
+		case shared:
+			// nobody gets past the shared limit, and the keys that
+			// come later have to wait their turn after this one
+			later(at)
+		case !at.IsZero():
+			stuck[k] = true
+			later(at)
+			continue
+		default:
+			q.finishLocked(k.waiters[0], nil)
+			k.waiters = k.waiters[1:]
+			if len(k.waiters) == 0 {
+				delete(q.queues, k.key)
+			}
+			q.vtime = k.vtime
+			k.vtime += 1 / k.weight
+			continue
+		}
+		break
+	}
+
+	if !next.IsZero() && (q.wakeAt.IsZero() || next.Before(q.wakeAt)) {
+		q.wakeAt = next
+		d := next.Sub(q.e.clock.Now())
+		if q.timer == nil {
+			q.timer = q.e.clock.AfterFunc(d, q.wake)
+		} else {
+			q.timer.Reset(d)
+		}
+	}
+}
+
+// nextLocked returns the waiting key whose turn is next, skipping stuck
+// ones, or nil if there is none. q.mu must be held.
+func (q *AdmissionQueue) nextLocked(stuck map[*admissionKey]bool) *admissionKey {
+	var best *admissionKey
+	for _, k := range q.queues {
+		if stuck[k] {
+			continue
+		}
+		if best == nil || k.vtime < best.vtime || k.vtime == best.vtime && k.seq < best.seq {
+			best = k
+		}
+	}
+	return best
+}
+
+// admit counts an admission to key if the limits allow it, and otherwise
+// returns when to try again, and whether it is the shared limit that
+// doesn't.
+func (q *AdmissionQueue) admit(key interface{}) (retry time.Time, shared bool, err error) {
+	r, ok := q.e.Reserve(key, 1, q.limit)
+	if !ok {
+		retry, err = q.e.retryAt(key, q.limit)
+		return retry, false, err
+	}
+	if q.fair != nil {
+		s, ok := q.e.Reserve(q.fair.Key, 1, q.fair.Limit)
+		if !ok {
+			r.Cancel()
+			retry, err = q.e.retryAt(q.fair.Key, q.fair.Limit)
+			return retry, true, err
+		}
+		s.Commit()
+	}
+	r.Commit()
+	return time.Time{}, false, nil
+}
+
+// wake dispatches when the timer fires.
+func (q *AdmissionQueue) wake() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.wakeAt = time.Time{}
+	q.dispatchLocked()
+}
+
+// finishLocked hands err to w. q.mu must be held.
+func (q *AdmissionQueue) finishLocked(w *admissionWaiter, err error) {
+	w.done = true
+	w.ready <- err
+}
+
+// failLocked fails every waiter with err. q.mu must be held.
+func (q *AdmissionQueue) failLocked(err error) {
+	for key, k := range q.queues {
+		for _, w := range k.waiters {
+			q.finishLocked(w, err)
+		}
+		delete(q.queues, key)
+	}
+}
@@ -0,0 +1,123 @@
+package ehc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until q has n callers waiting on key.
+func waitQueued(t *testing.T, q *AdmissionQueue, key interface{}, n int) {
+	t.Helper()
+	for i := 0; q.Queued(key) != n; i++ {
+		if i == 1000 {
+			t.Fatalf("AdmissionQueue.Queued(%v) = %d, want %d", key, q.Queued(key), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdmissionQueue_FIFO(t *testing.T) {
+	e := NewManualEHC(time.Second, time.Unix(0, 0))
+	q := NewAdmissionQueue(e.EHC, 1, nil)
+	if err := q.Wait(context.Background(), "k"); err != nil {
+		t.Fatalf("AdmissionQueue.Wait() = %v", err)
+	}
+
+	admitted := make(chan int)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			if err := q.Wait(context.Background(), "k"); err != nil {
+				t.Errorf("AdmissionQueue.Wait() = %v", err)
+			}
+			admitted <- i
+		}(i)
+		waitQueued(t, q, "k", i+1)
+	}
+
+	for i := 0; i < 3; i++ {
+		e.Tick(time.Second + 10*time.Millisecond)
+		if got := <-admitted; got != i {
+			t.Errorf("caller %d admitted in turn %d", got, i)
+		}
+		if v := e.value("k"); v != 1 {
+			t.Errorf("EHC count of k = %d, want 1", v)
+		}
+	}
+}
+
+func TestAdmissionQueue_Fairness(t *testing.T) {
+	e := NewManualEHC(time.Second, time.Unix(0, 0))
+	q := NewAdmissionQueue(e.EHC, 100, &Fairness{
+		Key:   "all",
+		Limit: 3,
+		Weight: func(key interface{}) float64 {
+			if key == "a" {
+				return 2
+			}
+			return 1
+		},
+	})
+	e.CountMultiple("all", 3)
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"a", "b"} {
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				if err := q.Wait(context.Background(), key); err != nil {
+					t.Errorf("AdmissionQueue.Wait(%s) = %v", key, err)
+				}
+			}(key)
+			waitQueued(t, q, key, i+1)
+		}
+	}
+
+	// a gets two turns for every one of b's
+	for _, want := range []struct{ a, b int }{{4, 5}, {2, 4}, {0, 3}, {0, 0}} {
+		e.Tick(time.Second + 10*time.Millisecond)
+		if a, b := q.Queued("a"), q.Queued("b"); a != want.a || b != want.b {
+			t.Errorf("AdmissionQueue.Queued() = %d for a and %d for b, want %d and %d", a, b, want.a, want.b)
+		}
+		if v := e.value("all"); v > 3 {
+			t.Errorf("EHC count of all = %d, want at most 3", v)
+		}
+	}
+	wg.Wait()
+}
+
+func TestAdmissionQueue_Cancel(t *testing.T) {
+	e := NewManualEHC(time.Second, time.Unix(0, 0))
+	q := NewAdmissionQueue(e.EHC, 1, nil)
+	e.Count("k")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 2)
+	go func() { errc <- q.Wait(ctx, "k") }()
+	waitQueued(t, q, "k", 1)
+	go func() { errc <- q.Wait(context.Background(), "k") }()
+	waitQueued(t, q, "k", 2)
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("AdmissionQueue.Wait() = %v when cancelled, want %v", err, context.Canceled)
+	}
+	waitQueued(t, q, "k", 1)
+
+	e.Tick(time.Second + 10*time.Millisecond)
+	if err := <-errc; err != nil {
+		t.Errorf("AdmissionQueue.Wait() = %v behind a cancelled caller, want nil", err)
+	}
+
+	go func() { errc <- q.Wait(context.Background(), "k") }()
+	waitQueued(t, q, "k", 1)
+	e.Close()
+	if err := <-errc; err != ErrClosed {
+		t.Errorf("AdmissionQueue.Wait() = %v when closed, want %v", err, ErrClosed)
+	}
+	if err := q.Wait(context.Background(), "other"); err != ErrClosed {
+		t.Errorf("AdmissionQueue.Wait() = %v after Close, want %v", err, ErrClosed)
+	}
+}
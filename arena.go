@@ -0,0 +1,100 @@
+package ehc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// arena hands out counters from contiguous chunks instead of allocating
+// each one individually. Counters created during the same window generation
+// share chunks, so they tend to expire together, and a chunk is released
+// wholesale once every counter in it has been removed.
+type arena struct {
+	mu        sync.Mutex
+	chunkSize int
+	window    time.Duration
+	cur       *chunk
+
+	// chunks is the number of chunks that still hold live counters.
+	chunks int64
+}
+
+type chunk struct {
+	counters []counter
+	next     int
+	live     int
+	gen      int64
+}
+
+func newArena(chunkSize int, window time.Duration) *arena {
+	return &arena{
+		chunkSize: chunkSize,
+		window:    window,
+	}
+}
+
+// generation returns the window generation that t falls in.
+func (a *arena) generation(t time.Time) int64 {
+	if a.window <= 0 {
+		return 0
+	}
+	return t.UnixNano() / int64(a.window)
+}
+
+// alloc returns a zeroed counter from the current chunk, starting a new chunk
+// if the current one is full or belongs to an earlier generation.
+func (a *arena) alloc() *counter {
+	gen := a.generation(time.Now())
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.cur == nil || a.cur.next == len(a.cur.counters) || a.cur.gen != gen {
+		a.retire(a.cur)
+		a.cur = &chunk{
+			counters: make([]counter, a.chunkSize),
+			gen:      gen,
+		}
+		atomic.AddInt64(&a.chunks, 1)
+	}
+
+	c := &a.cur.counters[a.cur.next]
+	c.chunk = a.cur
+	a.cur.next++
+	a.cur.live++
+	return c
+}
+
+// release records that c was removed from the map.
+func (a *arena) release(c *counter) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c.chunk.live--
+	if c.chunk != a.cur {
+		a.retire(c.chunk)
+	}
+}
+
+// retire drops the accounting for a chunk that no longer takes allocations
+// once all of its counters are gone. Nothing references the chunk after
+// that, so the garbage collector frees it in one piece.
+func (a *arena) retire(ch *chunk) {
+	if ch != nil && ch.live == 0 {
+		// mark it so a later release can't count it twice
+		ch.live = -1
+		atomic.AddInt64(&a.chunks, -1)
+	}
+}
+
+// WithArena enables the experimental arena storage mode, where counters are
+// carved out of chunks of chunkSize counters grouped by window generation
+// instead of being allocated one by one. This trades some memory held by
+// partially used chunks for far fewer heap objects for the garbage collector
+// to track on workloads with many short-lived keys.
+func WithArena(chunkSize int) Option {
+	return func(c *config) {
+		c.arenaChunkSize = chunkSize
+	}
+}
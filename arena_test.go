@@ -0,0 +1,37 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_Arena(t *testing.T) {
+	e := NewEHC(10*time.Millisecond, WithArena(4))
+	for i := 0; i < 10; i++ {
+		e.Count(i)
+	}
+	e.Count(0)
+
+	values, locker := e.Values()
+	if len(values) != 10 || values[0].Value() != 2 {
+		t.Errorf("EHC.Values() with arena has %d keys, key 0 = %d", len(values), values[0].Value())
+	}
+	locker.Unlock()
+
+	if chunks := e.Stats().ArenaChunks; chunks < 3 {
+		t.Errorf("EHC.Stats().ArenaChunks = %d, want at least 3", chunks)
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	// the current chunk is kept for reuse until a new one replaces it
+	if chunks := e.Stats().ArenaChunks; chunks > 1 {
+		t.Errorf("EHC.Stats().ArenaChunks after expiry = %d, want at most 1", chunks)
+	}
+}
+
+func BenchmarkEHC_UniquesArena(b *testing.B) {
+	e := NewEHC(10*time.Millisecond, WithArena(1024))
+	for i := 0; i < b.N; i++ {
+		e.Count(i)
+	}
+}
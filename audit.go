@@ -0,0 +1,150 @@
+package ehc
+
+import (
+	"sync"
+	"time"
+)
+
+// AuditEntry is an administrative operation recorded by WithAuditLog.
+type AuditEntry struct {
+	Time time.Time
+	// Actor is who made the change, as given to As or RecordAudit, or
+	// empty if unknown.
+	Actor string
+	// Op names the operation, such as "Expire", after the method that
+	// made it.
+	Op string
+	// Key is the key the operation applied to, if any.
+	Key interface{}
+	// Detail describes the change further, if needed, such as the new
+	// allowance of a Budget.
+	Detail string
+}
+
+// WithAuditLog keeps a log of the latest n administrative operations on the
+// EHC, for AuditLog to return, so that changes made in production, such as
+// limit resets, can be traced. Expire, ExpireAll, Unblock, Budget and
+// MigrateTo are recorded, as is every operation made through As, and
+// RecordAudit adds changes made elsewhere, such as to rules. Block is only
+// recorded when made through As, as rules block keys as a matter of course.
+//
+// The log is kept by MigrateTo whatever the new options.
+func WithAuditLog(n int) Option {
+	return func(c *config) {
+		c.auditEntries = n
+	}
+}
+
+// AuditLog returns the operations recorded since WithAuditLog, oldest first,
+// or nil without it.
+func (e *EHC) AuditLog() []AuditEntry {
+	if e.audits == nil {
+		return nil
+	}
+	return e.audits.entries()
+}
+
+// RecordAudit adds entry to the log of WithAuditLog, if there is one, e.g.
+// for a change to rules acting on the EHC. A zero Time is set to the
+// current time of the EHC's clock.
+func (e *EHC) RecordAudit(entry AuditEntry) {
+	if e.audits == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = e.clock.Now()
+	}
+	e.audits.add(entry)
+}
+
+// audit records an operation made by actor.
+func (e *EHC) audit(actor, op string, key interface{}, detail string) {
+	if e.audits == nil {
+		return
+	}
+	e.audits.add(AuditEntry{Time: e.clock.Now(), Actor: actor, Op: op, Key: key, Detail: detail})
+}
+
+// Admin makes administrative operations on an EHC on behalf of an actor,
+// which the log of WithAuditLog records with them.
+type Admin struct {
+	e     *EHC
+	actor string
+}
+
+// As returns an Admin making operations on e on behalf of actor, such as a
+// user name.
+func (e *EHC) As(actor string) *Admin {
+	return &Admin{e: e, actor: actor}
+}
+
+// Expire is like EHC.Expire.
+func (a *Admin) Expire(key interface{}) bool {
+	had := a.e.expire(key)
+	a.e.audit(a.actor, "Expire", key, "")
+	return had
+}
+
+// ExpireAll is like EHC.ExpireAll.
+func (a *Admin) ExpireAll() {
+	a.e.expireAll()
+	a.e.audit(a.actor, "ExpireAll", nil, "")
+}
+
+// Block is like EHC.Block.
+func (a *Admin) Block(key interface{}, d time.Duration) {
+	a.e.Block(key, d)
+	a.e.audit(a.actor, "Block", key, "for "+d.String())
+}
+
+// Unblock is like EHC.Unblock.
+func (a *Admin) Unblock(key interface{}) {
+	a.e.unblock(key)
+	a.e.audit(a.actor, "Unblock", key, "")
+}
+
+// Budget is like EHC.Budget.
+func (a *Admin) Budget(key interface{}, allowed int64) {
+	a.e.setBudget(key, allowed)
+	a.e.audit(a.actor, "Budget", key, budgetDetail(allowed))
+}
+
+// MigrateTo is like EHC.MigrateTo.
+func (a *Admin) MigrateTo(opts ...Option) {
+	a.e.migrateTo(opts)
+	a.e.audit(a.actor, "MigrateTo", nil, "")
+}
+
+// auditLog is a ring of the latest entries.
+type auditLog struct {
+	mu   sync.Mutex
+	ring []AuditEntry
+	// next is where the next entry goes, and full whether the ring has
+	// wrapped around.
+	next int
+	full bool
+}
+
+func newAuditLog(n int) *auditLog {
+	return &auditLog{ring: make([]AuditEntry, n)}
+}
+
+func (l *auditLog) add(entry AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ring[l.next] = entry
+	l.next++
+	if l.next == len(l.ring) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+func (l *auditLog) entries() []AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]AuditEntry(nil), l.ring[:l.next]...)
+	}
+	return append(append([]AuditEntry(nil), l.ring[l.next:]...), l.ring[:l.next]...)
+}
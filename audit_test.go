@@ -0,0 +1,49 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_AuditLog(t *testing.T) {
+	start := time.Unix(0, 0)
+	e := NewManualEHC(time.Minute, start, WithAuditLog(3))
+	e.Count("a")
+	e.Expire("a")
+	e.Tick(time.Second)
+	e.As("alice").Block("b", time.Minute)
+	e.Block("c", time.Minute)
+	e.RecordAudit(AuditEntry{Actor: "bob", Op: "Rules", Detail: "raised per-ip"})
+
+	log := e.AuditLog()
+	want := []AuditEntry{
+		{Time: start, Op: "Expire", Key: "a"},
+		{Time: start.Add(time.Second), Actor: "alice", Op: "Block", Key: "b", Detail: "for 1m0s"},
+		{Time: start.Add(time.Second), Actor: "bob", Op: "Rules", Detail: "raised per-ip"},
+	}
+	if len(log) != len(want) {
+		t.Fatalf("EHC.AuditLog() = %v, want %v", log, want)
+	}
+	for i := range want {
+		if !log[i].Time.Equal(want[i].Time) || log[i].Actor != want[i].Actor || log[i].Op != want[i].Op || log[i].Key != want[i].Key || log[i].Detail != want[i].Detail {
+			t.Errorf("EHC.AuditLog()[%d] = %+v, want %+v", i, log[i], want[i])
+		}
+	}
+
+	// the oldest entries make way, and the log is kept across MigrateTo
+	e.As("alice").Budget("a", -1)
+	e.MigrateTo(WithBuckets(4))
+	log = e.AuditLog()
+	if len(log) != 3 || log[0].Op != "Rules" || log[1].Detail != "removed" || log[2].Op != "MigrateTo" {
+		t.Errorf("EHC.AuditLog() after wrapping = %+v", log)
+	}
+}
+
+func TestEHC_AuditLogDisabled(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	e.Expire("a")
+	e.RecordAudit(AuditEntry{Op: "Rules"})
+	if log := e.AuditLog(); log != nil {
+		t.Errorf("EHC.AuditLog() without WithAuditLog = %v, want nil", log)
+	}
+}
@@ -0,0 +1,56 @@
+package ehc
+
+import (
+	"math/rand"
+	"time"
+)
+
+// DefaultBackoffBase is the delay Backoff suggests after a single failure,
+// unless set with WithBackoff.
+const DefaultBackoffBase = 100 * time.Millisecond
+
+// WithBackoff sets how Backoff turns failure counts into delays: a single
+// failure calls for base, and every further one doubles it, up to max. A base
+// of 0 keeps DefaultBackoffBase, and a max of 0 makes it the window, after
+// which the failures have expired anyway.
+func WithBackoff(base, max time.Duration) Option {
+	return func(c *config) {
+		c.backoffBase = base
+		c.backoffMax = max
+	}
+}
+
+// Backoff suggests how long to wait before retrying an operation whose
+// failures are counted under key, so that retry loops back off on the same
+// counts alerts are raised from. It is 0 while the window holds no failures,
+// and otherwise grows exponentially with them, as set by WithBackoff, with
+// jitter: the delay is drawn from the upper half of the exponential one, so
+// that clients failing together don't retry together. For failures counted
+// with CountError, key is the ErrorKey of the class to back off on.
+func (e *EHC) Backoff(key interface{}) time.Duration {
+	failures, _ := e.Get(key)
+	if failures <= 0 {
+		return 0
+	}
+	base, max := e.backoffBase, e.backoffMax
+	if base <= 0 {
+		base = DefaultBackoffBase
+	}
+	if max <= 0 {
+		max = e.window
+	}
+
+	d := base
+	for i := int64(1); i < failures && d < max; i++ {
+		if d > max/2 {
+			d = max
+			break
+		}
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	half := d / 2
+	return d - half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,48 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_Backoff(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithBackoff(time.Second, 10*time.Second))
+	if d := e.Backoff("db"); d != 0 {
+		t.Errorf("Backoff() = %v without failures, want 0", d)
+	}
+
+	for _, tt := range []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	} {
+		e.CountMultiple("db", tt.failures-e.value("db"))
+		for i := 0; i < 20; i++ {
+			if d := e.Backoff("db"); d < tt.want/2 || d > tt.want {
+				t.Fatalf("Backoff() = %v after %d failures, want between %v and %v", d, tt.failures, tt.want/2, tt.want)
+			}
+		}
+	}
+
+	e.Tick(time.Minute)
+	if d := e.Backoff("db"); d != 0 {
+		t.Errorf("Backoff() = %v once the failures expired, want 0", d)
+	}
+}
+
+func TestEHC_BackoffDefaults(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	e.Count("db")
+	if d := e.Backoff("db"); d < DefaultBackoffBase/2 || d > DefaultBackoffBase {
+		t.Errorf("Backoff() = %v after a failure, want at most %v", d, DefaultBackoffBase)
+	}
+	e.CountMultiple("db", 1000)
+	if d := e.Backoff("db"); d < 30*time.Second || d > time.Minute {
+		t.Errorf("Backoff() = %v after many failures, want capped at the window", d)
+	}
+}
@@ -0,0 +1,104 @@
+package ehc
+
+import (
+	"sync"
+	"time"
+)
+
+// blocklist holds keys that are blocked until a deadline, independently of
+// the measurement window.
+type blocklist struct {
+	clock Clock
+
+	mu    sync.Mutex
+	until map[interface{}]time.Time
+}
+
+// Block adds key to the EHC's blocklist for d, so that IsBlocked reports true
+// for it until then. Blocking an already blocked key only ever extends the
+// block, and keys exempted with BypassLimits are ignored. This lets
+// enforcement persist beyond the measurement window without external state.
+func (e *EHC) Block(key interface{}, d time.Duration) {
+	key, ok := e.enforcedKey(key)
+	if !ok {
+		return
+	}
+	e.blocks.block(key, d)
+}
+
+// Unblock removes key from the blocklist.
+func (e *EHC) Unblock(key interface{}) {
+	e.unblock(key)
+	e.audit("", "Unblock", key, "")
+}
+
+func (e *EHC) unblock(key interface{}) {
+	key, ok := e.enforcedKey(key)
+	if !ok {
+		return
+	}
+
+	e.blocks.mu.Lock()
+	defer e.blocks.mu.Unlock()
+	delete(e.blocks.until, key)
+}
+
+// IsBlocked reports whether key is currently on the blocklist. Keys exempted
+// with BypassLimits are never blocked.
+func (e *EHC) IsBlocked(key interface{}) bool {
+	key, ok := e.enforcedKey(key)
+	if !ok {
+		return false
+	}
+
+	return e.blocks.blocked(key)
+}
+
+// enforcedKey normalizes a key for enforcement, returning false if it is
+// invalid or exempt from enforcement.
+func (e *EHC) enforcedKey(key interface{}) (interface{}, bool) {
+	e.valueLock.RLock()
+	defer e.valueLock.RUnlock()
+
+	if e.bypassed(key, BypassLimits) {
+		return nil, false
+	}
+	return e.normalizeKey(key)
+}
+
+// block blocks a normalized key for d.
+func (b *blocklist) block(key interface{}, d time.Duration) {
+	until := b.clock.Now().Add(d)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.until == nil {
+		b.until = map[interface{}]time.Time{}
+	}
+	if prev, ok := b.until[key]; ok && !until.After(prev) {
+		return
+	}
+	b.until[key] = until
+	b.clock.AfterFunc(d, func() {
+		b.expire(key)
+	})
+}
+
+// blocked reports whether a normalized key is blocked.
+func (b *blocklist) blocked(key interface{}) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.until[key]
+	return ok && b.clock.Now().Before(until)
+}
+
+// expire removes key if its block has run out. A block that was extended
+// stays, to be removed by the timer of the extension.
+func (b *blocklist) expire(key interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until, ok := b.until[key]; ok && !b.clock.Now().Before(until) {
+		delete(b.until, key)
+	}
+}
@@ -0,0 +1,40 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_Block(t *testing.T) {
+	e := NewEHC(time.Minute)
+	if e.IsBlocked("k") {
+		t.Fatalf("EHC.IsBlocked() = true before blocking")
+	}
+
+	e.Block("k", 20*time.Millisecond)
+	e.Block("k", time.Millisecond)
+	if !e.IsBlocked("k") {
+		t.Fatalf("EHC.IsBlocked() = false after blocking")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if !e.IsBlocked("k") {
+		t.Errorf("a shorter Block() cut the existing block short")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if e.IsBlocked("k") {
+		t.Errorf("EHC.IsBlocked() = true after the block expired")
+	}
+	e.blocks.mu.Lock()
+	if n := len(e.blocks.until); n != 0 {
+		t.Errorf("blocklist holds %d expired keys, want 0", n)
+	}
+	e.blocks.mu.Unlock()
+
+	e.Block("k", time.Minute)
+	e.Unblock("k")
+	if e.IsBlocked("k") {
+		t.Errorf("EHC.IsBlocked() = true after Unblock()")
+	}
+}
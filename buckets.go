@@ -0,0 +1,299 @@
+package ehc
+
+import (
+	"sync"
+	"time"
+)
+
+// WithBuckets switches the EHC to bucketed sliding-window expiry: each key's
+// increments are added up in a ring of n+1 buckets, each window/n long, in
+// place of a pending retraction per increment. A key's value is the sum of
+// the buckets inside the window plus the share of the oldest bucket that the
+// window still overlaps, assuming its increments were spread evenly across
+// it. Memory per key is fixed regardless of its rate, and counting is O(1),
+// which makes sustained high-rate counting on a single key practical.
+//
+// The estimate is off by at most the count of one bucket, so larger n
+// tightens it at the cost of memory per key. Unlike WithGenerations, counts
+// don't linger past the window. It is implemented as an ExpiryStrategy, and
+// WithArena and WithInterner have no effect in this mode.
+func WithBuckets(n int) Option {
+	return func(c *config) {
+		c.buckets = n
+	}
+}
+
+// buckets implements the bucketed expiry mode. Buckets are aligned to
+// multiples of their length since the Unix epoch, and numbered accordingly.
+type buckets struct {
+	n      int64
+	length time.Duration
+
+	mu    sync.RWMutex
+	rings map[interface{}]*bucketRing
+}
+
+// bucketRing is the buckets of one key.
+type bucketRing struct {
+	mu sync.Mutex
+	// newest is the number of the newest bucket counted into; the ring
+	// holds the buckets from newest-len(counts)+1 to newest.
+	newest int64
+	counts []int64
+}
+
+func newBuckets(window time.Duration, n int) *buckets {
+	if n <= 0 {
+		n = 1
+	}
+	b := &buckets{
+		n:      int64(n),
+		length: window / time.Duration(n),
+		rings:  map[interface{}]*bucketRing{},
+	}
+	if b.length <= 0 {
+		b.length = 1
+	}
+	return b
+}
+
+// bucket returns the number of the bucket now falls in.
+func (b *buckets) bucket(now time.Time) int64 {
+	return now.UnixNano() / int64(b.length)
+}
+
+// mod returns i modulo n, which is never negative, unlike i%n, as bucket
+// numbers before the first window can be.
+func mod(i, n int64) int64 {
+	return (i%n + n) % n
+}
+
+// Add adds n to key in the bucket now falls in.
+func (b *buckets) Add(key interface{}, n int64, now time.Time) {
+	b.mu.RLock()
+	r := b.rings[key]
+	b.mu.RUnlock()
+	if r == nil {
+		b.mu.Lock()
+		if r = b.rings[key]; r == nil {
+			r = &bucketRing{newest: b.bucket(now), counts: make([]int64, b.n+1)}
+			b.rings[key] = r
+		}
+		b.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	addToRing(r.counts, &r.newest, b.bucket(now), n)
+}
+
+// addToRing adds n to bucket of the ring counts, whose newest bucket is
+// *newest.
+func addToRing(counts []int64, newest *int64, bucket, n int64) {
+	size := int64(len(counts))
+	if bucket > *newest {
+		// clear the buckets that have been skipped over, which is the
+		// whole ring if the key has been idle for a window
+		for i := *newest + 1; i <= bucket && i <= *newest+size; i++ {
+			counts[mod(i, size)] = 0
+		}
+		*newest = bucket
+	}
+	if bucket <= *newest-size {
+		// MigrateTo backdating past the ring; it has expired anyway
+		return
+	}
+	counts[mod(bucket, size)] += n
+}
+
+// Value estimates key's count over the window ending at now.
+func (b *buckets) Value(key interface{}, now time.Time) int64 {
+	b.mu.RLock()
+	r := b.rings[key]
+	b.mu.RUnlock()
+	if r == nil {
+		return 0
+	}
+	return b.value(r, now)
+}
+
+// value estimates r's count over the window ending at now.
+func (b *buckets) value(r *bucketRing, now time.Time) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return b.sum(r.counts, r.newest, now)
+}
+
+// sum estimates the count of the ring counts, whose newest bucket is newest,
+// over the window ending at now.
+func (b *buckets) sum(counts []int64, newest int64, now time.Time) int64 {
+	cur := b.bucket(now)
+	size := int64(len(counts))
+
+	var total int64
+	for i := cur - b.n + 1; i <= cur; i++ {
+		if i <= newest && i > newest-size {
+			total += counts[mod(i, size)]
+		}
+	}
+	// the window starts partway through the oldest bucket
+	if oldest := cur - b.n; oldest <= newest && oldest > newest-size {
+		left := int64(b.length) - now.UnixNano()%int64(b.length)
+		total += counts[mod(oldest, size)] * left / int64(b.length)
+	}
+	return total
+}
+
+// Snapshot estimates the count of every key.
+func (b *buckets) Snapshot(now time.Time) map[interface{}]int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	totals := map[interface{}]int64{}
+	for k, r := range b.rings {
+		if v := b.value(r, now); v != 0 {
+			totals[k] = v
+		}
+	}
+	return totals
+}
+
+// Prune drops the rings of keys that haven't been counted for a window.
+func (b *buckets) Prune(now time.Time) {
+	cur := b.bucket(now)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for k, r := range b.rings {
+		r.mu.Lock()
+		stale := r.newest < cur-b.n
+		r.mu.Unlock()
+		if stale {
+			delete(b.rings, k)
+		}
+	}
+}
+
+// contributions returns the live count of every bucket, each expiring as if
+// it had all been counted at the bucket's end.
+func (b *buckets) contributions(now time.Time) []contribution {
+	cur := b.bucket(now)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var live []contribution
+	for k, r := range b.rings {
+		r.mu.Lock()
+		live = b.ringContributions(live, k, r.counts, r.newest, cur)
+		r.mu.Unlock()
+	}
+	return live
+}
+
+// ringContributions appends the live counts of the ring counts of key, whose
+// newest bucket is newest, as of bucket cur.
+func (b *buckets) ringContributions(live []contribution, key interface{}, counts []int64, newest, cur int64) []contribution {
+	size := int64(len(counts))
+	for i := cur - b.n; i <= cur; i++ {
+		if i > newest || i <= newest-size || counts[mod(i, size)] == 0 {
+			continue
+		}
+		deadline := time.Unix(0, int64(b.length)*(i+1)).Add(b.window())
+		live = append(live, contribution{key: key, count: counts[mod(i, size)], deadline: deadline})
+	}
+	return live
+}
+
+// halves adds every key's count in the buckets starting in the current half
+// of the window to cur, and the rest to prev.
+func (b *buckets) halves(now time.Time, cur, prev map[interface{}]int64) {
+	epoch := b.bucket(now)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for k, r := range b.rings {
+		r.mu.Lock()
+		b.ringHalves(k, r.counts, r.newest, epoch, cur, prev)
+		r.mu.Unlock()
+	}
+}
+
+// ringHalves is halves for the ring counts of key, whose newest bucket is
+// newest, as of bucket epoch.
+func (b *buckets) ringHalves(key interface{}, counts []int64, newest, epoch int64, cur, prev map[interface{}]int64) {
+	size := int64(len(counts))
+	for i := epoch - b.n + 1; i <= epoch; i++ {
+		if i > newest || i <= newest-size {
+			continue
+		}
+		into := prev
+		if age := epoch - i; age < b.n/2 || b.n == 1 {
+			into = cur
+		}
+		into[key] += counts[mod(i, size)]
+	}
+}
+
+// window returns the length of the window the buckets cover.
+func (b *buckets) window() time.Duration {
+	return b.length * time.Duration(b.n)
+}
+
+// KeyDetail is a key's count together with its shape over the window, as
+// returned by SnapshotDetailed.
+type KeyDetail struct {
+	Count int64
+	// Buckets holds, with WithBuckets(n), the counts of the n+1 buckets
+	// overlapping the window, each window/n long, oldest first: the last
+	// is the current bucket, and only part of the first is still in the
+	// window. Without WithBuckets it is nil.
+	Buckets []int64
+}
+
+// SnapshotDetailed is like Snapshot, but with WithBuckets it also returns how
+// each key's count is spread over the window, so that exporters and
+// dashboards can show its shape, e.g. as a sparkline, and not just its total.
+func (e *EHC) SnapshotDetailed() map[interface{}]KeyDetail {
+	e.valueLock.RLock()
+	if b, ok := e.expiry.(*buckets); ok {
+		defer e.valueLock.RUnlock()
+		return b.detailed(e.now())
+	}
+	e.valueLock.RUnlock()
+
+	details := map[interface{}]KeyDetail{}
+	for k, v := range e.Snapshot() {
+		details[k] = KeyDetail{Count: v}
+	}
+	return details
+}
+
+// detailed returns the count and bucket series of every key with a count.
+func (b *buckets) detailed(now time.Time) map[interface{}]KeyDetail {
+	cur := b.bucket(now)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	details := map[interface{}]KeyDetail{}
+	for k, r := range b.rings {
+		v := b.value(r, now)
+		if v == 0 {
+			continue
+		}
+		series := make([]int64, b.n+1)
+		size := int64(len(r.counts))
+		r.mu.Lock()
+		for i := cur - b.n; i <= cur; i++ {
+			if i <= r.newest && i > r.newest-size {
+				series[i-(cur-b.n)] = r.counts[mod(i, size)]
+			}
+		}
+		r.mu.Unlock()
+		details[k] = KeyDetail{Count: v, Buckets: series}
+	}
+	return details
+}
@@ -0,0 +1,120 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_Buckets(t *testing.T) {
+	e := NewManualEHC(10*time.Second, time.Unix(0, 0), WithBuckets(10))
+	e.CountMultiple("a", 10)
+	e.Tick(5 * time.Second)
+	e.CountMultiple("a", 4)
+
+	for _, step := range []struct {
+		tick time.Duration
+		want int64
+	}{
+		{0, 14},
+		{5 * time.Second, 14},
+		// the window now starts halfway through the first bucket
+		{500 * time.Millisecond, 9},
+		{500 * time.Millisecond, 4},
+		{4500 * time.Millisecond, 2},
+		{500 * time.Millisecond, 0},
+	} {
+		e.Tick(step.tick)
+		if v := e.value("a"); v != step.want {
+			t.Errorf("count at %v = %d, want %d", e.Now().Sub(time.Unix(0, 0)), v, step.want)
+		}
+	}
+
+	e.CountMultiple("b", 1)
+	if values, locker := e.Values(); len(values) != 1 || values["b"].Value() != 1 {
+		t.Errorf("EHC.Values() = %v, want b=1 only", values)
+		locker.Unlock()
+	} else {
+		locker.Unlock()
+	}
+}
+
+func TestEHC_BucketsSameKey(t *testing.T) {
+	e := NewManualEHC(time.Second, time.Unix(0, 0), WithBuckets(4))
+	for i := 0; i < 100000; i++ {
+		e.Count("hot")
+		e.Tick(10 * time.Microsecond)
+	}
+
+	// one ring of five buckets, however many increments
+	b := e.expiry.(*buckets)
+	if len(b.rings) != 1 || len(b.rings["hot"].counts) != 5 {
+		t.Errorf("%d rings of %d buckets, want 1 of 5", len(b.rings), len(b.rings["hot"].counts))
+	}
+	if v := e.value("hot"); v < 99000 || v > 100000 {
+		t.Errorf("count = %d, want about 100000", v)
+	}
+}
+
+func TestEHC_BucketsMigrate(t *testing.T) {
+	e := NewManualEHC(10*time.Second, time.Unix(0, 0), WithBuckets(10))
+	e.CountMultiple("a", 3)
+	e.Tick(4 * time.Second)
+	e.CountMultiple("a", 2)
+
+	e.MigrateTo()
+	if v := e.value("a"); v != 5 {
+		t.Fatalf("count = %d after migrating to timers, want 5", v)
+	}
+	e.MigrateTo(WithBuckets(10))
+	if v := e.value("a"); v != 5 {
+		t.Fatalf("count = %d after migrating back to buckets, want 5", v)
+	}
+
+	// the first increments leave over the first bucket after the window
+	e.Tick(6*time.Second + 500*time.Millisecond)
+	if v := e.value("a"); v != 3 {
+		t.Errorf("count = %d halfway out of the first bucket, want 3", v)
+	}
+	e.Tick(time.Second)
+	if v := e.value("a"); v != 2 {
+		t.Errorf("count = %d after the first bucket, want 2", v)
+	}
+}
+
+func TestEHC_SnapshotDetailed(t *testing.T) {
+	e := NewManualEHC(4*time.Second, time.Unix(0, 0), WithBuckets(4))
+	e.CountMultiple("a", 3)
+	e.Tick(2 * time.Second)
+	e.Count("a")
+	e.Count("b")
+	e.Tick(time.Second)
+	e.CountMultiple("a", 2)
+
+	details := e.SnapshotDetailed()
+	want := map[interface{}]KeyDetail{
+		"a": {Count: 6, Buckets: []int64{0, 3, 0, 1, 2}},
+		"b": {Count: 1, Buckets: []int64{0, 0, 0, 1, 0}},
+	}
+	if len(details) != len(want) {
+		t.Fatalf("EHC.SnapshotDetailed() = %v, want %v", details, want)
+	}
+	for k, w := range want {
+		d := details[k]
+		if d.Count != w.Count || len(d.Buckets) != len(w.Buckets) {
+			t.Errorf("EHC.SnapshotDetailed()[%v] = %v, want %v", k, d, w)
+			continue
+		}
+		for i := range w.Buckets {
+			if d.Buckets[i] != w.Buckets[i] {
+				t.Errorf("EHC.SnapshotDetailed()[%v] = %v, want %v", k, d, w)
+				break
+			}
+		}
+	}
+
+	plain := NewManualEHC(time.Minute, time.Unix(0, 0))
+	plain.CountMultiple("a", 2)
+	if d := plain.SnapshotDetailed(); len(d) != 1 || d["a"].Count != 2 || d["a"].Buckets != nil {
+		t.Errorf("EHC.SnapshotDetailed() without buckets = %v, want a: 2 without buckets", d)
+	}
+}
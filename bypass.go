@@ -0,0 +1,35 @@
+package ehc
+
+import "sync/atomic"
+
+// BypassMode chooses what WithBypass exempts keys from.
+type BypassMode int
+
+const (
+	// BypassCounting skips counting the key entirely.
+	BypassCounting BypassMode = iota
+	// BypassLimits counts the key as usual but exempts it from
+	// enforcement: it can never be blocked.
+	BypassLimits
+)
+
+// WithBypass exempts the keys for which bypass returns true, such as health
+// checks or internal addresses, from counting or from enforcement depending
+// on mode. bypass is called before the key is even looked up, so it should be
+// cheap. Each bypassed event is counted in Stats().Bypassed.
+func WithBypass(bypass func(key interface{}) bool, mode BypassMode) Option {
+	return func(c *config) {
+		c.bypass = bypass
+		c.bypassMode = mode
+	}
+}
+
+// bypassed reports whether key is exempt from the given kind of treatment,
+// recording the event if so.
+func (e *EHC) bypassed(key interface{}, mode BypassMode) bool {
+	if e.bypass == nil || e.bypassMode != mode || !e.bypass(key) {
+		return false
+	}
+	atomic.AddInt64(&e.stats.bypassed, 1)
+	return true
+}
@@ -0,0 +1,47 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithBypass(t *testing.T) {
+	isHealthCheck := func(key interface{}) bool {
+		return key == "/healthz"
+	}
+
+	t.Run("counting", func(t *testing.T) {
+		e := NewEHC(time.Minute, WithBypass(isHealthCheck, BypassCounting))
+		e.Count("/healthz")
+		e.Count("/healthz")
+		e.Count("/api")
+
+		values, locker := e.Values()
+		if len(values) != 1 || values["/api"] == nil {
+			t.Errorf("EHC.Values() = %v, want only /api", values)
+		}
+		locker.Unlock()
+		if bypassed := e.Stats().Bypassed; bypassed != 2 {
+			t.Errorf("EHC.Stats().Bypassed = %d, want 2", bypassed)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		e := NewEHC(time.Minute, WithBypass(isHealthCheck, BypassLimits))
+		e.Count("/healthz")
+		e.Block("/healthz", time.Minute)
+		e.Block("/api", time.Minute)
+
+		values, locker := e.Values()
+		if values["/healthz"] == nil {
+			t.Errorf("BypassLimits key was not counted")
+		}
+		locker.Unlock()
+		if e.IsBlocked("/healthz") {
+			t.Errorf("BypassLimits key was blocked")
+		}
+		if !e.IsBlocked("/api") {
+			t.Errorf("ordinary key was not blocked")
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package ehc
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// WithCallSites records which function made each Count, as a secondary
+// dimension that CallSites reports, to answer "who is incrementing this key
+// so much" from the counter itself while debugging. The caller is the first
+// function on the stack outside this package, found with runtime.Callers and
+// a cache of the functions seen, but it still costs a stack walk per Count,
+// so this is best left off in production.
+func WithCallSites() Option {
+	return func(c *config) {
+		c.callSites = true
+	}
+}
+
+// CallSites returns the count of key within the window per calling
+// function, as recorded with WithCallSites, or nil if it isn't enabled.
+// Functions are named as in stack traces, e.g. "main.(*server).handle".
+//
+// It scans every key and caller, so it is meant for debugging rather than
+// frequent queries.
+func (e *EHC) CallSites(key interface{}) map[string]int64 {
+	sites, _ := e.sites.Load().(*callSites)
+	if sites == nil {
+		return nil
+	}
+
+	e.valueLock.RLock()
+	key, ok := e.normalizeKey(key)
+	e.valueLock.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	counts := map[string]int64{}
+	values, locker := sites.counts.Values()
+	for k, c := range values {
+		if site := k.(callSite); site.key == key && c.Value() > 0 {
+			counts[site.caller] = c.Value()
+		}
+	}
+	locker.Unlock()
+	return counts
+}
+
+// counted records count increments of a normalized key that were just
+// counted, for the features that follow every count.
+func (e *EHC) counted(key interface{}, count int64) {
+	e.recordTotal(key, count)
+	e.countedGlobal(count)
+	e.countedBudget(key)
+	e.countedThresholds(key)
+	e.countedDeltas(key, count)
+	if sites, _ := e.sites.Load().(*callSites); sites != nil {
+		sites.record(key, count)
+	}
+}
+
+// callSite is what callSites counts under.
+type callSite struct {
+	key    interface{}
+	caller string
+}
+
+// callSites counts increments per key and calling function.
+type callSites struct {
+	counts *EHC
+
+	// funcs caches, per program counter, the function's name and
+	// whether it belongs to this package.
+	funcs sync.Map
+}
+
+// funcInfo is a cached entry of callSites.funcs.
+type funcInfo struct {
+	name     string
+	internal bool
+}
+
+// pkgPrefix is the prefix of the names of this package's functions.
+const pkgPrefix = "github.com/coder543/ehc."
+
+// storeCallSites installs the call site tracker as configured, keeping the
+// current one across migrations.
+func (e *EHC) storeCallSites() {
+	sites, _ := e.sites.Load().(*callSites)
+	switch {
+	case !e.callSites:
+		sites = nil
+	case sites == nil:
+		sites = &callSites{counts: newEHC(e.window, e.clock)}
+	}
+	e.sites.Store(sites)
+}
+
+func (s *callSites) record(key interface{}, count int64) {
+	s.counts.CountMultiple(callSite{key: key, caller: s.caller()}, count)
+}
+
+// caller returns the name of the first function on the stack outside this
+// package.
+func (s *callSites) caller() string {
+	var pcs [16]uintptr
+	// skip runtime.Callers and caller itself
+	n := runtime.Callers(2, pcs[:])
+	for _, pc := range pcs[:n] {
+		info := s.lookup(pc)
+		if !info.internal {
+			return info.name
+		}
+	}
+	return "unknown"
+}
+
+func (s *callSites) lookup(pc uintptr) funcInfo {
+	if info, ok := s.funcs.Load(pc); ok {
+		return info.(funcInfo)
+	}
+	info := funcInfo{name: "unknown"}
+	if fn := runtime.FuncForPC(pc - 1); fn != nil {
+		// this package's tests count as callers from outside it
+		file, _ := fn.FileLine(pc - 1)
+		info.name = fn.Name()
+		info.internal = strings.HasPrefix(info.name, pkgPrefix) &&
+			!strings.HasSuffix(file, "_test.go")
+	}
+	s.funcs.Store(pc, info)
+	return info
+}
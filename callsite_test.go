@@ -0,0 +1,36 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func countFromA(e *EHC) { e.Count("k") }
+func countFromB(e *EHC) { e.CountMultiple("k", 3) }
+
+func TestEHC_CallSites(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithCallSites())
+	countFromA(e.EHC)
+	countFromA(e.EHC)
+	countFromB(e.EHC)
+	e.Count("other")
+
+	sites := e.CallSites("k")
+	a, b := pkgPrefix+"countFromA", pkgPrefix+"countFromB"
+	if len(sites) != 2 || sites[a] != 2 || sites[b] != 3 {
+		t.Errorf("EHC.CallSites(k) = %v, want %s: 2 and %s: 3", sites, a, b)
+	}
+
+	e.Tick(time.Minute)
+	if sites := e.CallSites("k"); len(sites) != 0 {
+		t.Errorf("EHC.CallSites(k) = %v after the window, want none", sites)
+	}
+}
+
+func TestEHC_CallSitesDisabled(t *testing.T) {
+	e := NewEHC(time.Minute)
+	e.Count("k")
+	if sites := e.CallSites("k"); sites != nil {
+		t.Errorf("EHC.CallSites(k) = %v without WithCallSites, want nil", sites)
+	}
+}
@@ -0,0 +1,198 @@
+package ehc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time and timers of an EHC. The methods behave like
+// the functions of the time package of the same names. The system clock is
+// used unless WithClock says otherwise, and ehcclock provides a manual one
+// for tests.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer created by a Clock. Its methods behave like those of
+// time.Timer, and C returns the channel a timer created by NewTimer sends
+// the time on when it fires, or nil for one created by AfterFunc.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// WithClock makes the EHC take the time, and schedule its expirations and
+// other timers, with c instead of the system clock, e.g. so that tests can
+// move time forward on their own. WithCoarseClock has no effect then, and
+// MigrateTo keeps the clock the EHC was created with.
+func WithClock(c Clock) Option {
+	return func(cfg *config) {
+		cfg.customClock = c
+	}
+}
+
+// realClock is the system clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// realTimer is a time.Timer.
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+func (t realTimer) Reset(d time.Duration) bool {
+	return t.t.Reset(d)
+}
+
+// ManualEHC is an EHC whose clock only moves when Tick is called, so that
+// simulations and deterministic replays can drive it from their own
+// scheduler. Counts expire during Tick, on the calling goroutine, in the
+// order of their deadlines, and the EHC never starts goroutines of its own.
+//
+// WithCoarseClock has no effect on a ManualEHC. An EHC can also be given a
+// manual clock of its own with WithClock.
+type ManualEHC struct {
+	*EHC
+	clock *manualClock
+}
+
+// NewManualEHC returns a ManualEHC whose clock starts at start.
+func NewManualEHC(window time.Duration, start time.Time, opts ...Option) *ManualEHC {
+	c := &manualClock{now: start}
+	opts = append(opts[:len(opts):len(opts)], WithClock(c))
+	return &ManualEHC{EHC: newEHC(window, c, opts...), clock: c}
+}
+
+// Now returns the current time of the ManualEHC's clock.
+func (m *ManualEHC) Now() time.Time {
+	return m.clock.Now()
+}
+
+// Tick advances the clock by d, running everything that falls due on the
+// way. Increments made by those callbacks that fall due within d run too.
+func (m *ManualEHC) Tick(d time.Duration) {
+	m.clock.advance(d)
+}
+
+// manualClock is a clock that only moves when advanced.
+type manualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int64
+	timers []*manualTimer
+}
+
+// manualTimer is a timer of a manualClock. Timers due at the same time run
+// in the order they were scheduled.
+type manualTimer struct {
+	c   *manualClock
+	at  time.Time
+	seq int64
+	f   func()
+	// ch is the channel of a timer created by NewTimer.
+	ch chan time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &manualTimer{c: c, f: f}
+	t.Reset(d)
+	return t
+}
+
+func (c *manualClock) NewTimer(d time.Duration) Timer {
+	t := &manualTimer{c: c, ch: make(chan time.Time, 1)}
+	t.f = func() {
+		select {
+		case t.ch <- c.Now():
+		default:
+		}
+	}
+	t.Reset(d)
+	return t
+}
+
+// advance moves the clock forward by d, running due timers in order.
+func (c *manualClock) advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].at.After(target) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+// removeLocked unschedules t, reporting whether it was pending.
+func (c *manualClock) removeLocked(t *manualTimer) bool {
+	for i, p := range c.timers {
+		if p == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *manualTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	return t.c.removeLocked(t)
+}
+
+func (t *manualTimer) Reset(d time.Duration) bool {
+	c := t.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pending := c.removeLocked(t)
+	c.seq++
+	t.at, t.seq = c.now.Add(d), c.seq
+	i := sort.Search(len(c.timers), func(i int) bool {
+		p := c.timers[i]
+		return p.at.After(t.at) || p.at.Equal(t.at) && p.seq > t.seq
+	})
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+	return pending
+}
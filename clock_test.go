@@ -0,0 +1,77 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualEHC_Tick(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := NewManualEHC(time.Minute, start)
+	e.Count("k")
+	e.Tick(30 * time.Second)
+	e.CountMultiple("k", 2)
+
+	// real time passing changes nothing
+	time.Sleep(10 * time.Millisecond)
+	if v := e.value("k"); v != 3 {
+		t.Errorf("EHC count of k = %d, want 3", v)
+	}
+
+	e.Tick(30 * time.Second)
+	if v := e.value("k"); v != 2 {
+		t.Errorf("EHC count of k = %d after 1m, want 2", v)
+	}
+	if now := e.Now(); !now.Equal(start.Add(time.Minute)) {
+		t.Errorf("ManualEHC.Now() = %v, want %v", now, start.Add(time.Minute))
+	}
+
+	e.Tick(time.Hour)
+	values, locker := e.Values()
+	if len(values) != 0 {
+		t.Errorf("EHC.Values() = %v after the window, want empty", values)
+	}
+	locker.Unlock()
+}
+
+func TestManualEHC_TickOrder(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithLinger(10*time.Second))
+	e.Count("a")
+	e.Tick(time.Second)
+	e.Count("b")
+
+	// a expires at 1m and lingers until 1m10s, b expires at 1m1s and
+	// lingers until 1m11s
+	e.Tick(65 * time.Second)
+	values, locker := e.Values()
+	if _, ok := values["a"]; !ok || values["b"] == nil {
+		t.Errorf("EHC.Values() = %v, want a and b lingering", values)
+	}
+	locker.Unlock()
+
+	e.Tick(4 * time.Second)
+	values, locker = e.Values()
+	if _, ok := values["a"]; ok || values["b"] == nil {
+		t.Errorf("EHC.Values() = %v, want only b lingering", values)
+	}
+	locker.Unlock()
+}
+
+func TestManualEHC_Generations(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithGenerations(4), WithCoarseClock(time.Millisecond))
+	e.Count("k")
+	if v := e.value("k"); v != 1 {
+		t.Errorf("EHC count of k = %d, want 1", v)
+	}
+
+	e.MigrateTo()
+	e.Tick(30 * time.Second)
+	e.Count("k")
+	if v := e.value("k"); v != 2 {
+		t.Errorf("EHC count of k = %d after migrating, want 2", v)
+	}
+	e.Tick(time.Minute)
+	if v := e.value("k"); v != 0 {
+		t.Errorf("EHC count of k = %d after the window, want 0", v)
+	}
+}
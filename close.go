@@ -0,0 +1,133 @@
+package ehc
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// Close tears down the EHC: it cancels every pending expiration, stops the
+// timers and goroutines of the features in use, and drops all counts, so
+// that nothing keeps firing or holds on to the EHC once it is abandoned.
+// Calls to WaitAllow return ErrClosed.
+//
+// Afterwards Count and its variants do nothing, Reserve fails, committing or
+// cancelling an earlier reservation has no effect, and queries report zero.
+// The totals of the window in progress are never delivered to
+// OnWindowComplete, and MigrateTo does nothing. Close may be called more than
+// once, and always returns nil.
+func (e *EHC) Close() error {
+	now := e.clock.Now()
+
+	e.valueLock.Lock()
+	if e.closed {
+		e.valueLock.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.done)
+	e.drainLocked(now)
+	if e.expiry != nil {
+		e.expiry = e.config.newExpiry(e.window, now)
+	}
+	if e.wheel != nil {
+		e.wheel.stop()
+	}
+	e.stopCoarseClock()
+	runtime.SetFinalizer(e, nil)
+	e.valueLock.Unlock()
+
+	// the trackers are created lazily; using up their Once keeps them
+	// from being created from now on, so they are nil if never used
+	e.pairsOnce.Do(func() {})
+	if e.pairs != nil {
+		e.pairs.close()
+	}
+	e.slotsOnce.Do(func() {})
+	if e.slots != nil {
+		e.slots.close()
+	}
+	if t, _ := e.totals.Load().(*windowTotals); t != nil {
+		t.close()
+	}
+	if b, _ := e.errorBudgets.Load().(*errorBudgets); b != nil {
+		b.close()
+	}
+	if t, _ := e.thresholds.Load().(*thresholds); t != nil {
+		t.close()
+	}
+	if sites, _ := e.sites.Load().(*callSites); sites != nil {
+		sites.counts.Close()
+	}
+	if g, _ := e.global.Load().(*globalTotal); g != nil {
+		g.counts.Close()
+	}
+	e.blocks.mu.Lock()
+	e.blocks.until = nil
+	e.blocks.mu.Unlock()
+	e.sample.Store((*aliasTable)(nil))
+	e.hot.Store((*hotKeyTable)(nil))
+	return nil
+}
+
+// stop cancels the timer and forgets every scheduled retraction.
+func (w *wheel) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.levels = [wheelLevels]*wheelLevel{}
+	atomic.StoreInt64(&w.pending, 0)
+	w.armed = -1
+}
+
+func (p *pairTracker) close() {
+	p.pairs.Close()
+	p.mu.Lock()
+	p.keys = map[interface{}]*subState{}
+	p.mu.Unlock()
+}
+
+func (t *slotTracker) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for _, s := range t.keys {
+		s.timer.Stop()
+	}
+	t.keys = map[interface{}]*slotSet{}
+}
+
+// close drops the totals not yet delivered and cancels the retries of the
+// sinks.
+func (t *windowTotals) close() {
+	t.mu.Lock()
+	t.closed = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.counts = map[interface{}]int64{}
+	t.ended = nil
+	sinks := t.sinks
+	t.mu.Unlock()
+
+	for _, s := range sinks {
+		s.mu.Lock()
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.pending = nil
+		s.mu.Unlock()
+	}
+}
+
+func (b *errorBudgets) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.armed = false
+	b.allowed = map[interface{}]int64{}
+	b.exhausted = map[interface{}]bool{}
+}
@@ -0,0 +1,86 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_Close(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"timer":       nil,
+		"generations": {WithGenerations(4)},
+		"buckets":     {WithBuckets(4)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := NewManualEHC(time.Minute, time.Unix(0, 0), opts...)
+			delivered := 0
+			e.OnWindowComplete(func(interface{}, int64, time.Time, time.Time) {
+				delivered++
+			})
+			e.Budget("k", 1)
+			e.CountMultiple("k", 3)
+			e.CountPair("k", "a")
+			e.MarkSlot("k", 1)
+			r, ok := e.Reserve("k", 1, 10)
+			if !ok {
+				t.Fatal("EHC.Reserve() failed before Close")
+			}
+
+			if err := e.Close(); err != nil {
+				t.Fatalf("EHC.Close() = %v", err)
+			}
+			if n := len(e.clock.timers); n != 0 {
+				t.Errorf("%d timers pending after Close, want 0", n)
+			}
+			if v := e.value("k"); v != 0 {
+				t.Errorf("EHC count of k = %d after Close, want 0", v)
+			}
+
+			e.Count("k")
+			e.CountPair("k", "b")
+			e.MarkSlot("k", 2)
+			r.Commit()
+			if _, ok := e.Reserve("k", 1, 10); ok {
+				t.Error("EHC.Reserve() succeeded after Close")
+			}
+			e.MigrateTo()
+			e.Tick(time.Hour)
+
+			if v := e.value("k"); v != 0 {
+				t.Errorf("EHC count of k = %d after counting a closed EHC, want 0", v)
+			}
+			if n := e.SubCardinality("k"); n != 0 {
+				t.Errorf("EHC.SubCardinality(k) = %d after Close, want 0", n)
+			}
+			if n := e.SlotCount("k"); n != 0 {
+				t.Errorf("EHC.SlotCount(k) = %d after Close, want 0", n)
+			}
+			if e.Exhausted("k") {
+				t.Error("EHC.Exhausted(k) = true after Close")
+			}
+			if delivered != 0 {
+				t.Errorf("%d window totals delivered after Close, want 0", delivered)
+			}
+			if err := e.Close(); err != nil {
+				t.Errorf("second EHC.Close() = %v", err)
+			}
+		})
+	}
+}
+
+func TestEHC_CloseUnused(t *testing.T) {
+	e := NewEHC(time.Minute, WithCoarseClock(time.Millisecond))
+	e.Close()
+	e.Count("k")
+	if n := e.SubCardinality("k"); n != 0 {
+		t.Errorf("EHC.SubCardinality(k) = %d after Close, want 0", n)
+	}
+	if n := e.SlotCount("k"); n != 0 {
+		t.Errorf("EHC.SlotCount(k) = %d after Close, want 0", n)
+	}
+	select {
+	case <-e.coarse.done:
+	default:
+		t.Error("coarse clock still running after Close")
+	}
+}
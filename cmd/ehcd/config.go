@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coder543/ehc"
+	"github.com/coder543/ehc/ehcguard"
+)
+
+// config is what ehcd serves, as given with flags or read from the JSON file
+// given with -config, e.g.
+//
+//	{
+//		"addr": ":7070",
+//		"data": "/var/lib/ehcd",
+//		"admin": true,
+//		"instances": [{
+//			"name": "api",
+//			"window": "1m",
+//			"backend": "buckets",
+//			"buckets": 60,
+//			"limit": 100,
+//			"limits": {"batch": 1000},
+//			"rules": [{"name": "hot key", "metric": "concentration", "threshold": 0.5}],
+//			"statsd": {"addr": "localhost:8125", "prefix": "ehcd.api"}
+//		}]
+//	}
+//
+// Durations are given as by time.ParseDuration.
+type config struct {
+	// Addr is the address to serve on.
+	Addr string `json:"addr"`
+	// Instances are the EHCs to serve.
+	Instances []instanceConfig `json:"instances"`
+	// Data is the directory the counts are saved in, or "" not to save
+	// them, every SaveInterval.
+	Data         string   `json:"data"`
+	SaveInterval duration `json:"save"`
+	// ID names this ehcd to its Peers, the base URLs of the other ehcd
+	// to share counts with every GossipInterval, or a tenth of the window
+	// if 0.
+	ID             string   `json:"id"`
+	Peers          []string `json:"peers"`
+	GossipInterval duration `json:"gossip"`
+	// Admin serves the admin pages of every instance.
+	Admin bool `json:"admin"`
+	// Token, if set, is required as a bearer token by the gossip and
+	// admin pages, and sent to the Peers, which must share it. The other
+	// pages stay open.
+	Token string `json:"token"`
+}
+
+// instanceConfig is an EHC to serve.
+type instanceConfig struct {
+	Name   string   `json:"name"`
+	Window duration `json:"window"`
+
+	// Backend is how counts expire: "timer", the default, "buckets",
+	// "compact", "generations" or "decay", as set by WithBuckets,
+	// WithCompactBuckets, WithGenerations and WithExponentialDecay with
+	// Buckets, Generations and HalfLife.
+	Backend     string   `json:"backend"`
+	Buckets     int      `json:"buckets"`
+	Generations int      `json:"generations"`
+	HalfLife    duration `json:"halfLife"`
+	// Resolution, Shards and MaxMemory are passed to WithResolution,
+	// WithShards and WithMaxMemory if set.
+	Resolution duration `json:"resolution"`
+	Shards     int      `json:"shards"`
+	MaxMemory  int64    `json:"maxMemory"`
+
+	// Limit is the limit of allow requests that don't give one, unless
+	// the key has one in Limits. GlobalLimit is passed to
+	// WithGlobalLimit.
+	Limit       int64            `json:"limit"`
+	Limits      map[string]int64 `json:"limits"`
+	GlobalLimit int64            `json:"globalLimit"`
+
+	// Rules watch the counts made with count requests, which block the
+	// keys breaking them from allow requests. Those not on single keys
+	// are checked every CheckInterval, a tenth of the window by default.
+	Rules         []ruleConfig `json:"rules"`
+	CheckInterval duration     `json:"check"`
+
+	// StatsD and Graphite export the counts, with ehcexport.
+	StatsD   *statsDConfig   `json:"statsd"`
+	Graphite *graphiteConfig `json:"graphite"`
+}
+
+// ruleConfig is an ehcguard.Rule, which logs its violations.
+type ruleConfig struct {
+	Name string `json:"name"`
+	// Metric is "key count", "new key rate" or "concentration".
+	Metric    string  `json:"metric"`
+	Threshold float64 `json:"threshold"`
+	// Block blocks the offending key for as long, if set, escalating
+	// the blocks of repeat offenders with Escalation.
+	Block      duration          `json:"block"`
+	Escalation *escalationConfig `json:"escalation"`
+}
+
+// escalationConfig is an ehcguard.Escalation.
+type escalationConfig struct {
+	Window duration `json:"window"`
+	Base   duration `json:"base"`
+	Factor float64  `json:"factor"`
+	Max    duration `json:"max"`
+}
+
+// statsDConfig is an ehcexport.StatsDConfig.
+type statsDConfig struct {
+	Addr     string   `json:"addr"`
+	Prefix   string   `json:"prefix"`
+	Interval duration `json:"interval"`
+	Deltas   bool     `json:"deltas"`
+}
+
+// graphiteConfig is an ehcexport.GraphiteConfig.
+type graphiteConfig struct {
+	Addr     string   `json:"addr"`
+	Prefix   string   `json:"prefix"`
+	Interval duration `json:"interval"`
+}
+
+// duration is a time.Duration given as by time.ParseDuration, in flags and
+// in the config file.
+type duration time.Duration
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d *duration) UnmarshalText(b []byte) error {
+	return d.Set(string(b))
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// loadConfig reads and validates the config file at path.
+func loadConfig(path string) (config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config{}, err
+	}
+	var cfg config
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = ":7070"
+	}
+	if cfg.SaveInterval == 0 {
+		cfg.SaveInterval = duration(time.Minute)
+	}
+	if cfg.ID == "" {
+		cfg.ID, _ = os.Hostname()
+	}
+	if err := cfg.validate(); err != nil {
+		return config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// validate reports the first mistake in cfg.
+func (cfg *config) validate() error {
+	names := map[string]bool{}
+	for _, ic := range cfg.Instances {
+		if err := ic.validate(); err != nil {
+			return err
+		}
+		if names[ic.Name] {
+			return fmt.Errorf("instance %s defined twice", ic.Name)
+		}
+		names[ic.Name] = true
+	}
+	if len(cfg.Peers) > 0 && cfg.ID == "" {
+		return errors.New("peers need an id")
+	}
+	return nil
+}
+
+func (ic *instanceConfig) validate() error {
+	if ic.Name == "" || strings.Contains(ic.Name, "/") || ic.Name == "metrics" {
+		return fmt.Errorf("bad instance name %q", ic.Name)
+	}
+	if err := ic.check(); err != nil {
+		return fmt.Errorf("instance %s: %w", ic.Name, err)
+	}
+	return nil
+}
+
+func (ic *instanceConfig) check() error {
+	if ic.Window <= 0 {
+		return errors.New("window must be positive")
+	}
+	switch ic.Backend {
+	case "", "timer", "decay":
+	case "buckets", "compact":
+		if ic.Buckets <= 0 {
+			return fmt.Errorf("backend %s needs buckets", ic.Backend)
+		}
+	case "generations":
+		if ic.Generations <= 0 {
+			return errors.New("backend generations needs generations")
+		}
+	default:
+		return fmt.Errorf("unknown backend %q", ic.Backend)
+	}
+	if ic.Limit < 0 || ic.GlobalLimit < 0 || ic.MaxMemory < 0 || ic.Shards < 0 {
+		return errors.New("limits, maxMemory and shards can't be negative")
+	}
+	for key, limit := range ic.Limits {
+		if limit < 0 {
+			return fmt.Errorf("negative limit for %q", key)
+		}
+	}
+	for _, rc := range ic.Rules {
+		if _, err := rc.metric(); err != nil {
+			return fmt.Errorf("rule %q: %w", rc.Name, err)
+		}
+		if esc := rc.Escalation; esc != nil && (esc.Window <= 0 || esc.Base <= 0) {
+			return fmt.Errorf("rule %q: escalation needs a window and a base", rc.Name)
+		}
+	}
+	if ic.StatsD != nil && ic.StatsD.Addr == "" {
+		return errors.New("statsd needs an addr")
+	}
+	if ic.Graphite != nil && ic.Graphite.Addr == "" {
+		return errors.New("graphite needs an addr")
+	}
+	return nil
+}
+
+// options returns the options of the EHC of ic.
+func (ic *instanceConfig) options() []ehc.Option {
+	var opts []ehc.Option
+	switch ic.Backend {
+	case "buckets":
+		opts = append(opts, ehc.WithBuckets(ic.Buckets))
+	case "compact":
+		opts = append(opts, ehc.WithCompactBuckets(ic.Buckets))
+	case "generations":
+		opts = append(opts, ehc.WithGenerations(ic.Generations))
+	case "decay":
+		opts = append(opts, ehc.WithExponentialDecay(time.Duration(ic.HalfLife)))
+	}
+	if ic.Resolution > 0 {
+		opts = append(opts, ehc.WithResolution(time.Duration(ic.Resolution)))
+	}
+	if ic.Shards > 0 {
+		opts = append(opts, ehc.WithShards(ic.Shards))
+	}
+	if ic.MaxMemory > 0 {
+		opts = append(opts, ehc.WithMaxMemory(ic.MaxMemory))
+	}
+	if ic.GlobalLimit > 0 {
+		opts = append(opts, ehc.WithGlobalLimit(ic.GlobalLimit))
+	}
+	return opts
+}
+
+// metric returns the metric the rule watches.
+func (rc *ruleConfig) metric() (ehcguard.Metric, error) {
+	for _, m := range []ehcguard.Metric{ehcguard.KeyCount, ehcguard.NewKeyRate, ehcguard.Concentration} {
+		if rc.Metric == m.String() {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown metric %q", rc.Metric)
+}
+
+// rules returns the rules of ic, acting on e.
+func (ic *instanceConfig) rules(e *ehc.EHC) []ehcguard.Rule {
+	var rules []ehcguard.Rule
+	for _, rc := range ic.Rules {
+		m, _ := rc.metric()
+		r := ehcguard.Rule{
+			Name:      rc.Name,
+			Metric:    m,
+			Threshold: rc.Threshold,
+			Actions:   []ehcguard.Action{ehcguard.LogAction(nil)},
+		}
+		if rc.Block > 0 {
+			r.Actions = append(r.Actions, ehcguard.BlockAction(e, time.Duration(rc.Block)))
+		}
+		if esc := rc.Escalation; esc != nil {
+			r.Escalation = &ehcguard.Escalation{
+				Window: time.Duration(esc.Window),
+				Base:   time.Duration(esc.Base),
+				Factor: esc.Factor,
+				Max:    time.Duration(esc.Max),
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
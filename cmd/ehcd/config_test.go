@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, path, s string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ehcd.json")
+	writeConfig(t, path, `{
+		"id": "a",
+		"peers": ["http://b:7070"],
+		"instances": [{
+			"name": "api",
+			"window": "1m",
+			"backend": "buckets",
+			"buckets": 60,
+			"limit": 100,
+			"limits": {"batch": 1000},
+			"rules": [{"name": "hot", "metric": "concentration", "threshold": 0.5, "block": "10m"}],
+			"statsd": {"addr": "localhost:8125", "interval": "5s"}
+		}]
+	}`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":7070" || cfg.SaveInterval != duration(time.Minute) {
+		t.Errorf("defaults: addr %q, save %v", cfg.Addr, cfg.SaveInterval)
+	}
+	ic := cfg.Instances[0]
+	if ic.Window != duration(time.Minute) || ic.Buckets != 60 || ic.Limits["batch"] != 1000 ||
+		ic.Rules[0].Block != duration(10*time.Minute) || ic.StatsD.Interval != duration(5*time.Second) {
+		t.Errorf("instance = %+v", ic)
+	}
+	if n := len(ic.options()); n != 1 {
+		t.Errorf("%d options, want 1", n)
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ehcd.json")
+	for _, tt := range []struct{ config, err string }{
+		{`{"instances": [{"name": "a", "window": "1m"}]`, "unexpected EOF"},
+		{`{"instances": [{"name": "a", "window": "1m", "windw": "1h"}]}`, "unknown field"},
+		{`{"instances": [{"name": "a", "window": "soon"}]}`, "invalid duration"},
+		{`{"instances": [{"name": "a"}]}`, "window must be positive"},
+		{`{"instances": [{"name": "a/b", "window": "1m"}]}`, "bad instance name"},
+		{`{"instances": [{"name": "a", "window": "1m"}, {"name": "a", "window": "1h"}]}`, "defined twice"},
+		{`{"instances": [{"name": "a", "window": "1m", "backend": "lru"}]}`, "unknown backend"},
+		{`{"instances": [{"name": "a", "window": "1m", "backend": "buckets"}]}`, "needs buckets"},
+		{`{"instances": [{"name": "a", "window": "1m", "limits": {"k": -1}}]}`, "negative limit"},
+		{`{"instances": [{"name": "a", "window": "1m", "rules": [{"metric": "speed"}]}]}`, "unknown metric"},
+		{`{"instances": [{"name": "a", "window": "1m", "rules": [{"metric": "key count", "escalation": {}}]}]}`, "escalation needs"},
+		{`{"instances": [{"name": "a", "window": "1m", "graphite": {}}]}`, "graphite needs an addr"},
+	} {
+		writeConfig(t, path, tt.config)
+		if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("loadConfig(%s) = %v, want %q", tt.config, err, tt.err)
+		}
+	}
+}
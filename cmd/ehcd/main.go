@@ -0,0 +1,197 @@
+// Command ehcd serves expiring hash counters over HTTP, so that services can
+// share counts and rate limits without embedding the ehc package, or
+// writing a server around it.
+//
+// Usage:
+//
+//	ehcd [flags]
+//
+// Each instance given with -instance is an EHC with a window of its own,
+// served under /name/:
+//
+//	POST /name/count?key=k&n=1      count k n times, for n of at least 1,
+//	                                answering its new count
+//	POST /name/allow?key=k&limit=l  count k if that keeps it within l, or
+//	                                its configured limit, answering 429 Too
+//	                                Many Requests if not or if k is blocked
+//	GET  /name/...                  the pages of ehchttp.NewReadHandler
+//	/name/admin/...                 those of ehchttp.NewAdminHandler, with
+//	                                -admin only
+//	POST /name/gossip               the gossip of other ehcd, with -peers
+//
+// and GET /metrics serves the counts of every instance in the Prometheus text
+// format, as ehc_count{instance="name",key="k"}. With -peers, the instances
+// share their counts with the instances of the same name on the other ehcd
+// by ehcgossip, and count, allow and /metrics answer with the counts of the
+// whole fleet; gRPC isn't offered, as it would take dependencies the module
+// doesn't. With -token, the gossip and admin pages require the header
+// "Authorization: Bearer token", which ehcd sends its peers; without it,
+// they're open to anyone who can reach ehcd. The count, allow and read pages
+// and /metrics stay open either way, so ehcd should only be reachable by the
+// services it counts for.
+//
+// With -data, the counts of every instance are saved to a file in that
+// directory every -save interval and on shutdown, and restored when ehcd
+// starts, so that restarts don't reset rate limits.
+//
+// Everything else, from the backend of each instance to its limits, rules
+// and exporters, is set in the JSON file given with -config, which takes the
+// place of the other flags; see config for its schema. On SIGHUP, ehcd
+// reads the file again and applies it without dropping any counts, except
+// for the address, data directory and save interval, which take a restart.
+// YAML and TOML aren't read, as the module takes no dependencies.
+//
+// For terminals without a browser for the dashboard page,
+//
+//	ehcd top [-n 20] [-prefix p] http://host:7070/name
+//
+// follows the stream page of an instance and shows its highest counts,
+// refreshing as they change.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// instanceFlags collects the -instance flags.
+type instanceFlags []instanceConfig
+
+func (f *instanceFlags) String() string {
+	var s []string
+	for _, c := range *f {
+		s = append(s, c.Name+"="+c.Window.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *instanceFlags) Set(v string) error {
+	name, window, ok := strings.Cut(v, "=")
+	if !ok {
+		return errors.New("want name=window")
+	}
+	var d duration
+	if err := d.Set(window); err != nil {
+		return err
+	}
+	*f = append(*f, instanceConfig{Name: name, Window: d})
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "top" {
+		if err := topMain(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var (
+		cfg       = config{SaveInterval: duration(time.Minute)}
+		instances instanceFlags
+		peers     string
+		file      string
+	)
+	flag.StringVar(&file, "config", "", "JSON file to read the config from, in place of the other flags, and to reload it from on SIGHUP")
+	flag.StringVar(&cfg.Addr, "addr", ":7070", "address to serve on")
+	flag.Var(&instances, "instance", "an instance as name=window, e.g. api=1m; repeatable, default=1m by default")
+	flag.StringVar(&cfg.Data, "data", "", "directory to save the counts in, if any")
+	flag.Var(&cfg.SaveInterval, "save", "how often to save the counts with -data")
+	flag.StringVar(&cfg.ID, "id", "", "name of this ehcd among its peers, its hostname by default")
+	flag.StringVar(&peers, "peers", "", "comma-separated base URLs of the other ehcd to share counts with, e.g. http://ehcd-2:7070")
+	flag.Var(&cfg.GossipInterval, "gossip", "how often to share counts with -peers, a tenth of the window by default")
+	flag.BoolVar(&cfg.Admin, "admin", false, "serve the admin pages, which can expire and block keys")
+	flag.StringVar(&cfg.Token, "token", "", "bearer token the gossip and admin pages require, and that is sent to -peers")
+	flag.Parse()
+
+	if file != "" {
+		var err error
+		if cfg, err = loadConfig(file); err != nil {
+			log.Fatal(err)
+		}
+		if err := run(cfg, file); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	cfg.Instances = instances
+	if len(cfg.Instances) == 0 {
+		cfg.Instances = []instanceConfig{{Name: "default", Window: duration(time.Minute)}}
+	}
+	if peers != "" {
+		cfg.Peers = strings.Split(peers, ",")
+	}
+	if cfg.ID == "" {
+		cfg.ID, _ = os.Hostname()
+	}
+
+	if err := run(cfg, ""); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves cfg until interrupted, reloading it from file, if given, on
+// SIGHUP.
+func run(cfg config, file string) error {
+	s, err := newServer(cfg)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: cfg.Addr, Handler: s}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	log.Printf("ehcd serving %d instances on %s", len(cfg.Instances), cfg.Addr)
+	if cfg.Token == "" && (cfg.Admin || len(cfg.Peers) > 0) {
+		log.Printf("ehcd: anyone who can reach %s can use the gossip and admin pages; set a token", cfg.Addr)
+	}
+
+	hup := make(chan os.Signal, 1)
+	if file != "" {
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
+	for err == nil {
+		select {
+		case err = <-errc:
+		case <-ctx.Done():
+			shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = srv.Shutdown(shutdown)
+			cancel()
+			if err == nil {
+				err = http.ErrServerClosed
+			}
+		case <-hup:
+			next, lerr := loadConfig(file)
+			if lerr == nil {
+				lerr = s.reload(next)
+			}
+			if lerr != nil {
+				log.Printf("ehcd: reloading: %v", lerr)
+			} else {
+				log.Printf("ehcd: reloaded %s", file)
+			}
+		}
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("ehcd: %w", err)
+	}
+	return nil
+}
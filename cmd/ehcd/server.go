@@ -0,0 +1,560 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder543/ehc"
+	"github.com/coder543/ehc/ehcexport"
+	"github.com/coder543/ehc/ehcgossip"
+	"github.com/coder543/ehc/ehcguard"
+	"github.com/coder543/ehc/ehchttp"
+)
+
+// server serves the instances of a config.
+type server struct {
+	// cfg is only changed by reload, under reloadMu.
+	cfg      config
+	reloadMu sync.Mutex
+
+	mu        sync.RWMutex
+	instances map[string]*instance
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// instance is a served EHC. It is replaced whole when its config changes, so
+// that requests see it either before or after.
+type instance struct {
+	name string
+	cfg  instanceConfig
+	e    *ehc.EHC
+	// node shares the counts with the peers, if there are any.
+	node *ehcgossip.Node
+	// guard enforces the rules, if there are any, until stop is
+	// closed.
+	guard *ehcguard.Guard
+	stop  chan struct{}
+
+	statsd   *ehcexport.StatsD
+	graphite *ehcexport.Graphite
+	read     http.Handler
+	admin    http.Handler
+}
+
+// newServer starts the instances of cfg, restoring their counts from
+// cfg.Data.
+func newServer(cfg config) (*server, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	s := &server{
+		cfg:       cfg,
+		instances: map[string]*instance{},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, ic := range cfg.Instances {
+		in, err := s.start(ic)
+		if err != nil {
+			s.closeInstances()
+			return nil, err
+		}
+		s.instances[ic.Name] = in
+	}
+	go s.saveLoop()
+	return s, nil
+}
+
+// start creates the EHC of ic, restoring its counts.
+func (s *server) start(ic instanceConfig) (*instance, error) {
+	e := ehc.NewEHC(time.Duration(ic.Window), ic.options()...)
+	if s.cfg.Data != "" {
+		if err := load(e, snapshotPath(s.cfg.Data, ic.Name)); err != nil {
+			e.Close()
+			return nil, fmt.Errorf("instance %s: %w", ic.Name, err)
+		}
+	}
+	in, err := s.attach(ic, e)
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	return in, nil
+}
+
+// attach returns an instance of ic serving e, which is already configured.
+func (s *server) attach(ic instanceConfig, e *ehc.EHC) (*instance, error) {
+	in := &instance{name: ic.Name, cfg: ic, e: e}
+	logErr := func(what string) func(error) {
+		return func(err error) { log.Printf("instance %s: %s: %v", ic.Name, what, err) }
+	}
+	if len(s.cfg.Peers) > 0 {
+		peers := make([]string, len(s.cfg.Peers))
+		for i, p := range s.cfg.Peers {
+			peers[i] = strings.TrimSuffix(p, "/") + "/" + ic.Name + "/gossip"
+		}
+		opts := []ehcgossip.Option{ehcgossip.WithErrorHandler(logErr("gossip"))}
+		if s.cfg.GossipInterval > 0 {
+			opts = append(opts, ehcgossip.WithInterval(time.Duration(s.cfg.GossipInterval)))
+		}
+		var transport ehcgossip.HTTPTransport
+		if s.cfg.Token != "" {
+			transport.Header = http.Header{"Authorization": {"Bearer " + s.cfg.Token}}
+		}
+		in.node = ehcgossip.New(e, s.cfg.ID, peers, transport, opts...)
+	}
+
+	var debugOpts []ehchttp.DebugOption
+	if len(ic.Rules) > 0 {
+		in.guard = ehcguard.New(e, ic.rules(e)...)
+		in.stop = make(chan struct{})
+		interval := time.Duration(ic.CheckInterval)
+		if interval <= 0 {
+			interval = time.Duration(ic.Window) / 10
+		}
+		go in.checkLoop(interval)
+		debugOpts = append(debugOpts, ehchttp.WithRules(in.guard))
+	}
+
+	if c := ic.StatsD; c != nil {
+		statsd, err := ehcexport.NewStatsD(e, ehcexport.StatsDConfig{
+			Addr:         c.Addr,
+			Prefix:       c.Prefix,
+			Interval:     time.Duration(c.Interval),
+			Deltas:       c.Deltas,
+			ErrorHandler: logErr("statsd"),
+		})
+		if err != nil {
+			in.detach()
+			return nil, fmt.Errorf("instance %s: %w", ic.Name, err)
+		}
+		in.statsd = statsd
+	}
+	if c := ic.Graphite; c != nil {
+		in.graphite = ehcexport.NewGraphite(e, ehcexport.GraphiteConfig{
+			Addr:         c.Addr,
+			Prefix:       c.Prefix,
+			Interval:     time.Duration(c.Interval),
+			ErrorHandler: logErr("graphite"),
+		})
+	}
+
+	in.read = ehchttp.NewReadHandler(e, debugOpts...)
+	if s.cfg.Admin {
+		in.admin = ehchttp.NewAdminHandler(e, debugOpts...)
+	}
+	return in, nil
+}
+
+// checkLoop checks the rules every interval, until in is detached.
+func (in *instance) checkLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-in.stop:
+			return
+		case <-ticker.C:
+			in.guard.Check()
+		}
+	}
+}
+
+// detach stops what serves the EHC of the instance, but not the EHC.
+func (in *instance) detach() {
+	if in.node != nil {
+		in.node.Close()
+	}
+	if in.stop != nil {
+		close(in.stop)
+	}
+	if in.statsd != nil {
+		in.statsd.Close()
+	}
+	if in.graphite != nil {
+		in.graphite.Close()
+	}
+}
+
+// close stops the instance.
+func (in *instance) close() {
+	in.detach()
+	in.e.Close()
+}
+
+// snapshotPath returns the file in dir the counts of the instance name are
+// saved in.
+func snapshotPath(dir, name string) string {
+	return filepath.Join(dir, name+".snapshot")
+}
+
+// load restores the counts saved at path into e, if any.
+func load(e *ehc.EHC, path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return e.LoadSnapshot(f)
+}
+
+// save saves the counts in dir, replacing the previous save atomically.
+func (in *instance) save(dir string) error {
+	f, err := os.CreateTemp(dir, in.name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := in.e.SaveSnapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), snapshotPath(dir, in.name))
+}
+
+// reload applies cfg, which must be valid, to the running server. Instances
+// that are new to cfg are started, those gone from it saved and closed, and
+// those whose config changed rebuilt in place, keeping their counts: with
+// MigrateTo if their window stayed the same, or by way of a snapshot if not.
+// Addr, Data and SaveInterval only take effect on restart.
+func (s *server) reload(cfg config) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if cfg.Addr != s.cfg.Addr || cfg.Data != s.cfg.Data || cfg.SaveInterval != s.cfg.SaveInterval || cfg.Token != s.cfg.Token {
+		log.Printf("ehcd: addr, data, save and token only change on restart")
+	}
+	shared := cfg.ID != s.cfg.ID || cfg.GossipInterval != s.cfg.GossipInterval ||
+		cfg.Admin != s.cfg.Admin || !reflect.DeepEqual(cfg.Peers, s.cfg.Peers)
+	s.cfg.ID, s.cfg.Peers, s.cfg.GossipInterval, s.cfg.Admin = cfg.ID, cfg.Peers, cfg.GossipInterval, cfg.Admin
+	s.cfg.Instances = cfg.Instances
+
+	var first error
+	keep := map[string]bool{}
+	for _, ic := range cfg.Instances {
+		keep[ic.Name] = true
+		s.mu.RLock()
+		old := s.instances[ic.Name]
+		s.mu.RUnlock()
+		if old != nil && !shared && reflect.DeepEqual(old.cfg, ic) {
+			continue
+		}
+		in, err := s.rebuild(old, ic)
+		if err != nil {
+			log.Printf("ehcd: %v", err)
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		s.mu.Lock()
+		s.instances[ic.Name] = in
+		s.mu.Unlock()
+		if old != nil {
+			if old.e == in.e {
+				old.detach()
+			} else {
+				old.close()
+			}
+		}
+	}
+
+	for _, in := range s.snapshot() {
+		if keep[in.name] {
+			continue
+		}
+		s.mu.Lock()
+		delete(s.instances, in.name)
+		s.mu.Unlock()
+		if s.cfg.Data != "" {
+			if err := in.save(s.cfg.Data); err != nil {
+				log.Printf("instance %s: saving: %v", in.name, err)
+			}
+		}
+		in.close()
+	}
+	return first
+}
+
+// rebuild returns an instance of ic carrying on the counts of old, if it
+// isn't nil.
+func (s *server) rebuild(old *instance, ic instanceConfig) (*instance, error) {
+	switch {
+	case old == nil:
+		return s.start(ic)
+	case old.cfg.Window == ic.Window:
+		old.e.MigrateTo(ic.options()...)
+		return s.attach(ic, old.e)
+	}
+	var buf bytes.Buffer
+	if err := old.e.SaveSnapshot(&buf); err != nil {
+		return nil, fmt.Errorf("instance %s: %w", ic.Name, err)
+	}
+	e := ehc.NewEHC(time.Duration(ic.Window), ic.options()...)
+	if err := e.LoadSnapshot(&buf); err != nil {
+		e.Close()
+		return nil, fmt.Errorf("instance %s: %w", ic.Name, err)
+	}
+	in, err := s.attach(ic, e)
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	return in, nil
+}
+
+func (s *server) saveLoop() {
+	defer close(s.done)
+	if s.cfg.Data == "" || s.cfg.SaveInterval <= 0 {
+		<-s.stop
+		return
+	}
+	ticker := time.NewTicker(time.Duration(s.cfg.SaveInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.save()
+		}
+	}
+}
+
+// save saves the counts of every instance, reporting the first error.
+func (s *server) save() error {
+	if s.cfg.Data == "" {
+		return nil
+	}
+	var first error
+	for _, in := range s.snapshot() {
+		if err := in.save(s.cfg.Data); err != nil {
+			log.Printf("instance %s: saving: %v", in.name, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// snapshot returns the instances sorted by name.
+func (s *server) snapshot() []*instance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	instances := make([]*instance, 0, len(s.instances))
+	for _, in := range s.instances {
+		instances = append(instances, in)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].name < instances[j].name })
+	return instances
+}
+
+// Close saves the counts and stops every instance.
+func (s *server) Close() error {
+	close(s.stop)
+	<-s.done
+	err := s.save()
+	s.closeInstances()
+	return err
+}
+
+func (s *server) closeInstances() {
+	for _, in := range s.snapshot() {
+		in.close()
+	}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if name == "metrics" && rest == "" {
+		s.serveMetrics(w)
+		return
+	}
+	s.mu.RLock()
+	in := s.instances[name]
+	s.mu.RUnlock()
+	if in == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	page, _, _ := strings.Cut(rest, "/")
+	switch page {
+	case "count", "allow":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if page == "count" {
+			in.serveCount(w, r)
+		} else {
+			in.serveAllow(w, r)
+		}
+	case "gossip":
+		if !s.authorized(w, r) {
+			return
+		}
+		if in.node == nil {
+			http.NotFound(w, r)
+			return
+		}
+		in.node.ServeHTTP(w, r)
+	case "admin":
+		if !s.authorized(w, r) {
+			return
+		}
+		if in.admin == nil {
+			http.NotFound(w, r)
+			return
+		}
+		http.StripPrefix("/"+name+"/admin", in.admin).ServeHTTP(w, r)
+	default:
+		http.StripPrefix("/"+name, in.read).ServeHTTP(w, r)
+	}
+}
+
+// authorized reports whether r bears the configured token, answering 401 if
+// not. Without a token, every request is authorized.
+func (s *server) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if s.cfg.Token == "" {
+		return true
+	}
+	got := []byte(r.Header.Get("Authorization"))
+	if subtle.ConstantTimeCompare(got, []byte("Bearer "+s.cfg.Token)) == 1 {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+	return false
+}
+
+// formInt returns the form value name as an int64, or def if it is empty.
+func formInt(r *http.Request, name string, def int64) (int64, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s %q", name, v)
+	}
+	return n, nil
+}
+
+// get returns the count of key, of the whole fleet if there are peers.
+func (in *instance) get(key string) int64 {
+	if in.node != nil {
+		return in.node.Get(key)
+	}
+	n, _ := in.e.Get(key)
+	return n
+}
+
+func (in *instance) serveCount(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	n, err := formInt(r, "n", 1)
+	if err == nil && n < 1 {
+		// a negative n would lower the count under every limit
+		err = fmt.Errorf("bad n %d, want at least 1", n)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if in.guard != nil {
+		in.guard.ObserveN(key, n)
+	} else {
+		in.e.CountMultiple(key, n)
+	}
+	fmt.Fprintln(w, in.get(key))
+}
+
+// limit returns the configured limit of key, or -1 if it has none.
+func (in *instance) limit(key string) int64 {
+	if limit, ok := in.cfg.Limits[key]; ok {
+		return limit
+	}
+	if in.cfg.Limit > 0 {
+		return in.cfg.Limit
+	}
+	return -1
+}
+
+func (in *instance) serveAllow(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	limit, err := formInt(r, "limit", in.limit(key))
+	if err == nil && limit < 0 {
+		err = errors.New("missing limit")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if in.e.IsBlocked(key) {
+		http.Error(w, "key blocked", http.StatusTooManyRequests)
+		return
+	}
+	var ok bool
+	if in.node != nil {
+		ok = in.node.Allow(key, limit)
+	} else {
+		ok = in.e.Allow(key, limit)
+	}
+	if !ok {
+		http.Error(w, "limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	io.WriteString(w, "allowed\n")
+}
+
+// serveMetrics writes the counts of every instance in the Prometheus text
+// format.
+func (s *server) serveMetrics(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	io.WriteString(w, "# HELP ehc_count Count of a key over the window of its instance.\n# TYPE ehc_count gauge\n")
+	for _, in := range s.snapshot() {
+		var counts map[interface{}]int64
+		if in.node != nil {
+			counts = in.node.Snapshot()
+		} else {
+			counts = in.e.Snapshot()
+		}
+		keys := make([]string, 0, len(counts))
+		byKey := make(map[string]int64, len(counts))
+		for k, n := range counts {
+			key := fmt.Sprint(k)
+			keys = append(keys, key)
+			byKey[key] += n
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "ehc_count{instance=\"%s\",key=\"%s\"} %d\n",
+				labelEscaper.Replace(in.name), labelEscaper.Replace(key), byKey[key])
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, cfg config) (*server, *httptest.Server) {
+	t.Helper()
+	s, err := newServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func do(t *testing.T, method, url string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestServer(t *testing.T) {
+	s, ts := newTestServer(t, config{Instances: []instanceConfig{
+		{Name: "api", Window: duration(time.Minute)},
+		{Name: "login", Window: duration(time.Hour)},
+	}})
+	defer s.Close()
+
+	if code, body := do(t, "POST", ts.URL+"/api/count?key=a&n=3"); code != 200 || body != "3\n" {
+		t.Errorf("count: %d %q, want 200 \"3\\n\"", code, body)
+	}
+	if code, _ := do(t, "GET", ts.URL+"/api/count?key=a"); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET count: %d, want 405", code)
+	}
+	if code, _ := do(t, "POST", ts.URL+"/api/count?key=a&n=x"); code != http.StatusBadRequest {
+		t.Errorf("bad n: %d, want 400", code)
+	}
+	for _, n := range []string{"0", "-1000000"} {
+		if code, _ := do(t, "POST", ts.URL+"/api/count?key=a&n="+n); code != http.StatusBadRequest {
+			t.Errorf("n=%s: %d, want 400", n, code)
+		}
+	}
+
+	for i, want := range []int{200, 200, 429} {
+		if code, _ := do(t, "POST", ts.URL+"/login/allow?key=bob&limit=2"); code != want {
+			t.Errorf("allow %d: %d, want %d", i, code, want)
+		}
+	}
+	if code, _ := do(t, "POST", ts.URL+"/login/allow?key=bob"); code != http.StatusBadRequest {
+		t.Errorf("allow without limit: %d, want 400", code)
+	}
+
+	if code, body := do(t, "GET", ts.URL+"/api/get?key=a"); code != 200 || !strings.Contains(body, "3") {
+		t.Errorf("read handler: %d %q", code, body)
+	}
+	if code, _ := do(t, "GET", ts.URL+"/api/admin/expire"); code != http.StatusNotFound {
+		t.Errorf("admin without -admin: %d, want 404", code)
+	}
+	if code, _ := do(t, "POST", ts.URL+"/api/gossip"); code != http.StatusNotFound {
+		t.Errorf("gossip without -peers: %d, want 404", code)
+	}
+	if code, _ := do(t, "POST", ts.URL+"/nope/count?key=a"); code != http.StatusNotFound {
+		t.Errorf("unknown instance: %d, want 404", code)
+	}
+
+	_, body := do(t, "GET", ts.URL+"/metrics")
+	for _, want := range []string{
+		"# TYPE ehc_count gauge\n",
+		`ehc_count{instance="api",key="a"} 3` + "\n",
+		`ehc_count{instance="login",key="bob"} 2` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestServer_BadConfig(t *testing.T) {
+	for _, cfg := range []config{
+		{Instances: []instanceConfig{{Name: "", Window: duration(time.Minute)}}},
+		{Instances: []instanceConfig{{Name: "a/b", Window: duration(time.Minute)}}},
+		{Instances: []instanceConfig{{Name: "metrics", Window: duration(time.Minute)}}},
+		{Instances: []instanceConfig{{Name: "a", Window: 0}}},
+		{Instances: []instanceConfig{{Name: "a", Window: duration(time.Minute)}, {Name: "a", Window: duration(time.Hour)}}},
+	} {
+		if s, err := newServer(cfg); err == nil {
+			s.Close()
+			t.Errorf("newServer(%+v) succeeded", cfg)
+		}
+	}
+}
+
+func TestServer_Persistence(t *testing.T) {
+	cfg := config{
+		Instances:    []instanceConfig{{Name: "api", Window: duration(time.Hour)}},
+		Data:         t.TempDir(),
+		SaveInterval: duration(time.Hour),
+	}
+	s, ts := newTestServer(t, cfg)
+	do(t, "POST", ts.URL+"/api/count?key=a&n=5")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, ts = newTestServer(t, cfg)
+	defer s.Close()
+	if code, body := do(t, "POST", ts.URL+"/api/count?key=a"); code != 200 || body != "6\n" {
+		t.Errorf("count after restart: %d %q, want 200 \"6\\n\"", code, body)
+	}
+}
+
+func TestServer_Peers(t *testing.T) {
+	// Each server needs the URL of the other before it is created, so
+	// route both through handlers swapped in afterwards.
+	var handlers [2]http.Handler
+	var urls [2]string
+	for i := range handlers {
+		i := i
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(ts.Close)
+		urls[i] = ts.URL
+	}
+	var servers [2]*server
+	for i := range servers {
+		s, err := newServer(config{
+			Instances:      []instanceConfig{{Name: "api", Window: duration(time.Hour)}},
+			ID:             urls[i],
+			Peers:          []string{urls[1-i]},
+			GossipInterval: duration(10 * time.Millisecond),
+			Token:          "secret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		servers[i], handlers[i] = s, s
+	}
+
+	do(t, "POST", urls[0]+"/api/count?key=a&n=2")
+	do(t, "POST", urls[1]+"/api/count?key=a&n=3")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, body := do(t, "GET", urls[0]+"/metrics")
+		if strings.Contains(body, `ehc_count{instance="api",key="a"} 5`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("counts never converged:\n%s", body)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestServer_Token(t *testing.T) {
+	s, ts := newTestServer(t, config{
+		Instances: []instanceConfig{{Name: "api", Window: duration(time.Hour)}},
+		ID:        "a",
+		Peers:     []string{"http://127.0.0.1:1"},
+		Admin:     true,
+		Token:     "secret",
+	})
+	defer s.Close()
+
+	for _, path := range []string{"/api/admin/", "/api/gossip"} {
+		for _, auth := range []string{"", "Bearer wrong", "secret"} {
+			req, _ := http.NewRequest("POST", ts.URL+path, nil)
+			if auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("%s with Authorization %q: %d, want 401", path, auth, resp.StatusCode)
+			}
+		}
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/api/admin/audit", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("admin with the token: %d, want 200", resp.StatusCode)
+	}
+	if code, _ := do(t, "POST", ts.URL+"/api/count?key=a"); code != 200 {
+		t.Errorf("count without the token: %d, want 200", code)
+	}
+}
+
+func TestServer_Limits(t *testing.T) {
+	s, ts := newTestServer(t, config{Instances: []instanceConfig{{
+		Name:   "api",
+		Window: duration(time.Hour),
+		Limit:  2,
+		Limits: map[string]int64{"vip": 3, "banned": 0},
+	}}})
+	defer s.Close()
+
+	for key, allowed := range map[string]int{"a": 2, "vip": 3, "banned": 0} {
+		n := 0
+		for i := 0; i < 5; i++ {
+			if code, _ := do(t, "POST", ts.URL+"/api/allow?key="+key); code == 200 {
+				n++
+			}
+		}
+		if n != allowed {
+			t.Errorf("%s: allowed %d times, want %d", key, n, allowed)
+		}
+	}
+	if code, _ := do(t, "POST", ts.URL+"/api/allow?key=b&limit=4"); code != 200 {
+		t.Errorf("explicit limit: %d, want 200", code)
+	}
+}
+
+func TestServer_Rules(t *testing.T) {
+	s, ts := newTestServer(t, config{Admin: true, Instances: []instanceConfig{{
+		Name:   "login",
+		Window: duration(time.Hour),
+		Limit:  100,
+		Rules: []ruleConfig{{
+			Name:      "brute force",
+			Metric:    "key count",
+			Threshold: 3,
+			Block:     duration(time.Hour),
+		}},
+	}}})
+	defer s.Close()
+
+	do(t, "POST", ts.URL+"/login/count?key=mallory&n=1000000000000")
+	if code, _ := do(t, "POST", ts.URL+"/login/allow?key=mallory"); code != http.StatusTooManyRequests {
+		t.Errorf("blocked key: %d, want 429", code)
+	}
+	if code, _ := do(t, "POST", ts.URL+"/login/allow?key=alice"); code != 200 {
+		t.Errorf("other key: %d, want 200", code)
+	}
+	if _, body := do(t, "GET", ts.URL+"/login/admin/rules"); !strings.Contains(body, "brute force") {
+		t.Errorf("rules page doesn't show the rule:\n%s", body)
+	}
+}
+
+func TestServer_Reload(t *testing.T) {
+	cfg := config{
+		Instances: []instanceConfig{
+			{Name: "api", Window: duration(time.Hour)},
+			{Name: "login", Window: duration(time.Hour)},
+			{Name: "old", Window: duration(time.Hour)},
+		},
+		Data: t.TempDir(),
+	}
+	s, ts := newTestServer(t, cfg)
+	defer s.Close()
+	for _, name := range []string{"api", "login", "old"} {
+		do(t, "POST", ts.URL+"/"+name+"/count?key=a&n=5")
+	}
+	login := s.instances["login"]
+
+	err := s.reload(config{
+		Instances: []instanceConfig{
+			// a new backend, migrated
+			{Name: "api", Window: duration(time.Hour), Backend: "buckets", Buckets: 10},
+			// unchanged
+			{Name: "login", Window: duration(time.Hour)},
+			// a new window, carried over by a snapshot
+			{Name: "new", Window: duration(2 * time.Hour)},
+		},
+		Data: cfg.Data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.instances["login"] != login {
+		t.Error("unchanged instance was rebuilt")
+	}
+	for name, want := range map[string]string{"api": "6\n", "login": "6\n", "new": "1\n"} {
+		if _, body := do(t, "POST", ts.URL+"/"+name+"/count?key=a"); body != want {
+			t.Errorf("%s: count = %q, want %q", name, body, want)
+		}
+	}
+	if code, _ := do(t, "POST", ts.URL+"/old/count?key=a"); code != http.StatusNotFound {
+		t.Errorf("removed instance: %d, want 404", code)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Data, "old.snapshot")); err != nil {
+		t.Errorf("removed instance wasn't saved: %v", err)
+	}
+
+	// a new window keeps the counts
+	if err := s.reload(config{
+		Instances: []instanceConfig{{Name: "api", Window: duration(2 * time.Hour)}},
+		Data:      cfg.Data,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, body := do(t, "POST", ts.URL+"/api/count?key=a"); body != "7\n" {
+		t.Errorf("after new window: count = %q, want \"7\\n\"", body)
+	}
+
+	// turning on the admin pages rebuilds every instance
+	if err := s.reload(config{
+		Instances: []instanceConfig{{Name: "api", Window: duration(2 * time.Hour)}},
+		Data:      cfg.Data,
+		Admin:     true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := do(t, "GET", ts.URL+"/api/admin/audit"); code == http.StatusNotFound {
+		t.Error("admin pages not served after reload")
+	}
+}
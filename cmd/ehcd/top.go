@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+)
+
+// topMain runs "ehcd top", a live view of the counts of an instance, for
+// terminals without a browser for its dashboard page.
+func topMain(args []string) error {
+	fs := flag.NewFlagSet("ehcd top", flag.ContinueOnError)
+	n := fs.Int("n", 20, "how many of the highest counts to show")
+	prefix := fs.String("prefix", "", "only show the keys starting with prefix")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ehcd top [flags] URL, e.g. ehcd top http://localhost:7070/api")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *n < 1 {
+		return fmt.Errorf("bad -n %d, want at least 1", *n)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("want the URL of an instance")
+	}
+	url := strings.TrimSuffix(fs.Arg(0), "/")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/stream", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+
+	err = follow(resp.Body, func(counts map[string]int64) {
+		// clear the screen and draw from its top
+		io.WriteString(os.Stdout, "\x1b[H\x1b[2J")
+		fmt.Fprintf(os.Stdout, "%s\t%s\n\n", url, time.Now().Format(time.TimeOnly))
+		render(os.Stdout, counts, *prefix, *n)
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// follow reads the update events of a stream page from r into a map of the
+// counts, calling draw with it after each interval's events.
+func follow(r io.Reader, draw func(map[string]int64)) error {
+	br := bufio.NewReader(r)
+	counts := map[string]int64{}
+	var event, data string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			if event == "update" {
+				var u struct {
+					Key   string `json:"key"`
+					Value int64  `json:"value"`
+				}
+				if err := json.Unmarshal([]byte(data), &u); err != nil {
+					return fmt.Errorf("bad update %q: %w", data, err)
+				}
+				if u.Value == 0 {
+					delete(counts, u.Key)
+				} else {
+					counts[u.Key] = u.Value
+				}
+			}
+			event, data = "", ""
+			// the events of an interval are flushed together, so
+			// they're all in once nothing more is buffered
+			if br.Buffered() == 0 {
+				draw(counts)
+			}
+		}
+	}
+}
+
+// render writes the n highest of the counts of the keys starting with
+// prefix, highest first.
+func render(w io.Writer, counts map[string]int64, prefix string, n int) {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	fmt.Fprintf(w, "%d keys\n", len(keys))
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	for _, k := range keys {
+		fmt.Fprintf(w, "%d\t%s\n", counts[k], k)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFollow(t *testing.T) {
+	stream := "event: update\ndata: {\"key\":\"a\",\"value\":3}\n\n" +
+		"event: update\ndata: {\"key\":\"b/1\",\"value\":5}\n\n" +
+		"event: update\ndata: {\"key\":\"b/2\",\"value\":1}\n\n" +
+		"event: update\ndata: {\"key\":\"a\",\"value\":0}\n\n"
+	var got strings.Builder
+	err := follow(strings.NewReader(stream), func(counts map[string]int64) {
+		render(&got, counts, "b/", 10)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2 keys\n5\tb/1\n1\tb/2\n"; got.String() != want {
+		t.Errorf("rendered %q, want %q", got.String(), want)
+	}
+
+	got.Reset()
+	render(&got, map[string]int64{"a": 1, "b": 2, "c": 2}, "", 2)
+	if want := "3 keys\n2\tb\n2\tc\n"; got.String() != want {
+		t.Errorf("rendered %q, want %q", got.String(), want)
+	}
+}
+
+func TestTopMain_BadN(t *testing.T) {
+	for _, n := range []string{"0", "-1"} {
+		if err := topMain([]string{"-n", n, "http://localhost:7070/api"}); err == nil {
+			t.Errorf("topMain with -n %s succeeded", n)
+		}
+	}
+}
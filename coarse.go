@@ -0,0 +1,86 @@
+package ehc
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// coarseClock caches the current time, refreshed once per tick by a
+// background goroutine, so hot paths can read the time with an atomic load
+// instead of calling time.Now.
+type coarseClock struct {
+	now      int64
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newCoarseClock(tick time.Duration) *coarseClock {
+	c := &coarseClock{
+		now:  time.Now().UnixNano(),
+		done: make(chan struct{}),
+	}
+	go c.run(tick)
+	return c
+}
+
+func (c *coarseClock) run(tick time.Duration) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			atomic.StoreInt64(&c.now, now.UnixNano())
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Now returns the cached time, which lags real time by at most one tick.
+func (c *coarseClock) Now() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.now))
+}
+
+func (c *coarseClock) stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// now returns the current time for the lazily expiring modes, from the
+// coarse clock if one is configured.
+func (e *EHC) now() time.Time {
+	if e.coarse != nil {
+		return e.coarse.Now()
+	}
+	return e.clock.Now()
+}
+
+// startCoarseClock starts the coarse clock if one is configured. The clock's
+// goroutine doesn't reference the EHC, so a finalizer can stop it once the
+// EHC is no longer reachable.
+func (e *EHC) startCoarseClock() {
+	if e.coarseTick <= 0 {
+		return
+	}
+	e.coarse = newCoarseClock(e.coarseTick)
+	runtime.SetFinalizer(e, (*EHC).stopCoarseClock)
+}
+
+func (e *EHC) stopCoarseClock() {
+	if e.coarse != nil {
+		e.coarse.stop()
+	}
+}
+
+// WithCoarseClock makes the generation mode read the time from a cached
+// timestamp refreshed every tick, rather than calling time.Now on every Count.
+// Generation boundaries may then be observed up to one tick late, so tick
+// should be well below the generation length.
+func WithCoarseClock(tick time.Duration) Option {
+	return func(c *config) {
+		c.coarseTick = tick
+	}
+}
@@ -0,0 +1,388 @@
+package ehc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/maphash"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// WithCompactBuckets switches the EHC to bucketed sliding-window expiry, as
+// WithBuckets(n) does, with the same counts, but keeps them in storage laid
+// out for instances of millions of keys, such as the core of a counting
+// daemon. Keys of type string, int, int32, int64, uint32 and uint64 are held
+// in flat, pointer-free arrays, which the garbage collector doesn't have to
+// scan, so its pauses and CPU cost don't grow with the number of keys, and
+// memory per key is a fixed 41 to 49 bytes plus the key and 8 bytes per
+// bucket, where WithBuckets needs a map entry, a ring and its lock. Keys of
+// other types are kept as with WithBuckets.
+//
+// The arrays are split into 64 stripes by the hash of the key, each with a
+// lock of its own. Keys that haven't been counted for a window are swept
+// incrementally: every quarter of the window, a quarter of the stripes are
+// rebuilt without them, one at a time, so that a sweep holds up the Count
+// calls of at most a stripe, a 64th of the keys, while it runs, and the
+// memory of stale keys is returned within two windows. Expire zeroes a
+// key's counts, and the key goes with the next sweep of its stripe.
+//
+// BenchmarkEHC_GCCompactBuckets and BenchmarkEHC_GCBuckets measure the cost
+// of a garbage collection with a million string keys in each mode: on a
+// typical server, about 0.7ms here, against 150ms with WithBuckets. It is
+// implemented as an ExpiryStrategy, and WithArena and WithInterner have no
+// effect in this mode. SnapshotDetailed doesn't break its counts down.
+func WithCompactBuckets(n int) Option {
+	return func(c *config) {
+		c.buckets = n
+		c.compactBuckets = true
+	}
+}
+
+const (
+	// compactStripes is the number of stripes of compact storage; a
+	// power of two, as stripes are picked by the top bits of the hash.
+	compactStripes = 64
+	// compactStripeBits is log2(compactStripes).
+	compactStripeBits = 6
+)
+
+// The kinds of keys compact storage holds, as encoded by compactKey.
+const (
+	compactString uint8 = iota + 1
+	compactInt
+	compactInt32
+	compactInt64
+	compactUint32
+	compactUint64
+)
+
+// compact implements the compact bucketed expiry mode.
+type compact struct {
+	// others keeps the keys of other kinds, and is what knows the
+	// geometry of the buckets.
+	others *buckets
+	seed   maphash.Seed
+
+	// pruneMu guards next, the first stripe the next Prune sweeps.
+	pruneMu sync.Mutex
+	next    int
+
+	stripes [compactStripes]compactStripe
+}
+
+// compactStripe is part of the keys of compact storage, held by slot
+// numbers in slices of plain values.
+type compactStripe struct {
+	mu sync.RWMutex
+	// index maps hashes to slots by open addressing with linear probing:
+	// each entry is a slot number plus 1, or 0 if empty. Its length is a
+	// power of two, and at least twice the number of slots.
+	index []uint32
+
+	// hashes, kinds, keys and newest are per slot: keys holds the start
+	// and end of the slot's key in arena as start<<32 | end, and newest
+	// the number of the newest bucket of its ring.
+	hashes []uint64
+	kinds  []uint8
+	keys   []uint64
+	newest []int64
+	// counts holds the ring of every slot, one after the other.
+	counts []int64
+	arena  []byte
+}
+
+func newCompact(window time.Duration, n int) *compact {
+	return &compact{others: newBuckets(window, n), seed: maphash.MakeSeed()}
+}
+
+// compactKey returns the kind of key and its bytes, using buf for integers,
+// or false if compact storage doesn't hold keys of its type. The bytes of a
+// string key are the string's own, and mustn't be changed.
+func compactKey(key interface{}, buf *[8]byte) (uint8, []byte, bool) {
+	var kind uint8
+	var v uint64
+	switch k := key.(type) {
+	case string:
+		return compactString, unsafe.Slice(unsafe.StringData(k), len(k)), true
+	case int:
+		kind, v = compactInt, uint64(k)
+	case int32:
+		kind, v = compactInt32, uint64(k)
+	case int64:
+		kind, v = compactInt64, uint64(k)
+	case uint32:
+		kind, v = compactUint32, uint64(k)
+	case uint64:
+		kind, v = compactUint64, k
+	default:
+		return 0, nil, false
+	}
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return kind, buf[:], true
+}
+
+// decodeCompactKey returns the key of kind encoded as b by compactKey.
+func decodeCompactKey(kind uint8, b []byte) interface{} {
+	if kind == compactString {
+		return string(b)
+	}
+	v := binary.LittleEndian.Uint64(b)
+	switch kind {
+	case compactInt:
+		return int(v)
+	case compactInt32:
+		return int32(v)
+	case compactInt64:
+		return int64(v)
+	case compactUint32:
+		return uint32(v)
+	default:
+		return v
+	}
+}
+
+// locate returns the hash of an encoded key and its stripe.
+func (c *compact) locate(kind uint8, b []byte) (uint64, *compactStripe) {
+	h := maphash.Bytes(c.seed, b) ^ uint64(kind)*0x9e3779b97f4a7c15
+	return h, &c.stripes[h>>(64-compactStripeBits)]
+}
+
+// size returns the number of buckets of a ring.
+func (c *compact) size() int {
+	return int(c.others.n) + 1
+}
+
+// Add adds n to key in the bucket now falls in.
+func (c *compact) Add(key interface{}, n int64, now time.Time) {
+	var buf [8]byte
+	kind, b, ok := compactKey(key, &buf)
+	if !ok {
+		c.others.Add(key, n, now)
+		return
+	}
+	h, s := c.locate(kind, b)
+	bucket := c.others.bucket(now)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot, ok := s.find(h, kind, b)
+	if !ok {
+		slot = s.insert(h, kind, b, bucket, c.size())
+	}
+	addToRing(s.ring(slot, c.size()), &s.newest[slot], bucket, n)
+}
+
+// Value estimates key's count over the window ending at now.
+func (c *compact) Value(key interface{}, now time.Time) int64 {
+	var buf [8]byte
+	kind, b, ok := compactKey(key, &buf)
+	if !ok {
+		return c.others.Value(key, now)
+	}
+	h, s := c.locate(kind, b)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	slot, ok := s.find(h, kind, b)
+	if !ok {
+		return 0
+	}
+	return c.others.sum(s.ring(slot, c.size()), s.newest[slot], now)
+}
+
+// Snapshot estimates the count of every key.
+func (c *compact) Snapshot(now time.Time) map[interface{}]int64 {
+	totals := c.others.Snapshot(now)
+	c.each(func(s *compactStripe, slot int) {
+		if v := c.others.sum(s.ring(slot, c.size()), s.newest[slot], now); v != 0 {
+			totals[s.key(slot)] = v
+		}
+	})
+	return totals
+}
+
+// each calls fn for every slot of every stripe, holding the stripe's lock
+// shared.
+func (c *compact) each(fn func(s *compactStripe, slot int)) {
+	for i := range c.stripes {
+		s := &c.stripes[i]
+		s.mu.RLock()
+		for slot := range s.hashes {
+			fn(s, slot)
+		}
+		s.mu.RUnlock()
+	}
+}
+
+// Prune sweeps the next quarter of the stripes, dropping the keys that
+// haven't been counted for a window.
+func (c *compact) Prune(now time.Time) {
+	c.others.Prune(now)
+	stale := c.others.bucket(now) - c.others.n
+
+	c.pruneMu.Lock()
+	first := c.next
+	c.next = (c.next + compactStripes/4) % compactStripes
+	c.pruneMu.Unlock()
+
+	for i := first; i < first+compactStripes/4; i++ {
+		s := &c.stripes[i]
+		s.mu.Lock()
+		s.sweep(stale, c.size())
+		s.mu.Unlock()
+	}
+}
+
+// forget zeroes all of key's counts.
+func (c *compact) forget(key interface{}) {
+	var buf [8]byte
+	kind, b, ok := compactKey(key, &buf)
+	if !ok {
+		c.others.forget(key)
+		return
+	}
+	h, s := c.locate(kind, b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slot, ok := s.find(h, kind, b); ok {
+		clear(s.ring(slot, c.size()))
+	}
+}
+
+// contributions returns the live count of every bucket, as buckets does.
+func (c *compact) contributions(now time.Time) []contribution {
+	live := c.others.contributions(now)
+	cur := c.others.bucket(now)
+	c.each(func(s *compactStripe, slot int) {
+		live = c.others.ringContributions(live, s.key(slot), s.ring(slot, c.size()), s.newest[slot], cur)
+	})
+	return live
+}
+
+// halves splits every key's count as buckets does.
+func (c *compact) halves(now time.Time, cur, prev map[interface{}]int64) {
+	c.others.halves(now, cur, prev)
+	epoch := c.others.bucket(now)
+	c.each(func(s *compactStripe, slot int) {
+		c.others.ringHalves(s.key(slot), s.ring(slot, c.size()), s.newest[slot], epoch, cur, prev)
+	})
+}
+
+// find returns the slot of an encoded key. s.mu must be held.
+func (s *compactStripe) find(h uint64, kind uint8, b []byte) (int, bool) {
+	if len(s.index) == 0 {
+		return 0, false
+	}
+	mask := uint64(len(s.index) - 1)
+	for i := h & mask; ; i = (i + 1) & mask {
+		e := s.index[i]
+		if e == 0 {
+			return 0, false
+		}
+		slot := int(e - 1)
+		if s.hashes[slot] == h && s.kinds[slot] == kind && bytes.Equal(s.keyBytes(slot), b) {
+			return slot, true
+		}
+	}
+}
+
+// insert adds a slot for an encoded key, with an empty ring of size buckets
+// whose newest is bucket, and returns it. s.mu must be held exclusively.
+func (s *compactStripe) insert(h uint64, kind uint8, b []byte, bucket int64, size int) int {
+	slot := len(s.hashes)
+	s.hashes = append(s.hashes, h)
+	s.kinds = append(s.kinds, kind)
+	start := len(s.arena)
+	s.arena = append(s.arena, b...)
+	s.keys = append(s.keys, uint64(start)<<32|uint64(len(s.arena)))
+	s.newest = append(s.newest, bucket)
+	for i := 0; i < size; i++ {
+		s.counts = append(s.counts, 0)
+	}
+
+	if 2*len(s.hashes) > len(s.index) {
+		s.reindex()
+	} else {
+		s.place(slot)
+	}
+	return slot
+}
+
+// place enters slot in the index.
+func (s *compactStripe) place(slot int) {
+	mask := uint64(len(s.index) - 1)
+	i := s.hashes[slot] & mask
+	for s.index[i] != 0 {
+		i = (i + 1) & mask
+	}
+	s.index[i] = uint32(slot + 1)
+}
+
+// reindex rebuilds the index for the current slots.
+func (s *compactStripe) reindex() {
+	n := 16
+	for n < 2*len(s.hashes) {
+		n *= 2
+	}
+	s.index = make([]uint32, n)
+	for slot := range s.hashes {
+		s.place(slot)
+	}
+}
+
+// sweep rebuilds the stripe without the slots whose newest bucket is before
+// stale. s.mu must be held exclusively.
+func (s *compactStripe) sweep(stale int64, size int) {
+	kept, arena := 0, 0
+	for slot, newest := range s.newest {
+		if newest >= stale {
+			kept++
+			arena += len(s.keyBytes(slot))
+		}
+	}
+	if kept == len(s.hashes) {
+		return
+	}
+
+	hashes := make([]uint64, 0, kept)
+	kinds := make([]uint8, 0, kept)
+	keys := make([]uint64, 0, kept)
+	newests := make([]int64, 0, kept)
+	counts := make([]int64, 0, kept*size)
+	keyArena := make([]byte, 0, arena)
+	for slot, newest := range s.newest {
+		if newest < stale {
+			continue
+		}
+		hashes = append(hashes, s.hashes[slot])
+		kinds = append(kinds, s.kinds[slot])
+		start := len(keyArena)
+		keyArena = append(keyArena, s.keyBytes(slot)...)
+		keys = append(keys, uint64(start)<<32|uint64(len(keyArena)))
+		newests = append(newests, newest)
+		counts = append(counts, s.ring(slot, size)...)
+	}
+	s.hashes, s.kinds, s.keys, s.newest, s.counts, s.arena = hashes, kinds, keys, newests, counts, keyArena
+	s.index = nil
+	if kept > 0 {
+		s.reindex()
+	}
+}
+
+// ring returns the ring of slot, of size buckets.
+func (s *compactStripe) ring(slot, size int) []int64 {
+	return s.counts[slot*size : (slot+1)*size : (slot+1)*size]
+}
+
+// keyBytes returns the encoded key of slot.
+func (s *compactStripe) keyBytes(slot int) []byte {
+	k := s.keys[slot]
+	return s.arena[k>>32 : k&0xffffffff]
+}
+
+// key returns the key of slot.
+func (s *compactStripe) key(slot int) interface{} {
+	return decodeCompactKey(s.kinds[slot], s.keyBytes(slot))
+}
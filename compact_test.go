@@ -0,0 +1,148 @@
+package ehc
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestEHC_CompactBuckets(t *testing.T) {
+	type other struct{ name string }
+	for _, key := range []interface{}{"a", 7, other{"a"}} {
+		t.Run(fmt.Sprint(key), func(t *testing.T) {
+			e := NewManualEHC(10*time.Second, time.Unix(0, 0), WithCompactBuckets(10))
+			e.CountMultiple(key, 10)
+			e.Tick(5 * time.Second)
+			e.CountMultiple(key, 4)
+
+			// the same counts as WithBuckets
+			for _, step := range []struct {
+				tick time.Duration
+				want int64
+			}{
+				{0, 14},
+				{5 * time.Second, 14},
+				{500 * time.Millisecond, 9},
+				{500 * time.Millisecond, 4},
+				{4500 * time.Millisecond, 2},
+				{500 * time.Millisecond, 0},
+			} {
+				e.Tick(step.tick)
+				if v := e.value(key); v != step.want {
+					t.Errorf("count at %v = %d, want %d", e.Now().Sub(time.Unix(0, 0)), v, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEHC_CompactBucketsKeys(t *testing.T) {
+	type other struct{ name string }
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithCompactBuckets(4))
+	want := map[interface{}]int64{
+		"a":             1,
+		"":              2,
+		-1:              3,
+		int32(-1):       4,
+		int64(-1):       5,
+		uint32(1 << 31): 6,
+		uint64(1 << 63): 7,
+		other{"a"}:      8,
+	}
+	for key, n := range want {
+		e.CountMultiple(key, n)
+	}
+	if got := e.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot() = %v, want %v", got, want)
+	}
+
+	e.Expire(-1)
+	e.Expire(other{"a"})
+	delete(want, -1)
+	delete(want, other{"a"})
+	if got := e.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot() = %v after Expire, want %v", got, want)
+	}
+}
+
+func TestEHC_CompactBucketsSweep(t *testing.T) {
+	e := NewManualEHC(4*time.Second, time.Unix(0, 0), WithCompactBuckets(4))
+	for i := 0; i < 10000; i++ {
+		e.Count(fmt.Sprint("key", i))
+	}
+	c := e.expiry.(*compact)
+	slots := func() int {
+		n := 0
+		for i := range c.stripes {
+			n += len(c.stripes[i].hashes)
+		}
+		return n
+	}
+	if n := slots(); n != 10000 {
+		t.Fatalf("%d slots, want 10000", n)
+	}
+
+	// every quarter of the window, a quarter of the stripes are swept
+	for i := 0; i < 8; i++ {
+		e.Tick(time.Second)
+		e.Count("live")
+	}
+	if n := slots(); n != 1 {
+		t.Errorf("%d slots two windows later, want 1", n)
+	}
+	// off by at most a bucket
+	if v := e.value("live"); v < 4 || v > 5 {
+		t.Errorf("live = %d, want 4 or 5", v)
+	}
+	if v := e.value("key1"); v != 0 {
+		t.Errorf("key1 = %d once swept, want 0", v)
+	}
+	e.Count("key1")
+	if v := e.value("key1"); v != 1 {
+		t.Errorf("key1 = %d counted again, want 1", v)
+	}
+}
+
+func TestEHC_CompactBucketsMigrate(t *testing.T) {
+	e := NewManualEHC(10*time.Second, time.Unix(0, 0), WithBuckets(10))
+	e.CountMultiple("a", 3)
+	e.Tick(5 * time.Second)
+	e.CountMultiple(2, 4)
+
+	e.MigrateTo(WithCompactBuckets(10))
+	if a, b := e.value("a"), e.value(2); a != 3 || b != 4 {
+		t.Errorf("counts = %d, %d after migrating to compact buckets, want 3, 4", a, b)
+	}
+	e.Tick(5 * time.Second)
+	e.MigrateTo()
+	e.Tick(time.Second)
+	if a, b := e.value("a"), e.value(2); a != 0 || b != 4 {
+		t.Errorf("counts = %d, %d after migrating back, want 0, 4", a, b)
+	}
+}
+
+// benchmarkGC measures a garbage collection with a million keys held.
+func benchmarkGC(b *testing.B, opts ...Option) {
+	e := NewEHC(time.Hour, opts...)
+	defer e.Close()
+	for i := 0; i < 1000000; i++ {
+		e.Count(fmt.Sprint("key", i))
+	}
+	runtime.GC()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	b.StopTimer()
+	runtime.KeepAlive(e)
+}
+
+func BenchmarkEHC_GCBuckets(b *testing.B) {
+	benchmarkGC(b, WithBuckets(4))
+}
+
+func BenchmarkEHC_GCCompactBuckets(b *testing.B) {
+	benchmarkGC(b, WithCompactBuckets(4))
+}
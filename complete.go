@@ -0,0 +1,261 @@
+package ehc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WindowCompleteFunc receives the total counted for key over one completed
+// window.
+type WindowCompleteFunc func(key interface{}, finalCount int64, windowStart, windowEnd time.Time)
+
+// WindowTotal is the total counted for one key over one completed window.
+type WindowTotal struct {
+	Key        interface{}
+	Count      int64
+	Start, End time.Time
+}
+
+// WindowSinkFunc receives a batch of completed window totals. Returning nil
+// acknowledges the whole batch; returning an error keeps it for redelivery.
+type WindowSinkFunc func(totals []WindowTotal) error
+
+// OnWindowComplete registers fn to receive the per-key totals of every
+// window once it ends, so that they can be shipped to long-term storage
+// instead of being retracted and lost.
+//
+// Totals are kept for consecutive fixed windows of the EHC's duration,
+// aligned to multiples of it since the zero time, independently of the
+// sliding counts. Each window's totals are delivered exactly once, to every
+// registered function in turn, shortly after the window ends. Only keys
+// counted after the first registration are included. A panic in fn is
+// recovered and reported as set with WithPanicHandler.
+func (e *EHC) OnWindowComplete(fn WindowCompleteFunc) {
+	t := e.windowTotals()
+	t.mu.Lock()
+	t.fns = append(t.fns, fn)
+	t.mu.Unlock()
+}
+
+// OnWindowCompleteAck is like OnWindowComplete, but for sinks that can fail,
+// such as a remote database. Each window's totals are delivered as a batch,
+// and are kept until fn acknowledges them by returning nil. Failed batches
+// are redelivered, together with any windows completed since, when the next
+// window completes or one window's duration after the failure, whichever
+// comes first.
+//
+// At most limit unacknowledged totals are kept; beyond that the oldest are
+// spilled to the DeadLetter set with WithDeadLetter, or discarded and counted
+// in Stats.TotalsDiscarded if there is none or it is full. A batch may be redelivered
+// after fn stored it but failed to report so, so sinks needing exactly-once
+// storage should deduplicate on the key and window start.
+func (e *EHC) OnWindowCompleteAck(fn WindowSinkFunc, limit int) {
+	t := e.windowTotals()
+	e.valueLock.RLock()
+	s := &windowSink{
+		fn:        fn,
+		limit:     limit,
+		retry:     t.window,
+		clock:     t.clock,
+		dead:      e.deadLetter,
+		discarded: &e.stats.totalsDiscarded,
+		handle:    e.handlePanic,
+		submit:    e.submitCallback,
+	}
+	e.valueLock.RUnlock()
+	t.mu.Lock()
+	t.sinks = append(t.sinks, s)
+	t.mu.Unlock()
+}
+
+func (e *EHC) windowTotals() *windowTotals {
+	e.totalsOnce.Do(func() {
+		t := newWindowTotals(e.window, e.clock)
+		t.handle = e.handlePanic
+		t.submit = e.submitCallback
+		e.totals.Store(t)
+	})
+	return e.totals.Load().(*windowTotals)
+}
+
+// recordTotal adds count to the current window's total for a normalized
+// key, if anything is listening.
+func (e *EHC) recordTotal(key interface{}, count int64) {
+	if t, _ := e.totals.Load().(*windowTotals); t != nil {
+		t.record(key, count, t.clock.Now())
+	}
+}
+
+// windowTotals accumulates the totals of the current fixed window.
+type windowTotals struct {
+	window time.Duration
+	clock  Clock
+	// handle is passed the panics of callbacks; see protect.
+	handle func(error)
+	// submit, if set, runs deliveries; see EHC.submitCallback.
+	submit func(job func())
+
+	// deliverMu serializes deliveries so that windows are delivered in
+	// order. It is taken before mu.
+	deliverMu sync.Mutex
+
+	mu     sync.Mutex
+	fns    []WindowCompleteFunc
+	sinks  []*windowSink
+	start  time.Time
+	counts map[interface{}]int64
+	// ended holds windows that ended before the timer delivered them.
+	ended []completedWindow
+	timer Timer
+	// closed is set by Close, after which counts are no longer recorded.
+	closed bool
+}
+
+// completedWindow is the totals of one ended window.
+type completedWindow struct {
+	start  time.Time
+	counts map[interface{}]int64
+}
+
+func newWindowTotals(window time.Duration, clk Clock) *windowTotals {
+	if window <= 0 {
+		window = 1
+	}
+	return &windowTotals{window: window, clock: clk, counts: map[interface{}]int64{}}
+}
+
+func (t *windowTotals) record(key interface{}, count int64, now time.Time) {
+	start := now.Truncate(t.window)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	if len(t.counts) > 0 && !start.Equal(t.start) {
+		// the timer hasn't delivered the previous window yet
+		t.ended = append(t.ended, completedWindow{t.start, t.counts})
+		t.counts = map[interface{}]int64{}
+	}
+	if len(t.counts) == 0 {
+		t.start = start
+		d := start.Add(t.window).Sub(now)
+		if len(t.ended) > 0 {
+			d = 0
+		}
+		if t.timer == nil {
+			t.timer = t.clock.AfterFunc(d, t.flush)
+		} else {
+			t.timer.Reset(d)
+		}
+	}
+	t.counts[key] += count
+}
+
+// flush delivers the windows that have ended, rearming the timer for the
+// current window otherwise.
+func (t *windowTotals) flush() {
+	t.deliverMu.Lock()
+	defer t.deliverMu.Unlock()
+
+	now := t.clock.Now()
+	t.mu.Lock()
+	if len(t.counts) > 0 {
+		if end := t.start.Add(t.window); now.Before(end) {
+			t.timer.Reset(end.Sub(now))
+		} else {
+			t.ended = append(t.ended, completedWindow{t.start, t.counts})
+			t.counts = map[interface{}]int64{}
+		}
+	}
+	ended := t.ended
+	t.ended = nil
+	fns, sinks := t.fns, t.sinks
+	t.mu.Unlock()
+
+	for _, w := range ended {
+		w := w
+		t.run(func() {
+			t.deliver(w, fns, sinks)
+		})
+	}
+}
+
+// deliver passes the totals of an ended window to fns and sinks.
+func (t *windowTotals) deliver(w completedWindow, fns []WindowCompleteFunc, sinks []*windowSink) {
+	end := w.start.Add(t.window)
+	batch := make([]WindowTotal, 0, len(w.counts))
+	for key, count := range w.counts {
+		for _, fn := range fns {
+			protect("OnWindowComplete", t.handle, func() {
+				fn(key, count, w.start, end)
+			})
+		}
+		batch = append(batch, WindowTotal{key, count, w.start, end})
+	}
+	for _, s := range sinks {
+		s.deliver(batch)
+	}
+}
+
+// run runs a delivery on the callback workers, if any, or else directly.
+func (t *windowTotals) run(job func()) {
+	if t.submit == nil {
+		job()
+		return
+	}
+	t.submit(job)
+}
+
+// windowSink buffers the totals delivered to a WindowSinkFunc until they
+// are acknowledged.
+type windowSink struct {
+	fn        WindowSinkFunc
+	limit     int
+	retry     time.Duration
+	clock     Clock
+	dead      *DeadLetter
+	discarded *int64
+	handle    func(error)
+	submit    func(job func())
+
+	// mu is held while fn runs, so that deliveries don't overlap.
+	mu      sync.Mutex
+	pending []WindowTotal
+	timer   Timer
+}
+
+// deliver appends batch to the unacknowledged totals and delivers them all.
+func (s *windowSink) deliver(batch []WindowTotal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = append(s.pending, batch...)
+	if over := len(s.pending) - s.limit; over > 0 {
+		if s.dead == nil || s.dead.write(s.pending[:over]) != nil {
+			atomic.AddInt64(s.discarded, int64(over))
+		}
+		s.pending = append([]WindowTotal(nil), s.pending[over:]...)
+	}
+	if len(s.pending) == 0 {
+		return
+	}
+
+	var err error
+	ok := protect("OnWindowCompleteAck", s.handle, func() {
+		err = s.fn(s.pending)
+	})
+	if ok && err == nil {
+		s.pending = nil
+		return
+	}
+	if s.timer == nil {
+		s.timer = s.clock.AfterFunc(s.retry, func() {
+			s.submit(func() { s.deliver(nil) })
+		})
+	} else {
+		s.timer.Reset(s.retry)
+	}
+}
@@ -0,0 +1,190 @@
+package ehc
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type windowRecord struct {
+	key        interface{}
+	count      int64
+	start, end time.Time
+}
+
+func TestEHC_OnWindowComplete(t *testing.T) {
+	const window = 30 * time.Millisecond
+	e := NewEHC(window)
+
+	var mu sync.Mutex
+	var got []windowRecord
+	e.OnWindowComplete(func(key interface{}, count int64, start, end time.Time) {
+		mu.Lock()
+		got = append(got, windowRecord{key, count, start, end})
+		mu.Unlock()
+	})
+
+	e.Count("a")
+	e.CountMultiple("a", 2)
+	e.Count("b")
+	if r, ok := e.Reserve("a", 4, 100); !ok {
+		t.Fatal("EHC.Reserve() failed")
+	} else {
+		r.Commit()
+	}
+
+	time.Sleep(2 * window)
+	mu.Lock()
+	defer mu.Unlock()
+
+	totals := map[interface{}]int64{}
+	for _, r := range got {
+		totals[r.key] += r.count
+		if r.end.Sub(r.start) != window {
+			t.Errorf("window %v to %v, want a length of %v", r.start, r.end, window)
+		}
+		if !r.start.Equal(r.start.Truncate(window)) {
+			t.Errorf("window start %v isn't aligned to %v", r.start, window)
+		}
+	}
+	if totals["a"] != 7 || totals["b"] != 1 {
+		t.Errorf("delivered totals = %v, want a:7 b:1", totals)
+	}
+}
+
+func TestWindowTotals_LateTimer(t *testing.T) {
+	wt := newWindowTotals(time.Hour, realClock{})
+	var mu sync.Mutex
+	var got []windowRecord
+	wt.fns = append(wt.fns, func(key interface{}, count int64, start, end time.Time) {
+		mu.Lock()
+		got = append(got, windowRecord{key, count, start, end})
+		mu.Unlock()
+	})
+
+	now := time.Now().Truncate(time.Hour)
+	wt.record("k", 1, now)
+	wt.record("k", 2, now.Add(time.Hour))
+	wt.record("k", 3, now.Add(time.Hour))
+
+	// the first window is delivered without waiting for the second
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 || got[0].count != 1 || !got[0].start.Equal(now) {
+		t.Errorf("delivered %v, want only the first window's total of 1", got)
+	}
+	wt.timer.Stop()
+}
+
+func TestEHC_OnWindowCompleteAck(t *testing.T) {
+	const window = 20 * time.Millisecond
+	e := NewEHC(window)
+
+	var mu sync.Mutex
+	failures := 2
+	stored := map[interface{}]int64{}
+	e.OnWindowCompleteAck(func(totals []WindowTotal) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			return errors.New("sink unavailable")
+		}
+		for _, total := range totals {
+			stored[total.Key] += total.Count
+		}
+		return nil
+	}, 100)
+
+	e.CountMultiple("a", 3)
+	time.Sleep(window * 3 / 2)
+	e.CountMultiple("b", 2)
+
+	time.Sleep(4 * window)
+	mu.Lock()
+	defer mu.Unlock()
+	if stored["a"] != 3 || stored["b"] != 2 {
+		t.Errorf("stored totals = %v, want a:3 b:2 after redelivery", stored)
+	}
+}
+
+func TestEHC_OnWindowCompleteAckLimit(t *testing.T) {
+	e := NewEHC(time.Hour)
+	e.OnWindowCompleteAck(func([]WindowTotal) error {
+		return errors.New("sink unavailable")
+	}, 2)
+
+	t0 := time.Now().Truncate(time.Hour)
+	wt := e.windowTotals()
+	wt.record("a", 1, t0)
+	wt.record("b", 1, t0)
+	wt.record("c", 1, t0)
+	wt.record("d", 1, t0.Add(time.Hour))
+
+	time.Sleep(10 * time.Millisecond)
+	if n := e.Stats().TotalsDiscarded; n != 1 {
+		t.Errorf("EHC.Stats().TotalsDiscarded = %d, want 1", n)
+	}
+	wt.timer.Stop()
+}
+
+func TestEHC_OnWindowCompletePanic(t *testing.T) {
+	var mu sync.Mutex
+	var panics []error
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithPanicHandler(func(err error) {
+		mu.Lock()
+		panics = append(panics, err)
+		mu.Unlock()
+	}))
+
+	var got int64
+	e.OnWindowComplete(func(key interface{}, count int64, start, end time.Time) {
+		panic("boom")
+	})
+	e.OnWindowComplete(func(key interface{}, count int64, start, end time.Time) {
+		got += count
+	})
+	fail := true
+	var acked int64
+	e.OnWindowCompleteAck(func(totals []WindowTotal) error {
+		if fail {
+			fail = false
+			panic(errors.New("sink down"))
+		}
+		for _, t := range totals {
+			acked += t.Count
+		}
+		return nil
+	}, 100)
+
+	e.CountMultiple("a", 3)
+	e.Tick(time.Minute)
+	if got != 3 {
+		t.Errorf("second callback got %d, want 3 despite the first panicking", got)
+	}
+	if acked != 0 {
+		t.Errorf("sink acknowledged %d before recovering, want 0", acked)
+	}
+	e.Tick(time.Minute)
+	if acked != 3 {
+		t.Errorf("sink acknowledged %d after the retry, want 3", acked)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(panics) != 2 {
+		t.Fatalf("panic handler called %d times, want 2", len(panics))
+	}
+	var p *CallbackPanic
+	if !errors.As(panics[0], &p) || p.Callback != "OnWindowComplete" || p.Value != "boom" {
+		t.Errorf("first panic = %#v, want OnWindowComplete's boom", panics[0])
+	}
+	if panics[1].Error() != "ehc: OnWindowCompleteAck callback panicked: sink down" {
+		t.Errorf("second panic = %q", panics[1])
+	}
+	if n := e.Stats().CallbackPanics; n != 2 {
+		t.Errorf("Stats.CallbackPanics = %d, want 2", n)
+	}
+}
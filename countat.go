@@ -0,0 +1,83 @@
+package ehc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CountAt increments the counter mapped to key by count as of ts, for events
+// counted late, such as ones drained from a queue: the increment expires a
+// window after ts rather than after now, and is dropped at once if ts is
+// already a window or more in the past. A ts in the future is taken as now.
+//
+// With an ExpiryStrategy, the increment is added as of ts, which
+// WithGenerations rounds to the generations it still has, and decay
+// discounts by its age.
+func (e *EHC) CountAt(key interface{}, count int64, ts time.Time) {
+	e.valueLock.RLock()
+	key, counted := e.countAtLocked(key, count, ts)
+	e.valueLock.RUnlock()
+	if counted {
+		e.counted(key, count)
+	}
+}
+
+// countAtLocked is CountAt for callers holding valueLock, returning the
+// normalized key and whether the increment was counted.
+func (e *EHC) countAtLocked(key interface{}, count int64, ts time.Time) (interface{}, bool) {
+	if e.closed || e.bypassed(key, BypassCounting) {
+		return key, false
+	}
+	key, ok := e.normalizeKey(key)
+	if !ok {
+		atomic.AddInt64(&e.stats.dropped, 1)
+		return key, false
+	}
+
+	now := e.clock.Now()
+	if e.expiry != nil {
+		now = e.now()
+	}
+	if ts.After(now) {
+		ts = now
+	}
+	deadline := ts.Add(e.window)
+	if !deadline.After(now) || count == 0 {
+		return key, false
+	}
+
+	if e.expiry != nil {
+		if e.validateKey != nil && e.expiry.Value(key, now) == 0 && !e.validate(key) {
+			return key, false
+		}
+		if g, ok := e.expiry.(*generations); ok {
+			g.restore(key, count, deadline, now)
+		} else {
+			e.expiry.Add(key, count, ts)
+		}
+		e.maybePrune(now)
+		return key, true
+	}
+
+	s := e.shardFor(key)
+	atomic.AddInt64(&s.increments, 1)
+	s.rlock()
+	c, _ := s.values.Get(key).(*counter)
+	if c != nil && c.add(count, deadline, now) {
+		s.mu.RUnlock()
+		return key, true
+	}
+	s.mu.RUnlock()
+
+	if !e.validate(key) {
+		return key, false
+	}
+	if e.memory != nil && e.memory.full() {
+		e.memory.overflow.add(key, count, ts)
+		return key, true
+	}
+	s.lock()
+	e.counterLocked(s, key).add(count, deadline, now)
+	s.mu.Unlock()
+	return key, true
+}
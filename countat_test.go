@@ -0,0 +1,56 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_CountAt(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"timer", nil},
+		{"buckets", []Option{WithBuckets(60)}},
+		{"generations", []Option{WithGenerations(60)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Unix(0, 0)
+			e := NewManualEHC(time.Minute, start, tt.opts...)
+			e.Tick(30 * time.Second)
+
+			e.CountAt("a", 2, start.Add(10*time.Second))
+			e.CountAt("a", 1, start.Add(time.Hour))
+			e.CountAt("b", 5, start.Add(-30*time.Second))
+			if got := e.value("a"); got != 3 {
+				t.Errorf("a = %d, want 3", got)
+			}
+			if got := e.value("b"); got != 0 {
+				t.Errorf("b = %d counted a window ago, want 0", got)
+			}
+
+			// the backdated increment expires a window after its
+			// time, and the one from the future one after now
+			e.Tick(41 * time.Second)
+			if got := e.value("a"); got != 1 {
+				t.Errorf("a = %d once the backdated increment expired, want 1", got)
+			}
+			e.Tick(20 * time.Second)
+			if got := e.value("a"); got != 0 {
+				t.Errorf("a = %d a window after now, want 0", got)
+			}
+		})
+	}
+}
+
+func TestEHC_CountAtHooks(t *testing.T) {
+	start := time.Unix(0, 0)
+	e := NewManualEHC(time.Minute, start)
+	var crossed int64
+	e.OnThreshold(2, func(key interface{}, value int64) { crossed = value })
+	e.Tick(10 * time.Second)
+	e.CountAt("a", 2, start)
+	if crossed != 2 {
+		t.Errorf("OnThreshold told of %d, want 2", crossed)
+	}
+}
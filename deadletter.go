@@ -0,0 +1,175 @@
+package ehc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrDeadLetterFull is returned when a batch doesn't fit in a DeadLetter.
+var ErrDeadLetterFull = errors.New("ehc: dead letter file is full")
+
+// DeadLetter is a bounded file of window totals that couldn't be delivered,
+// so that a sink outage longer than its buffer doesn't lose them. Install it
+// with WithDeadLetter, and Replay it once the sink is healthy again.
+//
+// Totals are stored with encoding/gob, so keys of types other than the
+// predeclared ones must be registered with gob.Register.
+type DeadLetter struct {
+	path     string
+	maxBytes int64
+
+	mu sync.Mutex
+}
+
+// NewDeadLetter returns a DeadLetter that stores batches in the file at path,
+// creating it if needed, and refuses batches that would grow it beyond
+// maxBytes. Batches already in the file are kept for Replay.
+func NewDeadLetter(path string, maxBytes int64) (*DeadLetter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return &DeadLetter{path: path, maxBytes: maxBytes}, nil
+}
+
+// WithDeadLetter spills the totals that sinks registered with
+// OnWindowCompleteAck can't keep buffered to d, instead of discarding them.
+func WithDeadLetter(d *DeadLetter) Option {
+	return func(c *config) {
+		c.deadLetter = d
+	}
+}
+
+// Len returns the number of batches in the file.
+func (d *DeadLetter) Len() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	records, err := d.readLocked()
+	return len(records), err
+}
+
+// Replay delivers the stored batches to fn, oldest first, removing each one
+// fn acknowledges. It stops at the first error, which it returns, keeping
+// that batch and the ones after it.
+func (d *DeadLetter) Replay(fn WindowSinkFunc) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	records, err := d.readLocked()
+	if err != nil {
+		return err
+	}
+	for i, record := range records {
+		var batch []WindowTotal
+		if err := gob.NewDecoder(bytes.NewReader(record)).Decode(&batch); err != nil {
+			return err
+		}
+		if err := fn(batch); err != nil {
+			if werr := d.rewriteLocked(records[i:]); werr != nil {
+				return werr
+			}
+			return err
+		}
+	}
+	return d.rewriteLocked(nil)
+}
+
+// write appends batch to the file.
+func (d *DeadLetter) write(batch []WindowTotal) error {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 4))
+	if err := gob.NewEncoder(&buf).Encode(batch); err != nil {
+		return err
+	}
+	record := buf.Bytes()
+	binary.BigEndian.PutUint32(record, uint32(len(record)-4))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := os.OpenFile(d.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size()+int64(len(record)) > d.maxBytes {
+		return ErrDeadLetterFull
+	}
+	if _, err := f.Write(record); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readLocked returns the encoded batches in the file. A record cut short by
+// a crash while writing is ignored.
+func (d *DeadLetter) readLocked() ([][]byte, error) {
+	f, err := os.Open(d.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records [][]byte
+	r := bufio.NewReader(f)
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return records, nil
+			}
+			return nil, err
+		}
+		record := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(r, record); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return records, nil
+			}
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// rewriteLocked replaces the file's contents with records.
+func (d *DeadLetter) rewriteLocked(records [][]byte) error {
+	tmp := d.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var size [4]byte
+	for _, record := range records {
+		binary.BigEndian.PutUint32(size[:], uint32(len(record)))
+		w.Write(size[:])
+		w.Write(record)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
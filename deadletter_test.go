@@ -0,0 +1,92 @@
+package ehc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeadLetter_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead")
+	d, err := NewDeadLetter(path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Truncate(time.Minute)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := d.write([]WindowTotal{{key, 1, start, start.Add(time.Minute)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a crash mid-write leaves a partial record behind
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	var replayed []interface{}
+	err = d.Replay(func(batch []WindowTotal) error {
+		if batch[0].Key == "b" {
+			return errors.New("sink unavailable")
+		}
+		replayed = append(replayed, batch[0].Key)
+		return nil
+	})
+	if err == nil {
+		t.Error("DeadLetter.Replay() succeeded, want the sink's error")
+	}
+	if n, _ := d.Len(); n != 2 {
+		t.Errorf("DeadLetter.Len() = %d, want the 2 batches from b", n)
+	}
+
+	if err := d.Replay(func(batch []WindowTotal) error {
+		replayed = append(replayed, batch[0].Key)
+		if !batch[0].Start.Equal(start) {
+			t.Errorf("replayed start %v, want %v", batch[0].Start, start)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 3 || replayed[0] != "a" || replayed[1] != "b" || replayed[2] != "c" {
+		t.Errorf("replayed %v, want [a b c]", replayed)
+	}
+	if n, _ := d.Len(); n != 0 {
+		t.Errorf("DeadLetter.Len() = %d after replay, want 0", n)
+	}
+}
+
+func TestEHC_WithDeadLetter(t *testing.T) {
+	d, err := NewDeadLetter(filepath.Join(t.TempDir(), "dead"), 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEHC(time.Hour, WithDeadLetter(d))
+	e.OnWindowCompleteAck(func([]WindowTotal) error {
+		return errors.New("sink unavailable")
+	}, 1)
+
+	t0 := time.Now().Truncate(time.Hour)
+	wt := e.windowTotals()
+	wt.record("a", 1, t0)
+	wt.record("b", 1, t0.Add(time.Hour))
+	wt.record("c", 1, t0.Add(2*time.Hour))
+	wt.record("d", 1, t0.Add(3*time.Hour))
+	wt.record("e", 1, t0.Add(4*time.Hour))
+
+	time.Sleep(10 * time.Millisecond)
+	n, err := d.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || n == 4 {
+		t.Errorf("DeadLetter.Len() = %d, want some but not all spilled", n)
+	}
+	if discarded := e.Stats().TotalsDiscarded; int(discarded)+n != 3 {
+		t.Errorf("spilled %d and discarded %d, want 3 overflowed in all", n, discarded)
+	}
+	wt.timer.Stop()
+}
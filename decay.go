@@ -0,0 +1,132 @@
+package ehc
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// WithExponentialDecay switches the EHC to exponentially decaying counts:
+// rather than being retracted whole once the window has passed, every
+// increment fades continuously, halving every halfLife. A key's value is the
+// sum of what is left of its increments, rounded to the nearest integer, so
+// it moves smoothly instead of in the steps of expirations, as wanted for
+// rate estimates and load-balancing weights.
+//
+// A halfLife of 0 makes it the window times ln 2, so that increments last
+// the window on average, and a key counted at a steady rate has the same
+// value as it would with a sliding window. Memory per key is fixed, and
+// counting is O(1). It is implemented as an ExpiryStrategy; WithArena and
+// WithInterner have no effect in this mode, and Trending finds nothing.
+// MigrateTo carries the current values over as if they had just been
+// counted, which loses nothing, as decay doesn't depend on the age of
+// increments.
+func WithExponentialDecay(halfLife time.Duration) Option {
+	return func(c *config) {
+		c.decay = true
+		c.decayHalfLife = halfLife
+	}
+}
+
+// decay implements the exponential decay mode.
+type decay struct {
+	// lifetime is the mean lifetime of an increment, the half-life
+	// over ln 2.
+	lifetime float64
+
+	mu   sync.RWMutex
+	keys map[interface{}]*decayed
+}
+
+// decayed is the value of one key as of a time.
+type decayed struct {
+	mu    sync.Mutex
+	value float64
+	at    time.Time
+}
+
+func newDecay(window, halfLife time.Duration) *decay {
+	lifetime := float64(window)
+	if halfLife > 0 {
+		lifetime = float64(halfLife) / math.Ln2
+	}
+	if lifetime <= 0 {
+		lifetime = 1
+	}
+	return &decay{
+		lifetime: lifetime,
+		keys:     map[interface{}]*decayed{},
+	}
+}
+
+// at returns what is left at now of value as of at, which may be later for
+// increments backdated by MigrateTo.
+func (d *decay) at(value float64, at, now time.Time) float64 {
+	return value * math.Exp(-float64(now.Sub(at))/d.lifetime)
+}
+
+// Add adds n to key at now.
+func (d *decay) Add(key interface{}, n int64, now time.Time) {
+	d.mu.RLock()
+	k := d.keys[key]
+	d.mu.RUnlock()
+	if k == nil {
+		d.mu.Lock()
+		if k = d.keys[key]; k == nil {
+			k = &decayed{at: now}
+			d.keys[key] = k
+		}
+		d.mu.Unlock()
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if now.After(k.at) {
+		k.value = d.at(k.value, k.at, now)
+		k.at = now
+	}
+	k.value += d.at(float64(n), now, k.at)
+}
+
+// Value returns key's decayed count at now.
+func (d *decay) Value(key interface{}, now time.Time) int64 {
+	d.mu.RLock()
+	k := d.keys[key]
+	d.mu.RUnlock()
+	if k == nil {
+		return 0
+	}
+	return d.value(k, now)
+}
+
+func (d *decay) value(k *decayed, now time.Time) int64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return int64(math.Round(d.at(k.value, k.at, now)))
+}
+
+// Snapshot returns the decayed count of every key that hasn't decayed to 0.
+func (d *decay) Snapshot(now time.Time) map[interface{}]int64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	totals := map[interface{}]int64{}
+	for key, k := range d.keys {
+		if v := d.value(k, now); v != 0 {
+			totals[key] = v
+		}
+	}
+	return totals
+}
+
+// Prune drops the keys that have decayed to 0.
+func (d *decay) Prune(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, k := range d.keys {
+		if d.value(k, now) == 0 {
+			delete(d.keys, key)
+		}
+	}
+}
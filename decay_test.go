@@ -0,0 +1,53 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_ExponentialDecay(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithExponentialDecay(10*time.Second))
+	e.CountMultiple("a", 100)
+
+	for _, step := range []struct {
+		tick time.Duration
+		want int64
+	}{
+		{0, 100},
+		{10 * time.Second, 50},
+		{5 * time.Second, 35},
+		{5 * time.Second, 25},
+		{80 * time.Second, 0},
+	} {
+		e.Tick(step.tick)
+		if v := e.value("a"); v != step.want {
+			t.Errorf("count at %v = %d, want %d", e.Now().Sub(time.Unix(0, 0)), v, step.want)
+		}
+	}
+
+	e.CountMultiple("b", 8)
+	e.Tick(10 * time.Second)
+	e.CountMultiple("b", 8)
+	if v := e.value("b"); v != 12 {
+		t.Errorf("count of b = %d, want 12", v)
+	}
+	e.expiry.Prune(e.Now())
+	if d := e.expiry.(*decay); len(d.keys) != 1 {
+		t.Errorf("%d keys kept after pruning, want only b", len(d.keys))
+	}
+}
+
+func TestEHC_ExponentialDecaySteadyRate(t *testing.T) {
+	const window = 10 * time.Second
+	e := NewManualEHC(window, time.Unix(0, 0), WithExponentialDecay(0))
+
+	// at a steady 10 a second, the value settles on what a sliding
+	// window would count
+	for i := 0; i < 1000; i++ {
+		e.Count("k")
+		e.Tick(100 * time.Millisecond)
+	}
+	if v := e.value("k"); v < 95 || v > 105 {
+		t.Errorf("count at a steady rate = %d, want about 100", v)
+	}
+}
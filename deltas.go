@@ -0,0 +1,60 @@
+package ehc
+
+import "sync"
+
+// DeltaTracker accumulates what is counted in an EHC between calls to Take,
+// for exporters that report increments rather than counts over the window,
+// such as StatsD counters.
+type DeltaTracker struct {
+	e *EHC
+
+	mu     sync.Mutex
+	counts map[interface{}]int64
+}
+
+// TrackDeltas returns a DeltaTracker fed every count made from now on, by
+// normalized key, until it is stopped. Each tracker costs a map update per
+// count.
+func (e *EHC) TrackDeltas() *DeltaTracker {
+	d := &DeltaTracker{e: e, counts: map[interface{}]int64{}}
+	e.deltasMu.Lock()
+	defer e.deltasMu.Unlock()
+	trackers, _ := e.deltas.Load().([]*DeltaTracker)
+	e.deltas.Store(append(trackers[:len(trackers):len(trackers)], d))
+	return d
+}
+
+// Take returns what was counted for every key since the last Take, or since
+// the tracker was created, and starts over.
+func (d *DeltaTracker) Take() map[interface{}]int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	counts := d.counts
+	d.counts = map[interface{}]int64{}
+	return counts
+}
+
+// Stop stops feeding the tracker.
+func (d *DeltaTracker) Stop() {
+	e := d.e
+	e.deltasMu.Lock()
+	defer e.deltasMu.Unlock()
+	trackers, _ := e.deltas.Load().([]*DeltaTracker)
+	kept := make([]*DeltaTracker, 0, len(trackers))
+	for _, t := range trackers {
+		if t != d {
+			kept = append(kept, t)
+		}
+	}
+	e.deltas.Store(kept)
+}
+
+// countedDeltas feeds the trackers a count just made for a normalized key.
+func (e *EHC) countedDeltas(key interface{}, count int64) {
+	trackers, _ := e.deltas.Load().([]*DeltaTracker)
+	for _, d := range trackers {
+		d.mu.Lock()
+		d.counts[key] += count
+		d.mu.Unlock()
+	}
+}
@@ -0,0 +1,32 @@
+package ehc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEHC_TrackDeltas(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	e.Count("before")
+	d := e.TrackDeltas()
+
+	e.CountMultiple("a", 3)
+	e.Count("b")
+	if got, want := d.Take(), map[interface{}]int64{"a": 3, "b": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Take() = %v, want %v", got, want)
+	}
+
+	// expirations aren't deltas
+	e.Tick(time.Minute)
+	e.Count("a")
+	if got, want := d.Take(), map[interface{}]int64{"a": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Take() = %v after expiry, want %v", got, want)
+	}
+
+	d.Stop()
+	e.Count("a")
+	if got := d.Take(); len(got) != 0 {
+		t.Errorf("Take() = %v once stopped, want nothing", got)
+	}
+}
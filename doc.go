@@ -0,0 +1,28 @@
+// Package ehc provides an Expiring Hash Counter: a map of counters in which
+// every increment is retracted once a fixed window has elapsed, so that each
+// key's count is the number of times it was counted over the recent past.
+//
+// # Consistency
+//
+// Every operation on a single key is linearizable while its increments are
+// live: a Count takes effect at some instant between its call and its return,
+// and a read of the key's value, whether through Values or the limit checks
+// of Reserve, reflects exactly the increments that took effect before the
+// read did. In particular a read never misses a Count that returned before the
+// read was called, and two reads that don't overlap never see the count go
+// down except through expiry. This holds in every mode, including with
+// WithShards, WithArena, WithInterner and WithCoarseClock, and across
+// MigrateTo.
+//
+// Reads of different keys are not atomic with each other: the map returned by
+// Values holds each key's live counter, which may move while it is being
+// iterated, and in generation mode it is a copy in which each key was read at
+// a slightly different instant.
+//
+// Expiry is the one source of imprecision. In the default mode an increment
+// stays counted for at least the window after its Count was called and is
+// retracted shortly after that, or up to one resolution later with
+// WithResolution. WithGenerations(n) instead retracts it between (n-1)/n of
+// the window and the full window, which WithCoarseClock can delay by up to
+// one more tick.
+package ehc
@@ -1,39 +1,350 @@
 package ehc
 
 import (
+	"hash/maphash"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type EHC struct {
-	// valueLock controls the values map.
-	// a Lock() is required to insert/remove items from the map,
-	// but only RLock() is needed to view the map or
-	// to edit a counter that's already in the map.
+	// stats is kept first so its 64-bit atomics stay aligned on 32-bit platforms.
+	stats stats
+
+	// nextPrune is when expiry is next due to be pruned, in Unix
+	// nanoseconds. It follows stats to stay aligned.
+	nextPrune int64
+	// total is the sum of the counters, kept by addCount for Total. It
+	// follows nextPrune to stay aligned.
+	total int64
+
+	// valueLock controls the configuration and the shards.
+	// a Lock() is required to replace them, as MigrateTo does,
+	// but only RLock() is needed to use them; the shards have
+	// locks of their own for inserting and removing counters.
 	valueLock sync.RWMutex
 
-	values map[interface{}]Counter
+	// closed is set by Close. It is guarded by valueLock.
+	closed bool
+	// done is closed by Close, to wake up WaitAllow.
+	done chan struct{}
+
+	// shards hold the counters of the timer mode, split by seed.
+	shards []*shard
+	seed   maphash.Seed
 
 	// window controls the measurement window. Counts expire after this window.
 	window time.Duration
+	// started is when the EHC was created, which Rate needs while it is
+	// younger than the window.
+	started time.Time
+
+	// arena, if set, supplies storage for new counters.
+	arena *arena
+
+	// memory, if set, enforces WithMaxMemory.
+	memory *memoryCeiling
+
+	// audits, if set, is the log of WithAuditLog. Unlike the rest of the
+	// configuration, it is kept by MigrateTo.
+	audits *auditLog
+
+	// keyHistory, if set, is the history of WithKeyHistory, which is
+	// kept by MigrateTo like audits.
+	keyHistory *keyHistory
+
+	// expiry, if set, replaces the per-key counters.
+	expiry ExpiryStrategy
+
+	// held is the cost of outstanding reservations in expiry.
+	held held
+
+	// adapt, if set, tunes the expiry resolution at runtime.
+	adapt *adaptive
+
+	// budget, if set, limits the time spent on housekeeping.
+	budget *budget
+
+	// coarse, if set, provides the time for the lazily expiring modes.
+	coarse *coarseClock
+
+	// prof holds the *Profiler from the config, so that it can be read
+	// before taking valueLock.
+	prof atomic.Value
+
+	// sites holds the *callSites enabled by WithCallSites, or a nil one,
+	// for the same reason as prof.
+	sites atomic.Value
+
+	// workers holds the *callbackPool enabled by WithCallbackWorkers, or
+	// a nil one, for the same reason as prof.
+	workers atomic.Value
+
+	// global holds the *globalTotal enabled by WithGlobalLimit, or a nil
+	// one, for the same reason as prof.
+	global atomic.Value
+
+	// sample holds the *aliasTable SampleKey draws from, which sampleMu
+	// serializes rebuilding.
+	sample   atomic.Value
+	sampleMu sync.Mutex
+
+	// hot holds the *hotKeyTable of WithProfileLabels, which hotMu
+	// serializes rebuilding.
+	hot   atomic.Value
+	hotMu sync.Mutex
+
+	// onInsert and onDelete, if set, observe keys entering and leaving
+	// the map of the timer mode. They are called with the key's shard
+	// locked exclusively.
+	onInsert, onDelete func(key interface{})
+
+	// expireFns holds the []ExpireFunc registered with OnExpire, which
+	// expireMu serializes adding to.
+	expireFns atomic.Value
+	expireMu  sync.Mutex
+
+	// deltas holds the []*DeltaTracker created by TrackDeltas, which
+	// deltasMu serializes changes to.
+	deltas   atomic.Value
+	deltasMu sync.Mutex
+
+	// pairs tracks the sub-values recorded by CountPair.
+	pairsOnce sync.Once
+	pairs     *pairTracker
+
+	// slots tracks the slots marked with MarkSlot.
+	slotsOnce sync.Once
+	slots     *slotTracker
+
+	// totals holds the *windowTotals installed by OnWindowComplete.
+	totals     atomic.Value
+	totalsOnce sync.Once
+
+	// errorBudgets holds the *errorBudgets installed by Budget.
+	errorBudgets     atomic.Value
+	errorBudgetsOnce sync.Once
+
+	// thresholds holds the *thresholds installed by OnThreshold.
+	thresholds     atomic.Value
+	thresholdsOnce sync.Once
+
+	// blocks holds the keys blocked with Block.
+	blocks blocklist
+
+	// clock schedules expirations; see WithClock.
+	clock Clock
+
+	// wheel schedules the retractions of the timer mode.
+	wheel *wheel
+
+	config
 }
 
 // NewEHC will return an Expiring Hash Counter. Each increment will be removed
 // after the window elapses, allowing you to know that a particular key has been
 // counted exactly so many times over the past duration.
-func NewEHC(window time.Duration) *EHC {
-	return &EHC{
-		values: map[interface{}]Counter{},
+func NewEHC(window time.Duration, opts ...Option) *EHC {
+	return newEHC(window, realClock{}, opts...)
+}
+
+func newEHC(window time.Duration, clk Clock, opts ...Option) *EHC {
+	e := &EHC{
 		window: window,
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&e.config)
+	}
+	if e.customClock != nil {
+		clk = e.customClock
+	}
+	e.clock = clk
+	e.started = clk.Now()
+	e.blocks.clock = clk
+	e.applyPreset(window)
+	e.shards = e.config.newShards()
+	e.seed = maphash.MakeSeed()
+	e.expiry = e.config.newExpiry(window, clk.Now())
+	if e.expiry == nil {
+		e.wheel = newWheel(e.wheelTick, clk)
+		e.wheel.stats = &e.stats
+	}
+	if e.arenaChunkSize > 0 {
+		e.arena = newArena(e.arenaChunkSize, window)
+	}
+	if e.auditEntries > 0 {
+		e.audits = newAuditLog(e.auditEntries)
+	}
+	if e.keyHistoryLen > 0 {
+		interval := e.keyHistoryInterval
+		if interval <= 0 {
+			interval = window / 16
+		}
+		e.keyHistory = newKeyHistory(e.keyHistoryLen, interval)
+	}
+	if e.wheel != nil && e.keyHistory != nil {
+		e.wheel.onSweep = e.sampleKeys
+	}
+	if e.maxMemory > 0 && e.expiry == nil {
+		e.memory = newMemoryCeiling(e.maxMemory, e.wheel, window, clk.Now(), e.seed)
+	}
+	if e.adaptive != nil {
+		e.adapt = newAdaptive(*e.adaptive, clk.Now())
+	}
+	if e.maintenanceBudget > 0 {
+		e.budget = newBudget(e.maintenanceBudget)
 	}
+	if _, ok := clk.(realClock); ok {
+		e.startCoarseClock()
+	}
+	e.prof.Store(e.profiler)
+	e.storeCallSites()
+	e.storeCallbackPool()
+	e.storeGlobalTotal()
+	return e
+}
+
+// Window returns the measurement window the EHC was created with.
+func (e *EHC) Window() time.Duration {
+	return e.window
 }
 
+// valuesBatchSize is the batch size Values asks stores for.
+const valuesBatchSize = 1024
+
 // Values will lock the mutex, then return the map reference and the lock.
-// You must unlock it.
+// You must unlock it. Snapshot is easier to use where copying is affordable.
+//
+// With more than one shard (see WithShards) or WithStore the map is a copy of
+// the contents, and with an ExpiryStrategy, such as WithGenerations, it is a
+// snapshot of the counts.
 func (e *EHC) Values() (map[interface{}]Counter, sync.Locker) {
 	e.valueLock.RLock()
-	return e.values, e.valueLock.RLocker()
+	if e.expiry != nil {
+		totals := e.expiry.Snapshot(e.now())
+		values := make(map[interface{}]Counter, len(totals))
+		for k, v := range totals {
+			values[k] = fixedCounter(v)
+		}
+		return values, e.valueLock.RLocker()
+	}
+	if len(e.shards) == 1 {
+		s := e.shards[0]
+		if m, ok := s.values.(mapStore); ok {
+			s.mu.RLock()
+			return m, lockers{s.mu.RLocker(), e.valueLock.RLocker()}
+		}
+	}
+	values := map[interface{}]Counter{}
+	for _, s := range e.shards {
+		s.mu.RLock()
+		for cursor := uint64(0); ; {
+			var batch []StoreEntry
+			batch, cursor = s.values.IterateBatch(cursor, valuesBatchSize)
+			for _, entry := range batch {
+				values[entry.Key] = entry.Counter
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return values, e.valueLock.RLocker()
+}
+
+// Snapshot returns a copy of the current count of every key. Unlike Values,
+// it holds no locks once it returns, so the caller owns the map and writers
+// aren't held up while it is iterated, at the cost of copying it. Keys that
+// are lingering (see WithLinger) are included with a count of zero.
+func (e *EHC) Snapshot() map[interface{}]int64 {
+	e.valueLock.RLock()
+	defer e.valueLock.RUnlock()
+
+	if e.expiry != nil {
+		return e.expiry.Snapshot(e.now())
+	}
+	counts := map[interface{}]int64{}
+	e.rangeCounters(func(key interface{}, c *counter) bool {
+		counts[key] = c.Value()
+		return true
+	})
+	return counts
+}
+
+// Get returns the current count of key, and whether the key is in the EHC
+// at all, which includes lingering keys (see WithLinger) and, with an
+// ExpiryStrategy, is the case while the count isn't zero. It is the cheapest
+// way to read a single key, as for a rate limit check.
+func (e *EHC) Get(key interface{}) (int64, bool) {
+	e.valueLock.RLock()
+	defer e.valueLock.RUnlock()
+
+	key, ok := e.normalizeKey(key)
+	if !ok {
+		return 0, false
+	}
+	if e.expiry != nil {
+		n := e.expiry.Value(key, e.now())
+		return n, n != 0
+	}
+	n := e.overflowValue(key)
+	if c := e.lookup(key); c != nil {
+		if e.touchOnGet {
+			c.touch(e.clock.Now())
+		}
+		return c.Value() + n, true
+	}
+	return n, n != 0
+}
+
+// Len returns the number of keys in the EHC, as Get sees them, without
+// copying or locking the map for the caller, e.g. to monitor cardinality. In
+// the default timer mode it is the number of keys in the map, including any
+// lingering at zero or held by reservations, and takes a moment per shard.
+// With an ExpiryStrategy it is the number of keys whose count isn't zero,
+// found with a Snapshot.
+func (e *EHC) Len() int {
+	return e.liveKeys()
+}
+
+// Keys returns a copy of the keys in the EHC, the ones Len counts, in no
+// particular order, so that callers can go on to work on each key without
+// holding any lock of the EHC, as they would iterating over Values.
+func (e *EHC) Keys() []interface{} {
+	e.valueLock.RLock()
+	defer e.valueLock.RUnlock()
+
+	if e.expiry != nil {
+		counts := e.expiry.Snapshot(e.now())
+		keys := make([]interface{}, 0, len(counts))
+		for k := range counts {
+			keys = append(keys, k)
+		}
+		return keys
+	}
+	var keys []interface{}
+	e.rangeCounters(func(key interface{}, _ *counter) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// value returns the current count for key, which must already be normalized.
+func (e *EHC) value(key interface{}) int64 {
+	e.valueLock.RLock()
+	defer e.valueLock.RUnlock()
+
+	if e.expiry != nil {
+		return e.expiry.Value(key, e.now())
+	}
+	n := e.overflowValue(key)
+	if c := e.lookup(key); c != nil {
+		return c.Value() + n
+	}
+	return n
 }
 
 // Count increments the counter mapped to key by 1
@@ -43,50 +354,186 @@ func (e *EHC) Count(key interface{}) {
 
 // CountMultiple increments the counter mapped to key by the given count
 func (e *EHC) CountMultiple(key interface{}, count int64) {
+	prof := e.Profiler()
+	t := prof.start()
 	e.valueLock.RLock()
-	counter := e.values[key]
+	t = prof.done(PhaseLock, t)
+
+	if e.closed || e.bypassed(key, BypassCounting) {
+		e.valueLock.RUnlock()
+		return
+	}
+
+	key, ok := e.normalizeKey(key)
+	if !ok {
+		e.valueLock.RUnlock()
+		atomic.AddInt64(&e.stats.dropped, 1)
+		return
+	}
+	if e.expiry != nil {
+		counted := e.expiryCount(key, count)
+		prof.done(PhaseMap, t)
+		e.valueLock.RUnlock()
+		if counted {
+			e.counted(key, count)
+		}
+		return
+	}
+
+	s := e.shardFor(key)
+	atomic.AddInt64(&s.increments, 1)
+	s.rlock()
+	counter := s.values.Get(key)
+	t = prof.done(PhaseMap, t)
 	// does this counter exist?
 	if counter != nil {
-		// if it does exist, increment it
-		counter.inc(count)
+		// if it does exist, increment it, unless it was
+		// retired by a concurrent removal, in which case a
+		// fresh counter has to be created as if it never was
+		if counter.inc(count) {
+			prof.done(PhaseExpiry, t)
+			s.mu.RUnlock()
+			e.valueLock.RUnlock()
+			e.counted(key, count)
+			return
+		}
+	}
+	s.mu.RUnlock()
+
+	// doesn't exist yet, so let's acquire
+	// the shard's exclusive lock to create the
+	// counter, unless there is nothing to count,
+	// as it would then never expire
+	if count == 0 {
 		e.valueLock.RUnlock()
 		return
 	}
 
-	// doesn't exist yet, so let's acquire
-	// an exclusive lock to create the counter
-	e.valueLock.RUnlock()
-	e.valueLock.Lock()
+	// validate before taking the exclusive lock so that
+	// a slow validator doesn't stall the rest of the shard
+	if !e.validate(key) {
+		e.valueLock.RUnlock()
+		return
+	}
+
+	// at the memory ceiling, there's no room for another key
+	if e.memory != nil && e.memory.full() {
+		e.memory.overflow.add(key, count, e.clock.Now())
+		e.valueLock.RUnlock()
+		e.counted(key, count)
+		return
+	}
+
+	t = prof.start()
+	s.lock()
+	t = prof.done(PhaseLock, t)
 
 	// we need to check that no one raced us here;
 	// the counter may have already been created while
 	// we were waiting our turn for the Lock()
-	counter = e.values[key]
-	if counter == nil {
-		// if no one raced us here, let's create the counter
-		e.values[key] = newCounter(e, key)
+	c := e.counterLocked(s, key)
+	t = prof.done(PhaseMap, t)
+
+	// increment while still holding the lock, rather than
+	// starting over, so that the counter can't expire from
+	// under us and the lookup isn't repeated
+	c.inc(count)
+	prof.done(PhaseExpiry, t)
+	s.mu.Unlock()
+	e.valueLock.RUnlock()
+	e.counted(key, count)
+}
+
+// counterLocked returns the counter for key in s, its shard, creating it if
+// needed. s.mu must be held exclusively.
+func (e *EHC) counterLocked(s *shard, key interface{}) *counter {
+	if c, ok := s.values.Get(key).(*counter); ok {
+		return c
+	}
+	if str, ok := key.(string); ok && e.interner != nil {
+		key = e.interner.acquire(str)
+	}
+	c := newCounter(e, key)
+	s.values.Put(key, c)
+	if e.memory != nil {
+		e.memory.addKey(key, 1)
+	}
+	if n := s.values.Len(); n > s.peakKeys {
+		s.peakKeys = n
 	}
-	e.valueLock.Unlock()
+	if e.adapt != nil {
+		e.adapt.observe(&e.adapt.newKeys, e.now())
+	}
+	if e.onInsert != nil {
+		e.onInsert(key)
+	}
+	return c
+}
+
+// validate runs the key validator for a key about to be inserted, recording a
+// drop if it is rejected.
+func (e *EHC) validate(key interface{}) bool {
+	if e.validateKey != nil && e.validateKey(key) != nil {
+		atomic.AddInt64(&e.stats.dropped, 1)
+		return false
+	}
+	return true
+}
 
-	// now we can call Count and have it actually be applied
-	e.CountMultiple(key, count)
+func (e *EHC) remove(c *counter) {
+	// a counter only created for a reservation that came to nothing
+	// never held a count, so there is nothing to tell of its expiry;
+	// retiring it ordered any increment before this read
+	if e.removeIfEmpty(c) && c.counted {
+		e.expired(c.key)
+	}
 }
 
-func (e *EHC) remove(key interface{}) {
-	e.valueLock.Lock()
-	defer e.valueLock.Unlock()
+// removeIfEmpty removes c from the map if it is still the key's counter and
+// still empty, reporting whether it did.
+func (e *EHC) removeIfEmpty(c *counter) bool {
+	e.valueLock.RLock()
+	defer e.valueLock.RUnlock()
+	s := e.shardFor(c.key)
+	s.lock()
+	defer s.mu.Unlock()
 
 	// let's check to make sure the value wasn't incremented
-	// while we were preparing to remove it
-	val := e.values[key]
-	if val != nil && val.Value() == 0 {
-		delete(e.values, key)
+	// while we were preparing to remove it, and that the
+	// counter wasn't replaced by a migration in the meantime;
+	// once retired, any increment racing with us fails and
+	// is retried by its caller on a fresh counter
+	if s.values.Get(c.key) == Counter(c) && c.retireIfEmpty() {
+		e.deleteLocked(s, c)
+		e.maybeCompactLocked(s)
+		return true
+	}
+	return false
+}
+
+// deleteLocked removes c from s, its shard, and releases what it holds.
+// s.mu must be held exclusively.
+func (e *EHC) deleteLocked(s *shard, c *counter) {
+	s.values.Delete(c.key)
+	if c.chunk != nil {
+		e.arena.release(c)
+	}
+	if s, ok := c.key.(string); ok && e.interner != nil {
+		e.interner.release(s)
+	}
+	if e.memory != nil {
+		e.memory.addKey(c.key, -1)
+	}
+	if e.onDelete != nil {
+		e.onDelete(c.key)
 	}
 }
 
 // Counter is the public interface for what is stored in the map
 type Counter interface {
-	inc(int64)
+	// inc reports false if the counter was retired and the
+	// increment must be retried on the key's new counter.
+	inc(int64) bool
 	Value() int64
 }
 
@@ -95,30 +542,177 @@ type counter struct {
 	count  int64
 	parent *EHC
 	key    interface{}
+
+	// chunk is the arena chunk holding this counter, if any.
+	chunk *chunk
+
+	// mu guards pending, the increments still waiting to be
+	// retracted, oldest first, reserved, the cost held by
+	// outstanding reservations, retired, which is set once
+	// the counter is removed from the map and never cleared,
+	// and counted, which is set by its first increment.
+	mu       sync.Mutex
+	pending  []*retraction
+	reserved int64
+	retired  bool
+	counted  bool
 }
 
-func newCounter(parent *EHC, key interface{}) Counter {
+// retraction is a scheduled decrement of a counter.
+type retraction struct {
+	deadline time.Time
+	count    int64
+	counter  *counter
+
+	// tick is the wheel tick it is scheduled for, and level and index
+	// where in the wheel it is, or index -1 once taken off it; they are
+	// guarded by the wheel's mutex.
+	tick  int64
+	index int32
+	level uint8
+	// done is set, under the counter's mutex, once the retraction has
+	// been applied or no longer applies, for the wheel to skip it.
+	done bool
+}
+
+// currentResolution returns the resolution new expirations are batched at.
+func (e *EHC) currentResolution() time.Duration {
+	if e.adapt != nil {
+		return e.adapt.currentResolution()
+	}
+	return e.resolution
+}
+
+// roundUp rounds t up to the next multiple of d since the Unix epoch.
+func roundUp(t time.Time, d time.Duration) time.Time {
+	ns := t.UnixNano()
+	if rem := ns % int64(d); rem != 0 {
+		ns += int64(d) - rem
+	}
+	return time.Unix(0, ns)
+}
+
+func newCounter(parent *EHC, key interface{}) *counter {
+	if parent.arena != nil {
+		c := parent.arena.alloc()
+		c.parent = parent
+		c.key = key
+		return c
+	}
 	return &counter{
 		parent: parent,
 		key:    key,
 	}
 }
 
-func (c *counter) inc(count int64) {
-	if count == 0 {
+func (c *counter) inc(count int64) bool {
+	now := c.parent.clock.Now()
+	return c.add(count, now.Add(c.parent.window), now)
+}
+
+// add increments the counter by count, to be retracted at deadline. It
+// reports false, without counting, if the counter was retired.
+func (c *counter) add(count int64, deadline, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.retired {
+		return false
+	}
+	if count != 0 {
+		c.addLocked(count, deadline, now)
+	}
+	return true
+}
+
+// addLocked is add for callers already holding c.mu.
+func (c *counter) addLocked(count int64, deadline, now time.Time) {
+	c.counted = true
+	if res := c.parent.currentResolution(); res > 0 {
+		// round up to the next resolution boundary so that increments
+		// landing in the same slot can share a single timer
+		deadline = roundUp(deadline, res)
+		if n := len(c.pending); n > 0 && c.pending[n-1].deadline.Equal(deadline) {
+			c.addCount(count)
+			c.pending[n-1].count += count
+			return
+		}
+	}
+
+	// increments due in the same wheel tick are retracted together
+	// anyway, so they can share a retraction
+	deadline = roundUp(deadline, c.parent.wheel.tick)
+	if n := len(c.pending); n > 0 && c.pending[n-1].deadline.Equal(deadline) {
+		c.addCount(count)
+		c.pending[n-1].count += count
 		return
 	}
 
-	atomic.AddInt64(&c.count, count)
+	// at the memory ceiling, there's no room for another retraction,
+	// unless the counter has none: its key was admitted, and without
+	// one it would sit in the map at zero, never to expire
+	if m := c.parent.memory; m != nil && len(c.pending) > 0 && m.full() {
+		m.overflow.add(c.key, count, now)
+		return
+	}
+	c.addCount(count)
 
 	// after the window has elapsed, retract this increment
-	time.AfterFunc(c.parent.window, func() {
-		value := atomic.AddInt64(&c.count, -count)
-		// if we hit zero, remove this counter from the map
-		if value == 0 {
-			c.parent.remove(c.key)
+	if c.parent.adapt != nil {
+		c.parent.adapt.observe(&c.parent.adapt.timers, now)
+	}
+	r := &retraction{deadline: deadline, count: count, counter: c}
+	c.pending = append(c.pending, r)
+	c.parent.wheel.schedule(r)
+}
+
+// retract takes back an increment whose window has elapsed.
+func (c *counter) retract(r *retraction) {
+	c.mu.Lock()
+	if r.done {
+		c.mu.Unlock()
+		return
+	}
+	r.done = true
+	count := r.count
+	if len(c.pending) > 0 && c.pending[0] == r {
+		// the usual case, as retractions come due in order
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+	} else {
+		for i, p := range c.pending {
+			if p == r {
+				c.pending = append(c.pending[:i], c.pending[i+1:]...)
+				break
+			}
 		}
-	})
+	}
+	c.mu.Unlock()
+
+	value := c.addCount(-count)
+	// if we hit zero, remove this counter from the map
+	if value == 0 {
+		c.parent.zeroed(c)
+	}
+}
+
+// empty reports whether the counter holds neither counts nor reservations,
+// so that it may be removed from the map.
+func (c *counter) empty() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Value() == 0 && c.reserved == 0
+}
+
+// retireIfEmpty retires the counter if it is empty, reporting whether it
+// did. Retired counters reject further increments.
+func (c *counter) retireIfEmpty() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Value() != 0 || c.reserved != 0 {
+		return false
+	}
+	c.retired = true
+	return true
 }
 
 // Value returns the current value held in the atomic counter
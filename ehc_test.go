@@ -1,6 +1,12 @@
 package ehc
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -133,6 +139,16 @@ func BenchmarkEHC_Uniques(b *testing.B) {
 	}
 }
 
+func BenchmarkEHC_UniquesParallel(b *testing.B) {
+	e := NewEHC(10 * time.Millisecond)
+	var next int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			e.Count(atomic.AddInt64(&next, 1))
+		}
+	})
+}
+
 func BenchmarkEHC_Same(b *testing.B) {
 	e := NewEHC(10 * time.Millisecond)
 	for i := 0; i < b.N; i++ {
@@ -157,3 +173,221 @@ func BenchmarkEHC_MostlyDistribution(b *testing.B) {
 		}
 	}
 }
+
+func TestEHC_KeyValidator(t *testing.T) {
+	e := NewEHC(10*time.Millisecond, WithKeyValidator(func(key interface{}) error {
+		if s, ok := key.(string); ok && len(s) > 4 {
+			return errors.New("key too long")
+		}
+		return nil
+	}))
+	e.Count("ok")
+	e.Count("too long")
+	e.Count("too long")
+
+	values, locker := e.Values()
+	if len(values) != 1 || values["ok"] == nil {
+		t.Errorf("EHC.Values() = %v, want only the valid key", values)
+	}
+	locker.Unlock()
+
+	if dropped := e.Stats().Dropped; dropped != 2 {
+		t.Errorf("EHC.Stats().Dropped = %d, want 2", dropped)
+	}
+}
+
+func TestEHC_RetiredCounter(t *testing.T) {
+	e := NewEHC(time.Hour)
+	e.Count("k")
+	values, locker := e.Values()
+	c := values["k"].(*counter)
+	locker.Unlock()
+
+	// the count is retracted, and the counter removed, between a
+	// caller's lookup and its increment
+	for _, r := range c.pending {
+		c.retract(r)
+	}
+	if c.inc(1) {
+		t.Error("counter.inc() succeeded on a removed counter")
+	}
+
+	e.Count("k")
+	if v := e.value("k"); v != 1 {
+		t.Errorf("EHC count of k = %d, want 1 on a fresh counter", v)
+	}
+}
+
+func TestEHC_RemoveIncStress(t *testing.T) {
+	const (
+		workers = 8
+		counts  = 1000
+		keys    = 4
+	)
+	e := NewManualEHC(time.Second, time.Unix(0, 0))
+
+	run := func(expire bool) {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if expire {
+					e.Tick(time.Second)
+				}
+				values, locker := e.Values()
+				var cs []*counter
+				for _, c := range values {
+					cs = append(cs, c.(*counter))
+				}
+				locker.Unlock()
+				for _, c := range cs {
+					e.remove(c)
+				}
+			}
+		}()
+
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < counts; i++ {
+					e.Count((w + i) % keys)
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(stop)
+		<-done
+	}
+
+	// churn counters through expiry and removal while counting
+	run(true)
+	e.Tick(time.Second)
+
+	// then count with removals racing every increment; none may be lost
+	run(false)
+	var total int64
+	for k := 0; k < keys; k++ {
+		total += e.value(k)
+	}
+	if total != workers*counts {
+		t.Errorf("EHC total count = %d, want %d", total, workers*counts)
+	}
+
+	e.Tick(time.Second)
+	values, locker := e.Values()
+	if len(values) != 0 {
+		t.Errorf("EHC.Values() = %v after the window, want empty", values)
+	}
+	locker.Unlock()
+}
+
+func TestEHC_Snapshot(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"timers":      nil,
+		"shards":      {WithShards(4)},
+		"generations": {WithGenerations(4)},
+		"compact":     {WithCompactBuckets(4)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := NewEHC(time.Minute, opts...)
+			e.CountMultiple("a", 2)
+			e.Count("b")
+
+			snapshot := e.Snapshot()
+			// the snapshot holds no locks, and later counts don't change it
+			e.Count("a")
+			e.Count("c")
+			if len(snapshot) != 2 || snapshot["a"] != 2 || snapshot["b"] != 1 {
+				t.Errorf("EHC.Snapshot() = %v, want a: 2 and b: 1", snapshot)
+			}
+			if snapshot := e.Snapshot(); len(snapshot) != 3 || snapshot["a"] != 3 {
+				t.Errorf("EHC.Snapshot() = %v, want a: 3, b and c", snapshot)
+			}
+		})
+	}
+}
+
+func TestEHC_Len(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"timers":      nil,
+		"generations": {WithGenerations(4)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := NewManualEHC(time.Minute, time.Unix(0, 0), opts...)
+			if n := e.Len(); n != 0 {
+				t.Errorf("EHC.Len() = %d when empty, want 0", n)
+			}
+			e.CountMultiple("a", 3)
+			e.Count("b")
+			e.Tick(30 * time.Second)
+			e.Count("c")
+			if n := e.Len(); n != 3 {
+				t.Errorf("EHC.Len() = %d, want 3", n)
+			}
+			e.Tick(2 * time.Minute)
+			if n := e.Len(); n != 0 {
+				t.Errorf("EHC.Len() = %d once expired, want 0", n)
+			}
+		})
+	}
+}
+
+func TestEHC_Keys(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"timers":      nil,
+		"generations": {WithGenerations(4)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := NewManualEHC(time.Minute, time.Unix(0, 0), opts...)
+			e.CountMultiple("a", 3)
+			e.Count(2)
+			keys := e.Keys()
+			sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+			if !reflect.DeepEqual(keys, []interface{}{2, "a"}) {
+				t.Errorf("EHC.Keys() = %v, want 2 and a", keys)
+			}
+			e.Tick(2 * time.Minute)
+			if keys := e.Keys(); len(keys) != 0 {
+				t.Errorf("EHC.Keys() = %v once expired, want none", keys)
+			}
+		})
+	}
+}
+
+func TestEHC_Get(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"timers":      nil,
+		"generations": {WithGenerations(4)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := NewManualEHC(time.Minute, time.Unix(0, 0), opts...)
+			e.CountMultiple("k", 3)
+
+			if n, ok := e.Get("k"); n != 3 || !ok {
+				t.Errorf("EHC.Get(k) = %d, %v, want 3, true", n, ok)
+			}
+			if n, ok := e.Get("other"); n != 0 || ok {
+				t.Errorf("EHC.Get(other) = %d, %v, want 0, false", n, ok)
+			}
+			e.Tick(2 * time.Minute)
+			if n, ok := e.Get("k"); n != 0 || ok {
+				t.Errorf("EHC.Get(k) = %d, %v after the window, want 0, false", n, ok)
+			}
+		})
+	}
+
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithLinger(time.Minute))
+	e.Count("k")
+	e.Tick(90 * time.Second)
+	if n, ok := e.Get("k"); n != 0 || !ok {
+		t.Errorf("EHC.Get(k) = %d, %v while lingering, want 0, true", n, ok)
+	}
+}
@@ -0,0 +1,155 @@
+// Package ehcclock provides a manual ehc.Clock, which only moves when told
+// to, so that tests can drive an EHC through time deterministically and
+// without sleeping.
+package ehcclock
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// Manual is an ehc.Clock whose time only changes with Advance and Set.
+// Timers fire during those calls, on the calling goroutine, in the order of
+// their deadlines, and those due at the same time in the order they were
+// scheduled. Use it with ehc.WithClock:
+//
+//	clock := ehcclock.NewManual(time.Unix(0, 0))
+//	e := ehc.NewEHC(time.Minute, ehc.WithClock(clock))
+//	e.Count("k")
+//	clock.Advance(time.Minute) // k expires
+type Manual struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int64
+	timers []*timer
+}
+
+// timer is a timer of a Manual clock.
+type timer struct {
+	c   *Manual
+	at  time.Time
+	seq int64
+	f   func()
+	// ch is the channel of a timer created by NewTimer.
+	ch chan time.Time
+}
+
+// NewManual returns a Manual clock set to start.
+func NewManual(start time.Time) *Manual {
+	return &Manual{now: start}
+}
+
+// Now returns the clock's current time.
+func (c *Manual) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc schedules f to run once the clock has moved d forward.
+func (c *Manual) AfterFunc(d time.Duration, f func()) ehc.Timer {
+	t := &timer{c: c, f: f}
+	t.Reset(d)
+	return t
+}
+
+// NewTimer returns a timer that sends the time on its channel once the clock
+// has moved d forward.
+func (c *Manual) NewTimer(d time.Duration) ehc.Timer {
+	t := &timer{c: c, ch: make(chan time.Time, 1)}
+	t.f = func() {
+		select {
+		case t.ch <- c.Now():
+		default:
+		}
+	}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers that come due on
+// the way, each with the clock set to its deadline. Timers scheduled by those
+// that come due within d fire too.
+func (c *Manual) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.runLocked(c.now.Add(d))
+	c.mu.Unlock()
+}
+
+// Set moves the clock to t, like Advance. Setting it back in time fires
+// nothing.
+func (c *Manual) Set(t time.Time) {
+	c.mu.Lock()
+	if t.Before(c.now) {
+		c.now = t
+	} else {
+		c.runLocked(t)
+	}
+	c.mu.Unlock()
+}
+
+// Pending returns the number of timers waiting to fire, for tests to wait
+// until the code under test has scheduled what they expect.
+func (c *Manual) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// runLocked fires the timers due by target, in order, and then moves the
+// clock to target. c.mu must be held; it is released while a timer fires.
+func (c *Manual) runLocked(target time.Time) {
+	for len(c.timers) > 0 && !c.timers[0].at.After(target) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = target
+}
+
+// removeLocked unschedules t, reporting whether it was pending.
+func (c *Manual) removeLocked(t *timer) bool {
+	for i, p := range c.timers {
+		if p == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *timer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	return t.c.removeLocked(t)
+}
+
+func (t *timer) Reset(d time.Duration) bool {
+	c := t.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pending := c.removeLocked(t)
+	c.seq++
+	t.at, t.seq = c.now.Add(d), c.seq
+	i := sort.Search(len(c.timers), func(i int) bool {
+		p := c.timers[i]
+		return p.at.After(t.at) || p.at.Equal(t.at) && p.seq > t.seq
+	})
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+	return pending
+}
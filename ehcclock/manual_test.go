@@ -0,0 +1,92 @@
+package ehcclock
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+func TestManual_Timers(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewManual(start)
+
+	var fired []string
+	c.AfterFunc(2*time.Second, func() { fired = append(fired, "b") })
+	c.AfterFunc(time.Second, func() {
+		fired = append(fired, "a")
+		if now := c.Now(); !now.Equal(start.Add(time.Second)) {
+			t.Errorf("Now() = %v in a timer, want its deadline", now)
+		}
+		// scheduled from a timer, and due within the same Advance
+		c.AfterFunc(time.Second, func() { fired = append(fired, "c") })
+	})
+	stopped := c.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	if !stopped.Stop() {
+		t.Error("Stop() = false for a pending timer")
+	}
+	timer := c.NewTimer(3 * time.Second)
+
+	c.Advance(1500 * time.Millisecond)
+	if len(fired) != 1 {
+		t.Errorf("fired %v after 1.5s, want [a]", fired)
+	}
+	select {
+	case <-timer.C():
+		t.Error("NewTimer fired early")
+	default:
+	}
+
+	c.Advance(2 * time.Second)
+	if want := "[a b c]"; fmt.Sprint(fired) != want {
+		t.Errorf("fired %v, want %s", fired, want)
+	}
+	select {
+	case now := <-timer.C():
+		if !now.Equal(start.Add(3 * time.Second)) {
+			t.Errorf("NewTimer sent %v, want %v", now, start.Add(3*time.Second))
+		}
+	default:
+		t.Error("NewTimer didn't fire")
+	}
+	if now := c.Now(); !now.Equal(start.Add(3500 * time.Millisecond)) {
+		t.Errorf("Now() = %v, want %v", now, start.Add(3500*time.Millisecond))
+	}
+	if n := c.Pending(); n != 0 {
+		t.Errorf("Pending() = %d, want 0", n)
+	}
+}
+
+func TestManual_EHC(t *testing.T) {
+	c := NewManual(time.Unix(0, 0))
+	e := ehc.NewMap[string](time.Minute, ehc.WithClock(c))
+	e.Count("k")
+	c.Advance(30 * time.Second)
+	e.Count("k")
+
+	if v := e.Value("k"); v != 2 {
+		t.Errorf("count of k = %d, want 2", v)
+	}
+	c.Advance(30 * time.Second)
+	if v := e.Value("k"); v != 1 {
+		t.Errorf("count of k = %d after 1m, want 1", v)
+	}
+
+	// WaitAllow sleeps on the clock rather than in real time
+	errc := make(chan error)
+	go func() { errc <- e.WaitAllow(context.Background(), "k", 1) }()
+	for c.Pending() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	// the waiter may wake just before the expiry it waits for is applied
+	// at the same instant, and then tries again a wheel tick later
+	c.Advance(31 * time.Second)
+	if err := <-errc; err != nil {
+		t.Errorf("WaitAllow() = %v", err)
+	}
+	if v := e.Value("k"); v != 1 {
+		t.Errorf("count of k = %d after WaitAllow, want 1", v)
+	}
+}
@@ -0,0 +1,197 @@
+package ehcexport
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// GraphiteConfig configures a Graphite exporter.
+type GraphiteConfig struct {
+	// Addr is the TCP address of the Carbon plaintext listener, such as
+	// "localhost:2003".
+	Addr string
+	// Prefix starts the path of every metric, followed by a dot.
+	Prefix string
+	// Interval is how often counts are sent, 10 seconds by default.
+	Interval time.Duration
+	// Timeout bounds connecting and every write, 5 seconds by default.
+	Timeout time.Duration
+	// MaxBuffered is the number of lines held while the server can't be
+	// reached, 10000 by default. Once it is reached, the oldest lines
+	// are dropped.
+	MaxBuffered int
+	// Path maps a key to the path of its metric. By default it is the
+	// key in its fmt.Sprint form, with every character other than
+	// letters, digits, dots, dashes and underscores replaced by an
+	// underscore. Whitespace is replaced in what Path returns either
+	// way, as it would break the line.
+	Path func(key interface{}) string
+	// ErrorHandler is told of every error connecting or sending to the
+	// server. Without it they are ignored.
+	ErrorHandler func(error)
+}
+
+// Graphite sends the counts of an EHC to a Graphite server, in the Carbon
+// plaintext protocol. It connects lazily, and after an error reconnects on
+// the next flush, holding the lines it couldn't send until then. Lines cut
+// off by an error are sent again whole, which Carbon takes as the same
+// point.
+type Graphite struct {
+	e   *ehc.EHC
+	cfg GraphiteConfig
+
+	mu   sync.Mutex
+	conn net.Conn
+	// pending is the lines not sent yet, oldest first.
+	pending []string
+	// paths is the paths last sent, to be zeroed once their keys go
+	// away.
+	paths map[string]bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewGraphite starts sending the counts of e to the Graphite server of cfg.
+func NewGraphite(e *ehc.EHC, cfg GraphiteConfig) *Graphite {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.MaxBuffered <= 0 {
+		cfg.MaxBuffered = 10000
+	}
+	g := &Graphite{
+		e:     e,
+		cfg:   cfg,
+		paths: map[string]bool{},
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go g.run()
+	return g
+}
+
+func (g *Graphite) run() {
+	defer close(g.done)
+	ticker := time.NewTicker(g.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+			if err := g.Flush(); err != nil && g.cfg.ErrorHandler != nil {
+				g.cfg.ErrorHandler(err)
+			}
+		}
+	}
+}
+
+// Close stops the exporter and closes its connection. Lines still buffered
+// are dropped.
+func (g *Graphite) Close() error {
+	close(g.stop)
+	<-g.done
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.conn == nil {
+		return nil
+	}
+	err := g.conn.Close()
+	g.conn = nil
+	return err
+}
+
+// Flush sends the counts now, rather than waiting for the interval, along
+// with any lines buffered by earlier errors. Keys that went away since the
+// last flush are sent once as 0.
+func (g *Graphite) Flush() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ts := " " + strconv.FormatInt(time.Now().Unix(), 10)
+	var lines []string
+	sent := map[string]bool{}
+	for k, v := range g.e.Snapshot() {
+		path := g.path(k)
+		lines = append(lines, path+" "+strconv.FormatInt(v, 10)+ts)
+		sent[path] = true
+	}
+	for path := range g.paths {
+		if !sent[path] {
+			lines = append(lines, path+" 0"+ts)
+		}
+	}
+	g.paths = sent
+	sort.Strings(lines)
+
+	g.pending = append(g.pending, lines...)
+	if over := len(g.pending) - g.cfg.MaxBuffered; over > 0 {
+		g.pending = append(g.pending[:0], g.pending[over:]...)
+	}
+	return g.sendLocked()
+}
+
+// sendLocked writes the pending lines, connecting first if needed. g.mu
+// must be held.
+func (g *Graphite) sendLocked() error {
+	if len(g.pending) == 0 {
+		return nil
+	}
+	if g.conn == nil {
+		conn, err := net.DialTimeout("tcp", g.cfg.Addr, g.cfg.Timeout)
+		if err != nil {
+			return err
+		}
+		g.conn = conn
+	}
+	var buf strings.Builder
+	for _, line := range g.pending {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	g.conn.SetWriteDeadline(time.Now().Add(g.cfg.Timeout))
+	if _, err := g.conn.Write([]byte(buf.String())); err != nil {
+		g.conn.Close()
+		g.conn = nil
+		return err
+	}
+	g.pending = g.pending[:0]
+	return nil
+}
+
+// path returns the path of key's metric.
+func (g *Graphite) path(key interface{}) string {
+	var path string
+	if g.cfg.Path != nil {
+		path = strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+				return '_'
+			}
+			return r
+		}, g.cfg.Path(key))
+	} else {
+		path = strings.Map(func(r rune) rune {
+			switch {
+			case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9',
+				r == '.', r == '-', r == '_':
+				return r
+			}
+			return '_'
+		}, fmt.Sprint(key))
+	}
+	if g.cfg.Prefix != "" {
+		path = g.cfg.Prefix + "." + path
+	}
+	return path
+}
@@ -0,0 +1,146 @@
+package ehcexport
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// acceptLines accepts one connection on l and returns a function reading
+// the next line sent on it, without its timestamp.
+func acceptLines(t *testing.T, l net.Listener) func() string {
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conns <- conn
+		}
+	}()
+	var r *bufio.Reader
+	return func() string {
+		if r == nil {
+			select {
+			case conn := <-conns:
+				t.Cleanup(func() { conn.Close() })
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				r = bufio.NewReader(conn)
+			case <-time.After(5 * time.Second):
+				t.Fatal("no connection")
+			}
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			t.Fatalf("line %q, want a path, value and timestamp", line)
+		}
+		return fields[0] + " " + fields[1]
+	}
+}
+
+func TestGraphite(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	read := acceptLines(t, l)
+
+	e := ehc.NewManualEHC(time.Minute, time.Unix(0, 0))
+	g := NewGraphite(e.EHC, GraphiteConfig{Addr: l.Addr().String(), Prefix: "app", Interval: time.Hour})
+	defer g.Close()
+
+	e.CountMultiple("GET /users", 3)
+	e.Count("b")
+	if err := g.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"app.GET__users 3", "app.b 1"} {
+		if got := read(); got != want {
+			t.Errorf("line = %q, want %q", got, want)
+		}
+	}
+
+	// keys that went away are zeroed once
+	e.Tick(time.Minute)
+	e.Count("b")
+	g.Flush()
+	g.Flush()
+	for _, want := range []string{"app.GET__users 0", "app.b 1", "app.b 1"} {
+		if got := read(); got != want {
+			t.Errorf("line = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestGraphite_Path(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	read := acceptLines(t, l)
+
+	e := ehc.NewEHC(time.Minute)
+	g := NewGraphite(e, GraphiteConfig{
+		Addr:     l.Addr().String(),
+		Interval: time.Hour,
+		Path: func(key interface{}) string {
+			k := key.(ehc.ErrorKey)
+			return "errors." + k.Class + "." + k.Key.(string)
+		},
+	})
+	defer g.Close()
+
+	e.Count(ehc.ErrorKey{Key: "a b", Class: "timeout"})
+	g.Flush()
+	if got, want := read(), "errors.timeout.a_b 1"; got != want {
+		t.Errorf("line = %q, want %q", got, want)
+	}
+}
+
+func TestGraphite_Reconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	e := ehc.NewManualEHC(time.Minute, time.Unix(0, 0))
+	g := NewGraphite(e.EHC, GraphiteConfig{Addr: addr, Interval: time.Hour, MaxBuffered: 3})
+	defer g.Close()
+
+	// the lines are buffered while the server is down, and only the
+	// last MaxBuffered are kept
+	e.Count("a")
+	if err := g.Flush(); err == nil {
+		t.Fatal("Flush() succeeded with the server down")
+	}
+	e.Count("b")
+	if err := g.Flush(); err == nil {
+		t.Fatal("Flush() succeeded with the server down")
+	}
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skip("can't listen again on", addr, err)
+	}
+	defer l.Close()
+	read := acceptLines(t, l)
+	e.Tick(time.Minute)
+	if err := g.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"b 1", "a 0", "b 0"} {
+		if got := read(); got != want {
+			t.Errorf("line = %q, want %q", got, want)
+		}
+	}
+}
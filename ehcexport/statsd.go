@@ -0,0 +1,198 @@
+// Package ehcexport periodically exports the counts of an EHC to monitoring
+// systems such as StatsD and Graphite.
+package ehcexport
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// maxPacket is the size StatsD packets are kept under, so that they aren't
+// fragmented on common networks.
+const maxPacket = 1432
+
+// StatsDConfig configures a StatsD exporter.
+type StatsDConfig struct {
+	// Addr is the UDP address of the StatsD server, such as
+	// "localhost:8125".
+	Addr string
+	// Prefix starts the name of every metric, followed by a dot.
+	Prefix string
+	// Interval is how often counts are sent, 10 seconds by default.
+	Interval time.Duration
+	// Deltas sends what was counted since the last flush, as StatsD
+	// counters, instead of the counts over the window as gauges.
+	Deltas bool
+	// Metric maps a key to the name of its metric, and any DogStatsD
+	// tags, as "name:value" or "name". By default the name is the key
+	// in its fmt.Sprint form, without tags. Characters StatsD doesn't
+	// allow in names are replaced by underscores.
+	Metric func(key interface{}) (name string, tags []string)
+	// ErrorHandler is told of every error sending to the server.
+	// Without it they are ignored.
+	ErrorHandler func(error)
+}
+
+// StatsD sends the counts of an EHC to a StatsD, or DogStatsD, server.
+type StatsD struct {
+	e      *ehc.EHC
+	cfg    StatsDConfig
+	conn   net.Conn
+	deltas *ehc.DeltaTracker
+
+	mu sync.Mutex
+	// gauges is the metrics last sent as gauges, by name and tags, to
+	// be zeroed once their keys go away.
+	gauges map[string]bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewStatsD starts sending the counts of e to the StatsD server of cfg.
+func NewStatsD(e *ehc.EHC, cfg StatsDConfig) (*StatsD, error) {
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	s := &StatsD{
+		e:      e,
+		cfg:    cfg,
+		conn:   conn,
+		gauges: map[string]bool{},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if cfg.Deltas {
+		s.deltas = e.TrackDeltas()
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *StatsD) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil && s.cfg.ErrorHandler != nil {
+				s.cfg.ErrorHandler(err)
+			}
+		}
+	}
+}
+
+// Close stops the exporter, after sending what was counted since the last
+// flush if Deltas is set.
+func (s *StatsD) Close() error {
+	close(s.stop)
+	<-s.done
+	var err error
+	if s.deltas != nil {
+		err = s.Flush()
+		s.deltas.Stop()
+	}
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Flush sends the counts now, rather than waiting for the interval.
+func (s *StatsD) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lines []string
+	if s.deltas != nil {
+		for k, v := range s.deltas.Take() {
+			name, tags := s.metric(k)
+			lines = append(lines, statsdLine(name, tags, v, "c"))
+		}
+	} else {
+		sent := map[string]bool{}
+		for k, v := range s.e.Snapshot() {
+			name, tags := s.metric(k)
+			lines = append(lines, statsdLine(name, tags, v, "g"))
+			sent[name+tags] = true
+		}
+		for m := range s.gauges {
+			if !sent[m] {
+				name, tags, _ := strings.Cut(m, "|#")
+				if tags != "" {
+					tags = "|#" + tags
+				}
+				lines = append(lines, statsdLine(name, tags, 0, "g"))
+			}
+		}
+		s.gauges = sent
+	}
+	sort.Strings(lines)
+
+	var packet bytes.Buffer
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacket {
+			if _, err := s.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		if _, err := s.conn.Write(packet.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metric returns the name of key's metric, and its tags as a suffix of
+// the line.
+func (s *StatsD) metric(key interface{}) (name, tags string) {
+	var tagList []string
+	if s.cfg.Metric != nil {
+		name, tagList = s.cfg.Metric(key)
+	} else {
+		name = fmt.Sprint(key)
+	}
+	if s.cfg.Prefix != "" {
+		name = s.cfg.Prefix + "." + name
+	}
+	name = statsdSanitizer.Replace(name)
+	if len(tagList) > 0 {
+		sanitized := make([]string, len(tagList))
+		for i, tag := range tagList {
+			sanitized[i] = tagSanitizer.Replace(tag)
+		}
+		tags = "|#" + strings.Join(sanitized, ",")
+	}
+	return name, tags
+}
+
+func statsdLine(name, tags string, value int64, typ string) string {
+	return name + ":" + strconv.FormatInt(value, 10) + "|" + typ + tags
+}
+
+var (
+	statsdSanitizer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", "\n", "_", ",", "_")
+	tagSanitizer    = strings.NewReplacer("|", "_", "@", "_", "#", "_", "\n", "_", ",", "_")
+)
@@ -0,0 +1,111 @@
+package ehcexport
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// listenStatsD returns a UDP listener and a function reading the next
+// packet it receives.
+func listenStatsD(t *testing.T) (net.PacketConn, func() string) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc, func() string {
+		buf := make([]byte, 65536)
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+}
+
+func TestStatsD_Gauges(t *testing.T) {
+	pc, read := listenStatsD(t)
+	e := ehc.NewManualEHC(time.Minute, time.Unix(0, 0))
+	s, err := NewStatsD(e.EHC, StatsDConfig{
+		Addr:     pc.LocalAddr().String(),
+		Prefix:   "app",
+		Interval: time.Hour,
+		Metric: func(key interface{}) (string, []string) {
+			if k, ok := key.(ehc.ErrorKey); ok {
+				return "errors", []string{"class:" + k.Class}
+			}
+			return key.(string), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	e.CountMultiple("a:b", 3)
+	e.Count(ehc.ErrorKey{Key: "x", Class: "timeout"})
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := read(), "app.a_b:3|g\napp.errors:1|g|#class:timeout"; got != want {
+		t.Errorf("packet = %q, want %q", got, want)
+	}
+
+	// the gauges of keys that went away are zeroed once
+	e.Tick(time.Minute)
+	e.Count("c")
+	s.Flush()
+	if got, want := read(), "app.a_b:0|g\napp.c:1|g\napp.errors:0|g|#class:timeout"; got != want {
+		t.Errorf("packet = %q, want %q", got, want)
+	}
+}
+
+func TestStatsD_Deltas(t *testing.T) {
+	pc, read := listenStatsD(t)
+	e := ehc.NewEHC(time.Minute)
+	s, err := NewStatsD(e, StatsDConfig{Addr: pc.LocalAddr().String(), Interval: time.Hour, Deltas: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.CountMultiple("a", 3)
+	s.Flush()
+	if got := read(); got != "a:3|c" {
+		t.Errorf("packet = %q, want a:3|c", got)
+	}
+	e.Count("a")
+	e.Count("b")
+	s.Close()
+	if got := read(); got != "a:1|c\nb:1|c" {
+		t.Errorf("packet sent by Close = %q, want a:1|c and b:1|c", got)
+	}
+}
+
+func TestStatsD_Packets(t *testing.T) {
+	pc, read := listenStatsD(t)
+	e := ehc.NewEHC(time.Minute)
+	s, err := NewStatsD(e, StatsDConfig{Addr: pc.LocalAddr().String(), Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	key := strings.Repeat("k", 100)
+	for i := 0; i < 30; i++ {
+		e.Count(key + string(rune('a'+i)))
+	}
+	s.Flush()
+	lines := 0
+	for lines < 30 {
+		packet := read()
+		if len(packet) > maxPacket {
+			t.Fatalf("packet of %d bytes, over %d", len(packet), maxPacket)
+		}
+		lines += strings.Count(packet, "\n") + 1
+	}
+}
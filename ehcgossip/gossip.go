@@ -0,0 +1,384 @@
+// Package ehcgossip shares the counts of EHCs across a fleet of processes
+// without a central store: every process counts into its own EHC, and the
+// processes periodically gossip the counts to each other, so that each one
+// converges on approximate global counts, e.g. for rate limits that apply
+// across the fleet, where eventual consistency is enough.
+//
+// Each Node periodically sends its local counts that changed since its last
+// round to a few peers picked at random, and every few rounds sends them
+// everything it knows, including what it heard from other nodes, so that
+// counts spread through the fleet even when nodes can't all reach each
+// other. The counts of a node not heard of for a window, directly or not,
+// are dropped, as they would all have expired by then; nodes' clocks are
+// assumed to be roughly in sync for this.
+//
+// A node's counts are versioned, so that a stale message can't bring back
+// counts it has since dropped: a key whose count expires or is expired with
+// EHC.Expire is sent with a count of 0, which removes it everywhere. The
+// version starts with the time the Node was created, its incarnation, so
+// that a node restarted under the same id supersedes what was heard of it
+// before, rather than being ignored until that ages out.
+package ehcgossip
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// Transport delivers messages between nodes. Messages are opaque to it, and
+// each must be given to the receiving Node's Receive.
+type Transport interface {
+	// Send delivers msg to peer, as named in the list given to New.
+	Send(ctx context.Context, peer string, msg []byte) error
+}
+
+// Option configures a Node.
+type Option func(*Node)
+
+// WithInterval sets how often the node gossips, a tenth of the window by
+// default. Counts elsewhere are seen about this much late.
+func WithInterval(d time.Duration) Option {
+	return func(n *Node) {
+		n.interval = d
+	}
+}
+
+// WithFanout sets how many peers the node gossips to every round, 3 by
+// default.
+func WithFanout(k int) Option {
+	return func(n *Node) {
+		n.fanout = k
+	}
+}
+
+// WithFullEvery has the node send everything it knows every k rounds, and
+// only its changes in between, 5 by default. Lower k repairs missed
+// messages sooner at the cost of larger messages.
+func WithFullEvery(k int) Option {
+	return func(n *Node) {
+		n.fullEvery = k
+	}
+}
+
+// WithErrorHandler has fn told of every error sending to a peer or decoding
+// a message. Without it they are ignored.
+func WithErrorHandler(fn func(error)) Option {
+	return func(n *Node) {
+		n.onError = fn
+	}
+}
+
+// Node is one process's member of the fleet, gossiping the counts of its EHC.
+// Keys are sent with encoding/gob, so keys of types other than the
+// predeclared ones must be registered with gob.Register.
+type Node struct {
+	e         *ehc.EHC
+	id        string
+	peers     []string
+	transport Transport
+	interval  time.Duration
+	fanout    int
+	fullEvery int
+	onError   func(error)
+	now       func() time.Time
+
+	// incarnation tells this Node from earlier ones of the same id.
+	incarnation int64
+
+	mu sync.Mutex
+	// seq is the version of the local counts last sent, which were
+	// published, within the incarnation.
+	seq       uint64
+	published map[interface{}]int64
+	rounds    int
+	// remote is the counts of every other node heard from, by its id.
+	remote map[string]*origin
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// origin is the latest counts heard of a node.
+type origin struct {
+	incarnation int64
+	seq         uint64
+	counts      map[interface{}]int64
+	// heard is the time, by the node's clock, it was last known to be
+	// up, for dropping the counts of nodes that went away.
+	heard time.Time
+}
+
+// message is what nodes send each other.
+type message struct {
+	// States are the full counts of nodes, as of Seq.
+	States []state
+	// Deltas are changes to the counts of nodes since their Base.
+	Deltas []delta
+}
+
+type state struct {
+	Origin      string
+	Incarnation int64
+	Seq         uint64
+	Counts      map[interface{}]int64
+	// At is the time the origin was last known to be up.
+	At time.Time
+}
+
+type delta struct {
+	Origin      string
+	Incarnation int64
+	Base        uint64
+	Seq         uint64
+	// Changes holds the new count of every key that changed, 0 for
+	// those that went away. A delta without changes tells that the
+	// origin is still up.
+	Changes map[interface{}]int64
+	At      time.Time
+}
+
+// New returns a node, named id, gossiping the counts of e with peers, the
+// other nodes of the fleet as named for transport, and starts it. Every node
+// must have a unique id, and its EHC the same window as the others'.
+func New(e *ehc.EHC, id string, peers []string, transport Transport, opts ...Option) *Node {
+	n := &Node{
+		e:           e,
+		id:          id,
+		incarnation: time.Now().UnixNano(),
+		peers:       append([]string(nil), peers...),
+		transport:   transport,
+		interval:    e.Window() / 10,
+		fanout:      3,
+		fullEvery:   5,
+		now:         time.Now,
+		published:   map[interface{}]int64{},
+		remote:      map[string]*origin{},
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	if n.interval <= 0 {
+		n.interval = time.Second
+	}
+	if n.fullEvery <= 0 {
+		n.fullEvery = 1
+	}
+	go n.run()
+	return n
+}
+
+// Close stops gossiping. The node still answers reads with what it last
+// heard.
+func (n *Node) Close() error {
+	select {
+	case <-n.stop:
+	default:
+		close(n.stop)
+	}
+	<-n.done
+	return nil
+}
+
+func (n *Node) run() {
+	defer close(n.done)
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+			n.round()
+		}
+	}
+}
+
+// Get returns the approximate global count of key: its count in the local EHC
+// plus its latest counts heard from the other nodes.
+func (n *Node) Get(key interface{}) int64 {
+	v, _ := n.e.Get(key)
+	return v + n.remoteCount(key)
+}
+
+// remoteCount returns the latest counts of key heard from the other nodes.
+func (n *Node) remoteCount(key interface{}) int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dropStaleLocked()
+	var v int64
+	for _, o := range n.remote {
+		v += o.counts[key]
+	}
+	return v
+}
+
+// Snapshot returns the approximate global count of every key.
+func (n *Node) Snapshot() map[interface{}]int64 {
+	totals := n.e.Snapshot()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dropStaleLocked()
+	for _, o := range n.remote {
+		for k, v := range o.counts {
+			totals[k] += v
+		}
+	}
+	return totals
+}
+
+// Allow counts key in the local EHC and reports true if its global count is
+// below limit, and otherwise reports false. The local count is checked and
+// taken atomically, with EHC.AllowN, so concurrent callers on one node can't
+// overshoot limit between them, but as the counts of other nodes are seen
+// late, the fleet can overshoot it by what it counts in about an interval.
+func (n *Node) Allow(key interface{}, limit int64) bool {
+	return n.e.AllowN(key, 1, limit-n.remoteCount(key))
+}
+
+// dropStaleLocked forgets the nodes not known to be up for a window, whose
+// counts would all have expired by now.
+func (n *Node) dropStaleLocked() {
+	cutoff := n.now().Add(-n.e.Window())
+	for id, o := range n.remote {
+		if o.heard.Before(cutoff) {
+			delete(n.remote, id)
+		}
+	}
+}
+
+// round sends the local changes, or everything, to a few peers.
+func (n *Node) round() {
+	counts := n.e.Snapshot()
+
+	n.mu.Lock()
+	changes := map[interface{}]int64{}
+	for k, v := range counts {
+		if n.published[k] != v {
+			changes[k] = v
+		}
+	}
+	for k := range n.published {
+		if _, ok := counts[k]; !ok {
+			changes[k] = 0
+		}
+	}
+	now := n.now()
+	d := delta{Origin: n.id, Incarnation: n.incarnation, Base: n.seq, Seq: n.seq, At: now}
+	if len(changes) > 0 {
+		d.Seq++
+		d.Changes = changes
+		n.seq++
+		n.published = counts
+	}
+	msg := message{Deltas: []delta{d}}
+	n.rounds++
+	if n.rounds%n.fullEvery == 0 {
+		msg.States = append(msg.States, state{Origin: n.id, Incarnation: n.incarnation, Seq: n.seq, Counts: n.published, At: now})
+		n.dropStaleLocked()
+		for id, o := range n.remote {
+			msg.States = append(msg.States, state{Origin: id, Incarnation: o.incarnation, Seq: o.seq, Counts: o.counts, At: o.heard})
+		}
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(msg)
+	n.mu.Unlock()
+
+	if err != nil {
+		n.error(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.interval)
+	defer cancel()
+	for _, peer := range n.pick() {
+		if err := n.transport.Send(ctx, peer, buf.Bytes()); err != nil {
+			n.error(err)
+		}
+	}
+}
+
+// pick returns up to fanout peers at random.
+func (n *Node) pick() []string {
+	peers := append([]string(nil), n.peers...)
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > n.fanout {
+		peers = peers[:n.fanout]
+	}
+	return peers
+}
+
+// Receive applies a message sent by another node's Transport.
+func (n *Node) Receive(msg []byte) error {
+	var m message
+	if err := gob.NewDecoder(bytes.NewReader(msg)).Decode(&m); err != nil {
+		n.error(err)
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, s := range m.States {
+		if s.Origin == n.id {
+			continue
+		}
+		switch o := n.remote[s.Origin]; {
+		case o == nil || o.older(s.Incarnation, s.Seq):
+			counts := make(map[interface{}]int64, len(s.Counts))
+			for k, v := range s.Counts {
+				counts[k] = v
+			}
+			n.remote[s.Origin] = &origin{incarnation: s.Incarnation, seq: s.Seq, counts: counts, heard: s.At}
+		case s.Incarnation == o.incarnation && s.Seq == o.seq && s.At.After(o.heard):
+			o.heard = s.At
+		}
+	}
+	for _, d := range m.Deltas {
+		if d.Origin == n.id {
+			continue
+		}
+		o := n.remote[d.Origin]
+		if (o == nil || d.Incarnation > o.incarnation) && d.Base == 0 {
+			// a node new to us, or restarted, starting from nothing
+			o = &origin{incarnation: d.Incarnation, counts: map[interface{}]int64{}}
+			n.remote[d.Origin] = o
+		}
+		if o == nil || o.incarnation != d.Incarnation || o.seq != d.Base {
+			// a message was missed; the next full state repairs it
+			continue
+		}
+		for k, v := range d.Changes {
+			if v == 0 {
+				delete(o.counts, k)
+			} else {
+				o.counts[k] = v
+			}
+		}
+		o.seq = d.Seq
+		if d.At.After(o.heard) {
+			o.heard = d.At
+		}
+	}
+	return nil
+}
+
+// older reports whether the counts heard of the origin are older than the
+// version of an incarnation and seq.
+func (o *origin) older(incarnation int64, seq uint64) bool {
+	if incarnation != o.incarnation {
+		return incarnation > o.incarnation
+	}
+	return seq > o.seq
+}
+
+func (n *Node) error(err error) {
+	if n.onError != nil {
+		n.onError(err)
+	}
+}
@@ -0,0 +1,206 @@
+package ehcgossip
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// memTransport delivers messages straight to the nodes, by name.
+type memTransport map[string]*Node
+
+func (t memTransport) Send(_ context.Context, peer string, msg []byte) error {
+	return t[peer].Receive(msg)
+}
+
+// newRing returns n nodes that can only send to the next one around, so
+// that counts have to be passed on to reach the others.
+func newRing(t *testing.T, n int, start time.Time) ([]*Node, []*ehc.ManualEHC) {
+	transport := memTransport{}
+	nodes := make([]*Node, n)
+	ehcs := make([]*ehc.ManualEHC, n)
+	for i := range nodes {
+		ehcs[i] = ehc.NewManualEHC(time.Minute, start)
+		id := fmt.Sprint(i)
+		next := fmt.Sprint((i + 1) % n)
+		nodes[i] = New(ehcs[i].EHC, id, []string{next}, transport, WithInterval(time.Hour), WithFullEvery(2))
+		nodes[i].now = func() time.Time { return start }
+		transport[id] = nodes[i]
+		t.Cleanup(func() { nodes[i].Close() })
+	}
+	return nodes, ehcs
+}
+
+func rounds(nodes []*Node, k int) {
+	for i := 0; i < k; i++ {
+		for _, n := range nodes {
+			n.round()
+		}
+	}
+}
+
+func TestNode_Converges(t *testing.T) {
+	start := time.Unix(1000, 0)
+	nodes, ehcs := newRing(t, 4, start)
+	for i, e := range ehcs {
+		e.CountMultiple("k", int64(i+1))
+	}
+	ehcs[2].Count("only-2")
+
+	rounds(nodes, 8)
+	for i, n := range nodes {
+		if v := n.Get("k"); v != 10 {
+			t.Errorf("node %d: Get(k) = %d, want 10", i, v)
+		}
+		if s := n.Snapshot(); len(s) != 2 || s["only-2"] != 1 {
+			t.Errorf("node %d: Snapshot() = %v, want k: 10 and only-2: 1", i, s)
+		}
+	}
+
+	// expiring a key on its node removes it everywhere, and stale
+	// messages can't bring it back
+	stale := nodes[3].remote["2"]
+	ehcs[2].Expire("only-2")
+	rounds(nodes, 8)
+	nodes[3].Receive(encode(t, message{States: []state{{Origin: "2", Incarnation: stale.incarnation, Seq: stale.seq, Counts: stale.counts, At: start}}}))
+	for i, n := range nodes {
+		if v := n.Get("only-2"); v != 0 {
+			t.Errorf("node %d: Get(only-2) = %d after it was expired, want 0", i, v)
+		}
+		if v := n.Get("k"); v != 10 {
+			t.Errorf("node %d: Get(k) = %d after only-2 was expired, want 10", i, v)
+		}
+	}
+}
+
+func TestNode_Restart(t *testing.T) {
+	start := time.Unix(1000, 0)
+	nodes, ehcs := newRing(t, 2, start)
+	ehcs[1].CountMultiple("k", 5)
+	rounds(nodes, 4)
+	if v := nodes[0].Get("k"); v != 5 {
+		t.Fatalf("Get(k) = %d, want 5", v)
+	}
+
+	// node 1 restarts under the same id, with counts of its own, which
+	// are heard at once despite its versions starting over
+	transport := memTransport{"0": nodes[0]}
+	nodes[1].Close()
+	e := ehc.NewManualEHC(time.Minute, start)
+	restarted := New(e.EHC, "1", []string{"0"}, transport, WithInterval(time.Hour), WithFullEvery(2))
+	defer restarted.Close()
+	restarted.now = func() time.Time { return start }
+	e.CountMultiple("k", 2)
+	restarted.round()
+	if v := nodes[0].Get("k"); v != 2 {
+		t.Errorf("Get(k) = %d after node 1 restarted, want its new count of 2", v)
+	}
+
+	// and what was heard of its earlier incarnation can't come back
+	old := nodes[0].remote["1"]
+	nodes[0].Receive(encode(t, message{States: []state{{Origin: "1", Incarnation: old.incarnation - 1, Seq: 9, Counts: map[interface{}]int64{"k": 5}, At: start}}}))
+	restarted.round()
+	restarted.round()
+	if v := nodes[0].Get("k"); v != 2 {
+		t.Errorf("Get(k) = %d after a state of the earlier incarnation, want 2", v)
+	}
+}
+
+func TestNode_DropsGoneNodes(t *testing.T) {
+	start := time.Unix(1000, 0)
+	nodes, ehcs := newRing(t, 3, start)
+	ehcs[1].CountMultiple("k", 5)
+	rounds(nodes, 4)
+	if v := nodes[0].Get("k"); v != 5 {
+		t.Fatalf("Get(k) = %d, want 5", v)
+	}
+
+	// node 1 goes away, and node 0 hears nothing of it for a window
+	nodes[0].now = func() time.Time { return start.Add(time.Minute + time.Second) }
+	if v := nodes[0].Get("k"); v != 0 {
+		t.Errorf("Get(k) = %d once node 1 was gone for a window, want 0", v)
+	}
+}
+
+func TestNode_Allow(t *testing.T) {
+	nodes, _ := newRing(t, 2, time.Unix(1000, 0))
+	for i := 0; i < 2; i++ {
+		if !nodes[i].Allow("k", 3) {
+			t.Errorf("node %d: Allow() = false under the limit", i)
+		}
+	}
+	rounds(nodes, 2)
+	if !nodes[0].Allow("k", 3) {
+		t.Error("Allow() = false with a global count of 2")
+	}
+	rounds(nodes, 2)
+	for i := 0; i < 2; i++ {
+		if nodes[i].Allow("k", 3) {
+			t.Errorf("node %d: Allow() = true at the global limit", i)
+		}
+	}
+}
+
+func TestNode_AllowConcurrent(t *testing.T) {
+	nodes, _ := newRing(t, 2, time.Unix(1000, 0))
+	nodes[1].Allow("k", 10)
+	rounds(nodes, 2)
+
+	// with a remote count of 1, node 0 has room for 9 more however many
+	// callers race for them
+	var allowed int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if nodes[0].Allow("k", 10) {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if allowed != 9 {
+		t.Errorf("%d concurrent calls allowed, want 9", allowed)
+	}
+}
+
+func TestHTTPTransport(t *testing.T) {
+	start := time.Unix(1000, 0)
+	a, b := ehc.NewManualEHC(time.Minute, start), ehc.NewManualEHC(time.Minute, start)
+	nb := New(b.EHC, "b", nil, HTTPTransport{}, WithInterval(time.Hour))
+	defer nb.Close()
+	nb.now = func() time.Time { return start }
+	srv := httptest.NewServer(nb)
+	defer srv.Close()
+
+	na := New(a.EHC, "a", []string{srv.URL}, HTTPTransport{}, WithInterval(time.Hour), WithErrorHandler(func(err error) {
+		t.Error(err)
+	}))
+	defer na.Close()
+	na.now = func() time.Time { return start }
+	a.CountMultiple("k", 2)
+	na.round()
+	if v := nb.Get("k"); v != 2 {
+		t.Errorf("Get(k) = %d on the receiving node, want 2", v)
+	}
+}
+
+func encode(t *testing.T, m message) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
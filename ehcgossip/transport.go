@@ -0,0 +1,67 @@
+package ehcgossip
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxMessageBytes bounds the messages a Node accepts over HTTP.
+const maxMessageBytes = 32 << 20
+
+// HTTPTransport sends messages as POST requests to peers named by the URL a
+// Node is served at, as an http.Handler, on each.
+type HTTPTransport struct {
+	// Client sends the requests, or http.DefaultClient if nil.
+	Client *http.Client
+	// Header is added to every request, such as an Authorization header
+	// the peers require.
+	Header http.Header
+}
+
+// Send posts msg to the URL peer.
+func (t HTTPTransport) Send(ctx context.Context, peer string, msg []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	for k, v := range t.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("ehcgossip: %s answered %s", peer, resp.Status)
+	}
+	return nil
+}
+
+// ServeHTTP receives the messages sent by HTTPTransport.
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "gossip requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	msg, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := n.Receive(msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
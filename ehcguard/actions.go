@@ -0,0 +1,78 @@
+package ehcguard
+
+import (
+	"log"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// Action responds to a violation.
+type Action interface {
+	Act(v Violation)
+}
+
+// ActionFunc adapts a function to an Action.
+type ActionFunc func(v Violation)
+
+// Act calls f(v).
+func (f ActionFunc) Act(v Violation) {
+	f(v)
+}
+
+// LogAction logs each violation through logf, or log.Printf if logf is nil.
+func LogAction(logf func(format string, args ...interface{})) Action {
+	if logf == nil {
+		logf = log.Printf
+	}
+	return ActionFunc(func(v Violation) {
+		if v.Rule.Metric == Composite {
+			logf("ehcguard: rule %q: condition holds", v.Rule.Name)
+			return
+		}
+		if v.Key == nil {
+			logf("ehcguard: rule %q: %v %.2f exceeds %.2f", v.Rule.Name, v.Rule.Metric, v.Value, v.Rule.Threshold)
+			return
+		}
+		logf("ehcguard: rule %q: %v %.2f exceeds %.2f for %v", v.Rule.Name, v.Rule.Metric, v.Value, v.Rule.Threshold, v.Key)
+	})
+}
+
+// TarpitAction passes the offending key of each violation to tarpit, which
+// is expected to slow that key's traffic down. Violations without a key are
+// ignored.
+func TarpitAction(tarpit func(key interface{})) Action {
+	return ActionFunc(func(v Violation) {
+		if v.Key != nil {
+			tarpit(v.Key)
+		}
+	})
+}
+
+// BlocklistAction emits the offending key of each violation to emit, for
+// feeding an external block list. Violations without a key are ignored.
+func BlocklistAction(emit func(key interface{})) Action {
+	return ActionFunc(func(v Violation) {
+		if v.Key != nil {
+			emit(v.Key)
+		}
+	})
+}
+
+// BlockAction puts the offending key of each violation on e's blocklist for
+// d, so that e.IsBlocked reports it until the block runs out. If the rule has
+// an Escalation, the violation's escalated Penalty is used instead of d,
+// giving repeat offenders progressively longer blocks. Violations without a
+// key are ignored.
+func BlockAction(e *ehc.EHC, d time.Duration) Action {
+	return ActionFunc(func(v Violation) {
+		if v.Key == nil {
+			return
+		}
+		block := d
+		if v.Penalty > 0 {
+			block = v.Penalty
+		}
+		e.Block(v.Key, block)
+	})
+}
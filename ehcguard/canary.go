@@ -0,0 +1,143 @@
+package ehcguard
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// CanaryKey is the key a CanaryGuard counts requests under, failed or not.
+type CanaryKey struct {
+	Canary string
+	Failed bool
+}
+
+// CanaryConfig configures a CanaryGuard.
+type CanaryConfig struct {
+	// Name tells canaries sharing an EHC apart.
+	Name string
+	// MaxErrorRate is the fraction of requests, between 0 and 1, that
+	// may fail over the window.
+	MaxErrorRate float64
+	// Sustained is how long the error rate must stay above MaxErrorRate
+	// before the canary is rolled back, so that a short burst of errors
+	// doesn't abort a deploy. Zero rolls back as soon as it is exceeded.
+	Sustained time.Duration
+	// MinRequests is the number of requests the window must hold before
+	// the error rate is trusted.
+	MinRequests int64
+	// Rollback rolls the canary back. It is called once, when the
+	// error rate has been exceeded for Sustained, with the status then.
+	Rollback func(CanaryStatus)
+}
+
+// CanaryStatus is the state of a CanaryGuard.
+type CanaryStatus struct {
+	// Requests and Failures are the counts over the window, and
+	// ErrorRate the share of Requests that failed.
+	Requests  int64
+	Failures  int64
+	ErrorRate float64
+	// ExceededSince is when the error rate went above MaxErrorRate,
+	// or zero if it isn't above it.
+	ExceededSince time.Time
+	// RolledBack is when Rollback was called, or zero if it wasn't.
+	RolledBack time.Time
+}
+
+// CanaryGuard aborts a deploy when its error rate over the window of its EHC
+// stays too high, formalizing "roll back if the 5xx rate over the last two
+// minutes exceeds X": it counts every request of the canary with Observe,
+// and calls the Rollback of its CanaryConfig once the error rate has been
+// above MaxErrorRate for Sustained.
+//
+// The error rate is evaluated by Observe and Check; Check should be called
+// periodically, so that a canary that stops getting requests is still
+// rolled back.
+type CanaryGuard struct {
+	e   *ehc.EHC
+	cfg CanaryConfig
+	now func() time.Time
+
+	mu            sync.Mutex
+	exceededSince time.Time
+	rolledBack    time.Time
+}
+
+// NewCanary returns a CanaryGuard counting into e.
+func NewCanary(e *ehc.EHC, cfg CanaryConfig) *CanaryGuard {
+	return &CanaryGuard{e: e, cfg: cfg, now: time.Now}
+}
+
+// Observe counts a request of the canary, which failed if failed is set, and
+// evaluates the error rate.
+func (c *CanaryGuard) Observe(failed bool) {
+	c.e.Count(CanaryKey{Canary: c.cfg.Name})
+	if failed {
+		c.e.Count(CanaryKey{Canary: c.cfg.Name, Failed: true})
+	}
+	c.Check()
+}
+
+// Check evaluates the error rate, rolling the canary back if it has been
+// exceeded for long enough.
+func (c *CanaryGuard) Check() {
+	now := c.now()
+	st := c.counts()
+	exceeded := st.Requests >= c.cfg.MinRequests && st.Requests > 0 && st.ErrorRate > c.cfg.MaxErrorRate
+
+	c.mu.Lock()
+	if !c.rolledBack.IsZero() {
+		c.mu.Unlock()
+		return
+	}
+	if !exceeded {
+		c.exceededSince = time.Time{}
+		c.mu.Unlock()
+		return
+	}
+	if c.exceededSince.IsZero() {
+		c.exceededSince = now
+	}
+	if now.Sub(c.exceededSince) < c.cfg.Sustained {
+		c.mu.Unlock()
+		return
+	}
+	c.rolledBack = now
+	st.ExceededSince, st.RolledBack = c.exceededSince, c.rolledBack
+	c.mu.Unlock()
+
+	if c.cfg.Rollback != nil {
+		c.cfg.Rollback(st)
+	}
+}
+
+// Status returns the current state of the canary.
+func (c *CanaryGuard) Status() CanaryStatus {
+	st := c.counts()
+	c.mu.Lock()
+	st.ExceededSince, st.RolledBack = c.exceededSince, c.rolledBack
+	c.mu.Unlock()
+	return st
+}
+
+// Reset re-arms the guard after a rollback, e.g. for the next deploy. It
+// leaves the counts alone.
+func (c *CanaryGuard) Reset() {
+	c.mu.Lock()
+	c.exceededSince = time.Time{}
+	c.rolledBack = time.Time{}
+	c.mu.Unlock()
+}
+
+// counts returns the status of the counts over the window.
+func (c *CanaryGuard) counts() CanaryStatus {
+	var st CanaryStatus
+	st.Requests, _ = c.e.Get(CanaryKey{Canary: c.cfg.Name})
+	st.Failures, _ = c.e.Get(CanaryKey{Canary: c.cfg.Name, Failed: true})
+	if st.Requests > 0 {
+		st.ErrorRate = float64(st.Failures) / float64(st.Requests)
+	}
+	return st
+}
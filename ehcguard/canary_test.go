@@ -0,0 +1,66 @@
+package ehcguard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+func TestCanaryGuard(t *testing.T) {
+	start := time.Unix(0, 0)
+	e := ehc.NewManualEHC(2*time.Minute, start)
+	var rollbacks []CanaryStatus
+	c := NewCanary(e.EHC, CanaryConfig{
+		Name:         "v2",
+		MaxErrorRate: 0.1,
+		Sustained:    30 * time.Second,
+		MinRequests:  10,
+		Rollback:     func(st CanaryStatus) { rollbacks = append(rollbacks, st) },
+	})
+	now := start
+	c.now = func() time.Time { return now }
+
+	// too few requests to trust the error rate
+	for i := 0; i < 5; i++ {
+		c.Observe(true)
+	}
+	if st := c.Status(); !st.ExceededSince.IsZero() || st.ErrorRate != 1 {
+		t.Errorf("Status() with too few requests = %+v", st)
+	}
+
+	// exceeded, but not for long enough
+	for i := 0; i < 5; i++ {
+		c.Observe(false)
+	}
+	if st := c.Status(); !st.ExceededSince.Equal(start) || len(rollbacks) != 0 {
+		t.Errorf("Status() once exceeded = %+v with %d rollbacks", st, len(rollbacks))
+	}
+
+	// a dip below the threshold starts over
+	for i := 0; i < 40; i++ {
+		c.Observe(false)
+	}
+	if st := c.Status(); !st.ExceededSince.IsZero() {
+		t.Errorf("Status() after recovering = %+v", st)
+	}
+	for i := 0; i < 5; i++ {
+		c.Observe(true)
+	}
+	now = now.Add(20 * time.Second)
+	c.Check()
+	if len(rollbacks) != 0 {
+		t.Fatal("rolled back after 20 seconds over the threshold")
+	}
+	now = now.Add(10 * time.Second)
+	c.Check()
+	c.Check()
+	if len(rollbacks) != 1 || rollbacks[0].Requests != 55 || rollbacks[0].Failures != 10 || !rollbacks[0].RolledBack.Equal(now) {
+		t.Fatalf("rollbacks = %+v, want one after 30 seconds", rollbacks)
+	}
+
+	c.Reset()
+	if st := c.Status(); !st.RolledBack.IsZero() || !st.ExceededSince.IsZero() {
+		t.Errorf("Status() after Reset = %+v", st)
+	}
+}
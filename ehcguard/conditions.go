@@ -0,0 +1,143 @@
+package ehcguard
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// Query reads a quantity from the guard's EHC, such as a key's count.
+type Query func(e *ehc.EHC) float64
+
+// Count queries the count of key.
+func Count(key interface{}) Query {
+	return func(e *ehc.EHC) float64 {
+		return float64(value(e, key))
+	}
+}
+
+// Sum queries the total count of keys.
+func Sum(keys ...interface{}) Query {
+	return func(e *ehc.EHC) float64 {
+		var total float64
+		for _, key := range keys {
+			total += float64(value(e, key))
+		}
+		return total
+	}
+}
+
+// Ratio queries num divided by den, or 0 while den is 0, for conditions on
+// rates such as errors over requests.
+func Ratio(num, den Query) Query {
+	return func(e *ehc.EHC) float64 {
+		d := den(e)
+		if d == 0 {
+			return 0
+		}
+		return num(e) / d
+	}
+}
+
+// Condition is a boolean test over the guard's EHC, the trigger of Composite
+// rules. Conditions are built from queries with Above and Below, and combined
+// with All, Any, Not and For, e.g.
+//
+//	All(
+//		Above(Count("errors"), 100),
+//		For(Above(Ratio(Count("errors"), Count("requests")), 0.05), 2*time.Minute),
+//	)
+//
+// Conditions made with For keep state across Checks, so each must only be
+// used in one rule.
+type Condition interface {
+	// Holds evaluates the condition at now.
+	Holds(e *ehc.EHC, now time.Time) bool
+}
+
+// ConditionFunc adapts a function to a Condition.
+type ConditionFunc func(e *ehc.EHC, now time.Time) bool
+
+// Holds calls f(e, now).
+func (f ConditionFunc) Holds(e *ehc.EHC, now time.Time) bool {
+	return f(e, now)
+}
+
+// Above holds while q is greater than threshold.
+func Above(q Query, threshold float64) Condition {
+	return ConditionFunc(func(e *ehc.EHC, _ time.Time) bool {
+		return q(e) > threshold
+	})
+}
+
+// Below holds while q is less than threshold.
+func Below(q Query, threshold float64) Condition {
+	return ConditionFunc(func(e *ehc.EHC, _ time.Time) bool {
+		return q(e) < threshold
+	})
+}
+
+// All holds while every one of conds holds. Every condition is evaluated on
+// each Check, so that those made with For keep tracking.
+func All(conds ...Condition) Condition {
+	return ConditionFunc(func(e *ehc.EHC, now time.Time) bool {
+		holds := true
+		for _, c := range conds {
+			if !c.Holds(e, now) {
+				holds = false
+			}
+		}
+		return holds
+	})
+}
+
+// Any holds while at least one of conds holds. Every condition is evaluated
+// on each Check, so that those made with For keep tracking.
+func Any(conds ...Condition) Condition {
+	return ConditionFunc(func(e *ehc.EHC, now time.Time) bool {
+		holds := false
+		for _, c := range conds {
+			if c.Holds(e, now) {
+				holds = true
+			}
+		}
+		return holds
+	})
+}
+
+// Not holds while c doesn't.
+func Not(c Condition) Condition {
+	return ConditionFunc(func(e *ehc.EHC, now time.Time) bool {
+		return !c.Holds(e, now)
+	})
+}
+
+// For holds once c has held on every Check for at least d, so that brief
+// spikes don't trigger a rule.
+func For(c Condition, d time.Duration) Condition {
+	return &sustained{c: c, d: d}
+}
+
+type sustained struct {
+	c Condition
+	d time.Duration
+
+	mu    sync.Mutex
+	since time.Time
+}
+
+func (s *sustained) Holds(e *ehc.EHC, now time.Time) bool {
+	holds := s.c.Holds(e, now)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !holds {
+		s.since = time.Time{}
+		return false
+	}
+	if s.since.IsZero() {
+		s.since = now
+	}
+	return now.Sub(s.since) >= s.d
+}
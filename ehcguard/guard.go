@@ -0,0 +1,267 @@
+// Package ehcguard is a configurable abuse detector built on EHC. It combines
+// per-key counts, the rate at which new keys appear, how concentrated traffic
+// is on the hottest key, and boolean conditions over several keys into rules
+// that trigger pluggable actions such as logging, tarpitting, or emitting
+// keys to a block list.
+package ehcguard
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// Metric is a quantity a Rule watches.
+type Metric int
+
+const (
+	// KeyCount is a single key's count within the window. It is evaluated
+	// by Observe, for the key being observed.
+	KeyCount Metric = iota
+	// NewKeyRate is the number of keys per second first seen within the
+	// window. It is evaluated by Check.
+	NewKeyRate
+	// Concentration is the fraction, between 0 and 1, of all counts in the
+	// window that belong to the hottest key. It is evaluated by Check, and
+	// violations name the hottest key.
+	Concentration
+	// Composite is the rule's Condition, a combination of queries over
+	// any number of keys, which is 1 while it holds and 0 otherwise. It is
+	// evaluated by Check.
+	Composite
+)
+
+func (m Metric) String() string {
+	switch m {
+	case KeyCount:
+		return "key count"
+	case NewKeyRate:
+		return "new key rate"
+	case Concentration:
+		return "concentration"
+	case Composite:
+		return "condition"
+	}
+	return "unknown metric"
+}
+
+// Rule triggers its actions when Metric exceeds Threshold.
+type Rule struct {
+	Name      string
+	Metric    Metric
+	Threshold float64
+	Actions   []Action
+
+	// Condition is what Composite rules evaluate; their Threshold is
+	// ignored.
+	Condition Condition
+
+	// Escalation, if set, makes penalties grow for keys that keep
+	// violating the rule.
+	Escalation *Escalation
+}
+
+// Escalation configures progressively longer penalties for repeat offenders.
+// A key's violations are remembered for Window, which is normally much
+// longer than the measurement window. The first violation earns Base, and
+// each further one within Window multiplies the penalty by Factor, up to Max.
+type Escalation struct {
+	Window time.Duration
+	Base   time.Duration
+	// Factor defaults to 2.
+	Factor float64
+	// Max defaults to no limit.
+	Max time.Duration
+}
+
+// Penalty returns the penalty for a key's nth violation within the window.
+func (esc *Escalation) Penalty(n int64) time.Duration {
+	factor := esc.Factor
+	if factor <= 0 {
+		factor = 2
+	}
+	p := float64(esc.Base)
+	for i := int64(1); i < n; i++ {
+		p *= factor
+		if esc.Max > 0 && p >= float64(esc.Max) {
+			return esc.Max
+		}
+	}
+	return time.Duration(p)
+}
+
+// Violation describes a rule being exceeded.
+type Violation struct {
+	Rule *Rule
+	// Key is the offending key, or nil for NewKeyRate and Composite.
+	Key   interface{}
+	Value float64
+	At    time.Time
+
+	// Offenses is how many times Key has violated the rule within its
+	// escalation window, including this time. It is zero unless the
+	// rule has an Escalation.
+	Offenses int64
+	// Penalty is the escalated penalty for this violation, which
+	// actions such as BlockAction apply in place of their default.
+	Penalty time.Duration
+}
+
+// Guard evaluates rules against the traffic it observes.
+type Guard struct {
+	e       *ehc.EHC
+	newKeys *ehc.EHC
+	rules   []*Rule
+
+	// offenses counts violations per key for rules with an Escalation,
+	// over their escalation windows.
+	offenses map[*Rule]*ehc.EHC
+
+	// states tracks each rule for Status. Its entries are created
+	// by New, so the map itself is read without locking.
+	states map[*Rule]*ruleState
+
+	mu sync.Mutex
+	// tripped records which global rules are currently exceeded, so
+	// they only fire when they start being exceeded.
+	tripped map[*Rule]bool
+}
+
+// newKey is the single key new keys are counted under.
+type newKey struct{}
+
+// New returns a Guard counting into e and evaluating rules.
+func New(e *ehc.EHC, rules ...Rule) *Guard {
+	g := &Guard{
+		e:        e,
+		newKeys:  ehc.NewEHC(e.Window()),
+		offenses: map[*Rule]*ehc.EHC{},
+		states:   map[*Rule]*ruleState{},
+		tripped:  map[*Rule]bool{},
+	}
+	for i := range rules {
+		r := &rules[i]
+		g.rules = append(g.rules, r)
+		g.states[r] = &ruleState{}
+		if r.Escalation != nil {
+			g.offenses[r] = ehc.NewEHC(r.Escalation.Window)
+		}
+	}
+	return g
+}
+
+// EHC returns the EHC the Guard counts into.
+func (g *Guard) EHC() *ehc.EHC {
+	return g.e
+}
+
+// Observe counts one event for key and evaluates the KeyCount rules for it.
+// A rule fires each time the key's count crosses its threshold.
+func (g *Guard) Observe(key interface{}) {
+	g.ObserveN(key, 1)
+}
+
+// ObserveN counts n events for key at once and evaluates the KeyCount rules
+// for it a single time. A rule fires if the n events take the key's count
+// across its threshold, so a rule fires at most once however large n is.
+func (g *Guard) ObserveN(key interface{}, n int64) {
+	if n <= 0 {
+		return
+	}
+	g.e.CountMultiple(key, n)
+	count := value(g.e, key)
+	if count == n {
+		g.newKeys.Count(newKey{})
+	}
+
+	now := time.Now()
+	for _, r := range g.rules {
+		if r.Metric != KeyCount {
+			continue
+		}
+		g.states[r].evaluate(float64(count), now)
+		if float64(count) > r.Threshold && float64(count-n) <= r.Threshold {
+			g.fire(Violation{Rule: r, Key: key, Value: float64(count), At: now})
+		}
+	}
+}
+
+// Check evaluates the NewKeyRate, Concentration and Composite rules. It should be called
+// periodically; a rule fires when it starts being exceeded and re-arms once
+// a Check finds it back under its threshold.
+func (g *Guard) Check() {
+	now := time.Now()
+	rate := float64(value(g.newKeys, newKey{})) / g.e.Window().Seconds()
+	hottest, share := g.concentration()
+
+	for _, r := range g.rules {
+		var v Violation
+		switch r.Metric {
+		case NewKeyRate:
+			v = Violation{Rule: r, Value: rate, At: now}
+		case Concentration:
+			v = Violation{Rule: r, Key: hottest, Value: share, At: now}
+		case Composite:
+			v = Violation{Rule: r, At: now}
+			if r.Condition != nil && r.Condition.Holds(g.e, now) {
+				v.Value = 1
+			}
+		default:
+			continue
+		}
+
+		exceeded := v.Value > r.Threshold
+		if r.Metric == Composite {
+			exceeded = v.Value == 1
+		}
+		g.states[r].evaluate(v.Value, now)
+		g.mu.Lock()
+		start := exceeded && !g.tripped[r]
+		g.tripped[r] = exceeded
+		g.mu.Unlock()
+		if start {
+			g.fire(v)
+		}
+	}
+}
+
+// concentration returns the hottest key and its share of all counts.
+func (g *Guard) concentration() (interface{}, float64) {
+	values, locker := g.e.Values()
+	defer locker.Unlock()
+
+	var hottest interface{}
+	var max, total int64
+	for k, c := range values {
+		v := c.Value()
+		total += v
+		if v > max {
+			hottest, max = k, v
+		}
+	}
+	if total == 0 {
+		return nil, 0
+	}
+	return hottest, float64(max) / float64(total)
+}
+
+func (g *Guard) fire(v Violation) {
+	if !g.states[v.Rule].fire(v.At) {
+		return
+	}
+	if offenses := g.offenses[v.Rule]; offenses != nil && v.Key != nil {
+		offenses.Count(v.Key)
+		v.Offenses = value(offenses, v.Key)
+		v.Penalty = v.Rule.Escalation.Penalty(v.Offenses)
+	}
+	for _, a := range v.Rule.Actions {
+		a.Act(v)
+	}
+}
+
+// value returns the current count of key in e.
+func value(e *ehc.EHC, key interface{}) int64 {
+	n, _ := e.Get(key)
+	return n
+}
@@ -0,0 +1,276 @@
+package ehcguard
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+func TestGuard_KeyCount(t *testing.T) {
+	var blocked []interface{}
+	var logged []string
+	g := New(ehc.NewEHC(time.Minute), Rule{
+		Name:      "per-ip",
+		Metric:    KeyCount,
+		Threshold: 3,
+		Actions: []Action{
+			BlocklistAction(func(key interface{}) {
+				blocked = append(blocked, key)
+			}),
+			LogAction(func(format string, args ...interface{}) {
+				logged = append(logged, fmt.Sprintf(format, args...))
+			}),
+		},
+	})
+
+	for i := 0; i < 10; i++ {
+		g.Observe("10.0.0.1")
+	}
+	g.Observe("10.0.0.2")
+
+	if len(blocked) != 1 || blocked[0] != "10.0.0.1" {
+		t.Errorf("blocked = %v, want [10.0.0.1] once", blocked)
+	}
+	if len(logged) != 1 {
+		t.Errorf("logged %d lines, want 1: %q", len(logged), logged)
+	}
+}
+
+func TestGuard_ObserveN(t *testing.T) {
+	var fired []float64
+	g := New(ehc.NewEHC(time.Minute), Rule{
+		Name:      "per-ip",
+		Metric:    KeyCount,
+		Threshold: 3,
+		Actions: []Action{ActionFunc(func(v Violation) {
+			fired = append(fired, v.Value)
+		})},
+	})
+
+	const many = int64(1) << 40
+	g.ObserveN("10.0.0.1", 2)
+	g.ObserveN("10.0.0.1", many)
+	g.ObserveN("10.0.0.1", 5)
+	g.ObserveN("10.0.0.2", 0)
+
+	if len(fired) != 1 || fired[0] != float64(2+many) {
+		t.Errorf("fired with %v, want once with %d", fired, 2+many)
+	}
+	if n, _ := g.EHC().Get("10.0.0.1"); n != 7+many {
+		t.Errorf("count = %d, want %d", n, 7+many)
+	}
+}
+
+func TestGuard_Check(t *testing.T) {
+	var violations []Violation
+	record := ActionFunc(func(v Violation) {
+		violations = append(violations, v)
+	})
+	g := New(ehc.NewEHC(time.Second),
+		Rule{Name: "churn", Metric: NewKeyRate, Threshold: 5, Actions: []Action{record}},
+		Rule{Name: "hot", Metric: Concentration, Threshold: 0.5, Actions: []Action{record}},
+	)
+
+	for i := 0; i < 10; i++ {
+		g.Observe(i)
+	}
+	g.Check()
+	if len(violations) != 1 || violations[0].Rule.Name != "churn" || violations[0].Value != 10 {
+		t.Fatalf("violations after churn = %+v, want one churn violation at 10/s", violations)
+	}
+
+	for i := 0; i < 20; i++ {
+		g.Observe("hot")
+	}
+	g.Check()
+	g.Check()
+	if len(violations) != 2 || violations[1].Rule.Name != "hot" || violations[1].Key != "hot" {
+		t.Fatalf("violations after hot key = %+v, want a single concentration violation for hot", violations)
+	}
+}
+
+func TestTarpitAction(t *testing.T) {
+	var tarpitted []interface{}
+	a := TarpitAction(func(key interface{}) {
+		tarpitted = append(tarpitted, key)
+	})
+	a.Act(Violation{Rule: &Rule{}, Key: "k"})
+	a.Act(Violation{Rule: &Rule{}})
+	if len(tarpitted) != 1 || tarpitted[0] != "k" {
+		t.Errorf("tarpitted = %v, want [k]", tarpitted)
+	}
+}
+
+func TestBlockAction(t *testing.T) {
+	e := ehc.NewEHC(time.Minute)
+	g := New(e, Rule{
+		Metric:    KeyCount,
+		Threshold: 1,
+		Actions:   []Action{BlockAction(e, time.Minute)},
+	})
+	g.Observe("k")
+	if e.IsBlocked("k") {
+		t.Fatalf("key blocked before exceeding the threshold")
+	}
+	g.Observe("k")
+	if !e.IsBlocked("k") {
+		t.Errorf("key not blocked after exceeding the threshold")
+	}
+}
+
+func TestEscalation_Penalty(t *testing.T) {
+	esc := &Escalation{Base: time.Second, Factor: 3, Max: 20 * time.Second}
+	want := []time.Duration{time.Second, 3 * time.Second, 9 * time.Second, 20 * time.Second, 20 * time.Second}
+	for i, w := range want {
+		if got := esc.Penalty(int64(i + 1)); got != w {
+			t.Errorf("Escalation.Penalty(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestGuard_Escalation(t *testing.T) {
+	var penalties []time.Duration
+	var offenses []int64
+	g := New(ehc.NewEHC(20*time.Millisecond), Rule{
+		Metric:     KeyCount,
+		Threshold:  1,
+		Escalation: &Escalation{Window: time.Minute, Base: time.Second},
+		Actions: []Action{ActionFunc(func(v Violation) {
+			penalties = append(penalties, v.Penalty)
+			offenses = append(offenses, v.Offenses)
+		})},
+	})
+
+	for round := 0; round < 3; round++ {
+		g.Observe("k")
+		g.Observe("k")
+		// let the counts expire so the threshold is crossed again
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	if len(penalties) != len(want) {
+		t.Fatalf("penalties = %v, want %v", penalties, want)
+	}
+	for i := range want {
+		if penalties[i] != want[i] || offenses[i] != int64(i+1) {
+			t.Errorf("violation %d: penalty %v offenses %d, want %v and %d", i, penalties[i], offenses[i], want[i], i+1)
+		}
+	}
+}
+
+func TestGuard_Composite(t *testing.T) {
+	var fired []time.Time
+	var logged []string
+	e := ehc.NewEHC(time.Minute)
+	g := New(e, Rule{
+		Name:   "error-rate",
+		Metric: Composite,
+		Condition: All(
+			Above(Count("errors"), 5),
+			For(Above(Ratio(Count("errors"), Sum("ok", "errors")), 0.5), 20*time.Millisecond),
+		),
+		Actions: []Action{
+			ActionFunc(func(v Violation) { fired = append(fired, v.At) }),
+			LogAction(func(format string, args ...interface{}) {
+				logged = append(logged, fmt.Sprintf(format, args...))
+			}),
+		},
+	})
+
+	e.CountMultiple("errors", 3)
+	e.CountMultiple("ok", 1)
+	g.Check()
+	if len(fired) != 0 {
+		t.Fatal("rule fired below the error count")
+	}
+
+	e.CountMultiple("errors", 3)
+	g.Check()
+	if len(fired) != 0 {
+		t.Fatal("rule fired before the error rate was sustained")
+	}
+	time.Sleep(25 * time.Millisecond)
+	g.Check()
+	g.Check()
+	if len(fired) != 1 {
+		t.Fatalf("rule fired %d times once sustained, want 1", len(fired))
+	}
+	if len(logged) != 1 || logged[0] != `ehcguard: rule "error-rate": condition holds` {
+		t.Errorf("logged %q", logged)
+	}
+
+	// the rate drops, re-arming the rule, and must be sustained again
+	e.CountMultiple("ok", 10)
+	g.Check()
+	e.CountMultiple("errors", 20)
+	g.Check()
+	if len(fired) != 1 {
+		t.Errorf("rule fired %d times, want it to wait for the rate to be sustained again", len(fired))
+	}
+}
+
+func TestConditions(t *testing.T) {
+	e := ehc.NewEHC(time.Minute)
+	e.CountMultiple("a", 2)
+	now := time.Now()
+
+	holds := Above(Count("a"), 1)
+	fails := Below(Count("a"), 1)
+	cases := []struct {
+		name string
+		c    Condition
+		want bool
+	}{
+		{"above", holds, true},
+		{"below", fails, false},
+		{"all", All(holds, fails), false},
+		{"any", Any(holds, fails), true},
+		{"not", Not(fails), true},
+		{"ratio of zero", Above(Ratio(Count("a"), Count("missing")), -1), true},
+	}
+	for _, c := range cases {
+		if got := c.c.Holds(e, now); got != c.want {
+			t.Errorf("%s: Holds() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestGuard_Status(t *testing.T) {
+	fired := 0
+	g := New(ehc.NewEHC(time.Minute), Rule{
+		Name:      "per-ip",
+		Metric:    KeyCount,
+		Threshold: 1,
+		Actions:   []Action{ActionFunc(func(Violation) { fired++ })},
+	})
+
+	if !g.Suppress("per-ip", time.Minute) {
+		t.Fatal("Guard.Suppress() didn't find the rule")
+	}
+	if g.Suppress("missing", time.Minute) {
+		t.Error("Guard.Suppress() found a missing rule")
+	}
+	g.Observe("a")
+	g.Observe("a")
+	if fired != 0 {
+		t.Errorf("suppressed rule ran its actions %d times", fired)
+	}
+
+	st := g.Status()
+	if len(st) != 1 {
+		t.Fatalf("Guard.Status() = %+v, want one rule", st)
+	}
+	if st[0].Value != 2 || !st[0].Exceeded || st[0].Fired != 1 || st[0].LastFired.IsZero() || st[0].SuppressedUntil.IsZero() {
+		t.Errorf("Guard.Status()[0] = %+v, want exceeded at 2, fired once while suppressed", st[0])
+	}
+
+	g.Suppress("per-ip", 0)
+	g.Observe("b")
+	g.Observe("b")
+	if fired != 1 {
+		t.Errorf("rule ran its actions %d times after the suppression ended, want 1", fired)
+	}
+}
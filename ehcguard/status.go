@@ -0,0 +1,159 @@
+package ehcguard
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// RuleStatus is a snapshot of a rule's evaluation, for operators wondering
+// why a rule is or isn't firing.
+type RuleStatus struct {
+	Rule *Rule
+
+	// Value is the rule's metric as last evaluated, at Evaluated. For
+	// KeyCount rules it is the count of the last key observed.
+	Value     float64
+	Evaluated time.Time
+	// Exceeded reports whether Value exceeds the rule's threshold, or
+	// for Composite rules whether the condition held.
+	Exceeded bool
+
+	// Fired is the number of violations, and LastFired the time of the
+	// latest, including those suppressed.
+	Fired     int64
+	LastFired time.Time
+
+	// SuppressedUntil is when a suppression set with Suppress ends, or
+	// zero if the rule isn't suppressed.
+	SuppressedUntil time.Time
+}
+
+// ruleState tracks a rule for Status.
+type ruleState struct {
+	// value and evaluated are updated on every evaluation, so they are
+	// atomics rather than behind mu: value holds float64 bits and
+	// evaluated Unix nanoseconds.
+	value     uint64
+	evaluated int64
+
+	mu              sync.Mutex
+	fired           int64
+	lastFired       time.Time
+	suppressedUntil time.Time
+}
+
+func (s *ruleState) evaluate(v float64, now time.Time) {
+	atomic.StoreUint64(&s.value, math.Float64bits(v))
+	atomic.StoreInt64(&s.evaluated, now.UnixNano())
+}
+
+// fire records a violation at now, reporting false if the rule is
+// suppressed and its actions must not run.
+func (s *ruleState) fire(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fired++
+	s.lastFired = now
+	return !now.Before(s.suppressedUntil)
+}
+
+// Suppress keeps the rule named name from running its actions for d, while
+// it is still evaluated, e.g. during a known traffic spike. It reports false
+// if there is no such rule. Suppressions are recorded in the AuditLog of the
+// Guard's EHC, if it keeps one.
+func (g *Guard) Suppress(name string, d time.Duration) bool {
+	found := false
+	until := time.Now().Add(d)
+	for _, r := range g.rules {
+		if r.Name != name {
+			continue
+		}
+		s := g.states[r]
+		s.mu.Lock()
+		s.suppressedUntil = until
+		s.mu.Unlock()
+		found = true
+	}
+	if found {
+		g.e.RecordAudit(ehc.AuditEntry{Op: "Suppress", Detail: name + " for " + d.String()})
+	}
+	return found
+}
+
+// Status returns the status of every rule, in the order they were given to
+// New.
+func (g *Guard) Status() []RuleStatus {
+	now := time.Now()
+	statuses := make([]RuleStatus, 0, len(g.rules))
+	for _, r := range g.rules {
+		s := g.states[r]
+		st := RuleStatus{
+			Rule:  r,
+			Value: math.Float64frombits(atomic.LoadUint64(&s.value)),
+		}
+		if ns := atomic.LoadInt64(&s.evaluated); ns != 0 {
+			st.Evaluated = time.Unix(0, ns)
+		}
+		st.Exceeded = st.Value > r.Threshold
+		if r.Metric == Composite {
+			st.Exceeded = st.Value == 1
+		}
+
+		s.mu.Lock()
+		st.Fired, st.LastFired = s.fired, s.lastFired
+		if now.Before(s.suppressedUntil) {
+			st.SuppressedUntil = s.suppressedUntil
+		}
+		s.mu.Unlock()
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// WriteTo writes the status of every rule to w as a human-readable table.
+func (g *Guard) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "rule\tmetric\tthreshold\tvalue\tstate\tfired\tlast fired\tsuppressed until")
+	for _, st := range g.Status() {
+		state := "ok"
+		if st.Exceeded {
+			state = "exceeded"
+		}
+		threshold := fmt.Sprintf("%.2f", st.Rule.Threshold)
+		if st.Rule.Metric == Composite {
+			threshold = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%s\t%.2f\t%s\t%d\t%s\t%s\n",
+			st.Rule.Name, st.Rule.Metric, threshold, st.Value, state,
+			st.Fired, formatTime(st.LastFired), formatTime(st.SuppressedUntil))
+	}
+	err := tw.Flush()
+	return cw.n, err
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
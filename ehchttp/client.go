@@ -0,0 +1,64 @@
+package ehchttp
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/coder543/ehc"
+)
+
+// ResponseKey is the key InstrumentRoundTripper counts responses under.
+type ResponseKey struct {
+	Key interface{}
+	// Class is the status class of the response: "1xx" through "5xx".
+	Class string
+}
+
+// HostKey keys requests by the host they are sent to. It is the default key
+// function for InstrumentRoundTripper.
+func HostKey(r *http.Request) interface{} {
+	return r.URL.Host
+}
+
+// InstrumentRoundTripper wraps rt so that every outgoing request is counted
+// into e, keyed by keyFn (HostKey if nil). A response is counted under
+// ResponseKey{key, class}; a transport error is counted with e.CountError, so
+// it is classified by the EHC's error classes. If rt is nil,
+// http.DefaultTransport is used.
+//
+// Together these give the health of each dependency over a sliding window
+// from the client side.
+func InstrumentRoundTripper(e *ehc.EHC, rt http.RoundTripper, keyFn func(*http.Request) interface{}) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if keyFn == nil {
+		keyFn = HostKey
+	}
+	return &instrumentedTransport{e: e, rt: rt, keyFn: keyFn}
+}
+
+type instrumentedTransport struct {
+	e     *ehc.EHC
+	rt    http.RoundTripper
+	keyFn func(*http.Request) interface{}
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := t.keyFn(req)
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		t.e.CountError(key, err)
+		return resp, err
+	}
+	t.e.Count(ResponseKey{Key: key, Class: statusClass(resp.StatusCode)})
+	return resp, nil
+}
+
+// statusClass returns the class of an HTTP status code, such as "2xx".
+func statusClass(code int) string {
+	if code < 100 || code > 999 {
+		return strconv.Itoa(code)
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
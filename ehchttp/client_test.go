@@ -0,0 +1,61 @@
+package ehchttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+func TestInstrumentRoundTripper(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	e := ehc.NewEHC(time.Minute, ehc.WithErrorClasses(ehc.ErrorAs("url", new(*url.Error))))
+	failing := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return nil, &url.Error{Op: "Get", URL: "x", Err: errors.New("refused")}
+	})
+
+	client := &http.Client{Transport: InstrumentRoundTripper(e, nil, nil)}
+	for _, p := range []string{"/", "/", "/missing"} {
+		resp, err := client.Get(srv.URL + p)
+		if err != nil {
+			t.Fatalf("Get(%q) error = %v", p, err)
+		}
+		resp.Body.Close()
+	}
+	req := httptest.NewRequest("GET", "http://down.example/", nil)
+	InstrumentRoundTripper(e, failing, nil).RoundTrip(req)
+
+	host := srv.Listener.Addr().String()
+	want := map[interface{}]int64{
+		ResponseKey{Key: host, Class: "2xx"}:            2,
+		ResponseKey{Key: host, Class: "4xx"}:            1,
+		ehc.ErrorKey{Key: "down.example", Class: "url"}: 1,
+	}
+
+	values, locker := e.Values()
+	defer locker.Unlock()
+	if len(values) != len(want) {
+		t.Errorf("EHC.Values() has %d keys, want %d: %v", len(values), len(want), values)
+	}
+	for k, v := range want {
+		if values[k] == nil || values[k].Value() != v {
+			t.Errorf("EHC.Values()[%v] missing or wrong, want %d", k, v)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
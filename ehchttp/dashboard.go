@@ -0,0 +1,38 @@
+package ehchttp
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(dashboardHTML))
+
+// serveDashboard serves a page rendering the stream page live: the keys with
+// the highest counts, their rates over the window, and a sparkline of each
+// one's count over the last minute. The n form value sets how many keys are
+// shown, 20 by default.
+func (h *debugHandler) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	top := 20
+	if v := r.FormValue("n"); v != "" {
+		var err error
+		if top, err = strconv.Atoi(v); err != nil || top < 0 {
+			http.Error(w, "invalid n: "+v, http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	dashboardTemplate.Execute(w, struct {
+		Window        string
+		WindowSeconds float64
+		Top           int
+	}{
+		Window:        h.e.Window().String(),
+		WindowSeconds: h.e.Window().Seconds(),
+		Top:           top,
+	})
+}
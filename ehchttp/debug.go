@@ -0,0 +1,270 @@
+// Package ehchttp connects EHC to net/http.
+package ehchttp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// NewDebugHandler returns a handler that exposes the state of e as plain text
+// for debugging, serving both the pages of NewReadHandler and those of
+// NewAdminHandler. It can be mounted under any prefix. To expose the counts
+// without also exposing the operations that change them, mount the two
+// separately instead, each with its own WithAuthorize.
+func NewDebugHandler(e *ehc.EHC, opts ...DebugOption) http.Handler {
+	return newDebugHandler(e, true, true, opts)
+}
+
+// NewReadHandler returns a handler that exposes the state of e as plain text,
+// and can't change it. It can be mounted under any prefix and serves:
+//
+//	.../          the count of every live key; see below
+//	.../top       the same for the n keys with the highest counts, highest
+//	              first, where n is the n form value, 10 by default
+//	.../get       the count of the string key given as the key form value
+//	.../stats     the EHC's Stats
+//	.../shards    the ShardStats of every shard, one "shard<TAB>keys<TAB>
+//	              increments<TAB>contended<TAB>lock wait" line each, with
+//	              the ones ehc.HotShards finds at a factor of 2 marked hot
+//	.../profile   the timings recorded by its Profiler, if any
+//	.../stream    the counts as server-sent events, pushing the keys whose
+//	              counts changed as often as WithStreamInterval says
+//	.../dashboard a live HTML dashboard of the top keys, their rates and
+//	              recent history, drawn from the stream page
+//
+// The counts are served, sorted by key, as one "key<TAB>count" line each, or
+// as a JSON array of {"key": ..., "count": ...} objects or an HTML table,
+// as chosen by the format form value, "text", "json" or "html", or else by
+// the Accept header. They can be narrowed down with these form values:
+//
+//	prefix   only keys starting with it
+//	min      only counts of at least it
+//	q        only keys and counts matching it, as an ehc.Query
+//
+// It is typically mounted with
+//
+//	http.Handle("/debug/ehc/", ehchttp.NewReadHandler(e))
+func NewReadHandler(e *ehc.EHC, opts ...DebugOption) http.Handler {
+	return newDebugHandler(e, true, false, opts)
+}
+
+// NewAdminHandler returns a handler for administering e, whose pages other
+// than those listed are not found. It can be mounted under any prefix and
+// serves:
+//
+//	.../rules     the state of the rules given with WithRules, if any
+//	.../expire    on POST, expires the string key given as the key form
+//	              value with EHC.Expire, or every key with all=1
+//	.../audit     one "time<TAB>actor<TAB>op<TAB>key<TAB>detail" line per
+//	              entry of the EHC's AuditLog, oldest first
+func NewAdminHandler(e *ehc.EHC, opts ...DebugOption) http.Handler {
+	return newDebugHandler(e, false, true, opts)
+}
+
+func newDebugHandler(e *ehc.EHC, read, admin bool, opts []DebugOption) *debugHandler {
+	h := &debugHandler{e: e, read: read, admin: admin}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// DebugOption configures optional behavior of a debug handler.
+type DebugOption func(*debugHandler)
+
+// WithRules serves the state of the rules acting on the EHC, such as an
+// ehcguard.Guard's, so that operators can see why they are or aren't
+// firing.
+func WithRules(rules io.WriterTo) DebugOption {
+	return func(h *debugHandler) {
+		h.rules = rules
+	}
+}
+
+// WithActor names who makes the changes requested through the handler, such
+// as expirations, for the EHC's AuditLog, e.g. from the authenticated user of
+// r. Without it they are recorded with no actor.
+func WithActor(actor func(r *http.Request) string) DebugOption {
+	return func(h *debugHandler) {
+		h.actor = actor
+	}
+}
+
+// WithAuthorize has the handler serve only the requests authorize accepts,
+// answering the others with 403 Forbidden, e.g. by checking a token or the
+// client certificate.
+func WithAuthorize(authorize func(r *http.Request) bool) DebugOption {
+	return func(h *debugHandler) {
+		h.authorize = authorize
+	}
+}
+
+type debugHandler struct {
+	e         *ehc.EHC
+	rules     io.WriterTo
+	actor     func(r *http.Request) string
+	authorize func(r *http.Request) bool
+	// streamInterval is set by WithStreamInterval.
+	streamInterval time.Duration
+	// read and admin are whether the pages of NewReadHandler and
+	// NewAdminHandler are served.
+	read, admin bool
+}
+
+func (h *debugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.authorize != nil && !h.authorize(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	page := path.Base(r.URL.Path)
+	if h.read && h.serveRead(w, r, page) {
+		return
+	}
+	if h.admin && h.serveAdmin(w, r, page) {
+		return
+	}
+	if !h.read {
+		http.NotFound(w, r)
+		return
+	}
+	h.serveValues(w, r)
+}
+
+// serveRead serves page if it is one of NewReadHandler's other than the
+// values, reporting whether it was.
+func (h *debugHandler) serveRead(w http.ResponseWriter, r *http.Request, page string) bool {
+	switch page {
+	case "top":
+		h.serveTop(w, r)
+	case "get":
+		h.serveGet(w, r)
+	case "stream":
+		h.serveStream(w, r)
+	case "dashboard":
+		h.serveDashboard(w, r)
+	case "stats":
+		fmt.Fprintf(w, "%+v\n", h.e.Stats())
+	case "shards":
+		h.serveShards(w)
+	case "profile":
+		p := h.e.Profiler()
+		if p == nil {
+			http.Error(w, "profiling is not enabled", http.StatusNotFound)
+			return true
+		}
+		p.WriteTo(w)
+	default:
+		return false
+	}
+	return true
+}
+
+// serveShards writes the stats of every shard.
+func (h *debugHandler) serveShards(w http.ResponseWriter) {
+	stats := h.e.ShardStats()
+	hot := map[int]bool{}
+	for _, i := range ehc.HotShards(stats, 2) {
+		hot[i] = true
+	}
+	for i, s := range stats {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%v", i, s.Keys, s.Increments, s.Contended, s.LockWait)
+		if hot[i] {
+			io.WriteString(w, "\thot")
+		}
+		io.WriteString(w, "\n")
+	}
+}
+
+// serveAdmin serves page if it is one of NewAdminHandler's, reporting
+// whether it was.
+func (h *debugHandler) serveAdmin(w http.ResponseWriter, r *http.Request, page string) bool {
+	switch page {
+	case "rules":
+		if h.rules == nil {
+			http.Error(w, "no rules are configured", http.StatusNotFound)
+			return true
+		}
+		h.rules.WriteTo(w)
+	case "expire":
+		h.serveExpire(w, r)
+	case "audit":
+		h.serveAudit(w)
+	default:
+		return false
+	}
+	return true
+}
+
+func (h *debugHandler) serveTop(w http.ResponseWriter, r *http.Request) {
+	n := 10
+	if v := r.FormValue("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			http.Error(w, "invalid n: "+v, http.StatusBadRequest)
+			return
+		}
+	}
+	for _, kc := range h.e.TopN(n) {
+		fmt.Fprintf(w, "%v\t%d\n", kc.Key, kc.Count)
+	}
+}
+
+func (h *debugHandler) serveGet(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	value, ok := h.e.Get(key)
+	if !ok {
+		http.Error(w, "no counts for "+key, http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "%d\n", value)
+}
+
+func (h *debugHandler) serveExpire(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "expiring requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var actor string
+	if h.actor != nil {
+		actor = h.actor(r)
+	}
+	admin := h.e.As(actor)
+	if !r.Form.Has("key") {
+		// a missing key must not be taken for every key
+		if r.Form.Get("all") != "1" {
+			http.Error(w, "expiring needs a key, or all=1", http.StatusBadRequest)
+			return
+		}
+		admin.ExpireAll()
+		fmt.Fprintln(w, "expired every key")
+		return
+	}
+	key := r.Form.Get("key")
+	if !admin.Expire(key) {
+		http.Error(w, "no counts for "+key, http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "expired %s\n", key)
+}
+
+func (h *debugHandler) serveAudit(w http.ResponseWriter) {
+	for _, ent := range h.e.AuditLog() {
+		key := ""
+		if ent.Key != nil {
+			key = fmt.Sprint(ent.Key)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", ent.Time.Format(time.RFC3339Nano), ent.Actor, ent.Op, key, ent.Detail)
+	}
+}
@@ -0,0 +1,237 @@
+package ehchttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+	"github.com/coder543/ehc/ehcguard"
+)
+
+func TestDebugHandler(t *testing.T) {
+	p := ehc.NewProfiler()
+	e := ehc.NewEHC(time.Minute, ehc.WithProfiler(p))
+	e.Count("b")
+	e.CountMultiple("a", 2)
+
+	tests := []struct {
+		name     string
+		path     string
+		want     []string
+		wantCode int
+	}{
+		{
+			name:     "values",
+			path:     "/debug/ehc/",
+			want:     []string{"a\t2\nb\t1\n"},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "stats",
+			path:     "/debug/ehc/stats",
+			want:     []string{"Dropped:0"},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "shards",
+			path:     "/debug/ehc/shards",
+			want:     []string{"0\t"},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "profile",
+			path:     "/debug/ehc/profile",
+			want:     []string{"phase", "lock", "expiry"},
+			wantCode: http.StatusOK,
+		},
+	}
+	h := NewDebugHandler(e)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("body %q does not contain %q", rec.Body.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugHandler_NoProfiler(t *testing.T) {
+	h := NewDebugHandler(ehc.NewEHC(time.Minute))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/profile", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestDebugHandler_Rules(t *testing.T) {
+	e := ehc.NewEHC(time.Minute)
+	g := ehcguard.New(e, ehcguard.Rule{Name: "per-ip", Metric: ehcguard.KeyCount, Threshold: 1})
+	g.Observe("10.0.0.1")
+	g.Observe("10.0.0.1")
+
+	h := NewDebugHandler(e, WithRules(g))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/ehc/rules", nil))
+	for _, want := range []string{"per-ip", "key count", "exceeded"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("body %q does not contain %q", rec.Body.String(), want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	NewDebugHandler(e).ServeHTTP(rec, httptest.NewRequest("GET", "/rules", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status without rules = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestDebugHandler_Expire(t *testing.T) {
+	e := ehc.NewEHC(time.Minute)
+	e.Count("a")
+	e.Count("b")
+	h := NewDebugHandler(e)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/ehc/expire?key=a", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/ehc/expire?key=a", nil))
+	if _, ok := e.Get("a"); rec.Code != http.StatusOK || ok {
+		t.Errorf("POST status = %d and a still counted = %v, want %d and false", rec.Code, ok, http.StatusOK)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/ehc/expire?key=a", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status expiring a again = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/ehc/expire?kye=b", nil))
+	if _, ok := e.Get("b"); rec.Code != http.StatusBadRequest || !ok {
+		t.Errorf("status without a key = %d and b still counted = %v, want %d and true", rec.Code, ok, http.StatusBadRequest)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/ehc/expire?all=1", nil))
+	if s := e.Snapshot(); rec.Code != http.StatusOK || len(s) != 0 {
+		t.Errorf("status expiring all = %d, leaving %v", rec.Code, s)
+	}
+}
+
+func TestDebugHandler_Audit(t *testing.T) {
+	e := ehc.NewEHC(time.Minute, ehc.WithAuditLog(10))
+	e.Count("a")
+	h := NewDebugHandler(e, WithActor(func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}))
+
+	req := httptest.NewRequest("POST", "/debug/ehc/expire?key=a", nil)
+	req.Header.Set("X-User", "alice")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/ehc/audit", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "\talice\tExpire\ta\t\n") {
+		t.Errorf("audit page = %q, want an Expire of a by alice", body)
+	}
+}
+
+func TestReadHandler(t *testing.T) {
+	e := ehc.NewEHC(time.Minute)
+	e.Count("b")
+	e.CountMultiple("a", 2)
+	e.CountMultiple("c", 3)
+	h := NewReadHandler(e)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		want     string
+		wantCode int
+	}{
+		{"values", "GET", "/debug/ehc/", "a\t2\nb\t1\nc\t3\n", http.StatusOK},
+		{"query", "GET", "/debug/ehc/?q=count+>+1+%26%26+key+!%3D+%22c%22", "a\t2\n", http.StatusOK},
+		{"query invalid", "GET", "/debug/ehc/?q=count+>", "unexpected end of query", http.StatusBadRequest},
+		{"top", "GET", "/debug/ehc/top?n=2", "c\t3\na\t2\n", http.StatusOK},
+		{"top invalid", "GET", "/debug/ehc/top?n=x", "invalid n", http.StatusBadRequest},
+		{"get", "GET", "/debug/ehc/get?key=a", "2\n", http.StatusOK},
+		{"get missing", "GET", "/debug/ehc/get?key=d", "no counts", http.StatusNotFound},
+		{"expire", "POST", "/debug/ehc/expire?key=a", "a\t2\n", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantCode || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, rec.Code, rec.Body.String(), tt.wantCode, tt.want)
+			}
+		})
+	}
+	if _, ok := e.Get("a"); !ok {
+		t.Error("the read handler expired a")
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	e := ehc.NewEHC(time.Minute)
+	e.Count("a")
+	h := NewAdminHandler(e, WithAuthorize(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer secret"
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/ehc/expire?key=a", nil))
+	if _, ok := e.Get("a"); rec.Code != http.StatusForbidden || !ok {
+		t.Errorf("unauthorized POST status = %d and a still counted = %v, want %d and true", rec.Code, ok, http.StatusForbidden)
+	}
+
+	for _, path := range []string{"/debug/ehc/", "/debug/ehc/stats"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s status = %d, want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/debug/ehc/expire?key=a", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if _, ok := e.Get("a"); rec.Code != http.StatusOK || ok {
+		t.Errorf("authorized POST status = %d and a still counted = %v, want %d and false", rec.Code, ok, http.StatusOK)
+	}
+}
+
+func TestReadHandler_Dashboard(t *testing.T) {
+	h := NewReadHandler(ehc.NewEHC(time.Minute))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/ehc/dashboard?n=5", nil))
+	if ct := rec.Header().Get("Content-Type"); rec.Code != http.StatusOK || !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("status = %d and Content-Type = %q, want %d and HTML", rec.Code, ct, http.StatusOK)
+	}
+	// html/template pads the values it puts in scripts with spaces
+	body := strings.Join(strings.Fields(rec.Body.String()), " ")
+	for _, want := range []string{"the last 1m0s", "const windowSeconds = 60 ;", "const maxKeys = 5 ;", `new EventSource("stream")`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dashboard does not contain %q", want)
+		}
+	}
+}
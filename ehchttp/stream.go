@@ -0,0 +1,79 @@
+package ehchttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultStreamInterval is how often the stream page checks for changes
+// without WithStreamInterval.
+const defaultStreamInterval = time.Second
+
+// WithStreamInterval sets how often the stream page of the handler checks the
+// counts for changes to push, one second by default.
+func WithStreamInterval(d time.Duration) DebugOption {
+	return func(h *debugHandler) {
+		h.streamInterval = d
+	}
+}
+
+// streamUpdate is the data of an update event of the stream page.
+type streamUpdate struct {
+	Key   string `json:"key"`
+	Value int64  `json:"value"`
+}
+
+// serveStream pushes the counts as server-sent events until the client goes
+// away: first every live key, then, at every interval, the keys whose counts
+// changed, with a count of 0 for those that expired. Each is an "update"
+// event whose data is a JSON object with the key, in its fmt.Sprint form, and
+// its value, and the events of an interval are flushed together.
+func (h *debugHandler) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	interval := h.streamInterval
+	if interval <= 0 {
+		interval = defaultStreamInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := map[string]int64{}
+	for {
+		cur := map[string]int64{}
+		for k, v := range h.e.Snapshot() {
+			cur[fmt.Sprint(k)] += v
+		}
+		for k, v := range cur {
+			if old, ok := last[k]; !ok || old != v {
+				writeUpdate(w, k, v)
+			}
+		}
+		for k := range last {
+			if _, ok := cur[k]; !ok {
+				writeUpdate(w, k, 0)
+			}
+		}
+		flusher.Flush()
+		last = cur
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func writeUpdate(w http.ResponseWriter, key string, value int64) {
+	data, _ := json.Marshal(streamUpdate{Key: key, Value: value})
+	fmt.Fprintf(w, "event: update\ndata: %s\n\n", data)
+}
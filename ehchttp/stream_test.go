@@ -0,0 +1,54 @@
+package ehchttp
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+func TestReadHandler_Stream(t *testing.T) {
+	e := ehc.NewEHC(time.Minute)
+	e.CountMultiple("a", 2)
+	srv := httptest.NewServer(NewReadHandler(e, WithStreamInterval(5*time.Millisecond)))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/debug/ehc/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() string {
+		for lines.Scan() {
+			if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+				return data
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return ""
+	}
+	if data := next(); data != `{"key":"a","value":2}` {
+		t.Errorf("first update = %s, want a at 2", data)
+	}
+	e.Count("a")
+	if data := next(); data != `{"key":"a","value":3}` {
+		t.Errorf("update after counting = %s, want a at 3", data)
+	}
+	e.Expire("a")
+	if data := next(); data != `{"key":"a","value":0}` {
+		t.Errorf("update after expiring = %s, want a at 0", data)
+	}
+}
@@ -0,0 +1,126 @@
+package ehchttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coder543/ehc"
+)
+
+// valueEntry is a key and its count as served by the values page.
+type valueEntry struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+var valuesTemplate = template.Must(template.New("values").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ehc</title>
+<style>
+body { font: 14px sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 1em; text-align: left; }
+td + td, th + th { text-align: right; }
+tr:nth-child(even) { background: #f4f4f4; }
+</style>
+</head>
+<body>
+<table>
+<thead><tr><th>key</th><th>count</th></tr></thead>
+<tbody>
+{{range .}}<tr><td>{{.Key}}</td><td>{{.Count}}</td></tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
+
+func (h *debugHandler) serveValues(w http.ResponseWriter, r *http.Request) {
+	match, err := valuesFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := valuesFormat(r)
+	if format != "text" && format != "json" && format != "html" {
+		http.Error(w, "unknown format: "+format, http.StatusBadRequest)
+		return
+	}
+
+	values, locker := h.e.Values()
+	entries := make([]valueEntry, 0, len(values))
+	for k, v := range values {
+		value := v.Value()
+		if match(k, value) {
+			entries = append(entries, valueEntry{Key: fmt.Sprint(k), Count: value})
+		}
+	}
+	locker.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		valuesTemplate.Execute(w, entries)
+	default:
+		for _, ent := range entries {
+			fmt.Fprintf(w, "%s\t%d\n", ent.Key, ent.Count)
+		}
+	}
+}
+
+// valuesFilter returns the filter of the values page given by r's prefix,
+// min and q form values.
+func valuesFilter(r *http.Request) (func(key interface{}, count int64) bool, error) {
+	prefix := r.FormValue("prefix")
+	min := int64(-1 << 63)
+	if v := r.FormValue("min"); v != "" {
+		var err error
+		if min, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid min: %s", v)
+		}
+	}
+	var q *ehc.Query
+	if src := r.FormValue("q"); src != "" {
+		var err error
+		if q, err = ehc.ParseQuery(src); err != nil {
+			return nil, err
+		}
+	}
+	return func(key interface{}, count int64) bool {
+		if count < min {
+			return false
+		}
+		if prefix != "" && !strings.HasPrefix(fmt.Sprint(key), prefix) {
+			return false
+		}
+		return q == nil || q.Match(key, count)
+	}, nil
+}
+
+// valuesFormat returns the format the values page is asked for in.
+func valuesFormat(r *http.Request) string {
+	if f := r.FormValue("format"); f != "" {
+		return f
+	}
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/json"):
+		return "json"
+	case strings.Contains(accept, "text/html"):
+		return "html"
+	}
+	return "text"
+}
@@ -0,0 +1,59 @@
+package ehchttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+func TestReadHandler_Values(t *testing.T) {
+	e := ehc.NewEHC(time.Minute)
+	e.CountMultiple("ip:1", 5)
+	e.CountMultiple("ip:2", 1)
+	e.CountMultiple("user:<b>", 7)
+	h := NewReadHandler(e)
+
+	tests := []struct {
+		name   string
+		path   string
+		accept string
+		want   string
+		code   int
+	}{
+		{"prefix", "/debug/ehc/?prefix=ip:", "", "ip:1\t5\nip:2\t1\n", http.StatusOK},
+		{"min", "/debug/ehc/?min=5", "", "ip:1\t5\nuser:<b>\t7\n", http.StatusOK},
+		{"prefix and min", "/debug/ehc/?prefix=ip:&min=2", "", "ip:1\t5\n", http.StatusOK},
+		{"invalid min", "/debug/ehc/?min=x", "", "invalid min: x\n", http.StatusBadRequest},
+		{"html", "/debug/ehc/?min=6", "text/html,*/*", "<td>user:&lt;b&gt;</td><td>7</td>", http.StatusOK},
+		{"json format", "/debug/ehc/?format=json&prefix=ip:", "text/html", `[{"key":"ip:1","count":5},{"key":"ip:2","count":1}]` + "\n", http.StatusOK},
+		{"unknown format", "/debug/ehc/?format=xml", "", "unknown format: xml\n", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			body := rec.Body.String()
+			if rec.Code != tt.code || !strings.HasPrefix(tt.want, "<") && body != tt.want || !strings.Contains(body, tt.want) {
+				t.Errorf("GET %s = %d %q, want %d %q", tt.path, rec.Code, body, tt.code, tt.want)
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/debug/ehc/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var entries []valueEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil || len(entries) != 3 || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("GET with Accept: application/json = %q (%v)", rec.Body.String(), err)
+	}
+}
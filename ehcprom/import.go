@@ -0,0 +1,83 @@
+package ehcprom
+
+import (
+	"io"
+	"math"
+
+	"github.com/coder543/ehc"
+)
+
+// Option configures Import and ImportSamples.
+type Option func(*importer)
+
+// WithKey maps each sample to the key its value is counted under, and
+// whether to count it at all. By default every sample is counted, under its
+// series as formatted by Sample.String. Samples mapped to the same key add
+// up.
+func WithKey(fn func(s Sample) (key interface{}, ok bool)) Option {
+	return func(im *importer) {
+		im.key = fn
+	}
+}
+
+// WithReplace makes the imported counts replace the counts the keys already
+// have, rather than be added to them, as by calling Expire on each key first.
+func WithReplace() Option {
+	return func(im *importer) {
+		im.replace = true
+	}
+}
+
+type importer struct {
+	key     func(s Sample) (interface{}, bool)
+	replace bool
+}
+
+// Import parses metrics in the Prometheus text exposition format from r, and
+// counts them in e as by ImportSamples. Nothing is counted if r doesn't
+// parse.
+func Import(e *ehc.EHC, r io.Reader, opts ...Option) (int, error) {
+	samples, err := Parse(r)
+	if err != nil {
+		return 0, err
+	}
+	return ImportSamples(e, samples, opts...), nil
+}
+
+// ImportSamples counts the value of each sample in e, as if it had all been
+// counted just now, so that it expires a window from now, and returns the
+// number of samples counted. Values are rounded to the nearest integer;
+// samples that come out at 0 or less, or too large for an int64, are
+// skipped, and so are NaNs. The timestamps of samples are ignored, since a
+// cumulative count can't be spread back over the times it was made at.
+func ImportSamples(e *ehc.EHC, samples []Sample, opts ...Option) int {
+	im := &importer{
+		key: func(s Sample) (interface{}, bool) { return s.String(), true },
+	}
+	for _, opt := range opts {
+		opt(im)
+	}
+
+	replaced := map[interface{}]bool{}
+	n := 0
+	for _, s := range samples {
+		if math.IsNaN(s.Value) || s.Value >= math.MaxInt64 {
+			continue
+		}
+		count := int64(math.Round(s.Value))
+		if count <= 0 {
+			continue
+		}
+		key, ok := im.key(s)
+		if !ok {
+			continue
+		}
+		if im.replace && !replaced[key] {
+			e.Expire(key)
+			replaced[key] = true
+		}
+		e.CountMultiple(key, count)
+		n++
+	}
+	return n
+}
@@ -0,0 +1,57 @@
+package ehcprom
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+func TestImport(t *testing.T) {
+	e := ehc.NewEHC(time.Minute)
+	defer e.Close()
+	n, err := Import(e, strings.NewReader(exposition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("Import() = %d, want 5 samples counted", n)
+	}
+	for key, want := range map[string]int64{
+		`http_requests_total{method="POST",path="/b"}`: 3,
+		`latency_seconds_sum`:                          4,
+		`temperature`:                                  0,
+	} {
+		if got, _ := e.Get(key); got != want {
+			t.Errorf("Get(%s) = %d, want %d", key, got, want)
+		}
+	}
+
+	if _, err := Import(e, strings.NewReader("x{")); err == nil {
+		t.Error("Import() of a bad exposition succeeded")
+	}
+}
+
+func TestImport_KeyAndReplace(t *testing.T) {
+	e := ehc.NewEHC(time.Minute)
+	defer e.Close()
+	e.CountMultiple("GET", 100)
+	e.CountMultiple("POST", 100)
+
+	n, err := Import(e, strings.NewReader(exposition), WithReplace(), WithKey(func(s Sample) (interface{}, bool) {
+		return s.Labels["method"], s.Name == "http_requests_total" && s.Labels["method"] == "GET"
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Import() = %d, want 1 sample counted", n)
+	}
+	if got, _ := e.Get("GET"); got != 1027 {
+		t.Errorf("Get(GET) = %d, want 1027 replacing 100", got)
+	}
+	if got, _ := e.Get("POST"); got != 100 {
+		t.Errorf("Get(POST) = %d, want 100 untouched", got)
+	}
+}
@@ -0,0 +1,234 @@
+// Package ehcprom seeds the counts of an EHC from Prometheus metrics, for
+// moving from scrape-based counters to windowed ones in process without
+// starting from zero.
+//
+// Import reads the Prometheus text exposition format, as served on /metrics:
+//
+//	resp, err := http.Get("http://old-service/metrics")
+//	...
+//	n, err := ehcprom.Import(e, resp.Body, ehcprom.WithKey(func(s ehcprom.Sample) (interface{}, bool) {
+//		return s.Labels["path"], s.Name == "http_requests_total"
+//	}))
+//
+// Remote-read responses are snappy-compressed protocol buffers, which this
+// module doesn't take the dependencies to decode; once decoded, e.g. with
+// the Prometheus client libraries, their series can be passed to
+// ImportSamples as Samples.
+package ehcprom
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample is one sample of a Prometheus series.
+type Sample struct {
+	// Name is the name of the series, such as "http_requests_total" or
+	// "latency_seconds_bucket".
+	Name string
+	// Labels are the labels of the series, if any.
+	Labels map[string]string
+	// Type is the type of the metric family the series belongs to, as
+	// declared by its # TYPE line: "counter", "gauge", "histogram",
+	// "summary" or "untyped", the default.
+	Type string
+	// Value is the value of the sample.
+	Value float64
+	// Timestamp is the time of the sample, if given.
+	Timestamp time.Time
+}
+
+// String returns the series of s as Prometheus writes it, with its labels
+// sorted by name, e.g. `http_requests_total{code="200",method="GET"}`.
+func (s Sample) String() string {
+	if len(s.Labels) == 0 {
+		return s.Name
+	}
+	names := make([]string, 0, len(s.Labels))
+	for name := range s.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(s.Name)
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(s.Labels[name]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// ParseError is a syntax error in the text format, on a line counting from 1.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("ehcprom: line %d: %s", e.Line, e.Msg)
+}
+
+// Parse reads the samples of metrics in the Prometheus text exposition
+// format from r.
+func Parse(r io.Reader) ([]Sample, error) {
+	var samples []Sample
+	types := map[string]string{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			fields := strings.Fields(text[1:])
+			if len(fields) >= 3 && fields[0] == "TYPE" {
+				types[fields[1]] = fields[2]
+			}
+			continue
+		}
+		s, err := parseSample(text)
+		if err != nil {
+			return nil, &ParseError{Line: line, Msg: err.Error()}
+		}
+		s.Type = familyType(types, s.Name)
+		samples = append(samples, s)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// familyType returns the declared type of the family of the series name,
+// whose histograms and summaries are written as several series.
+func familyType(types map[string]string, name string) string {
+	if typ, ok := types[name]; ok {
+		return typ
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if family, ok := strings.CutSuffix(name, suffix); ok {
+			if typ := types[family]; typ == "histogram" || (typ == "summary" && suffix != "_bucket") {
+				return typ
+			}
+		}
+	}
+	return "untyped"
+}
+
+// parseSample parses a sample line: a name, any labels in braces, a value
+// and an optional timestamp in milliseconds.
+func parseSample(text string) (Sample, error) {
+	var s Sample
+	i := strings.IndexAny(text, "{ \t")
+	if i <= 0 {
+		return s, fmt.Errorf("sample without a value")
+	}
+	s.Name = text[:i]
+	rest := text[i:]
+	if rest[0] == '{' {
+		var err error
+		if s.Labels, rest, err = parseLabels(rest[1:]); err != nil {
+			return s, err
+		}
+	}
+
+	fields := strings.Fields(rest)
+	switch len(fields) {
+	case 2:
+		ms, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return s, fmt.Errorf("bad timestamp %q", fields[1])
+		}
+		s.Timestamp = time.UnixMilli(ms)
+	case 1:
+	default:
+		return s, fmt.Errorf("want a value and an optional timestamp after %s", s.Name)
+	}
+	v, err := parseValue(fields[0])
+	if err != nil {
+		return s, err
+	}
+	s.Value = v
+	return s, nil
+}
+
+// parseLabels parses labels up to the closing brace, returning what follows
+// it.
+func parseLabels(text string) (map[string]string, string, error) {
+	labels := map[string]string{}
+	for {
+		text = strings.TrimLeft(text, " \t")
+		if strings.HasPrefix(text, "}") {
+			return labels, text[1:], nil
+		}
+		eq := strings.IndexByte(text, '=')
+		if eq <= 0 {
+			return nil, "", fmt.Errorf("bad label in %q", text)
+		}
+		name := strings.TrimSpace(text[:eq])
+		text = strings.TrimLeft(text[eq+1:], " \t")
+		if !strings.HasPrefix(text, `"`) {
+			return nil, "", fmt.Errorf("label %s without a quoted value", name)
+		}
+
+		var value strings.Builder
+		i := 1
+		for ; i < len(text) && text[i] != '"'; i++ {
+			c := text[i]
+			if c == '\\' && i+1 < len(text) {
+				i++
+				switch text[i] {
+				case 'n':
+					c = '\n'
+				default:
+					c = text[i]
+				}
+			}
+			value.WriteByte(c)
+		}
+		if i == len(text) {
+			return nil, "", fmt.Errorf("unterminated value of label %s", name)
+		}
+		labels[name] = value.String()
+
+		text = strings.TrimLeft(text[i+1:], " \t")
+		if strings.HasPrefix(text, ",") {
+			text = text[1:]
+		} else if !strings.HasPrefix(text, "}") {
+			return nil, "", fmt.Errorf("want , or } after label %s", name)
+		}
+	}
+}
+
+func parseValue(text string) (float64, error) {
+	switch text {
+	case "NaN":
+		return math.NaN(), nil
+	case "+Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	}
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", text)
+	}
+	return v, nil
+}
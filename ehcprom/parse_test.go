@@ -0,0 +1,69 @@
+package ehcprom
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const exposition = `# HELP http_requests_total Requests served.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",path="/a \"quoted\"\\"} 1027 1395066363000
+http_requests_total{method="POST", path="/b",} 3
+
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="+Inf"} 14
+latency_seconds_sum 3.5
+latency_seconds_count 14
+# a comment
+temperature NaN
+`
+
+func TestParse(t *testing.T) {
+	samples, err := Parse(strings.NewReader(exposition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Sample{
+		{Name: "http_requests_total", Labels: map[string]string{"method": "GET", "path": `/a "quoted"\`}, Type: "counter", Value: 1027, Timestamp: time.UnixMilli(1395066363000)},
+		{Name: "http_requests_total", Labels: map[string]string{"method": "POST", "path": "/b"}, Type: "counter", Value: 3},
+		{Name: "latency_seconds_bucket", Labels: map[string]string{"le": "+Inf"}, Type: "histogram", Value: 14},
+		{Name: "latency_seconds_sum", Type: "histogram", Value: 3.5},
+		{Name: "latency_seconds_count", Type: "histogram", Value: 14},
+	}
+	if len(samples) != len(want)+1 {
+		t.Fatalf("Parse() = %d samples, want %d", len(samples), len(want)+1)
+	}
+	for i, s := range want {
+		if !reflect.DeepEqual(samples[i], s) {
+			t.Errorf("sample %d = %+v, want %+v", i, samples[i], s)
+		}
+	}
+	if s := samples[len(want)]; s.Name != "temperature" || s.Type != "untyped" || !math.IsNaN(s.Value) {
+		t.Errorf("last sample = %+v, want an untyped NaN temperature", s)
+	}
+
+	if got, want := samples[0].String(), `http_requests_total{method="GET",path="/a \"quoted\"\\"}`; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, text := range []string{
+		"novalue",
+		`x{a="b" 1`,
+		`x{a=b} 1`,
+		"x one",
+		"x 1 later",
+		"x 1 2 3",
+	} {
+		_, err := Parse(strings.NewReader("# TYPE x counter\n" + text))
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Line != 2 {
+			t.Errorf("Parse(%q) = %v, want an error on line 2", text, err)
+		}
+	}
+}
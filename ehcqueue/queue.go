@@ -0,0 +1,83 @@
+// Package ehcqueue counts job queue and worker pool activity per job type
+// over a sliding window, so queue authors can adopt EHC with minimal glue.
+package ehcqueue
+
+import (
+	"github.com/coder543/ehc"
+)
+
+// Event is something that happens to a job.
+type Event string
+
+// The events Metrics counts.
+const (
+	Enqueued Event = "enqueued"
+	Dequeued Event = "dequeued"
+	Failed   Event = "failed"
+	Retried  Event = "retried"
+)
+
+// Key is the key events are counted under in the underlying EHC.
+type Key struct {
+	JobType interface{}
+	Event   Event
+}
+
+// Metrics counts queue events per job type.
+type Metrics struct {
+	e *ehc.EHC
+}
+
+// New returns Metrics that count into e.
+func New(e *ehc.EHC) *Metrics {
+	return &Metrics{e: e}
+}
+
+// EHC returns the EHC the events are counted into.
+func (m *Metrics) EHC() *ehc.EHC {
+	return m.e
+}
+
+// Enqueue records that a job of jobType was added to the queue.
+func (m *Metrics) Enqueue(jobType interface{}) {
+	m.e.Count(Key{JobType: jobType, Event: Enqueued})
+}
+
+// Dequeue records that a job of jobType was taken off the queue.
+func (m *Metrics) Dequeue(jobType interface{}) {
+	m.e.Count(Key{JobType: jobType, Event: Dequeued})
+}
+
+// Fail records that a job of jobType failed.
+func (m *Metrics) Fail(jobType interface{}) {
+	m.e.Count(Key{JobType: jobType, Event: Failed})
+}
+
+// Retry records that a job of jobType was scheduled for another attempt.
+func (m *Metrics) Retry(jobType interface{}) {
+	m.e.Count(Key{JobType: jobType, Event: Retried})
+}
+
+// Count returns how many times ev happened to jobs of jobType in the window.
+func (m *Metrics) Count(jobType interface{}, ev Event) int64 {
+	n, _ := m.e.Get(Key{JobType: jobType, Event: ev})
+	return n
+}
+
+// Rate returns how many times per second ev happened to jobs of jobType,
+// averaged over the window.
+func (m *Metrics) Rate(jobType interface{}, ev Event) float64 {
+	return float64(m.Count(jobType, ev)) / m.e.Window().Seconds()
+}
+
+// Backlog estimates how many jobs of jobType are waiting, as the number
+// enqueued minus the number dequeued within the window. Jobs enqueued before
+// the window began are invisible to it, so it is clamped at zero and is best
+// read as how far the consumers fell behind recently.
+func (m *Metrics) Backlog(jobType interface{}) int64 {
+	backlog := m.Count(jobType, Enqueued) - m.Count(jobType, Dequeued)
+	if backlog < 0 {
+		return 0
+	}
+	return backlog
+}
@@ -0,0 +1,36 @@
+package ehcqueue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New(ehc.NewEHC(2 * time.Second))
+	for i := 0; i < 5; i++ {
+		m.Enqueue("email")
+	}
+	m.Dequeue("email")
+	m.Dequeue("email")
+	m.Fail("email")
+	m.Retry("email")
+	m.Dequeue("sms")
+
+	if got := m.Count("email", Enqueued); got != 5 {
+		t.Errorf("Metrics.Count(email, Enqueued) = %d, want 5", got)
+	}
+	if got := m.Rate("email", Enqueued); got != 2.5 {
+		t.Errorf("Metrics.Rate(email, Enqueued) = %v, want 2.5", got)
+	}
+	if got := m.Backlog("email"); got != 3 {
+		t.Errorf("Metrics.Backlog(email) = %d, want 3", got)
+	}
+	if got := m.Backlog("sms"); got != 0 {
+		t.Errorf("Metrics.Backlog(sms) = %d, want 0", got)
+	}
+	if got := m.Count("email", Failed) + m.Count("email", Retried); got != 2 {
+		t.Errorf("failed + retried = %d, want 2", got)
+	}
+}
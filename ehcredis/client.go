@@ -0,0 +1,234 @@
+package ehcredis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxIdle is the number of idle connections a Client keeps for reuse.
+const maxIdle = 8
+
+// ErrClosed is returned for commands sent through a closed Client.
+var ErrClosed = errors.New("ehcredis: client is closed")
+
+// Error is an error reply from Redis.
+type Error string
+
+func (e Error) Error() string {
+	return "ehcredis: " + string(e)
+}
+
+// Client is a minimal Redis client, speaking just enough of the protocol for
+// the strategy of Strategy, over a pool of connections. It is safe for
+// concurrent use.
+type Client struct {
+	addr     string
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	password string
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithDialer dials connections with dial instead of a net.Dialer, e.g. for
+// TLS.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) ClientOption {
+	return func(c *Client) {
+		c.dial = dial
+	}
+}
+
+// WithPassword authenticates every connection with AUTH password.
+func WithPassword(password string) ClientOption {
+	return func(c *Client) {
+		c.password = password
+	}
+}
+
+// WithTimeout bounds every round trip to Redis, including dialing, to d,
+// one second by default.
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// NewClient returns a Client for the Redis server at the TCP address addr.
+// Connections are made as they are needed.
+func NewClient(addr string, opts ...ClientOption) *Client {
+	c := &Client{
+		addr:    addr,
+		dial:    (&net.Dialer{}).DialContext,
+		timeout: time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Close closes the idle connections, and those in use once they are done
+// with. Commands sent afterwards fail with ErrClosed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.closed = true
+	c.mu.Unlock()
+
+	for _, cn := range idle {
+		cn.Close()
+	}
+	return nil
+}
+
+// conn is a connection to Redis.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (c *Client) get() (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	nc, err := c.dial(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if c.password != "" {
+		if _, err := c.roundTrip(cn, [][]string{{"AUTH", c.password}}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	if c.closed || len(c.idle) >= maxIdle {
+		c.mu.Unlock()
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+	c.mu.Unlock()
+}
+
+// pipeline sends cmds in a single round trip and returns their replies. It
+// fails with the first error reply, if any.
+func (c *Client) pipeline(cmds ...[]string) ([]interface{}, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	replies, err := c.roundTrip(cn, cmds)
+	var reply Error
+	if err != nil && !errors.As(err, &reply) {
+		// the connection is in an unknown state
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return replies, err
+}
+
+func (c *Client) roundTrip(cn *conn, cmds [][]string) ([]interface{}, error) {
+	cn.SetDeadline(time.Now().Add(c.timeout))
+	for _, args := range cmds {
+		fmt.Fprintf(cn.w, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(cn.w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	// every reply is read, even after an error reply, to leave the
+	// connection ready for reuse
+	replies := make([]interface{}, len(cmds))
+	var first error
+	for i := range replies {
+		reply, err := readReply(cn.r)
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := reply.(Error); ok && first == nil {
+			first = e
+		}
+		replies[i] = reply
+	}
+	return replies, first
+}
+
+// readReply reads a reply: a string, an Error, an int64, nil, or a
+// []interface{} of those.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("ehcredis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return Error(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		elems := make([]interface{}, n)
+		for i := range elems {
+			if elems[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	}
+	return nil, fmt.Errorf("ehcredis: malformed reply %q", line)
+}
@@ -0,0 +1,362 @@
+// Package ehcredis keeps the counts of an EHC in Redis, so that the replicas
+// of a service share them: every replica counting into the same Redis sees
+// the counts of all of them.
+//
+// It is an ehc.ExpiryStrategy, so the EHC API is unchanged:
+//
+//	c := ehcredis.NewClient("localhost:6379")
+//	e := ehc.NewEHC(time.Minute, ehc.WithExpiryStrategy(ehcredis.Strategy(c)))
+//
+// Like ehc.WithBuckets, each key's increments are added up in buckets of a
+// fraction of the window, here with INCRBY on a Redis key per bucket, which
+// Redis expires once the bucket has left the window.
+package ehcredis
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// Option configures the strategy of Strategy.
+type Option func(*strategy)
+
+// WithPrefix starts the name of every Redis key the strategy uses with
+// prefix, "ehc:" by default, so that several EHCs can share a Redis. The
+// prefix must not contain braces, which would change the keys' hash tags.
+func WithPrefix(prefix string) Option {
+	return func(s *strategy) {
+		s.prefix = prefix
+	}
+}
+
+// WithBuckets splits the window into n buckets, 10 by default. As with
+// ehc.WithBuckets, counts are off by at most the count of one bucket, and
+// larger n tightens them at the cost of a longer read per key.
+func WithBuckets(n int) Option {
+	return func(s *strategy) {
+		s.n = int64(n)
+	}
+}
+
+// WithErrorHandler has fn told of every error talking to Redis. Without it
+// they are ignored.
+func WithErrorHandler(fn func(error)) Option {
+	return func(s *strategy) {
+		s.onError = fn
+	}
+}
+
+// Strategy returns a strategy, for ehc.WithExpiryStrategy, keeping the counts
+// in the Redis of c.
+//
+// Keys are stored by their fmt.Sprint form, which Snapshot, and so Values,
+// returns them as. Increments are sent to Redis in the background, rather
+// than while the EHC holds its lock, and a replica's reads wait for its own
+// increments to be sent, while the other replicas see them a round trip
+// later. The strategy fails open: while Redis can't be reached, increments
+// are lost, counts read as 0 and limits allow everything.
+//
+// The strategy is an ehc.LimitedStrategy: Reserve, Allow and AllowN check a
+// key's shared count against the limit and count it in one Lua script, so
+// that the replicas can't overshoot limits between them. As with any
+// LimitedStrategy, reservations count their cost as they are made.
+func Strategy(c *Client, opts ...Option) func(window time.Duration) ehc.ExpiryStrategy {
+	return func(window time.Duration) ehc.ExpiryStrategy {
+		s := &strategy{c: c, prefix: "ehc:", n: 10, pending: map[increment]int64{}}
+		for _, opt := range opts {
+			opt(s)
+		}
+		if s.n <= 0 {
+			s.n = 1
+		}
+		s.window = window
+		s.length = window / time.Duration(s.n)
+		if s.length <= 0 {
+			s.length = 1
+		}
+		return s
+	}
+}
+
+// strategy implements ehc.ExpiryStrategy on Redis. Buckets are aligned to
+// multiples of their length since the Unix epoch, and numbered accordingly,
+// so that every replica agrees on them. The count of a key in a bucket is
+// the Redis key prefix{hash}:bucket:key, where hash is the key's FNV-1a hash
+// in hex and the braces make it a hash tag, so that a key's buckets share a
+// slot of a Redis Cluster whatever the key holds. The keys counted in a
+// bucket are in the set prefix{keys}:bucket, so that the sets share a slot
+// too.
+type strategy struct {
+	c       *Client
+	prefix  string
+	n       int64
+	window  time.Duration
+	length  time.Duration
+	onError func(error)
+
+	// sending is held to send increments, and read-held by reads, so
+	// that reads see every increment already taken from pending.
+	sending sync.RWMutex
+
+	mu      sync.Mutex
+	pending map[increment]int64
+	running bool // whether a flush goroutine is running
+}
+
+// increment identifies a key's count in a bucket.
+type increment struct {
+	key    string
+	bucket int64
+}
+
+func (s *strategy) bucket(now time.Time) int64 {
+	return now.UnixNano() / int64(s.length)
+}
+
+func (s *strategy) countKey(key string, bucket int64) string {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return s.prefix + "{" + strconv.FormatUint(h.Sum64(), 16) + "}:" + strconv.FormatInt(bucket, 10) + ":" + key
+}
+
+func (s *strategy) setKey(bucket int64) string {
+	return s.prefix + "{keys}:" + strconv.FormatInt(bucket, 10)
+}
+
+// expireAt returns when, in Unix milliseconds, bucket leaves the window.
+func (s *strategy) expireAt(bucket int64) string {
+	end := time.Duration(bucket+1)*s.length + s.window
+	return strconv.FormatInt(int64(end/time.Millisecond)+1, 10)
+}
+
+func (s *strategy) error(err error) {
+	if s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// Add adds n to key in the bucket now falls in. It only queues the
+// increment, and has a goroutine send it, so that Redis's latency isn't
+// paid while the EHC holds its lock.
+func (s *strategy) Add(key interface{}, n int64, now time.Time) {
+	s.mu.Lock()
+	s.pending[increment{fmt.Sprint(key), s.bucket(now)}] += n
+	if !s.running {
+		s.running = true
+		go s.flush()
+	}
+	s.mu.Unlock()
+}
+
+// withinScript adds ARGV[1] to the last of KEYS, the buckets of a key
+// oldest first, if that keeps the key's count at most ARGV[2], and then has
+// it expire at ARGV[5]. The oldest bucket is weighed by ARGV[3] / ARGV[4],
+// the part of it still in the window, as values does. It returns 1 if it
+// added, and 0 if not.
+const withinScript = `
+local count = 0
+for i, key in ipairs(KEYS) do
+	local n = tonumber(redis.call('GET', key) or '0')
+	if i == 1 then
+		n = math.floor(n * tonumber(ARGV[3]) / tonumber(ARGV[4]))
+	end
+	count = count + n
+end
+if count + tonumber(ARGV[1]) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('INCRBY', KEYS[#KEYS], ARGV[1])
+redis.call('PEXPIREAT', KEYS[#KEYS], ARGV[5])
+return 1
+`
+
+// AddWithin adds n to key in the bucket now falls in if that keeps its count
+// over the window at most limit, checking and adding in one step in Redis.
+func (s *strategy) AddWithin(key interface{}, n, limit int64, now time.Time) bool {
+	k := fmt.Sprint(key)
+	cur := s.bucket(now)
+	eval := []string{"EVAL", withinScript, strconv.FormatInt(s.n+1, 10)}
+	for b := cur - s.n; b <= cur; b++ {
+		eval = append(eval, s.countKey(k, b))
+	}
+	left := int64(s.length) - now.UnixNano()%int64(s.length)
+	eval = append(eval,
+		strconv.FormatInt(n, 10),
+		strconv.FormatInt(limit, 10),
+		strconv.FormatInt(left, 10),
+		strconv.FormatInt(int64(s.length), 10),
+		s.expireAt(cur),
+	)
+	// the set is in another slot than the counts, so it can't be
+	// updated by the script; a refused key in it just reads as 0
+	set, at := s.setKey(cur), s.expireAt(cur)
+	replies, err := s.send(eval, []string{"SADD", set, k}, []string{"PEXPIREAT", set, at})
+	if err != nil {
+		s.error(err)
+		return true
+	}
+	return replies[0] == int64(1)
+}
+
+// flush sends the pending increments until there are none left.
+func (s *strategy) flush() {
+	for {
+		s.sending.Lock()
+		cmds := s.take()
+		if cmds == nil {
+			s.mu.Lock()
+			done := len(s.pending) == 0
+			if done {
+				s.running = false
+			}
+			s.mu.Unlock()
+			s.sending.Unlock()
+			if done {
+				return
+			}
+			continue
+		}
+		if _, err := s.c.pipeline(cmds...); err != nil {
+			s.error(err)
+		}
+		s.sending.Unlock()
+	}
+}
+
+// take empties pending, returning the commands that send its increments,
+// or nil if it was empty.
+func (s *strategy) take() [][]string {
+	s.mu.Lock()
+	pending := s.pending
+	if len(pending) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	s.pending = map[increment]int64{}
+	s.mu.Unlock()
+
+	var cmds [][]string
+	sets := map[int64]bool{}
+	for inc, n := range pending {
+		count, at := s.countKey(inc.key, inc.bucket), s.expireAt(inc.bucket)
+		cmds = append(cmds,
+			[]string{"INCRBY", count, strconv.FormatInt(n, 10)},
+			[]string{"PEXPIREAT", count, at},
+			[]string{"SADD", s.setKey(inc.bucket), inc.key},
+		)
+		sets[inc.bucket] = true
+	}
+	for bucket := range sets {
+		cmds = append(cmds, []string{"PEXPIREAT", s.setKey(bucket), s.expireAt(bucket)})
+	}
+	return cmds
+}
+
+// send sends cmds in one round trip along with the pending increments, so
+// that their replies count them, and returns the replies to cmds.
+func (s *strategy) send(cmds ...[]string) ([]interface{}, error) {
+	s.sending.RLock()
+	defer s.sending.RUnlock()
+	writes := s.take()
+	replies, err := s.c.pipeline(append(writes, cmds...)...)
+	if err != nil {
+		return nil, err
+	}
+	return replies[len(writes):], nil
+}
+
+// Value estimates key's count over the window ending at now.
+func (s *strategy) Value(key interface{}, now time.Time) int64 {
+	values, err := s.values([]string{fmt.Sprint(key)}, now)
+	if err != nil {
+		s.error(err)
+		return 0
+	}
+	return values[0]
+}
+
+// Snapshot estimates the count of every key counted in the window.
+func (s *strategy) Snapshot(now time.Time) map[interface{}]int64 {
+	cur := s.bucket(now)
+	args := []string{"SUNION"}
+	for i := cur - s.n; i <= cur; i++ {
+		args = append(args, s.setKey(i))
+	}
+	replies, err := s.send(args)
+	if err != nil {
+		s.error(err)
+		return map[interface{}]int64{}
+	}
+	members, _ := replies[0].([]interface{})
+	keys := make([]string, 0, len(members))
+	for _, m := range members {
+		if k, ok := m.(string); ok {
+			keys = append(keys, k)
+		}
+	}
+
+	totals := map[interface{}]int64{}
+	values, err := s.values(keys, now)
+	if err != nil {
+		s.error(err)
+		return totals
+	}
+	for i, k := range keys {
+		if values[i] != 0 {
+			totals[k] = values[i]
+		}
+	}
+	return totals
+}
+
+// Prune does nothing, as Redis expires the buckets.
+func (s *strategy) Prune(now time.Time) {}
+
+// values estimates the count of every key over the window ending at now,
+// reading each one's buckets with an MGET, all in one round trip.
+func (s *strategy) values(keys []string, now time.Time) ([]int64, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	cur := s.bucket(now)
+	cmds := make([][]string, len(keys))
+	for i, k := range keys {
+		cmd := []string{"MGET"}
+		for b := cur - s.n; b <= cur; b++ {
+			cmd = append(cmd, s.countKey(k, b))
+		}
+		cmds[i] = cmd
+	}
+	replies, err := s.send(cmds...)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]int64, len(keys))
+	for i, reply := range replies {
+		counts, _ := reply.([]interface{})
+		for j, c := range counts {
+			str, ok := c.(string)
+			if !ok {
+				continue
+			}
+			n, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("ehcredis: bad count %q", str)
+			}
+			if j == 0 {
+				// the window starts partway through the oldest
+				// bucket
+				left := int64(s.length) - now.UnixNano()%int64(s.length)
+				n = n * left / int64(s.length)
+			}
+			values[i] += n
+		}
+	}
+	return values, nil
+}
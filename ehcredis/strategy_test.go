@@ -0,0 +1,320 @@
+package ehcredis
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// fakeRedis serves the commands the strategy uses from memory, ignoring
+// expirations.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu     sync.Mutex
+	counts map[string]int64
+	sets   map[string]map[string]bool
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, password: password, counts: map[string]int64{}, sets: map[string]map[string]bool{}}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	authed := f.password == ""
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, a := range reply.([]interface{}) {
+			args = append(args, a.(string))
+		}
+		if args[0] == "AUTH" {
+			authed = args[1] == f.password
+			if !authed {
+				io.WriteString(w, "-WRONGPASS invalid password\r\n")
+			} else {
+				io.WriteString(w, "+OK\r\n")
+			}
+		} else if !authed {
+			io.WriteString(w, "-NOAUTH Authentication required.\r\n")
+		} else {
+			f.exec(w, args)
+		}
+		if r.Buffered() == 0 {
+			w.Flush()
+		}
+	}
+}
+
+func (f *fakeRedis) exec(w io.Writer, args []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch args[0] {
+	case "INCRBY":
+		n, _ := strconv.ParseInt(args[2], 10, 64)
+		f.counts[args[1]] += n
+		io.WriteString(w, ":"+strconv.FormatInt(f.counts[args[1]], 10)+"\r\n")
+	case "PEXPIREAT":
+		io.WriteString(w, ":1\r\n")
+	case "SADD":
+		if f.sets[args[1]] == nil {
+			f.sets[args[1]] = map[string]bool{}
+		}
+		f.sets[args[1]][args[2]] = true
+		io.WriteString(w, ":1\r\n")
+	case "SUNION":
+		union := map[string]bool{}
+		for _, set := range args[1:] {
+			for m := range f.sets[set] {
+				union[m] = true
+			}
+		}
+		io.WriteString(w, "*"+strconv.Itoa(len(union))+"\r\n")
+		for m := range union {
+			io.WriteString(w, "$"+strconv.Itoa(len(m))+"\r\n"+m+"\r\n")
+		}
+	case "MGET":
+		io.WriteString(w, "*"+strconv.Itoa(len(args)-1)+"\r\n")
+		for _, k := range args[1:] {
+			if n, ok := f.counts[k]; ok {
+				s := strconv.FormatInt(n, 10)
+				io.WriteString(w, "$"+strconv.Itoa(len(s))+"\r\n"+s+"\r\n")
+			} else {
+				io.WriteString(w, "$-1\r\n")
+			}
+		}
+	case "EVAL":
+		// runs withinScript, the only script the strategy sends
+		if args[1] != withinScript {
+			io.WriteString(w, "-NOSCRIPT unknown script\r\n")
+			return
+		}
+		numKeys, _ := strconv.Atoi(args[2])
+		keys, argv := args[3:3+numKeys], args[3+numKeys:]
+		arg := func(i int) int64 {
+			n, _ := strconv.ParseInt(argv[i], 10, 64)
+			return n
+		}
+		var count int64
+		for i, k := range keys {
+			n := f.counts[k]
+			if i == 0 {
+				n = n * arg(2) / arg(3)
+			}
+			count += n
+		}
+		if count+arg(0) > arg(1) {
+			io.WriteString(w, ":0\r\n")
+			return
+		}
+		f.counts[keys[len(keys)-1]] += arg(0)
+		io.WriteString(w, ":1\r\n")
+	default:
+		io.WriteString(w, "-ERR unknown command\r\n")
+	}
+}
+
+func TestStrategy(t *testing.T) {
+	f := newFakeRedis(t, "")
+	c := NewClient(f.ln.Addr().String())
+	defer c.Close()
+
+	start := time.Unix(1000, 0)
+	newReplica := func() *ehc.ManualEHC {
+		return ehc.NewManualEHC(time.Minute, start, ehc.WithExpiryStrategy(Strategy(c, WithBuckets(6))))
+	}
+	// each replica sends its increments before it reads, so that reading
+	// both has them all in Redis
+	flush := func(es ...*ehc.ManualEHC) {
+		for _, e := range es {
+			e.Get("")
+		}
+	}
+	a, b := newReplica(), newReplica()
+	a.CountMultiple("k", 2)
+	b.Count("k")
+	b.Count(7)
+	flush(a, b)
+
+	for _, e := range []*ehc.ManualEHC{a, b} {
+		if v, ok := e.Get("k"); v != 3 || !ok {
+			t.Errorf("Get(k) = %d, %v, want 3, true", v, ok)
+		}
+	}
+	if s := a.Snapshot(); len(s) != 2 || s["k"] != 3 || s["7"] != 1 {
+		t.Errorf("Snapshot() = %v, want k: 3 and 7: 1", s)
+	}
+
+	a.Tick(30 * time.Second)
+	b.Tick(30 * time.Second)
+	b.Count("k")
+	flush(b)
+	a.Tick(40 * time.Second)
+	if v, _ := a.Get("k"); v != 1 {
+		t.Errorf("Get(k) = %d once the first increments left the window, want 1", v)
+	}
+	if s := a.Snapshot(); len(s) != 1 {
+		t.Errorf("Snapshot() = %v once 7 left the window, want only k", s)
+	}
+}
+
+func TestStrategy_Errors(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+
+	var mu sync.Mutex
+	var errs []error
+	onError := WithErrorHandler(func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	})
+	reported := func() []error {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]error(nil), errs...)
+	}
+
+	c := NewClient(f.ln.Addr().String(), WithPassword("wrong"))
+	e := ehc.NewManualEHC(time.Minute, time.Unix(1000, 0), ehc.WithExpiryStrategy(Strategy(c, onError)))
+	e.Count("k")
+	e.Get("k")
+	if errs := reported(); len(errs) == 0 || !strings.Contains(errs[0].Error(), "WRONGPASS") {
+		t.Errorf("errors with the wrong password = %v, want WRONGPASS", errs)
+	}
+
+	n := len(reported())
+	c = NewClient(f.ln.Addr().String(), WithPassword("secret"))
+	e = ehc.NewManualEHC(time.Minute, time.Unix(1000, 0), ehc.WithExpiryStrategy(Strategy(c, onError)))
+	e.Count("k")
+	if v, _ := e.Get("k"); v != 1 || len(reported()) != n {
+		t.Errorf("Get(k) = %d with %d errors, want 1 and no new errors", v, len(reported())-n)
+	}
+
+	c.Close()
+	n = len(reported())
+	if v, _ := e.Get("k"); v != 0 || len(reported()) == n || !errors.Is(reported()[n], ErrClosed) {
+		t.Errorf("Get(k) = %d after Close with errors %v, want 0 and ErrClosed", v, reported())
+	}
+}
+
+func TestStrategy_Keys(t *testing.T) {
+	f := newFakeRedis(t, "")
+	c := NewClient(f.ln.Addr().String())
+	defer c.Close()
+
+	e := ehc.NewManualEHC(time.Minute, time.Unix(1000, 0), ehc.WithExpiryStrategy(Strategy(c, WithBuckets(6))))
+	keys := map[string]int64{"a": 1, "a}b": 2, "{a}": 3, "": 4, "a}:1": 5}
+	for k, n := range keys {
+		e.CountMultiple(k, n)
+	}
+	for k, n := range keys {
+		if v, _ := e.Get(k); v != n {
+			t.Errorf("Get(%q) = %d, want %d", k, v, n)
+		}
+	}
+	if s := e.Snapshot(); len(s) != len(keys) {
+		t.Errorf("Snapshot() = %v, want all of %v", s, keys)
+	}
+
+	// the hash tag of a Redis key is what's between its first { and the
+	// next }, and the sets must share one for SUNION to work in a Cluster
+	tag := func(k string) string {
+		i := strings.Index(k, "{")
+		j := strings.Index(k[i+1:], "}")
+		return k[i+1 : i+1+j]
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tags := map[string]bool{}
+	for k := range f.counts {
+		tags[tag(k)] = true
+	}
+	if len(tags) != len(keys) {
+		t.Errorf("counts are in %d hash tags, want one for each of the %d keys", len(tags), len(keys))
+	}
+	for k := range f.sets {
+		if tag(k) != "keys" {
+			t.Errorf("set %q has hash tag %q, want keys", k, tag(k))
+		}
+	}
+}
+
+func TestStrategy_AddWithin(t *testing.T) {
+	f := newFakeRedis(t, "")
+	c := NewClient(f.ln.Addr().String())
+	defer c.Close()
+
+	start := time.Unix(1000, 0)
+	var replicas []*ehc.ManualEHC
+	for i := 0; i < 3; i++ {
+		replicas = append(replicas, ehc.NewManualEHC(time.Minute, start, ehc.WithExpiryStrategy(Strategy(c))))
+	}
+	if _, ok := interface{}(Strategy(c)(time.Minute)).(ehc.LimitedStrategy); !ok {
+		t.Fatal("the strategy isn't an ehc.LimitedStrategy")
+	}
+
+	// the replicas race for the limit, which holds across them
+	replicas[0].Count("k")
+	var allowed int64
+	var wg sync.WaitGroup
+	for _, e := range replicas {
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(e *ehc.ManualEHC) {
+				defer wg.Done()
+				if e.Allow("k", 10) {
+					atomic.AddInt64(&allowed, 1)
+				}
+			}(e)
+		}
+	}
+	wg.Wait()
+	if allowed != 9 {
+		t.Errorf("%d calls allowed across the replicas, want 9", allowed)
+	}
+
+	r, ok := replicas[1].Reserve("r", 3, 3)
+	if !ok {
+		t.Fatal("Reserve() failed under the limit")
+	}
+	if v, _ := replicas[2].Get("r"); v != 3 {
+		t.Errorf("Get(r) = %d on another replica while reserved, want 3", v)
+	}
+	r.Cancel()
+	if v, _ := replicas[1].Get("r"); v != 0 {
+		t.Errorf("Get(r) = %d after Cancel, want 0", v)
+	}
+	if s := replicas[2].Snapshot(); len(s) != 1 || s["k"] != 10 {
+		t.Errorf("Snapshot() = %v, want k: 10", s)
+	}
+}
@@ -0,0 +1,74 @@
+package ehcsql
+
+import "strings"
+
+// Digest normalizes a query so that executions differing only in their
+// literal values share a key: string and numeric literals become ?, lists of
+// placeholders collapse to a single ?, and runs of whitespace become a single
+// space. Bind parameters such as ? and $1 are kept as they are.
+func Digest(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	space := false
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case isSpace(c):
+			space = b.Len() > 0
+			i++
+			continue
+		case c == '\'':
+			i = skipString(query, i)
+			c = '?'
+		case isDigit(c) && (i == 0 || !isIdent(query[i-1])):
+			for i < len(query) && (isDigit(query[i]) || query[i] == '.') {
+				i++
+			}
+			c = '?'
+		default:
+			i++
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteByte(c)
+	}
+
+	digest := b.String()
+	for _, list := range []string{"?, ?", "?,?"} {
+		for strings.Contains(digest, list) {
+			digest = strings.ReplaceAll(digest, list, "?")
+		}
+	}
+	return digest
+}
+
+// skipString returns the index just past the string literal starting at i,
+// treating a doubled quote as an escaped quote.
+func skipString(s string, i int) int {
+	for i++; i < len(s); i++ {
+		if s[i] != '\'' {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '\'' {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c)
+}
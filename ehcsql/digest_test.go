@@ -0,0 +1,38 @@
+package ehcsql
+
+import "testing"
+
+func TestDigest(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{
+			query: "SELECT * FROM users WHERE id = 42",
+			want:  "SELECT * FROM users WHERE id = ?",
+		},
+		{
+			query: "select  name\n\tfrom users where email = 'a@b.c' ",
+			want:  "select name from users where email = ?",
+		},
+		{
+			query: "SELECT 1 FROM t WHERE s = 'it''s' AND x > 1.5",
+			want:  "SELECT ? FROM t WHERE s = ? AND x > ?",
+		},
+		{
+			query: "DELETE FROM t WHERE id IN (1, 2, 3)",
+			want:  "DELETE FROM t WHERE id IN (?)",
+		},
+		{
+			query: "UPDATE t2 SET col1 = $1 WHERE id = ?",
+			want:  "UPDATE t2 SET col1 = $1 WHERE id = ?",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := Digest(tt.query); got != tt.want {
+				t.Errorf("Digest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Package ehcsql counts database/sql queries into an EHC, giving visibility
+// into which statements spiked recently without a full APM.
+package ehcsql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/coder543/ehc"
+)
+
+// QueryKey is the key queries are counted under.
+type QueryKey struct {
+	// Digest is the normalized statement, as returned by Digest.
+	Digest string
+}
+
+// Wrap returns a driver that counts every statement executed through d into
+// e. Each execution is counted under QueryKey{Digest(query)}, and each failed
+// one is additionally counted with e.CountError under the same key, so errors
+// are broken down by the EHC's error classes.
+//
+// Register the result with sql.Register to use it:
+//
+//	sql.Register("postgres-counted", ehcsql.Wrap(&pq.Driver{}, counts))
+func Wrap(d driver.Driver, e *ehc.EHC) driver.Driver {
+	return &wrappedDriver{d: d, e: e}
+}
+
+// WrapConnector is like Wrap for drivers that provide a driver.Connector, for
+// use with sql.OpenDB.
+func WrapConnector(c driver.Connector, e *ehc.EHC) driver.Connector {
+	return &wrappedConnector{c: c, e: e}
+}
+
+type wrappedDriver struct {
+	d driver.Driver
+	e *ehc.EHC
+}
+
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.d.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{c: c, e: d.e}, nil
+}
+
+type wrappedConnector struct {
+	c driver.Connector
+	e *ehc.EHC
+}
+
+func (c *wrappedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dc, err := c.c.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{c: dc, e: c.e}, nil
+}
+
+func (c *wrappedConnector) Driver() driver.Driver {
+	return &wrappedDriver{d: c.c.Driver(), e: c.e}
+}
+
+// record counts one execution of query and its error, if any.
+func record(e *ehc.EHC, query string, err error) {
+	if errors.Is(err, driver.ErrSkip) {
+		// database/sql will retry another way, which gets counted then
+		return
+	}
+	key := QueryKey{Digest: Digest(query)}
+	e.Count(key)
+	e.CountError(key, err)
+}
+
+type conn struct {
+	c driver.Conn
+	e *ehc.EHC
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	s, err := c.c.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{s: s, e: c.e, query: query}, nil
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	pc, ok := c.c.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	s, err := pc.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{s: s, e: c.e, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.c.Begin()
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if bc, ok := c.c.(driver.ConnBeginTx); ok {
+		return bc.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errors.New("ehcsql: driver does not support non-default transaction options")
+	}
+	return c.Begin()
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.c.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	res, err := ec.ExecContext(ctx, query, args)
+	record(c.e, query, err)
+	return res, err
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.c.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := qc.QueryContext(ctx, query, args)
+	record(c.e, query, err)
+	return rows, err
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.c.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.c.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.c.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.c.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type stmt struct {
+	s     driver.Stmt
+	e     *ehc.EHC
+	query string
+}
+
+func (s *stmt) Close() error {
+	return s.s.Close()
+}
+
+func (s *stmt) NumInput() int {
+	return s.s.NumInput()
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	res, err := s.s.Exec(args)
+	record(s.e, s.query, err)
+	return res, err
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.s.Query(args)
+	record(s.e, s.query, err)
+	return rows, err
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := s.s.(driver.StmtExecContext)
+	if !ok {
+		values, err := namedValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Exec(values)
+	}
+	res, err := ec.ExecContext(ctx, args)
+	record(s.e, s.query, err)
+	return res, err
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := s.s.(driver.StmtQueryContext)
+	if !ok {
+		values, err := namedValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Query(values)
+	}
+	rows, err := qc.QueryContext(ctx, args)
+	record(s.e, s.query, err)
+	return rows, err
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := s.s.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// namedValues converts arguments for drivers that predate named parameters.
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("ehcsql: driver does not support named parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,126 @@
+package ehcsql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+var errFake = errors.New("fake failure")
+
+// fakeDriver only implements the minimal driver interfaces, so every
+// statement goes through Prepare.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return fakeConn{}, nil
+}
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{fail: strings.Contains(query, "fail")}, nil
+}
+
+func (fakeConn) Close() error {
+	return nil
+}
+
+func (fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+type fakeStmt struct {
+	fail bool
+}
+
+func (fakeStmt) Close() error {
+	return nil
+}
+
+func (fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	if s.fail {
+		return nil, errFake
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	if s.fail {
+		return nil, errFake
+	}
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string {
+	return nil
+}
+
+func (fakeRows) Close() error {
+	return nil
+}
+
+func (fakeRows) Next([]driver.Value) error {
+	return io.EOF
+}
+
+// registered numbers the drivers TestWrap registers, as driver names can't
+// be reused when tests are run more than once.
+var registered int
+
+func TestWrap(t *testing.T) {
+	e := ehc.NewEHC(time.Minute, ehc.WithErrorClasses(ehc.ErrorIs("fake", errFake)))
+	registered++
+	name := fmt.Sprintf("ehcsql-fake-%d", registered)
+	sql.Register(name, Wrap(fakeDriver{}, e))
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	for _, id := range []int{1, 2, 3} {
+		if _, err := db.Exec("UPDATE t SET x = 1 WHERE id = ?", id); err != nil {
+			t.Fatalf("db.Exec() error = %v", err)
+		}
+	}
+	rows, err := db.Query("SELECT * FROM t")
+	if err != nil {
+		t.Fatalf("db.Query() error = %v", err)
+	}
+	rows.Close()
+	if _, err := db.Exec("fail"); !errors.Is(err, errFake) {
+		t.Fatalf("db.Exec(fail) error = %v, want %v", err, errFake)
+	}
+
+	want := map[interface{}]int64{
+		QueryKey{Digest: "UPDATE t SET x = ? WHERE id = ?"}:        3,
+		QueryKey{Digest: "SELECT * FROM t"}:                        1,
+		QueryKey{Digest: "fail"}:                                   1,
+		ehc.ErrorKey{Key: QueryKey{Digest: "fail"}, Class: "fake"}: 1,
+	}
+
+	values, locker := e.Values()
+	defer locker.Unlock()
+	if len(values) != len(want) {
+		t.Errorf("EHC.Values() has %d keys, want %d: %v", len(values), len(want), values)
+	}
+	for k, v := range want {
+		if values[k] == nil || values[k].Value() != v {
+			t.Errorf("EHC.Values()[%v] missing or wrong, want %d", k, v)
+		}
+	}
+}
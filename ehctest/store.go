@@ -0,0 +1,284 @@
+// Package ehctest provides conformance tests for implementations of the ehc
+// extension points, so that in-tree and third-party backends are held to the
+// same bar.
+package ehctest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder543/ehc"
+)
+
+// window is the window of the EHCs the tests drive.
+const window = time.Minute
+
+// RunStoreTests runs the conformance suite for the Store returned by
+// newStore, which must return an empty store on every call. The tests drive
+// an EHC through ehc.NewManualEHC, so they are deterministic and fast.
+func RunStoreTests(t *testing.T, newStore func() ehc.Store) {
+	t.Run("Counting", func(t *testing.T) { testCounting(t, newStore) })
+	t.Run("Expiry", func(t *testing.T) { testExpiry(t, newStore) })
+	t.Run("Keys", func(t *testing.T) { testKeys(t, newStore) })
+	t.Run("Iteration", func(t *testing.T) { testIteration(t, newStore) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, newStore) })
+	t.Run("Migration", func(t *testing.T) { testMigration(t, newStore) })
+}
+
+// tracked wraps newStore to remember the store most recently created.
+type tracked struct {
+	mu       sync.Mutex
+	newStore func() ehc.Store
+	store    ehc.Store
+}
+
+func (s *tracked) create() ehc.Store {
+	store := s.newStore()
+	s.mu.Lock()
+	s.store = store
+	s.mu.Unlock()
+	return store
+}
+
+func (s *tracked) current() ehc.Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store
+}
+
+func newEHC(newStore func() ehc.Store) (*ehc.ManualEHC, *tracked) {
+	s := &tracked{newStore: newStore}
+	e := ehc.NewManualEHC(window, time.Unix(0, 0), ehc.WithStore(s.create))
+	return e, s
+}
+
+func value(e *ehc.ManualEHC, key interface{}) int64 {
+	values, locker := e.Values()
+	defer locker.Unlock()
+	if c := values[key]; c != nil {
+		return c.Value()
+	}
+	return 0
+}
+
+func testCounting(t *testing.T, newStore func() ehc.Store) {
+	e, s := newEHC(newStore)
+	e.Count("a")
+	e.Count("a")
+	e.CountMultiple("b", 5)
+
+	if v := value(e, "a"); v != 2 {
+		t.Errorf("count of a = %d, want 2", v)
+	}
+	if v := value(e, "b"); v != 5 {
+		t.Errorf("count of b = %d, want 5", v)
+	}
+	if v := value(e, "missing"); v != 0 {
+		t.Errorf("count of missing = %d, want 0", v)
+	}
+	store := s.current()
+	if n := store.Len(); n != 2 {
+		t.Errorf("Store.Len() = %d, want 2", n)
+	}
+	if c := store.Get("a"); c == nil || c.Value() != 2 {
+		t.Errorf("Store.Get(a) = %v, want a counter of 2", c)
+	}
+	if c := store.Get("missing"); c != nil {
+		t.Errorf("Store.Get(missing) = %v, want nil", c)
+	}
+
+	if r, ok := e.Reserve("b", 5, 10); !ok {
+		t.Error("Reserve() failed under the limit")
+	} else {
+		r.Commit()
+	}
+	if _, ok := e.Reserve("b", 1, 10); ok {
+		t.Error("Reserve() succeeded over the limit")
+	}
+}
+
+func testExpiry(t *testing.T, newStore func() ehc.Store) {
+	e, s := newEHC(newStore)
+	e.Count("a")
+	e.Tick(window / 2)
+	e.Count("a")
+	e.Count("b")
+
+	e.Tick(window / 2)
+	if v := value(e, "a"); v != 1 {
+		t.Errorf("count of a = %d after its first increment expired, want 1", v)
+	}
+
+	e.Tick(window / 2)
+	if n := s.current().Len(); n != 0 {
+		t.Errorf("Store.Len() = %d after the window, want 0", n)
+	}
+	values, locker := e.Values()
+	if len(values) != 0 {
+		t.Errorf("Values() = %v after the window, want empty", values)
+	}
+	locker.Unlock()
+
+	// expired keys can be counted again
+	e.Count("a")
+	if v := value(e, "a"); v != 1 {
+		t.Errorf("count of a = %d when counted again, want 1", v)
+	}
+}
+
+type structKey struct {
+	a string
+	b int
+}
+
+func testKeys(t *testing.T, newStore func() ehc.Store) {
+	e, s := newEHC(newStore)
+	keys := []interface{}{
+		"", "a", 0, 1, int64(1), uint8(1), 1.5, true, nil,
+		structKey{"a", 1}, structKey{"a", 2}, [2]int{1, 2},
+	}
+	for i, key := range keys {
+		e.CountMultiple(key, int64(i+1))
+	}
+	for i, key := range keys {
+		if v := value(e, key); v != int64(i+1) {
+			t.Errorf("count of %#v = %d, want %d", key, v, i+1)
+		}
+	}
+	if n := s.current().Len(); n != len(keys) {
+		t.Errorf("Store.Len() = %d, want %d distinct keys", n, len(keys))
+	}
+
+	// a zero count must not leave behind a key that never expires
+	e.CountMultiple("zero", 0)
+	e.Tick(window)
+	if n := s.current().Len(); n != 0 {
+		t.Errorf("Store.Len() = %d after the window, want 0", n)
+	}
+}
+
+func testIteration(t *testing.T, newStore func() ehc.Store) {
+	e, s := newEHC(newStore)
+	const keys = 1000
+	for i := 0; i < keys; i++ {
+		e.CountMultiple(i, int64(i)+1)
+	}
+	store := s.current()
+
+	ranged := map[interface{}]int64{}
+	store.Range(func(k interface{}, c ehc.Counter) bool {
+		ranged[k] = c.Value()
+		return true
+	})
+	if len(ranged) != keys {
+		t.Errorf("Store.Range() visited %d keys, want %d", len(ranged), keys)
+	}
+
+	stopped := 0
+	store.Range(func(interface{}, ehc.Counter) bool {
+		stopped++
+		return stopped < 3
+	})
+	if stopped != 3 {
+		t.Errorf("Store.Range() went on for %d calls after fn returned false", stopped)
+	}
+
+	for _, n := range []int{1, 10, keys * 2} {
+		seen := map[interface{}]int{}
+		for cursor, calls := uint64(0), 0; ; calls++ {
+			if calls > keys+1 {
+				t.Fatalf("Store.IterateBatch(_, %d) never completed", n)
+			}
+			var batch []ehc.StoreEntry
+			batch, cursor = store.IterateBatch(cursor, n)
+			for _, entry := range batch {
+				seen[entry.Key]++
+				if want := ranged[entry.Key]; entry.Counter.Value() != want {
+					t.Errorf("Store.IterateBatch() counter of %v = %d, want %d", entry.Key, entry.Counter.Value(), want)
+				}
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+		if len(seen) != keys {
+			t.Errorf("Store.IterateBatch(_, %d) returned %d keys, want %d", n, len(seen), keys)
+		}
+		for k, times := range seen {
+			if times != 1 {
+				t.Errorf("Store.IterateBatch(_, %d) returned %v %d times", n, k, times)
+			}
+		}
+	}
+}
+
+func testConcurrency(t *testing.T, newStore func() ehc.Store) {
+	e, s := newEHC(newStore)
+	const (
+		workers = 8
+		counts  = 500
+		keys    = 16
+	)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < counts; i++ {
+				e.Count(fmt.Sprint((w + i) % keys))
+				if i%50 == 0 {
+					values, locker := e.Values()
+					for _, c := range values {
+						c.Value()
+					}
+					locker.Unlock()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	var total int64
+	for k := 0; k < keys; k++ {
+		total += value(e, fmt.Sprint(k))
+	}
+	if total != workers*counts {
+		t.Errorf("total count = %d, want %d", total, workers*counts)
+	}
+
+	// expire everything while counting new keys concurrently
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < counts; i++ {
+			e.Count(i)
+		}
+	}()
+	e.Tick(window)
+	wg.Wait()
+	e.Tick(window)
+	if n := s.current().Len(); n != 0 {
+		t.Errorf("Store.Len() = %d after the window, want 0", n)
+	}
+}
+
+func testMigration(t *testing.T, newStore func() ehc.Store) {
+	e, s := newEHC(newStore)
+	e.CountMultiple("a", 3)
+	e.Tick(window / 2)
+
+	e.MigrateTo(ehc.WithStore(s.create))
+	if v := value(e, "a"); v != 3 {
+		t.Errorf("count of a = %d after migrating, want 3", v)
+	}
+	e.Tick(window / 2)
+	if v := value(e, "a"); v != 0 {
+		t.Errorf("count of a = %d after its original expiry, want 0", v)
+	}
+	if n := s.current().Len(); n != 0 {
+		t.Errorf("Store.Len() = %d after the window, want 0", n)
+	}
+}
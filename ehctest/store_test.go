@@ -0,0 +1,19 @@
+package ehctest
+
+import (
+	"testing"
+
+	"github.com/coder543/ehc"
+)
+
+func TestMapStore(t *testing.T) {
+	RunStoreTests(t, ehc.NewMapStore)
+}
+
+func TestSyncMapStore(t *testing.T) {
+	RunStoreTests(t, ehc.NewSyncMapStore)
+}
+
+func TestShardedStore(t *testing.T) {
+	RunStoreTests(t, func() ehc.Store { return ehc.NewShardedStore(8) })
+}
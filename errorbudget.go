@@ -0,0 +1,189 @@
+package ehc
+
+import (
+	"strconv"
+	"sync"
+)
+
+// budgetChecks is how many times per window exhausted budgets are checked
+// for recovery.
+const budgetChecks = 16
+
+// BudgetFunc is told when the budget of key becomes exhausted, and again
+// when it recovers.
+type BudgetFunc func(key interface{}, exhausted bool)
+
+// Budget sets an error budget for key: its count may reach allowed within
+// the window, and the budget is exhausted while the count is above that.
+// Functions registered with OnBudgetChange are told of both edges, so that a
+// consumer can degrade when the budget runs out and restore once enough
+// counts have expired. A negative allowed removes the budget.
+func (e *EHC) Budget(key interface{}, allowed int64) {
+	e.setBudget(key, allowed)
+	e.audit("", "Budget", key, budgetDetail(allowed))
+}
+
+// budgetDetail describes a change of budget for the audit log.
+func budgetDetail(allowed int64) string {
+	if allowed < 0 {
+		return "removed"
+	}
+	return "allowed " + strconv.FormatInt(allowed, 10)
+}
+
+func (e *EHC) setBudget(key interface{}, allowed int64) {
+	e.valueLock.RLock()
+	key, ok := e.normalizeKey(key)
+	e.valueLock.RUnlock()
+	if !ok {
+		return
+	}
+
+	b := e.errorBudgetTracker()
+	b.mu.Lock()
+	if allowed < 0 {
+		delete(b.allowed, key)
+		delete(b.exhausted, key)
+		b.mu.Unlock()
+		return
+	}
+	b.allowed[key] = allowed
+	b.mu.Unlock()
+	e.checkBudget(b, key)
+}
+
+// Exhausted reports whether the budget of key is exhausted, which is never
+// the case for keys without one. It changes along with the notifications of
+// OnBudgetChange.
+func (e *EHC) Exhausted(key interface{}) bool {
+	b, _ := e.errorBudgets.Load().(*errorBudgets)
+	if b == nil {
+		return false
+	}
+
+	e.valueLock.RLock()
+	key, ok := e.normalizeKey(key)
+	e.valueLock.RUnlock()
+	if !ok {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exhausted[key]
+}
+
+// OnBudgetChange registers fn to be told whenever a budget set with Budget
+// becomes exhausted or recovers. Exhaustion is noticed as soon as the count
+// goes over budget, and recovery within a sixteenth of the window of the
+// count falling back, as counts expire in the background. fn runs like the
+// other callbacks; see WithCallbackWorkers and WithPanicHandler.
+func (e *EHC) OnBudgetChange(fn BudgetFunc) {
+	b := e.errorBudgetTracker()
+	b.mu.Lock()
+	b.fns = append(b.fns, fn)
+	b.mu.Unlock()
+}
+
+func (e *EHC) errorBudgetTracker() *errorBudgets {
+	e.errorBudgetsOnce.Do(func() {
+		e.errorBudgets.Store(&errorBudgets{
+			allowed:   map[interface{}]int64{},
+			exhausted: map[interface{}]bool{},
+		})
+	})
+	return e.errorBudgets.Load().(*errorBudgets)
+}
+
+// errorBudgets tracks the keys given a budget, and which of them are exhausted.
+type errorBudgets struct {
+	mu        sync.Mutex
+	allowed   map[interface{}]int64
+	exhausted map[interface{}]bool
+	fns       []BudgetFunc
+	// timer checks the exhausted budgets for recovery; it is armed
+	// while there are any.
+	timer Timer
+	armed bool
+}
+
+// countedBudget checks whether a count just made for a normalized key
+// exhausted its budget.
+func (e *EHC) countedBudget(key interface{}) {
+	if b, _ := e.errorBudgets.Load().(*errorBudgets); b != nil {
+		e.checkBudget(b, key)
+	}
+}
+
+// checkBudget brings the state of the budget of a normalized key up to date
+// with its count, notifying of any change.
+func (e *EHC) checkBudget(b *errorBudgets, key interface{}) {
+	// the count is read under b.mu so that concurrent checks can't
+	// apply stale counts out of order
+	b.mu.Lock()
+	allowed, ok := b.allowed[key]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+	exhausted := e.value(key) > allowed
+	if b.exhausted[key] == exhausted {
+		b.mu.Unlock()
+		return
+	}
+	if exhausted {
+		b.exhausted[key] = true
+		e.armBudgetsLocked(b)
+	} else {
+		delete(b.exhausted, key)
+	}
+	fns := b.fns
+	b.mu.Unlock()
+
+	e.submitCallback(func() {
+		for _, fn := range fns {
+			protect("OnBudgetChange", e.handlePanic, func() {
+				fn(key, exhausted)
+			})
+		}
+	})
+}
+
+// armBudgetsLocked schedules the next check for recovery, unless one is
+// already scheduled. b.mu must be held.
+func (e *EHC) armBudgetsLocked(b *errorBudgets) {
+	if b.armed {
+		return
+	}
+	b.armed = true
+	d := e.window / budgetChecks
+	if d <= 0 {
+		d = e.window
+	}
+	if b.timer == nil {
+		b.timer = e.clock.AfterFunc(d, func() { e.checkBudgets(b) })
+	} else {
+		b.timer.Reset(d)
+	}
+}
+
+// checkBudgets checks every exhausted budget for recovery.
+func (e *EHC) checkBudgets(b *errorBudgets) {
+	b.mu.Lock()
+	b.armed = false
+	keys := make([]interface{}, 0, len(b.exhausted))
+	for key := range b.exhausted {
+		keys = append(keys, key)
+	}
+	b.mu.Unlock()
+
+	for _, key := range keys {
+		e.checkBudget(b, key)
+	}
+
+	b.mu.Lock()
+	if len(b.exhausted) > 0 {
+		e.armBudgetsLocked(b)
+	}
+	b.mu.Unlock()
+}
@@ -0,0 +1,70 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+type budgetEvent struct {
+	key       interface{}
+	exhausted bool
+}
+
+func TestEHC_Budget(t *testing.T) {
+	const window = 16 * time.Second
+	e := NewManualEHC(window, time.Unix(0, 0))
+	var events []budgetEvent
+	e.OnBudgetChange(func(key interface{}, exhausted bool) {
+		events = append(events, budgetEvent{key, exhausted})
+	})
+
+	e.CountMultiple("svc", 2)
+	e.Budget("svc", 3)
+	e.Count("svc")
+	if e.Exhausted("svc") || len(events) != 0 {
+		t.Fatalf("budget exhausted at its allowance, events %v", events)
+	}
+
+	e.Tick(4 * time.Second)
+	e.Count("svc")
+	if !e.Exhausted("svc") {
+		t.Error("EHC.Exhausted(svc) = false over budget, want true")
+	}
+	if len(events) != 1 || events[0] != (budgetEvent{"svc", true}) {
+		t.Fatalf("events = %v, want svc exhausted", events)
+	}
+
+	// the first counts expire after the window, and the recovery is
+	// noticed by the next check, a sixteenth of the window later
+	e.Tick(window - 4*time.Second)
+	e.Tick(time.Second)
+	if e.Exhausted("svc") {
+		t.Error("EHC.Exhausted(svc) = true after counts expired, want false")
+	}
+	if len(events) != 2 || events[1] != (budgetEvent{"svc", false}) {
+		t.Fatalf("events = %v, want svc exhausted then recovered", events)
+	}
+
+	e.Budget("svc", -1)
+	e.CountMultiple("svc", 10)
+	if e.Exhausted("svc") || len(events) != 2 {
+		t.Errorf("removed budget still enforced, events %v", events)
+	}
+}
+
+func TestEHC_BudgetSetOverBudget(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	var events []budgetEvent
+	e.OnBudgetChange(func(key interface{}, exhausted bool) {
+		events = append(events, budgetEvent{key, exhausted})
+	})
+
+	e.CountMultiple("svc", 5)
+	e.Budget("svc", 1)
+	if !e.Exhausted("svc") || len(events) != 1 {
+		t.Errorf("budget set below the count isn't exhausted, events %v", events)
+	}
+	if e.Exhausted("other") {
+		t.Error("EHC.Exhausted(other) = true without a budget")
+	}
+}
@@ -0,0 +1,84 @@
+package ehc
+
+import (
+	"errors"
+	"reflect"
+)
+
+// OtherErrors is the class CountError uses for errors no class matches.
+const OtherErrors = "other"
+
+// ErrorClass is a named category of errors used by CountError.
+type ErrorClass struct {
+	Name  string
+	Match func(err error) bool
+}
+
+// ErrorIs returns a class matching the errors for which errors.Is(err,
+// target) reports true, e.g. ErrorIs("timeout", context.DeadlineExceeded).
+func ErrorIs(name string, target error) ErrorClass {
+	return ErrorClass{
+		Name: name,
+		Match: func(err error) bool {
+			return errors.Is(err, target)
+		},
+	}
+}
+
+// ErrorAs returns a class matching the errors for which errors.As would find
+// a match for target, which must be a non-nil pointer as for errors.As, e.g.
+// ErrorAs("net", new(*net.OpError)). target is only used for its type; each
+// match is tested against a fresh value, so the class is safe for concurrent
+// use.
+func ErrorAs(name string, target interface{}) ErrorClass {
+	typ := reflect.TypeOf(target)
+	if typ == nil || typ.Kind() != reflect.Ptr {
+		panic("ehc: ErrorAs target must be a non-nil pointer")
+	}
+	return ErrorClass{
+		Name: name,
+		Match: func(err error) bool {
+			return errors.As(err, reflect.New(typ.Elem()).Interface())
+		},
+	}
+}
+
+// ErrorKey is the key CountError counts under: the caller's key plus the
+// name of the class the error fell into.
+type ErrorKey struct {
+	Key   interface{}
+	Class string
+}
+
+// CountError counts err under ErrorKey{key, class}, where class is the name
+// of the first class registered with WithErrorClasses that matches err, or
+// OtherErrors if none do. A nil err is not counted. This way, "timeouts vs
+// 5xx vs cancellations per downstream" comes out of a single call.
+func (e *EHC) CountError(key interface{}, err error) {
+	if err == nil {
+		return
+	}
+	e.Count(ErrorKey{Key: key, Class: e.classifyError(err)})
+}
+
+// classifyError returns the name of the first class matching err.
+func (e *EHC) classifyError(err error) string {
+	e.valueLock.RLock()
+	classes := e.errorClasses
+	e.valueLock.RUnlock()
+
+	for _, class := range classes {
+		if class.Match(err) {
+			return class.Name
+		}
+	}
+	return OtherErrors
+}
+
+// WithErrorClasses registers the classes CountError sorts errors into. They
+// are tried in order and the first match wins.
+func WithErrorClasses(classes ...ErrorClass) Option {
+	return func(c *config) {
+		c.errorClasses = append(c.errorClasses, classes...)
+	}
+}
@@ -0,0 +1,43 @@
+package ehc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEHC_CountError(t *testing.T) {
+	e := NewEHC(time.Minute, WithErrorClasses(
+		ErrorIs("timeout", context.DeadlineExceeded),
+		ErrorIs("canceled", context.Canceled),
+		ErrorAs("path", new(*os.PathError)),
+	))
+
+	e.CountError("db", fmt.Errorf("query: %w", context.DeadlineExceeded))
+	e.CountError("db", context.DeadlineExceeded)
+	e.CountError("db", context.Canceled)
+	e.CountError("disk", &os.PathError{Op: "open", Path: "/x", Err: os.ErrNotExist})
+	e.CountError("db", errors.New("boom"))
+	e.CountError("db", nil)
+
+	want := map[ErrorKey]int64{
+		{Key: "db", Class: "timeout"}:   2,
+		{Key: "db", Class: "canceled"}:  1,
+		{Key: "disk", Class: "path"}:    1,
+		{Key: "db", Class: OtherErrors}: 1,
+	}
+
+	values, locker := e.Values()
+	defer locker.Unlock()
+	if len(values) != len(want) {
+		t.Errorf("EHC.Values() has %d keys, want %d: %v", len(values), len(want), values)
+	}
+	for k, v := range want {
+		if values[k] == nil || values[k].Value() != v {
+			t.Errorf("EHC.Values()[%v] missing or wrong, want %d", k, v)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package ehc
+
+import "math"
+
+// DefaultMinSamples is the number of exposures each variant of an Experiment
+// needs by default before its results are considered meaningful.
+const DefaultMinSamples = 100
+
+// Variant is one of the two arms of an Experiment.
+type Variant string
+
+// The variants of an Experiment: A is the control, and B the change.
+const (
+	VariantA Variant = "A"
+	VariantB Variant = "B"
+)
+
+// ExperimentKey is the key an Experiment counts under: the experiment's
+// name, the variant, and whether it is a conversion rather than an exposure.
+type ExperimentKey struct {
+	Experiment string
+	Variant    Variant
+	Converted  bool
+}
+
+// Experiment compares two variants of a rollout over the window, such as the
+// error rate of the old and new code paths, by counting how often each is
+// exposed and how often it converts, i.e. has the outcome being watched.
+type Experiment struct {
+	e    *EHC
+	name string
+
+	// MinSamples is the number of exposures each variant needs before
+	// Results reports them as Enough. It starts out as DefaultMinSamples.
+	MinSamples int64
+}
+
+// Experiment returns an Experiment named name, counting in e. Experiments of
+// the same name share their counts.
+func (e *EHC) Experiment(name string) *Experiment {
+	return &Experiment{e: e, name: name, MinSamples: DefaultMinSamples}
+}
+
+// Expose counts an exposure of variant v, e.g. a request it served.
+func (x *Experiment) Expose(v Variant) {
+	x.e.Count(ExperimentKey{Experiment: x.name, Variant: v})
+}
+
+// Convert counts a conversion of variant v, e.g. a request it failed.
+func (x *Experiment) Convert(v Variant) {
+	x.e.Count(ExperimentKey{Experiment: x.name, Variant: v, Converted: true})
+}
+
+// VariantResult is how a variant of an Experiment did over the window.
+type VariantResult struct {
+	Exposures   int64
+	Conversions int64
+	// Ratio is Conversions over Exposures, or 0 without exposures.
+	Ratio float64
+}
+
+// ExperimentResult compares the variants of an Experiment over the window.
+type ExperimentResult struct {
+	A, B VariantResult
+	// Lift is the change in ratio from A to B, relative to A's: 0.5
+	// means B's is half again A's. It is NaN if A's ratio is 0.
+	Lift float64
+	// Enough reports whether both variants had at least MinSamples
+	// exposures. Until they do, Lift is mostly noise.
+	Enough bool
+}
+
+// Results returns how the variants compare over the window.
+func (x *Experiment) Results() ExperimentResult {
+	r := ExperimentResult{A: x.variant(VariantA), B: x.variant(VariantB)}
+	r.Lift = math.NaN()
+	if r.A.Ratio != 0 {
+		r.Lift = (r.B.Ratio - r.A.Ratio) / r.A.Ratio
+	}
+	r.Enough = r.A.Exposures >= x.MinSamples && r.B.Exposures >= x.MinSamples
+	return r
+}
+
+func (x *Experiment) variant(v Variant) VariantResult {
+	var r VariantResult
+	r.Exposures, _ = x.e.Get(ExperimentKey{Experiment: x.name, Variant: v})
+	r.Conversions, _ = x.e.Get(ExperimentKey{Experiment: x.name, Variant: v, Converted: true})
+	if r.Exposures > 0 {
+		r.Ratio = float64(r.Conversions) / float64(r.Exposures)
+	}
+	return r
+}
@@ -0,0 +1,43 @@
+package ehc
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestExperiment(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	x := e.Experiment("checkout")
+	x.MinSamples = 10
+
+	for i := 0; i < 10; i++ {
+		x.Expose(VariantA)
+		x.Expose(VariantB)
+	}
+	x.Convert(VariantA)
+	x.Convert(VariantA)
+	x.Convert(VariantB)
+	x.Convert(VariantB)
+	x.Convert(VariantB)
+
+	r := x.Results()
+	if r.A != (VariantResult{Exposures: 10, Conversions: 2, Ratio: 0.2}) || r.B != (VariantResult{Exposures: 10, Conversions: 3, Ratio: 0.3}) {
+		t.Errorf("Results() = %+v, want A 2/10 and B 3/10", r)
+	}
+	if math.Abs(r.Lift-0.5) > 1e-9 || !r.Enough {
+		t.Errorf("Results() lift = %v and enough = %v, want 0.5 and true", r.Lift, r.Enough)
+	}
+
+	// another experiment counts separately, and has too few samples
+	y := e.Experiment("search")
+	y.Expose(VariantB)
+	if r := y.Results(); r.B.Exposures != 1 || r.A.Exposures != 0 || !math.IsNaN(r.Lift) || r.Enough {
+		t.Errorf("Results() of another experiment = %+v", r)
+	}
+
+	e.Tick(time.Minute)
+	if r := x.Results(); r.A.Exposures != 0 || r.B.Exposures != 0 || r.Enough {
+		t.Errorf("Results() after the window = %+v, want nothing", r)
+	}
+}
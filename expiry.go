@@ -0,0 +1,198 @@
+package ehc
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ExpiryStrategy keeps the counts of an EHC and decides how they decay,
+// replacing the default per-key counters with their individual expiry
+// timers. WithGenerations is implemented against it, and WithExpiryStrategy
+// plugs in custom algorithms, such as exponential decay or a sliding log.
+//
+// The EHC passes in the time of every call, so strategies should not read
+// the clock themselves, and they must be safe for concurrent use. Key
+// validation, bypasses, limits and window export all keep working on top
+// of any strategy.
+type ExpiryStrategy interface {
+	// Add records n increments of key made at now. MigrateTo passes
+	// earlier times, to carry over counts that are partway through the
+	// window.
+	Add(key interface{}, n int64, now time.Time)
+
+	// Value returns key's count at now.
+	Value(key interface{}, now time.Time) int64
+
+	// Snapshot returns the count of every key whose count is nonzero at
+	// now.
+	Snapshot(now time.Time) map[interface{}]int64
+
+	// Prune discards whatever no longer contributes to any count at now.
+	// The EHC calls it about four times per window while it is counting.
+	Prune(now time.Time)
+}
+
+// LimitedStrategy is an ExpiryStrategy that checks a limit and counts against
+// it in one step, such as one whose counts are shared between processes,
+// which no lock of the EHC's can keep from counting in between. Reserve, and
+// so Allow and AllowN, use AddWithin with such a strategy: the cost is
+// counted as it is reserved, rather than when the reservation is committed,
+// and Cancel takes it back.
+type LimitedStrategy interface {
+	ExpiryStrategy
+
+	// AddWithin records n increments of key made at now if that keeps
+	// key's count at most limit, and reports whether it did.
+	AddWithin(key interface{}, n, limit int64, now time.Time) bool
+}
+
+// WithExpiryStrategy replaces the per-key counters with the strategy
+// returned by newStrategy for the EHC's window.
+//
+// MigrateTo carries counts out of a custom strategy as if they had just been
+// counted, since it can't know when they would have expired. WithArena,
+// WithInterner, WithResolution and WithLinger have no effect with a
+// strategy.
+func WithExpiryStrategy(newStrategy func(window time.Duration) ExpiryStrategy) Option {
+	return func(c *config) {
+		c.expiryStrategy = newStrategy
+	}
+}
+
+// newExpiry returns the strategy configured for window, or nil for the
+// default per-key counters.
+func (c *config) newExpiry(window time.Duration, now time.Time) ExpiryStrategy {
+	switch {
+	case c.expiryStrategy != nil:
+		return c.expiryStrategy(window)
+	case c.generations > 0:
+		return newGenerations(window, c.generations, now)
+	case c.buckets > 0 && c.compactBuckets:
+		return newCompact(window, c.buckets)
+	case c.buckets > 0:
+		return newBuckets(window, c.buckets)
+	case c.decay:
+		return newDecay(window, c.decayHalfLife)
+	}
+	return nil
+}
+
+// expiryCount counts a normalized key with the strategy, reporting false if
+// the key was rejected. valueLock must be held.
+func (e *EHC) expiryCount(key interface{}, n int64) bool {
+	now := e.now()
+	if e.validateKey != nil && e.expiry.Value(key, now) == 0 && !e.validate(key) {
+		return false
+	}
+	e.expiry.Add(key, n, now)
+	e.maybePrune(now)
+	return true
+}
+
+// maybePrune prunes the strategy if a quarter of the window passed since it
+// was last pruned. valueLock must be held.
+func (e *EHC) maybePrune(now time.Time) {
+	next := atomic.LoadInt64(&e.nextPrune)
+	if now.UnixNano() < next {
+		return
+	}
+	if !atomic.CompareAndSwapInt64(&e.nextPrune, next, now.Add(e.window/4).UnixNano()) {
+		return
+	}
+	if !e.maintain(func() { e.expiry.Prune(now) }) {
+		// try again on the next count
+		atomic.StoreInt64(&e.nextPrune, next)
+	}
+}
+
+// heldStripes is the number of locks the keys of held are split over.
+const heldStripes = 64
+
+// held is the cost of outstanding reservations per key for a strategy.
+type held struct {
+	// stripes serialize the reservations and commits of the keys hashing
+	// to each, so that a strategy's value is read without holding up
+	// those of other keys, however slow it is.
+	stripes [heldStripes]sync.Mutex
+
+	mu   sync.Mutex
+	cost map[interface{}]int64
+}
+
+// stripe returns the lock of key's stripe.
+func (h *held) stripe(seed maphash.Seed, key interface{}) *sync.Mutex {
+	return &h.stripes[maphash.Comparable(seed, key)%heldStripes]
+}
+
+// expiryReserve holds cost against a normalized key's limit if it fits,
+// reporting whether it did, and whether the strategy counted the cost
+// already, as a LimitedStrategy does. valueLock must be held.
+func (e *EHC) expiryReserve(key interface{}, cost, limit int64) (ok, counted bool) {
+	stripe := e.held.stripe(e.seed, key)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	now := e.now()
+	e.held.mu.Lock()
+	held := e.held.cost[key]
+	e.held.mu.Unlock()
+	if held == 0 && e.validateKey != nil && e.expiry.Value(key, now) == 0 && !e.validate(key) {
+		return false, false
+	}
+	if l, ok := e.expiry.(LimitedStrategy); ok {
+		ok = l.AddWithin(key, cost, limit-held, now)
+		return ok, ok
+	}
+
+	// the stripe keeps the key's value and reservations from changing
+	// under us, but for cancellations, which only make more room
+	if e.expiry.Value(key, now)+held+cost > limit {
+		return false, false
+	}
+	e.held.mu.Lock()
+	defer e.held.mu.Unlock()
+	if e.held.cost == nil {
+		e.held.cost = map[interface{}]int64{}
+	}
+	e.held.cost[key] += cost
+	return true, false
+}
+
+// expiryCommit turns cost reserved for a normalized key into a count. The
+// reservation and the count change together under the key's stripe, so
+// that concurrent reservations see one or the other. valueLock must be
+// held.
+func (e *EHC) expiryCommit(key interface{}, cost int64) {
+	stripe := e.held.stripe(e.seed, key)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	e.held.mu.Lock()
+	e.releaseLocked(key, cost)
+	e.held.mu.Unlock()
+	e.expiry.Add(key, cost, e.now())
+}
+
+// expiryCancel releases cost reserved for a normalized key.
+func (e *EHC) expiryCancel(key interface{}, cost int64) {
+	e.held.mu.Lock()
+	defer e.held.mu.Unlock()
+	e.releaseLocked(key, cost)
+}
+
+// expiryUncount takes back cost a LimitedStrategy counted for a normalized
+// key as it was reserved. valueLock must be held.
+func (e *EHC) expiryUncount(key interface{}, cost int64) {
+	if l, ok := e.expiry.(LimitedStrategy); ok {
+		l.Add(key, -cost, e.now())
+	}
+}
+
+// releaseLocked releases cost reserved for key. e.held.mu must be held.
+func (e *EHC) releaseLocked(key interface{}, cost int64) {
+	if e.held.cost[key] -= cost; e.held.cost[key] == 0 {
+		delete(e.held.cost, key)
+	}
+}
@@ -0,0 +1,246 @@
+package ehc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// logStrategy is a sliding log: it keeps the time of every increment.
+type logStrategy struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	log    map[interface{}][]time.Time
+	pruned int
+}
+
+func newLogStrategy(window time.Duration) ExpiryStrategy {
+	return &logStrategy{window: window, log: map[interface{}][]time.Time{}}
+}
+
+func (s *logStrategy) Add(key interface{}, n int64, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := int64(0); i < n; i++ {
+		s.log[key] = append(s.log[key], now)
+	}
+}
+
+func (s *logStrategy) valueLocked(key interface{}, now time.Time) int64 {
+	var n int64
+	for _, at := range s.log[key] {
+		if now.Sub(at) < s.window {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *logStrategy) Value(key interface{}, now time.Time) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.valueLocked(key, now)
+}
+
+func (s *logStrategy) Snapshot(now time.Time) map[interface{}]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := map[interface{}]int64{}
+	for key := range s.log {
+		if n := s.valueLocked(key, now); n != 0 {
+			values[key] = n
+		}
+	}
+	return values
+}
+
+func (s *logStrategy) Prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruned++
+	for key := range s.log {
+		if s.valueLocked(key, now) == 0 {
+			delete(s.log, key)
+		}
+	}
+}
+
+func TestEHC_ExpiryStrategy(t *testing.T) {
+	var strategy *logStrategy
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithExpiryStrategy(func(window time.Duration) ExpiryStrategy {
+		s := newLogStrategy(window)
+		strategy = s.(*logStrategy)
+		return s
+	}))
+	e.Count("a")
+	e.CountMultiple("b", 2)
+	if r, ok := e.Reserve("a", 2, 3); !ok {
+		t.Fatal("EHC.Reserve() failed under the limit")
+	} else {
+		r.Commit()
+	}
+	if _, ok := e.Reserve("a", 1, 3); ok {
+		t.Error("EHC.Reserve() succeeded over the limit")
+	}
+
+	values, locker := e.Values()
+	if len(values) != 2 || values["a"].Value() != 3 || values["b"].Value() != 2 {
+		t.Errorf("EHC.Values() = %v, want a:3 b:2", values)
+	}
+	locker.Unlock()
+
+	e.Tick(time.Minute)
+	e.Count("c")
+	if v := e.value("a"); v != 0 {
+		t.Errorf("EHC count of a = %d after the window, want 0", v)
+	}
+	strategy.mu.Lock()
+	if strategy.pruned == 0 || len(strategy.log) != 1 {
+		t.Errorf("strategy pruned %d times leaving %d keys, want pruned down to c", strategy.pruned, len(strategy.log))
+	}
+	strategy.mu.Unlock()
+}
+
+func TestEHC_ExpiryStrategyMigrate(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	e.Count("k")
+	e.Tick(30 * time.Second)
+
+	e.MigrateTo(WithExpiryStrategy(newLogStrategy))
+	if v := e.value("k"); v != 1 {
+		t.Errorf("EHC count of k = %d after migrating in, want 1", v)
+	}
+	e.Tick(45 * time.Second)
+	if v := e.value("k"); v != 0 {
+		t.Errorf("EHC count of k = %d after its original expiry, want 0", v)
+	}
+
+	e.Count("k")
+	e.MigrateTo()
+	e.Tick(30 * time.Second)
+	if v := e.value("k"); v != 1 {
+		t.Errorf("EHC count of k = %d after migrating out, want 1", v)
+	}
+	e.Tick(30 * time.Second)
+	if v := e.value("k"); v != 0 {
+		t.Errorf("EHC count of k = %d after the window, want 0", v)
+	}
+}
+
+// limitedStrategy is a logStrategy that checks limits itself, and can have
+// the reads of one key wait.
+type limitedStrategy struct {
+	*logStrategy
+	addWithin int
+
+	// slow, if set, is waited for by the reads of slowKey, which are
+	// told of on reading.
+	slow    chan struct{}
+	slowKey interface{}
+	reading chan struct{}
+}
+
+func (s *limitedStrategy) AddWithin(key interface{}, n, limit int64, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addWithin++
+	if s.valueLocked(key, now)+n > limit {
+		return false
+	}
+	if n < 0 {
+		s.log[key] = s.log[key][:len(s.log[key])+int(n)]
+		return true
+	}
+	for i := int64(0); i < n; i++ {
+		s.log[key] = append(s.log[key], now)
+	}
+	return true
+}
+
+func (s *limitedStrategy) Add(key interface{}, n int64, now time.Time) {
+	if n < 0 {
+		s.mu.Lock()
+		s.log[key] = s.log[key][:len(s.log[key])+int(n)]
+		s.mu.Unlock()
+		return
+	}
+	s.logStrategy.Add(key, n, now)
+}
+
+func (s *limitedStrategy) Value(key interface{}, now time.Time) int64 {
+	if s.slow != nil && key == s.slowKey {
+		select {
+		case s.reading <- struct{}{}:
+		default:
+		}
+		<-s.slow
+	}
+	return s.logStrategy.Value(key, now)
+}
+
+func TestEHC_LimitedStrategy(t *testing.T) {
+	s := &limitedStrategy{logStrategy: newLogStrategy(time.Minute).(*logStrategy)}
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithExpiryStrategy(func(time.Duration) ExpiryStrategy { return s }))
+
+	for i, want := range []bool{true, true, false} {
+		if got := e.Allow("k", 2); got != want {
+			t.Errorf("Allow %d = %v, want %v", i, got, want)
+		}
+	}
+	if s.addWithin != 3 {
+		t.Errorf("AddWithin called %d times, want 3", s.addWithin)
+	}
+
+	r, ok := e.Reserve("r", 2, 2)
+	if !ok || e.value("r") != 2 {
+		t.Fatalf("Reserve() = %v with a count of %d, want true and counted at once", ok, e.value("r"))
+	}
+	r.Cancel()
+	if v := e.value("r"); v != 0 {
+		t.Errorf("count of r = %d after Cancel, want 0", v)
+	}
+	r, _ = e.Reserve("r", 2, 2)
+	r.Commit()
+	if v := e.value("r"); v != 2 {
+		t.Errorf("count of r = %d after Commit, want 2, counted once", v)
+	}
+}
+
+func TestEHC_ExpiryReserveSlowValue(t *testing.T) {
+	s := &limitedStrategy{logStrategy: newLogStrategy(time.Minute).(*logStrategy), slow: make(chan struct{}), slowKey: "slow", reading: make(chan struct{}, 1)}
+	// hide AddWithin, so that reservations read Value
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithExpiryStrategy(func(time.Duration) ExpiryStrategy {
+		return struct{ ExpiryStrategy }{s}
+	}))
+
+	// a key of another stripe than slow's
+	fast := 0
+	for e.held.stripe(e.seed, fast) == e.held.stripe(e.seed, "slow") {
+		fast++
+	}
+
+	done := make(chan bool)
+	go func() {
+		_, ok := e.Reserve("slow", 1, 1)
+		done <- ok
+	}()
+	<-s.reading
+	fastDone := make(chan bool)
+	go func() {
+		_, ok := e.Reserve(fast, 1, 1)
+		fastDone <- ok
+	}()
+	select {
+	case ok := <-fastDone:
+		if !ok {
+			t.Error("Reserve() of another key failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Reserve() of another key waited for a slow read")
+	}
+	close(s.slow)
+	if !<-done {
+		t.Error("Reserve() of the slow key failed")
+	}
+}
@@ -0,0 +1,28 @@
+package ehc
+
+import (
+	"expvar"
+	"fmt"
+)
+
+// Publish publishes the live counts of e as the expvar variable name, so that
+// they are served with the rest at /debug/vars. The counts are read afresh
+// every time the variable is, as an expvar.Map of each key, formatted with
+// fmt.Sprint, to its count; nothing is kept up to date in between. Like
+// expvar.Publish, it panics if name is already in use.
+func Publish(name string, e *EHC) {
+	expvar.Publish(name, publishedCounts{e})
+}
+
+// publishedCounts is the expvar.Var of Publish.
+type publishedCounts struct {
+	e *EHC
+}
+
+func (p publishedCounts) String() string {
+	m := new(expvar.Map)
+	for key, value := range p.e.Snapshot() {
+		m.Add(fmt.Sprint(key), value)
+	}
+	return m.String()
+}
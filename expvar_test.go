@@ -0,0 +1,38 @@
+package ehc
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// published numbers the names TestPublish publishes under, as expvar names
+// can't be reused when tests are run more than once.
+var published int
+
+func TestPublish(t *testing.T) {
+	published++
+	name := fmt.Sprintf("ehc_test_counts_%d", published)
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	Publish(name, e.EHC)
+	e.CountMultiple("a", 2)
+	e.Count(3)
+
+	read := func() map[string]int64 {
+		var counts map[string]int64
+		if err := json.Unmarshal([]byte(expvar.Get(name).String()), &counts); err != nil {
+			t.Fatal(err)
+		}
+		return counts
+	}
+	if counts := read(); len(counts) != 2 || counts["a"] != 2 || counts["3"] != 1 {
+		t.Errorf("published counts = %v, want a: 2 and 3: 1", counts)
+	}
+
+	e.Tick(time.Minute)
+	if counts := read(); len(counts) != 0 {
+		t.Errorf("published counts after the window = %v, want none", counts)
+	}
+}
@@ -0,0 +1,225 @@
+package ehc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// generations implements the coarse expiry mode. Increments are written into
+// the map for the current generation, reads sum every generation that is still
+// inside the window, and expiry is simply replacing the oldest generation's
+// map with a fresh one, which costs the same no matter how many keys it held.
+//
+// Rotation is lazy: the current generation is derived from the clock on each
+// access, so no background goroutine is needed.
+type generations struct {
+	mu     sync.RWMutex
+	base   time.Time
+	length time.Duration
+	slots  []generation
+}
+
+type generation struct {
+	epoch  int64
+	counts map[interface{}]*int64
+}
+
+func newGenerations(window time.Duration, n int, now time.Time) *generations {
+	g := &generations{
+		// start a full window back so restored counts that are already
+		// partway through the window have a generation to land in
+		base:   now.Add(-window),
+		length: window / time.Duration(n),
+		slots:  make([]generation, n),
+	}
+	if g.length <= 0 {
+		g.length = 1
+	}
+	for i := range g.slots {
+		// nothing has been written to these yet
+		g.slots[i].epoch = -1
+	}
+	return g
+}
+
+// epoch returns the generation number that now falls in.
+func (g *generations) epoch(now time.Time) int64 {
+	return int64(now.Sub(g.base) / g.length)
+}
+
+// live reports whether a slot stamped with epoch is inside the window at cur.
+func (g *generations) live(epoch, cur int64) bool {
+	return epoch >= 0 && epoch <= cur && epoch > cur-int64(len(g.slots))
+}
+
+// Add adds n to key in the generation now falls in.
+func (g *generations) Add(key interface{}, n int64, now time.Time) {
+	cur := g.epoch(now)
+	slot := &g.slots[cur%int64(len(g.slots))]
+
+	g.mu.RLock()
+	if slot.epoch == cur {
+		if p := slot.counts[key]; p != nil {
+			atomic.AddInt64(p, n)
+			g.mu.RUnlock()
+			return
+		}
+	}
+	g.mu.RUnlock()
+
+	g.mu.Lock()
+	g.addLocked(key, n, cur)
+	g.mu.Unlock()
+}
+
+// addLocked adds n to key in generation epoch, rotating that generation's
+// slot in if needed. g.mu must be held exclusively.
+func (g *generations) addLocked(key interface{}, n int64, epoch int64) {
+	slot := &g.slots[epoch%int64(len(g.slots))]
+	if slot.epoch != epoch {
+		// this is the rotation: the whole expired map is dropped at once
+		slot.epoch = epoch
+		slot.counts = map[interface{}]*int64{}
+	}
+	p := slot.counts[key]
+	if p == nil {
+		p = new(int64)
+		slot.counts[key] = p
+	}
+	atomic.AddInt64(p, n)
+}
+
+// Value totals key across the live generations.
+func (g *generations) Value(key interface{}, now time.Time) int64 {
+	cur := g.epoch(now)
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var total int64
+	for i := range g.slots {
+		slot := &g.slots[i]
+		if !g.live(slot.epoch, cur) {
+			continue
+		}
+		if p := slot.counts[key]; p != nil {
+			total += atomic.LoadInt64(p)
+		}
+	}
+	return total
+}
+
+// Snapshot merges the live generations into a single map of totals.
+func (g *generations) Snapshot(now time.Time) map[interface{}]int64 {
+	cur := g.epoch(now)
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	totals := map[interface{}]int64{}
+	for i := range g.slots {
+		slot := &g.slots[i]
+		if !g.live(slot.epoch, cur) {
+			continue
+		}
+		for k, p := range slot.counts {
+			totals[k] += atomic.LoadInt64(p)
+		}
+	}
+	for k, v := range totals {
+		if v == 0 {
+			delete(totals, k)
+		}
+	}
+	return totals
+}
+
+// Prune frees the maps of generations that have left the window, rather
+// than waiting for their slots to be reused.
+func (g *generations) Prune(now time.Time) {
+	cur := g.epoch(now)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i := range g.slots {
+		slot := &g.slots[i]
+		if slot.counts != nil && !g.live(slot.epoch, cur) {
+			slot.counts = nil
+		}
+	}
+}
+
+// contributions returns the live counts of every generation, each expiring
+// when its generation leaves the window.
+func (g *generations) contributions(now time.Time) []contribution {
+	cur := g.epoch(now)
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var live []contribution
+	for i := range g.slots {
+		slot := &g.slots[i]
+		if !g.live(slot.epoch, cur) {
+			continue
+		}
+		deadline := g.base.Add(time.Duration(slot.epoch+int64(len(g.slots))) * g.length)
+		for k, p := range slot.counts {
+			if n := atomic.LoadInt64(p); n != 0 {
+				live = append(live, contribution{key: k, count: n, deadline: deadline})
+			}
+		}
+	}
+	return live
+}
+
+// restore adds n to key in the latest generation that expires no later than
+// deadline, or the oldest live generation if every generation outlives it.
+func (g *generations) restore(key interface{}, n int64, deadline, now time.Time) {
+	cur := g.epoch(now)
+	epoch := int64(deadline.Sub(g.base)/g.length) - int64(len(g.slots))
+	if oldest := cur - int64(len(g.slots)) + 1; epoch < oldest {
+		epoch = oldest
+	}
+	if epoch > cur {
+		epoch = cur
+	}
+	if epoch < 0 {
+		epoch = 0
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.addLocked(key, n, epoch)
+}
+
+// fixedCounter is a Counter whose value was computed ahead of time, used to
+// present snapshots of modes that don't keep a counter per key.
+type fixedCounter int64
+
+func (c fixedCounter) inc(int64) bool { return true }
+
+// Value returns the precomputed value.
+func (c fixedCounter) Value() int64 {
+	return int64(c)
+}
+
+// WithGenerations switches the EHC to coarse generation-based expiry with n
+// generations spaced window/n apart. Count writes into the current generation
+// and a key's value is the sum over the generations still in the window; a
+// whole generation is discarded at once when it ages out, making expiry O(1)
+// regardless of traffic. It is implemented as an ExpiryStrategy, like those
+// given to WithExpiryStrategy.
+//
+// The price is accuracy: an increment is retracted somewhere between
+// (n-1)/n of the window and the full window after it was made. Larger n
+// tightens that bound at the cost of more work per read.
+//
+// WithArena and WithInterner have no effect in this mode.
+func WithGenerations(n int) Option {
+	return func(c *config) {
+		c.generations = n
+	}
+}
@@ -0,0 +1,98 @@
+package ehc
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEHC_Generations(t *testing.T) {
+	e := NewEHC(40*time.Millisecond, WithGenerations(4))
+	e.Count("a")
+	e.CountMultiple("a", 2)
+	e.Count("b")
+
+	values, locker := e.Values()
+	if len(values) != 2 || values["a"].Value() != 3 || values["b"].Value() != 1 {
+		t.Errorf("EHC.Values() = %v, want a=3 b=1", values)
+	}
+	locker.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+
+	values, locker = e.Values()
+	if len(values) != 0 {
+		t.Errorf("EHC.Values() after window = %v, want empty", values)
+	}
+	locker.Unlock()
+}
+
+func TestEHC_GenerationsValidator(t *testing.T) {
+	e := NewEHC(40*time.Millisecond, WithGenerations(4), WithKeyValidator(func(key interface{}) error {
+		if key == "bad" {
+			return errors.New("bad key")
+		}
+		return nil
+	}))
+	e.Count("good")
+	e.Count("bad")
+
+	values, locker := e.Values()
+	if len(values) != 1 {
+		t.Errorf("EHC.Values() = %v, want only the good key", values)
+	}
+	locker.Unlock()
+
+	if dropped := e.Stats().Dropped; dropped != 1 {
+		t.Errorf("EHC.Stats().Dropped = %d, want 1", dropped)
+	}
+}
+
+func BenchmarkEHC_GenerationsSame(b *testing.B) {
+	e := NewEHC(10*time.Millisecond, WithGenerations(8))
+	for i := 0; i < b.N; i++ {
+		e.Count("hi")
+	}
+}
+
+func BenchmarkEHC_GenerationsDistribution(b *testing.B) {
+	e := NewEHC(10*time.Millisecond, WithGenerations(8))
+	for i := 0; i < b.N; i++ {
+		e.Count(i % 10)
+	}
+}
+
+func TestEHC_GenerationsCoarseClock(t *testing.T) {
+	e := NewEHC(40*time.Millisecond, WithGenerations(4), WithCoarseClock(time.Millisecond))
+	defer e.stopCoarseClock()
+
+	e.Count("a")
+	values, locker := e.Values()
+	if values["a"] == nil || values["a"].Value() != 1 {
+		t.Errorf("EHC.Values() = %v, want a=1", values)
+	}
+	locker.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	values, locker = e.Values()
+	if len(values) != 0 {
+		t.Errorf("EHC.Values() after window = %v, want empty", values)
+	}
+	locker.Unlock()
+}
+
+func BenchmarkEHC_GenerationsSameCoarse(b *testing.B) {
+	e := NewEHC(10*time.Millisecond, WithGenerations(8), WithCoarseClock(100*time.Microsecond))
+	defer e.stopCoarseClock()
+	for i := 0; i < b.N; i++ {
+		e.Count("hi")
+	}
+}
+
+func BenchmarkEHC_GenerationsDistributionCoarse(b *testing.B) {
+	e := NewEHC(10*time.Millisecond, WithGenerations(8), WithCoarseClock(100*time.Microsecond))
+	defer e.stopCoarseClock()
+	for i := 0; i < b.N; i++ {
+		e.Count(i % 10)
+	}
+}
@@ -0,0 +1,62 @@
+package ehc
+
+// WithGlobalLimit caps the total cost reserved across all keys within the
+// window at n, on top of the limits passed to Reserve for each key, so that
+// the throughput of the whole system is bounded even when no single key is
+// hot. Reserve, and with it WaitAllow and AdmissionQueue, fails for any key
+// once the counts of every key plus the outstanding reservations leave no
+// room for the cost. Counts made with Count and its variants are included in
+// the total but are never refused, and keys exempted with BypassLimits are
+// neither checked nor included.
+//
+// The total is kept by a counter of its own, which MigrateTo carries over.
+// Running out of it doesn't block keys with WithBlockOnLimit, as no key is
+// to blame.
+func WithGlobalLimit(n int64) Option {
+	return func(c *config) {
+		c.globalLimit = n
+	}
+}
+
+// globalKey is what the global total is counted under.
+type globalKey struct{}
+
+// globalTotal keeps the total across keys for WithGlobalLimit.
+type globalTotal struct {
+	counts *EHC
+	limit  int64
+}
+
+// storeGlobalTotal installs the global total as configured, keeping the
+// current counts across migrations.
+func (e *EHC) storeGlobalTotal() {
+	g, _ := e.global.Load().(*globalTotal)
+	switch {
+	case e.globalLimit <= 0:
+		if g != nil {
+			g.counts.Close()
+		}
+		g = nil
+	case g == nil:
+		g = &globalTotal{counts: newEHC(e.window, e.clock), limit: e.globalLimit}
+	default:
+		g = &globalTotal{counts: g.counts, limit: e.globalLimit}
+	}
+	e.global.Store(g)
+}
+
+// countedGlobal adds count to the global total, if there is one.
+func (e *EHC) countedGlobal(count int64) {
+	if g, _ := e.global.Load().(*globalTotal); g != nil {
+		g.counts.CountMultiple(globalKey{}, count)
+	}
+}
+
+func (g *globalTotal) reserve(cost int64) bool {
+	ok, _ := g.counts.reserve(globalKey{}, cost, g.limit)
+	return ok
+}
+
+func (g *globalTotal) cancel(cost int64) {
+	g.counts.cancel(globalKey{}, cost)
+}
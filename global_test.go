@@ -0,0 +1,55 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_GlobalLimit(t *testing.T) {
+	start := time.Unix(0, 0)
+	e := NewManualEHC(time.Minute, start, WithGlobalLimit(5), WithBlockOnLimit(time.Minute))
+
+	r, ok := e.Reserve("a", 3, 10)
+	if !ok {
+		t.Fatal("EHC.Reserve(a) within both limits failed")
+	}
+	r.Commit()
+	if _, ok := e.Reserve("b", 3, 10); ok {
+		t.Error("EHC.Reserve(b) succeeded beyond the global limit")
+	}
+	if e.IsBlocked("b") {
+		t.Error("EHC.IsBlocked(b) = true after running out of the global limit")
+	}
+	r, ok = e.Reserve("b", 2, 10)
+	if !ok {
+		t.Fatal("EHC.Reserve(b) within both limits failed")
+	}
+	if _, ok := e.Reserve("c", 1, 10); ok {
+		t.Error("EHC.Reserve(c) succeeded despite the outstanding reservation")
+	}
+	r.Cancel()
+	// c's reservation stays outstanding, holding 1 of the total
+	if _, ok := e.Reserve("c", 1, 10); !ok {
+		t.Error("EHC.Reserve(c) failed after Cancel released the global limit")
+	}
+
+	// plain counts go into the total, and aren't refused
+	e.Tick(30 * time.Second)
+	e.CountMultiple("d", 4)
+	if n, _ := e.Get("d"); n != 4 {
+		t.Errorf("EHC.Get(d) = %d, want 4", n)
+	}
+	if at := e.WhenAllowed("e", 1, 10); !at.Equal(start.Add(90 * time.Second)) {
+		t.Errorf("EHC.WhenAllowed(e) = %v, want when d expires at %v", at, start.Add(90*time.Second))
+	}
+
+	// the total survives a migration
+	e.MigrateTo(WithGlobalLimit(5), WithGenerations(4))
+	if _, ok := e.Reserve("e", 1, 10); ok {
+		t.Error("EHC.Reserve(e) succeeded beyond the global limit after MigrateTo")
+	}
+	e.Tick(time.Minute + time.Second)
+	if _, ok := e.Reserve("e", 4, 10); !ok {
+		t.Error("EHC.Reserve(e) failed once the total expired")
+	}
+}
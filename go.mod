@@ -1 +1,3 @@
 module github.com/coder543/ehc
+
+go 1.27.1
@@ -0,0 +1,75 @@
+package ehc
+
+import (
+	"strings"
+	"sync"
+)
+
+// Interner deduplicates string keys. Each distinct string is stored once, in
+// its own freshly allocated backing array, no matter how many times or in how
+// many EHCs it is counted. Entries are reference counted by the EHCs using
+// them and disappear once the last of those keys expires.
+//
+// An Interner may be shared by any number of EHCs via WithInterner.
+type Interner struct {
+	mu      sync.Mutex
+	entries map[string]*internEntry
+}
+
+type internEntry struct {
+	s    string
+	refs int
+}
+
+// NewInterner returns an empty Interner.
+func NewInterner() *Interner {
+	return &Interner{
+		entries: map[string]*internEntry{},
+	}
+}
+
+// Len returns the number of distinct strings currently held.
+func (in *Interner) Len() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return len(in.entries)
+}
+
+// acquire returns the canonical copy of s, taking a reference on it.
+func (in *Interner) acquire(s string) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	ent := in.entries[s]
+	if ent == nil {
+		// clone so we never pin a larger buffer the caller sliced s from
+		ent = &internEntry{s: strings.Clone(s)}
+		in.entries[ent.s] = ent
+	}
+	ent.refs++
+	return ent.s
+}
+
+// release drops a reference taken by acquire.
+func (in *Interner) release(s string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	ent := in.entries[s]
+	if ent == nil {
+		return
+	}
+	ent.refs--
+	if ent.refs <= 0 {
+		delete(in.entries, s)
+	}
+}
+
+// WithInterner makes the EHC intern its string keys through in. Repeated
+// Count calls with equal, dynamically built strings then share a single
+// backing array, and so do EHCs that share the Interner.
+func WithInterner(in *Interner) Option {
+	return func(c *config) {
+		c.interner = in
+	}
+}
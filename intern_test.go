@@ -0,0 +1,39 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestInterner(t *testing.T) {
+	in := NewInterner()
+	e1 := NewEHC(10*time.Millisecond, WithInterner(in))
+	e2 := NewEHC(10*time.Millisecond, WithInterner(in))
+
+	buf := []byte("key:one and a lot of trailing data")
+	e1.Count(string(buf[:7]))
+	e1.Count(string(buf[:7]))
+	e2.Count(string(buf[:7]))
+
+	if n := in.Len(); n != 1 {
+		t.Fatalf("Interner.Len() = %d, want 1", n)
+	}
+
+	keyData := func(e *EHC) *byte {
+		values, locker := e.Values()
+		defer locker.Unlock()
+		for k := range values {
+			return unsafe.StringData(k.(string))
+		}
+		return nil
+	}
+	if keyData(e1) != keyData(e2) {
+		t.Errorf("keys in EHCs sharing an Interner have different backing arrays")
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	if n := in.Len(); n != 0 {
+		t.Errorf("Interner.Len() after expiry = %d, want 0", n)
+	}
+}
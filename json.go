@@ -0,0 +1,129 @@
+package ehc
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// stateJSON is the JSON form of an EHC's counts.
+type stateJSON struct {
+	Keys []keyJSON `json:"keys"`
+}
+
+type keyJSON struct {
+	Key           interface{}        `json:"key"`
+	Value         int64              `json:"value"`
+	Contributions []contributionJSON `json:"contributions"`
+}
+
+// contributionJSON is a live increment. TTL is informational; the increment
+// is restored to expire at Expires.
+type contributionJSON struct {
+	Count   int64     `json:"count"`
+	Expires time.Time `json:"expires"`
+	TTL     string    `json:"ttl"`
+}
+
+// MarshalJSON encodes the live counts of the EHC, for debugging endpoints
+// and for UnmarshalJSON to restore: every key with its count, and the
+// increments making it up, each with when it expires and the time left until
+// then. Keys are encoded as encoding/json encodes them, and sorted by their
+// fmt.Sprint form. Outstanding reservations are left out.
+func (e *EHC) MarshalJSON() ([]byte, error) {
+	now := e.clock.Now()
+	e.valueLock.RLock()
+	live := e.contributionsLocked(now)
+	e.valueLock.RUnlock()
+
+	byKey := map[interface{}]*keyJSON{}
+	var state stateJSON
+	for _, c := range live {
+		k := byKey[c.key]
+		if k == nil {
+			state.Keys = append(state.Keys, keyJSON{Key: c.key})
+			k = &state.Keys[len(state.Keys)-1]
+			byKey[c.key] = k
+		}
+		k.Value += c.count
+		k.Contributions = append(k.Contributions, contributionJSON{
+			Count:   c.count,
+			Expires: c.deadline,
+			TTL:     c.deadline.Sub(now).String(),
+		})
+	}
+	for i := range state.Keys {
+		cs := state.Keys[i].Contributions
+		sort.SliceStable(cs, func(a, b int) bool { return cs[a].Expires.Before(cs[b].Expires) })
+	}
+	sort.Slice(state.Keys, func(i, j int) bool {
+		return fmt.Sprint(state.Keys[i].Key) < fmt.Sprint(state.Keys[j].Key)
+	})
+	if state.Keys == nil {
+		state.Keys = []keyJSON{}
+	}
+	return json.Marshal(state)
+}
+
+// UnmarshalJSON adds the counts encoded by MarshalJSON to the EHC, which must
+// have been created by NewEHC, each increment expiring at the time it
+// originally would have; those already expired are skipped. Keys are decoded
+// as encoding/json decodes into an interface{}, so only string keys are
+// restored as they were: numbers, for example, come back as float64s. It
+// fails with ErrClosed if the EHC is closed.
+func (e *EHC) UnmarshalJSON(data []byte) error {
+	var state stateJSON
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	var live []contribution
+	for _, k := range state.Keys {
+		for _, c := range k.Contributions {
+			live = append(live, contribution{key: k.Key, count: c.Count, deadline: c.Expires})
+		}
+	}
+	return e.restore(live)
+}
+
+// restore adds live increments given from outside the EHC, normalizing their
+// keys, or fails with ErrClosed.
+func (e *EHC) restore(live []contribution) error {
+	now := e.clock.Now()
+	e.valueLock.Lock()
+	defer e.valueLock.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+	kept := live[:0]
+	for _, c := range live {
+		key, ok := e.normalizeKey(c.key)
+		if !ok {
+			continue
+		}
+		c.key = key
+		kept = append(kept, c)
+	}
+	e.restoreLocked(kept, now)
+	return nil
+}
+
+// contributionsLocked returns the increments still live at now, like
+// drainLocked but leaving them in place. valueLock must be held.
+func (e *EHC) contributionsLocked(now time.Time) []contribution {
+	if e.expiry != nil {
+		return e.strategyContributions(now)
+	}
+	var live []contribution
+	e.rangeCounters(func(key interface{}, c *counter) bool {
+		c.mu.Lock()
+		for _, r := range c.pending {
+			if r.deadline.After(now) {
+				live = append(live, contribution{key: c.key, count: r.count, deadline: r.deadline})
+			}
+		}
+		c.mu.Unlock()
+		return true
+	})
+	return live
+}
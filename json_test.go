@@ -0,0 +1,74 @@
+package ehc
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEHC_JSON(t *testing.T) {
+	start := time.Unix(0, 0)
+	e := NewManualEHC(time.Minute, start)
+	e.CountMultiple("a", 2)
+	e.Tick(20 * time.Second)
+	e.Count("a")
+	e.Count("b")
+
+	data, err := json.Marshal(e.EHC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"key":"a","value":3`, `"count":2`, `"ttl":"40s"`, `"key":"b","value":1`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("json.Marshal() = %s, missing %s", data, want)
+		}
+	}
+
+	// restored into a fresh EHC later on, the increments still expire at
+	// their original deadlines
+	restored := NewManualEHC(time.Minute, start.Add(30*time.Second))
+	if err := json.Unmarshal(data, restored.EHC); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := restored.value("a"), restored.value("b"); a != 3 || b != 1 {
+		t.Errorf("restored a = %d and b = %d, want 3 and 1", a, b)
+	}
+	restored.Tick(30 * time.Second)
+	if a := restored.value("a"); a != 1 {
+		t.Errorf("restored a = %d once its first increments expired, want 1", a)
+	}
+	restored.Tick(20 * time.Second)
+	if s := restored.Snapshot(); len(s) != 0 {
+		t.Errorf("restored counts = %v after the window, want none", s)
+	}
+
+	restored.Close()
+	if err := json.Unmarshal(data, restored.EHC); err != ErrClosed {
+		t.Errorf("json.Unmarshal() into a closed EHC = %v, want ErrClosed", err)
+	}
+}
+
+func TestEHC_JSONStrategies(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"buckets":     {WithBuckets(6)},
+		"generations": {WithGenerations(6)},
+		"decay":       {WithExponentialDecay(0)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := NewManualEHC(time.Minute, time.Unix(0, 0), opts...)
+			e.CountMultiple("a", 4)
+			data, err := json.Marshal(e.EHC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored := NewManualEHC(time.Minute, time.Unix(0, 0))
+			if err := json.Unmarshal(data, restored.EHC); err != nil {
+				t.Fatal(err)
+			}
+			if a := restored.value("a"); a != 4 {
+				t.Errorf("restored a = %d from %s, want 4", a, data)
+			}
+		})
+	}
+}
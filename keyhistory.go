@@ -0,0 +1,94 @@
+package ehc
+
+import (
+	"sync"
+	"time"
+)
+
+// WithKeyHistory keeps a history of how many keys the EHC holds, for
+// KeyHistory to return, so that capacity can be planned from the peaks the
+// EHC has seen itself. The keys are counted every time the timing wheel
+// expires counts, before it does, and the history keeps the largest and
+// latest number for each of the last n intervals of interval, or of a
+// sixteenth of the window if interval isn't positive.
+//
+// It only applies to the default timer mode. Like the log of WithAuditLog,
+// the history is kept by MigrateTo whatever the new options.
+func WithKeyHistory(n int, interval time.Duration) Option {
+	return func(c *config) {
+		c.keyHistoryLen = n
+		c.keyHistoryInterval = interval
+	}
+}
+
+// KeySample is the number of keys an EHC held over an interval of the history
+// of WithKeyHistory.
+type KeySample struct {
+	// Start is the start of the interval.
+	Start time.Time
+	// Peak is the largest number of keys counted in the interval.
+	Peak int
+	// Last is the number of keys counted last in the interval.
+	Last int
+}
+
+// KeyHistory returns the intervals of the history of WithKeyHistory in which
+// the keys were counted, oldest first, or nil without it.
+func (e *EHC) KeyHistory() []KeySample {
+	if e.keyHistory == nil {
+		return nil
+	}
+	return e.keyHistory.samples()
+}
+
+// sampleKeys adds the current number of keys to the history.
+func (e *EHC) sampleKeys() {
+	e.keyHistory.add(e.clock.Now(), e.keys())
+}
+
+// keyHistory is the history of WithKeyHistory.
+type keyHistory struct {
+	n        int
+	interval time.Duration
+
+	mu      sync.Mutex
+	history []KeySample
+}
+
+func newKeyHistory(n int, interval time.Duration) *keyHistory {
+	return &keyHistory{n: n, interval: interval}
+}
+
+func (h *keyHistory) add(now time.Time, keys int) {
+	start := now.Truncate(h.interval)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i := len(h.history) - 1; i >= 0 && !start.After(h.history[i].Start) {
+		s := &h.history[i]
+		s.Peak = max(s.Peak, keys)
+		s.Last = keys
+		return
+	}
+	if len(h.history) == h.n {
+		copy(h.history, h.history[1:])
+		h.history = h.history[:h.n-1]
+	}
+	h.history = append(h.history, KeySample{Start: start, Peak: keys, Last: keys})
+}
+
+func (h *keyHistory) samples() []KeySample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]KeySample(nil), h.history...)
+}
+
+// peak returns the largest number of keys in the history.
+func (h *keyHistory) peak() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	peak := 0
+	for _, s := range h.history {
+		peak = max(peak, s.Peak)
+	}
+	return peak
+}
@@ -0,0 +1,59 @@
+package ehc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEHC_KeyHistory(t *testing.T) {
+	start := time.Unix(0, 0)
+	e := NewManualEHC(16*time.Second, start, WithKeyHistory(2, 4*time.Second))
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	e.Count("a")
+	e.Count("b")
+	e.Count("c")
+	e.Tick(2 * time.Second)
+	e.Count("d")
+	// the keys are counted before the expired ones go
+	e.Tick(14 * time.Second)
+	e.Tick(2 * time.Second)
+	want := []KeySample{{Start: at(16 * time.Second), Peak: 4, Last: 1}}
+	if got := e.KeyHistory(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("KeyHistory() = %v, want %v", got, want)
+	}
+
+	e.Count("e")
+	e.Tick(16 * time.Second)
+	e.Count("f")
+	e.Tick(16 * time.Second)
+	want = []KeySample{
+		{Start: at(32 * time.Second), Peak: 1, Last: 1},
+		{Start: at(48 * time.Second), Peak: 1, Last: 1},
+	}
+	if got := e.KeyHistory(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("KeyHistory() = %v, want %v", got, want)
+	}
+	if got := e.Stats().PeakKeys; got != 1 {
+		t.Errorf("PeakKeys = %d, want 1 over the history kept", got)
+	}
+
+	// the history survives MigrateTo
+	e.MigrateTo()
+	e.Count("g")
+	e.Count("h")
+	e.Tick(16 * time.Second)
+	if got := e.KeyHistory(); len(got) != 2 || got[1].Peak != 2 {
+		t.Errorf("KeyHistory() = %v after MigrateTo, want a peak of 2 last", got)
+	}
+}
+
+func TestEHC_KeyHistoryDisabled(t *testing.T) {
+	e := NewManualEHC(time.Second, time.Unix(0, 0))
+	e.Count("a")
+	e.Tick(time.Second)
+	if got := e.KeyHistory(); got != nil {
+		t.Errorf("KeyHistory() = %v without WithKeyHistory, want nil", got)
+	}
+}
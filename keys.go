@@ -0,0 +1,42 @@
+package ehc
+
+import (
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// normalizeKey applies the configured key size policy before the key is used
+// for a lookup. It returns false if the key must be dropped.
+func (e *EHC) normalizeKey(key interface{}) (interface{}, bool) {
+	if e.maxKeySize <= 0 {
+		return key, true
+	}
+
+	var s string
+	switch k := key.(type) {
+	case string:
+		s = k
+	case []byte:
+		s = string(k)
+	default:
+		return key, true
+	}
+	if len(s) <= e.maxKeySize {
+		return s, true
+	}
+
+	if e.keySizePolicy != TruncateOversized {
+		return nil, false
+	}
+
+	// back up to the start of a rune so we don't leave a broken sequence
+	n := e.maxKeySize
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	atomic.AddInt64(&e.stats.truncated, 1)
+
+	// clone so the map doesn't pin the oversized backing array
+	return strings.Clone(s[:n]), true
+}
@@ -0,0 +1,76 @@
+package ehc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEHC_MaxKeySize(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        KeySizePolicy
+		keys          []interface{}
+		want          map[string]int64
+		wantDropped   int64
+		wantTruncated int64
+	}{
+		{
+			name:        "rejects oversized keys",
+			policy:      RejectOversized,
+			keys:        []interface{}{"short", strings.Repeat("x", 100)},
+			want:        map[string]int64{"short": 1},
+			wantDropped: 1,
+		},
+		{
+			name:          "truncates oversized keys",
+			policy:        TruncateOversized,
+			keys:          []interface{}{"abcdefghij", "abcdefghXX"},
+			want:          map[string]int64{"abcdefgh": 2},
+			wantTruncated: 2,
+		},
+		{
+			name:          "truncates on a rune boundary",
+			policy:        TruncateOversized,
+			keys:          []interface{}{"abcdefgé"},
+			want:          map[string]int64{"abcdefg": 1},
+			wantTruncated: 1,
+		},
+		{
+			name:   "converts byte slice keys",
+			policy: RejectOversized,
+			keys:   []interface{}{[]byte("bytes")},
+			want:   map[string]int64{"bytes": 1},
+		},
+		{
+			name:   "ignores non-string keys",
+			policy: RejectOversized,
+			keys:   []interface{}{123456789},
+			want:   map[string]int64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEHC(10*time.Millisecond, WithMaxKeySize(8, tt.policy))
+			for _, k := range tt.keys {
+				e.Count(k)
+			}
+
+			got, locker := e.Values()
+			for k, v := range tt.want {
+				if got[k] == nil || got[k].Value() != v {
+					t.Errorf("EHC.Values()[%q] missing or wrong, want %d", k, v)
+				}
+			}
+			locker.Unlock()
+
+			stats := e.Stats()
+			if stats.Dropped != tt.wantDropped {
+				t.Errorf("EHC.Stats().Dropped = %d, want %d", stats.Dropped, tt.wantDropped)
+			}
+			if stats.Truncated != tt.wantTruncated {
+				t.Errorf("EHC.Stats().Truncated = %d, want %d", stats.Truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
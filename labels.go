@@ -0,0 +1,82 @@
+package ehc
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"time"
+)
+
+// ProfileLabel is the pprof label Do sets to the hot key being counted.
+const ProfileLabel = "ehc_key"
+
+// WithProfileLabels makes Do label the work done for the n keys with the
+// highest counts with ProfileLabel, so that CPU profiles can attribute time
+// to the traffic keys driving it, e.g. with go tool pprof -tagfocus. The
+// hottest keys are found with TopN, at most once per refresh, or once per
+// sixteenth of the window if refresh isn't positive. Keys are labelled as
+// formatted by fmt.Sprint.
+func WithProfileLabels(n int, refresh time.Duration) Option {
+	return func(c *config) {
+		c.profileLabels = n
+		c.profileLabelsRefresh = refresh
+	}
+}
+
+// Do counts key, like Count, and calls fn with ctx. With WithProfileLabels,
+// if key is one of the hottest keys, fn runs as with pprof.Do, with ctx and
+// the goroutine labelled with ProfileLabel set to the key, and the labels of
+// ctx restored afterwards.
+func (e *EHC) Do(ctx context.Context, key interface{}, fn func(ctx context.Context)) {
+	e.Count(key)
+
+	e.valueLock.RLock()
+	n, refresh := e.profileLabels, e.profileLabelsRefresh
+	key, ok := e.normalizeKey(key)
+	e.valueLock.RUnlock()
+	if n <= 0 || !ok {
+		fn(ctx)
+		return
+	}
+	if refresh <= 0 {
+		refresh = e.window / 16
+	}
+
+	label, hot := e.hotKeys(n, refresh)[key]
+	if !hot {
+		fn(ctx)
+		return
+	}
+	pprof.Do(ctx, pprof.Labels(ProfileLabel, label), fn)
+}
+
+// hotKeyTable is the hottest keys found for WithProfileLabels, with their
+// labels.
+type hotKeyTable struct {
+	built  time.Time
+	n      int
+	labels map[interface{}]string
+}
+
+// hotKeys returns the labels of the n hottest keys, finding them again if
+// they were found more than refresh ago.
+func (e *EHC) hotKeys(n int, refresh time.Duration) map[interface{}]string {
+	now := e.clock.Now()
+	stale := func(t *hotKeyTable) bool {
+		return t == nil || t.n != n || now.Sub(t.built) >= refresh
+	}
+
+	t, _ := e.hot.Load().(*hotKeyTable)
+	if stale(t) {
+		e.hotMu.Lock()
+		if t, _ = e.hot.Load().(*hotKeyTable); stale(t) {
+			t = &hotKeyTable{built: now, n: n, labels: map[interface{}]string{}}
+			for _, kc := range e.TopN(n) {
+				t.labels[kc.Key] = fmt.Sprint(kc.Key)
+			}
+			e.hot.Store(t)
+		}
+		e.hotMu.Unlock()
+	}
+	return t.labels
+}
@@ -0,0 +1,54 @@
+package ehc
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+	"time"
+)
+
+func TestEHC_Do(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithProfileLabels(1, time.Second))
+	e.CountMultiple("hot", 10)
+	e.CountMultiple("cold", 5)
+
+	label := func(key string) string {
+		var got string
+		ran := false
+		e.Do(context.Background(), key, func(ctx context.Context) {
+			got, _ = pprof.Label(ctx, ProfileLabel)
+			ran = true
+		})
+		if !ran {
+			t.Fatalf("EHC.Do(%s) didn't call fn", key)
+		}
+		return got
+	}
+	if got := label("hot"); got != "hot" {
+		t.Errorf("EHC.Do(hot) labelled %q, want hot", got)
+	}
+	if got := label("cold"); got != "" {
+		t.Errorf("EHC.Do(cold) labelled %q, want nothing", got)
+	}
+
+	// the hottest keys are only found again after the refresh
+	e.CountMultiple("cold", 10)
+	if got := label("cold"); got != "" {
+		t.Errorf("EHC.Do(cold) labelled %q before the refresh, want nothing", got)
+	}
+	e.Tick(time.Second)
+	if got := label("cold"); got != "cold" {
+		t.Errorf("EHC.Do(cold) labelled %q after the refresh, want cold", got)
+	}
+	if n, _ := e.Get("cold"); n != 18 {
+		t.Errorf("EHC.Get(cold) = %d, want 18 counted by Do too", n)
+	}
+
+	// without the option nothing is labelled
+	plain := NewEHC(time.Minute)
+	plain.Do(context.Background(), "hot", func(ctx context.Context) {
+		if got, ok := pprof.Label(ctx, ProfileLabel); ok {
+			t.Errorf("EHC.Do() labelled %q without WithProfileLabels", got)
+		}
+	})
+}
@@ -0,0 +1,285 @@
+package ehc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CountCost charges cost units to key. It behaves exactly like CountMultiple,
+// and exists to read naturally where counts are the cost of operations
+// rather than their number, so that heavy endpoints can consume more of a
+// key's budget than cheap ones. The limits passed to Reserve are expressed in
+// the same units.
+func (e *EHC) CountCost(key interface{}, cost int64) {
+	e.CountMultiple(key, cost)
+}
+
+// Reservation is cost held against a key's limit by Reserve. It must be
+// either committed or cancelled.
+type Reservation struct {
+	e    *EHC
+	key  interface{}
+	cost int64
+
+	// unlimited is set for keys exempted from limits with BypassLimits.
+	unlimited bool
+	// counted is set if the cost was counted as it was reserved, as a
+	// LimitedStrategy does.
+	counted bool
+	// global is the global total the cost is also held against, if any.
+	global *globalTotal
+	// done is set once the reservation was committed or cancelled.
+	done int32
+}
+
+// Reserve holds cost units of key's budget if the key's count within the
+// window, plus the cost of its outstanding reservations, plus cost is at most
+// limit. Reservations are checked and taken atomically, so concurrent callers
+// can never overcommit a limit between them.
+//
+// On success the caller must eventually Commit the reservation, charging the
+// cost to the key as if counted at that moment, or Cancel it. Reserve fails
+// if the cost doesn't fit or the key is blocked; with WithBlockOnLimit, not
+// fitting also blocks the key. Keys exempted with BypassLimits always succeed.
+// ReservePriority shares a limit between priority classes.
+func (e *EHC) Reserve(key interface{}, cost, limit int64) (*Reservation, bool) {
+	return e.reserveClass(key, cost, limit, 0)
+}
+
+// Allow counts key if that keeps its count within the window at most limit,
+// and reports whether it did, like the Allow of a token bucket rate limiter.
+// The check and the count are atomic, so concurrent callers can never push
+// the key over the limit between them. It is AllowN with n of 1.
+func (e *EHC) Allow(key interface{}, limit int64) bool {
+	return e.AllowN(key, 1, limit)
+}
+
+// AllowN counts n increments of key if they fit under limit, as Reserve
+// decides, and reports whether it did. Blocked keys are refused, keys
+// exempted with BypassLimits are always allowed, and WithBlockOnLimit blocks
+// keys that don't fit, as with Reserve.
+func (e *EHC) AllowN(key interface{}, n, limit int64) bool {
+	r, ok := e.Reserve(key, n, limit)
+	if ok {
+		r.Commit()
+	}
+	return ok
+}
+
+// reserveClass is Reserve for a priority class; see ReservePriority.
+func (e *EHC) reserveClass(key interface{}, cost, limit int64, class int) (*Reservation, bool) {
+	e.valueLock.RLock()
+	unlimited := e.bypassed(key, BypassLimits)
+	key, ok := e.normalizeKey(key)
+	blockOnLimit := e.blockOnLimit
+	within := e.classLimit(limit, class)
+	e.valueLock.RUnlock()
+
+	if !ok {
+		atomic.AddInt64(&e.stats.dropped, 1)
+		return nil, false
+	}
+
+	r := &Reservation{e: e, key: key, cost: cost, unlimited: unlimited}
+	if unlimited {
+		return r, true
+	}
+	if e.blocks.blocked(key) {
+		return nil, false
+	}
+	r.global, _ = e.global.Load().(*globalTotal)
+	if r.global != nil && !r.global.reserve(cost) {
+		return nil, false
+	}
+	if ok, r.counted = e.reserve(key, cost, within); !ok {
+		if r.global != nil {
+			r.global.cancel(cost)
+		}
+		if blockOnLimit > 0 && within == limit {
+			e.blocks.block(key, blockOnLimit)
+		}
+		return nil, false
+	}
+	return r, true
+}
+
+// Commit charges the reserved cost to the key, starting its window now.
+// Calling Commit or Cancel again has no effect.
+func (r *Reservation) Commit() {
+	if !atomic.CompareAndSwapInt32(&r.done, 0, 1) {
+		return
+	}
+	if r.unlimited {
+		r.e.CountMultiple(r.key, r.cost)
+		return
+	}
+	if r.counted {
+		r.e.counted(r.key, r.cost)
+	} else {
+		r.e.commit(r.key, r.cost)
+	}
+	if r.global != nil {
+		// committing counted the cost into the total, so the hold
+		// is released only now, lest another reservation slip in
+		r.global.cancel(r.cost)
+	}
+}
+
+// Cancel releases the reserved cost without charging it. Calling Commit or
+// Cancel again has no effect.
+func (r *Reservation) Cancel() {
+	if !atomic.CompareAndSwapInt32(&r.done, 0, 1) || r.unlimited {
+		return
+	}
+	if r.counted {
+		r.e.uncount(r.key, r.cost)
+	} else {
+		r.e.cancel(r.key, r.cost)
+	}
+	if r.global != nil {
+		r.global.cancel(r.cost)
+	}
+}
+
+// reserve atomically holds cost against a normalized key's limit, reporting
+// whether it did, and whether the cost was counted already, as a
+// LimitedStrategy counts it.
+func (e *EHC) reserve(key interface{}, cost, limit int64) (ok, counted bool) {
+	for {
+		e.valueLock.RLock()
+		if e.closed {
+			e.valueLock.RUnlock()
+			return false, false
+		}
+		if e.expiry != nil {
+			ok, counted := e.expiryReserve(key, cost, limit)
+			e.valueLock.RUnlock()
+			return ok, counted
+		}
+
+		s := e.shardFor(key)
+		s.mu.RLock()
+		if c, _ := s.values.Get(key).(*counter); c != nil {
+			ok := c.reserve(cost, limit-e.overflowValue(key))
+			s.mu.RUnlock()
+			e.valueLock.RUnlock()
+			if !ok && c.empty() {
+				// don't leave behind a counter we created for nothing
+				e.remove(c)
+			}
+			return ok, false
+		}
+		s.mu.RUnlock()
+
+		if !e.validate(key) {
+			e.valueLock.RUnlock()
+			return false, false
+		}
+
+		// create the counter, then go around again to reserve on it
+		s.mu.Lock()
+		e.counterLocked(s, key)
+		s.mu.Unlock()
+		e.valueLock.RUnlock()
+	}
+}
+
+// commit turns cost reserved for a normalized key into a count.
+func (e *EHC) commit(key interface{}, cost int64) {
+	e.valueLock.RLock()
+	if e.closed {
+		// Close dropped the reservation along with the counter
+		e.valueLock.RUnlock()
+		return
+	}
+	if e.expiry != nil {
+		e.expiryCommit(key, cost)
+		e.valueLock.RUnlock()
+		e.counted(key, cost)
+		return
+	}
+
+	// the reservation keeps the counter in the map until now; should
+	// it be gone all the same, the cost is counted afresh
+	c := e.lookup(key)
+	if c == nil {
+		e.valueLock.RUnlock()
+		e.CountMultiple(key, cost)
+		return
+	}
+	now := e.clock.Now()
+	c.mu.Lock()
+	c.reserved -= cost
+	if cost != 0 {
+		c.addLocked(cost, now.Add(e.window), now)
+	}
+	c.mu.Unlock()
+	e.valueLock.RUnlock()
+
+	// nothing may be left to expire, if the cost was nothing or went to
+	// the overflow sketch of WithMaxMemory
+	if c.empty() {
+		e.remove(c)
+	}
+	e.counted(key, cost)
+}
+
+// cancel releases cost reserved for a normalized key.
+func (e *EHC) cancel(key interface{}, cost int64) {
+	e.valueLock.RLock()
+	if e.closed {
+		e.valueLock.RUnlock()
+		return
+	}
+	if e.expiry != nil {
+		e.expiryCancel(key, cost)
+		e.valueLock.RUnlock()
+		return
+	}
+
+	c := e.lookup(key)
+	if c == nil {
+		// the reservation went with the counter
+		e.valueLock.RUnlock()
+		return
+	}
+	c.mu.Lock()
+	c.reserved -= cost
+	c.mu.Unlock()
+	e.valueLock.RUnlock()
+
+	if c.empty() {
+		e.remove(c)
+	}
+}
+
+// uncount takes back cost counted for a normalized key as it was reserved.
+// Should the EHC have moved off the LimitedStrategy since, the cost is left
+// to expire.
+func (e *EHC) uncount(key interface{}, cost int64) {
+	e.valueLock.RLock()
+	defer e.valueLock.RUnlock()
+	if !e.closed && e.expiry != nil {
+		e.expiryUncount(key, cost)
+	}
+}
+
+// reserve holds cost if it fits under limit.
+func (c *counter) reserve(cost, limit int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Value()+c.reserved+cost > limit {
+		return false
+	}
+	c.reserved += cost
+	return true
+}
+
+// WithBlockOnLimit blocks a key for d whenever Reserve finds that it has
+// exceeded its limit, so enforcement persists beyond the measurement window.
+// Blocked keys are refused by Reserve until the block runs out.
+func WithBlockOnLimit(d time.Duration) Option {
+	return func(c *config) {
+		c.blockOnLimit = d
+	}
+}
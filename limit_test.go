@@ -0,0 +1,173 @@
+package ehc
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEHC_Reserve(t *testing.T) {
+	modes := map[string][]Option{
+		"timers":      nil,
+		"generations": {WithGenerations(4)},
+	}
+	for name, opts := range modes {
+		t.Run(name, func(t *testing.T) {
+			e := NewEHC(time.Minute, opts...)
+			e.CountCost("k", 5)
+
+			r, ok := e.Reserve("k", 4, 10)
+			if !ok {
+				t.Fatalf("EHC.Reserve() within the limit failed")
+			}
+			if _, ok := e.Reserve("k", 2, 10); ok {
+				t.Errorf("EHC.Reserve() succeeded despite the outstanding reservation")
+			}
+			r.Commit()
+			r.Commit()
+			if got := e.value("k"); got != 9 {
+				t.Errorf("value after Commit() = %d, want 9", got)
+			}
+
+			r, ok = e.Reserve("k", 1, 10)
+			if !ok {
+				t.Fatalf("EHC.Reserve() of the last unit failed")
+			}
+			r.Cancel()
+			if _, ok := e.Reserve("k", 1, 10); !ok {
+				t.Errorf("EHC.Reserve() failed after Cancel() released the budget")
+			}
+		})
+	}
+}
+
+func TestEHC_ReserveRemovesUnusedCounter(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	e.OnExpire(func(key interface{}) {
+		t.Errorf("OnExpire told of %v, which was never counted", key)
+	})
+	if _, ok := e.Reserve("k", 5, 1); ok {
+		t.Fatalf("EHC.Reserve() over the limit succeeded")
+	}
+	r, _ := e.Reserve("j", 1, 1)
+	r.Cancel()
+
+	values, locker := e.Values()
+	defer locker.Unlock()
+	if len(values) != 0 {
+		t.Errorf("EHC.Values() = %v, want no leftover counters", values)
+	}
+}
+
+func TestEHC_ReservationWithoutCounter(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+
+	// a counter gone from under its reservation is neither
+	// dereferenced nor missed
+	e.cancel("a", 1)
+	e.commit("b", 2)
+	if v := e.value("b"); v != 2 {
+		t.Errorf("value(b) = %d after committing without a counter, want 2", v)
+	}
+}
+
+func TestEHC_ReserveConcurrent(t *testing.T) {
+	e := NewEHC(time.Minute)
+	var granted int64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r, ok := e.Reserve("k", 1, 10); ok {
+				atomic.AddInt64(&granted, 1)
+				r.Commit()
+			}
+		}()
+	}
+	wg.Wait()
+	if granted != 10 {
+		t.Errorf("granted %d reservations, want exactly 10", granted)
+	}
+}
+
+func TestEHC_Allow(t *testing.T) {
+	e := NewEHC(time.Minute)
+	for i := 0; i < 3; i++ {
+		if !e.Allow("k", 3) {
+			t.Fatalf("EHC.Allow() #%d under the limit = false", i+1)
+		}
+	}
+	if e.Allow("k", 3) {
+		t.Error("EHC.Allow() at the limit = true")
+	}
+	if e.AllowN("j", 4, 3) || !e.AllowN("j", 3, 3) {
+		t.Error("EHC.AllowN() didn't allow exactly the cost that fits")
+	}
+	if v, j := e.value("k"), e.value("j"); v != 3 || j != 3 {
+		t.Errorf("counts of k and j = %d and %d, want 3 each", v, j)
+	}
+
+	var granted int64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if e.Allow("c", 10) {
+				atomic.AddInt64(&granted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if granted != 10 || e.value("c") != 10 {
+		t.Errorf("allowed %d concurrent calls, counting %d, want exactly 10", granted, e.value("c"))
+	}
+}
+
+func TestEHC_ReserveBlockOnLimit(t *testing.T) {
+	e := NewEHC(10*time.Millisecond, WithBlockOnLimit(time.Minute))
+	e.CountCost("k", 3)
+	if _, ok := e.Reserve("k", 1, 3); ok {
+		t.Fatalf("EHC.Reserve() over the limit succeeded")
+	}
+	if !e.IsBlocked("k") {
+		t.Fatalf("key not blocked after exceeding its limit")
+	}
+
+	// the block outlives the window
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := e.Reserve("k", 1, 3); ok {
+		t.Errorf("EHC.Reserve() succeeded for a blocked key")
+	}
+}
+
+func TestEHC_ReserveBypass(t *testing.T) {
+	e := NewEHC(time.Minute, WithBypass(func(key interface{}) bool {
+		return key == "internal"
+	}, BypassLimits))
+	for i := 0; i < 3; i++ {
+		r, ok := e.Reserve("internal", 1, 1)
+		if !ok {
+			t.Fatalf("EHC.Reserve() failed for a bypassed key")
+		}
+		r.Commit()
+	}
+	if got := e.value("internal"); got != 3 {
+		t.Errorf("bypassed key value = %d, want 3", got)
+	}
+}
+
+func TestEHC_ReserveAcrossMigration(t *testing.T) {
+	e := NewEHC(time.Minute)
+	r, _ := e.Reserve("k", 2, 2)
+	e.MigrateTo(WithGenerations(4))
+	if _, ok := e.Reserve("k", 1, 2); ok {
+		t.Errorf("reservation was lost in the migration")
+	}
+	r.Commit()
+	if got := e.value("k"); got != 2 {
+		t.Errorf("value after Commit() = %d, want 2", got)
+	}
+}
@@ -0,0 +1,207 @@
+package ehc
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// linOp is one operation in a recorded history.
+type linOp struct {
+	read      bool
+	key       int
+	value     int64
+	call, ret int64
+}
+
+// linRecorder records a concurrent history with a logical clock.
+type linRecorder struct {
+	clock int64
+	mu    sync.Mutex
+	ops   []linOp
+}
+
+func (r *linRecorder) run(read bool, key int, f func() int64) {
+	call := atomic.AddInt64(&r.clock, 1)
+	value := f()
+	ret := atomic.AddInt64(&r.clock, 1)
+	r.mu.Lock()
+	r.ops = append(r.ops, linOp{read: read, key: key, value: value, call: call, ret: ret})
+	r.mu.Unlock()
+}
+
+// linearizable reports whether ops, all on one key, can be ordered so that
+// the order respects real time and each read returns the number of counts
+// ordered before it. It is a Wing & Gong search with memoization of the
+// states already found to be dead ends.
+func linearizable(ops []linOp) bool {
+	done := make([]bool, len(ops))
+	dead := map[string]bool{}
+	state := func() string {
+		b := make([]byte, len(done))
+		for i, d := range done {
+			if d {
+				b[i] = 1
+			}
+		}
+		return string(b)
+	}
+
+	var search func(left int, count int64) bool
+	search = func(left int, count int64) bool {
+		if left == 0 {
+			return true
+		}
+		key := state()
+		if dead[key] {
+			return false
+		}
+		// an op may go next only if no pending op returned before it
+		// was called
+		minRet := int64(1<<63 - 1)
+		for i, op := range ops {
+			if !done[i] && op.ret < minRet {
+				minRet = op.ret
+			}
+		}
+		triedCount := false
+		for i, op := range ops {
+			if done[i] || op.call > minRet {
+				continue
+			}
+			next := count
+			if op.read {
+				if op.value != count {
+					continue
+				}
+			} else {
+				// counts are interchangeable, so trying one is enough
+				if triedCount {
+					continue
+				}
+				triedCount = true
+				next++
+			}
+			done[i] = true
+			if search(left-1, next) {
+				return true
+			}
+			done[i] = false
+		}
+		dead[key] = true
+		return false
+	}
+	return search(len(ops), 0)
+}
+
+func TestLinearizable_Checker(t *testing.T) {
+	ok := []linOp{
+		{read: false, call: 1, ret: 4},
+		{read: true, value: 1, call: 2, ret: 3},
+		{read: true, value: 1, call: 5, ret: 6},
+	}
+	if !linearizable(ok) {
+		t.Error("linearizable() rejected a valid history")
+	}
+
+	stale := []linOp{
+		{read: false, call: 1, ret: 2},
+		{read: true, value: 0, call: 3, ret: 4},
+	}
+	if linearizable(stale) {
+		t.Error("linearizable() accepted a stale read")
+	}
+
+	backwards := []linOp{
+		{read: false, call: 1, ret: 10},
+		{read: true, value: 1, call: 2, ret: 3},
+		{read: true, value: 0, call: 4, ret: 5},
+	}
+	if linearizable(backwards) {
+		t.Error("linearizable() accepted a read going backwards")
+	}
+}
+
+func TestEHC_Linearizable(t *testing.T) {
+	backends := []struct {
+		name    string
+		opts    []Option
+		migrate bool
+	}{
+		{"timers", nil, false},
+		{"resolution", []Option{WithResolution(time.Second)}, false},
+		{"shards", []Option{WithShards(4)}, false},
+		{"arena", []Option{WithArena(8), WithInterner(NewInterner())}, false},
+		{"generations", []Option{WithGenerations(4)}, false},
+		{"coarse", []Option{WithGenerations(4), WithCoarseClock(time.Millisecond)}, false},
+		{"compact", []Option{WithCompactBuckets(4)}, false},
+		{"migrate", nil, true},
+	}
+	const (
+		workers = 4
+		ops     = 40
+		keys    = 2
+	)
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			for round := 0; round < 20; round++ {
+				e := NewEHC(time.Hour, b.opts...)
+				rec := &linRecorder{}
+
+				var wg sync.WaitGroup
+				for w := 0; w < workers; w++ {
+					wg.Add(1)
+					go func(seed int64) {
+						defer wg.Done()
+						rng := rand.New(rand.NewSource(seed))
+						for i := 0; i < ops; i++ {
+							key := rng.Intn(keys)
+							if b.migrate && seed%workers == 0 && i%10 == 0 {
+								if i%20 == 0 {
+									e.MigrateTo(WithGenerations(4))
+								} else {
+									e.MigrateTo()
+								}
+							}
+							switch rng.Intn(3) {
+							case 0:
+								rec.run(false, key, func() int64 {
+									e.Count(key)
+									return 0
+								})
+							case 1:
+								rec.run(true, key, func() int64 {
+									return e.value(key)
+								})
+							default:
+								rec.run(true, key, func() int64 {
+									values, locker := e.Values()
+									defer locker.Unlock()
+									if c := values[key]; c != nil {
+										return c.Value()
+									}
+									return 0
+								})
+							}
+						}
+					}(int64(round*workers + w))
+				}
+				wg.Wait()
+
+				for key := 0; key < keys; key++ {
+					var history []linOp
+					for _, op := range rec.ops {
+						if op.key == key {
+							history = append(history, op)
+						}
+					}
+					if !linearizable(history) {
+						t.Fatalf("history of key %d isn't linearizable: %+v", key, history)
+					}
+				}
+			}
+		})
+	}
+}
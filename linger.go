@@ -0,0 +1,106 @@
+package ehc
+
+import "time"
+
+// WithLinger keeps keys in the map for d after their count decays to zero,
+// reporting 0, before they are removed. Increments still expire after the
+// window, so counts decay quickly, while the set of keys stays stable for
+// observers that want a continuous series per key. A key counted again while
+// lingering simply resumes.
+//
+// It only applies to the default timer mode.
+func WithLinger(d time.Duration) Option {
+	return func(c *config) {
+		c.linger = d
+	}
+}
+
+// zeroed is called when c's count decays to zero, and removes it from the
+// map either immediately or once it has lingered.
+func (e *EHC) zeroed(c *counter) {
+	e.valueLock.RLock()
+	linger := e.linger
+	e.valueLock.RUnlock()
+
+	if linger > 0 {
+		// remove re-checks that the counter is still empty by then
+		e.clock.AfterFunc(linger, func() {
+			e.remove(c)
+		})
+		return
+	}
+	e.remove(c)
+}
+
+// ExpireFunc is told that key was removed from the map, its count having
+// decayed to zero.
+type ExpireFunc func(key interface{})
+
+// OnExpire registers fn to be told whenever a key is removed from the map
+// because its count decayed to zero, after lingering if WithLinger is set,
+// e.g. to flush the key's final state or release what is associated with it.
+// fn is told once for every time a key enters the map and leaves it again;
+// keys dropped by Close or carried over by MigrateTo are not reported. fn
+// runs like the other callbacks; see WithCallbackWorkers and
+// WithPanicHandler.
+//
+// It only applies to the default timer mode.
+func (e *EHC) OnExpire(fn ExpireFunc) {
+	e.expireMu.Lock()
+	defer e.expireMu.Unlock()
+	fns, _ := e.expireFns.Load().([]ExpireFunc)
+	e.expireFns.Store(append(fns[:len(fns):len(fns)], fn))
+}
+
+// expired tells the functions registered with OnExpire that key was
+// removed.
+func (e *EHC) expired(key interface{}) {
+	fns, _ := e.expireFns.Load().([]ExpireFunc)
+	if len(fns) == 0 {
+		return
+	}
+	e.submitCallback(func() {
+		for _, fn := range fns {
+			protect("OnExpire", e.handlePanic, func() {
+				fn(key)
+			})
+		}
+	})
+}
+
+// WithTouchOnGet makes reading a key with Get restart the window of all its
+// increments, so that they expire a full window after the key was last read
+// rather than after they were counted, like entries of a cache that stay as
+// long as they are used. Keys that are counted but never read expire as
+// usual, and so do all keys once reads stop.
+//
+// It only applies to the default timer mode.
+func WithTouchOnGet() Option {
+	return func(c *config) {
+		c.touchOnGet = true
+	}
+}
+
+// touch reschedules all of c's increments to be retracted a window after
+// now, together.
+func (c *counter) touch(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 {
+		return
+	}
+
+	var total int64
+	for _, r := range c.pending {
+		r.done = true
+		c.parent.wheel.cancel(r)
+		total += r.count
+	}
+	r := &retraction{
+		deadline: roundUp(now.Add(c.parent.window), c.parent.wheel.tick),
+		count:    total,
+		counter:  c,
+	}
+	c.pending = []*retraction{r}
+	c.parent.wheel.schedule(r)
+}
@@ -0,0 +1,118 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_Linger(t *testing.T) {
+	e := NewEHC(10*time.Millisecond, WithLinger(30*time.Millisecond))
+	e.Count("k")
+
+	time.Sleep(20 * time.Millisecond)
+	values, locker := e.Values()
+	if c := values["k"]; c == nil || c.Value() != 0 {
+		t.Errorf("EHC.Values()[k] = %v, want a lingering zero", c)
+	}
+	locker.Unlock()
+
+	time.Sleep(30 * time.Millisecond)
+	values, locker = e.Values()
+	if c := values["k"]; c != nil {
+		t.Errorf("EHC.Values()[k] = %d, want removed after lingering", c.Value())
+	}
+	locker.Unlock()
+}
+
+func TestEHC_LingerResumes(t *testing.T) {
+	e := NewEHC(40*time.Millisecond, WithLinger(40*time.Millisecond))
+	e.Count("k")
+	time.Sleep(60 * time.Millisecond)
+	e.Count("k")
+
+	// the first linger ends while the key is counted again
+	time.Sleep(25 * time.Millisecond)
+	values, locker := e.Values()
+	if c := values["k"]; c == nil || c.Value() != 1 {
+		t.Errorf("EHC.Values()[k] = %v, want 1", c)
+	}
+	locker.Unlock()
+}
+
+func TestEHC_OnExpire(t *testing.T) {
+	e := NewManualEHC(time.Second, time.Unix(0, 0), WithLinger(time.Second))
+	var expired []interface{}
+	e.OnExpire(func(key interface{}) {
+		expired = append(expired, key)
+	})
+
+	e.CountMultiple("a", 2)
+	e.Tick(500 * time.Millisecond)
+	e.Count("a")
+	e.Count("b")
+	e.Tick(time.Second)
+	if len(expired) != 0 {
+		t.Fatalf("expired = %v while counted or lingering, want none", expired)
+	}
+
+	// a lingers from when its last count decays, a second later
+	e.Tick(time.Second)
+	if len(expired) != 2 || expired[0] != "a" || expired[1] != "b" {
+		t.Fatalf("expired = %v, want [a b]", expired)
+	}
+
+	// a key counted again is told of again once it is removed again
+	e.Count("a")
+	e.Tick(3 * time.Second)
+	if len(expired) != 3 || expired[2] != "a" {
+		t.Errorf("expired = %v, want a again", expired)
+	}
+}
+
+func TestEHC_TouchOnGet(t *testing.T) {
+	e := NewManualEHC(time.Second, time.Unix(0, 0), WithTouchOnGet())
+	e.CountMultiple("read", 2)
+	e.CountMultiple("unread", 2)
+	e.Tick(500 * time.Millisecond)
+	e.Count("read")
+
+	for i := 0; i < 3; i++ {
+		if n, ok := e.Get("read"); n != 3 || !ok {
+			t.Fatalf("EHC.Get(read) = %d, %v while being read, want 3, true", n, ok)
+		}
+		e.Tick(800 * time.Millisecond)
+	}
+	if v := e.value("unread"); v != 0 {
+		t.Errorf("count of unread = %d, want expired", v)
+	}
+
+	e.Tick(200 * time.Millisecond)
+	if n, ok := e.Get("read"); n != 0 || ok {
+		t.Errorf("EHC.Get(read) = %d, %v a window after the last read, want 0, false", n, ok)
+	}
+}
+
+func TestEHC_TouchOnGetScheduled(t *testing.T) {
+	e := NewManualEHC(time.Second, time.Unix(0, 0), WithTouchOnGet())
+	for i := 0; i < 5; i++ {
+		e.Count("k")
+		e.Tick(10 * time.Millisecond)
+	}
+	before := e.wheel.scheduled()
+
+	// superseded retractions leave the wheel rather than pile up
+	for i := 0; i < 1000; i++ {
+		e.Get("k")
+		e.Tick(time.Millisecond)
+	}
+	if n := e.wheel.scheduled(); n > before {
+		t.Errorf("%d retractions scheduled after repeated reads, want at most %d", n, before)
+	}
+	if v := e.value("k"); v != 5 {
+		t.Errorf("count of k = %d, want 5", v)
+	}
+	e.Tick(time.Second)
+	if v, n := e.value("k"), e.wheel.scheduled(); v != 0 || n != 0 {
+		t.Errorf("a window after the last read, count = %d and %d scheduled, want 0 and 0", v, n)
+	}
+}
@@ -0,0 +1,72 @@
+package ehc
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// LogLimiter suppresses floods of similar log messages. Each key may be
+// logged a limited number of times per window; once messages for a key start
+// being suppressed, a summary of how many were suppressed is emitted when
+// the window rolls over.
+type LogLimiter struct {
+	counts  *EHC
+	limit   int64
+	summary func(key interface{}, suppressed int64)
+
+	mu         sync.Mutex
+	suppressed map[interface{}]int64
+}
+
+// NewLogLimiter returns a LogLimiter allowing limit messages per key in any
+// window. summary is called with the number of messages suppressed for a key
+// at the end of each window in which some were; if nil, a line is written
+// with log.Printf. opts configure the EHC the messages are counted in, e.g.
+// WithClock, which the summaries are also scheduled with.
+func NewLogLimiter(window time.Duration, limit int64, summary func(key interface{}, suppressed int64), opts ...Option) *LogLimiter {
+	if summary == nil {
+		summary = func(key interface{}, suppressed int64) {
+			log.Printf("suppressed %d similar messages for %v", suppressed, key)
+		}
+	}
+	return &LogLimiter{
+		counts:     NewEHC(window, opts...),
+		limit:      limit,
+		summary:    summary,
+		suppressed: map[interface{}]int64{},
+	}
+}
+
+// ShouldLog records a message for key and reports whether it should be
+// logged. Only the messages logged count against the limit, so that a
+// steady flood still gets limit messages through every window.
+func (l *LogLimiter) ShouldLog(key interface{}) bool {
+	if l.counts.Allow(key, l.limit) {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.suppressed[key] == 0 {
+		// first suppression this window; report at the end of it
+		l.counts.clock.AfterFunc(l.counts.window, func() {
+			l.flush(key)
+		})
+	}
+	l.suppressed[key]++
+	return false
+}
+
+// flush emits the summary for key.
+func (l *LogLimiter) flush(key interface{}) {
+	l.mu.Lock()
+	n := l.suppressed[key]
+	delete(l.suppressed, key)
+	l.mu.Unlock()
+
+	if n > 0 {
+		l.summary(key, n)
+	}
+}
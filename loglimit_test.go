@@ -0,0 +1,63 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogLimiter(t *testing.T) {
+	clk := &manualClock{now: time.Unix(0, 0)}
+	summaries := map[interface{}]int64{}
+	l := NewLogLimiter(20*time.Millisecond, 2, func(key interface{}, suppressed int64) {
+		summaries[key] += suppressed
+	}, WithClock(clk))
+
+	logged := 0
+	for i := 0; i < 5; i++ {
+		if l.ShouldLog("disk full") {
+			logged++
+		}
+	}
+	if !l.ShouldLog("other") {
+		t.Errorf("LogLimiter.ShouldLog(other) = false, want keys limited independently")
+	}
+	if logged != 2 {
+		t.Errorf("logged %d messages, want 2", logged)
+	}
+
+	clk.advance(30 * time.Millisecond)
+	if summaries["disk full"] != 3 {
+		t.Errorf("summary for disk full = %d, want 3", summaries["disk full"])
+	}
+	if _, ok := summaries["other"]; ok {
+		t.Errorf("got a summary for a key that was never suppressed")
+	}
+
+	if !l.ShouldLog("disk full") {
+		t.Errorf("LogLimiter.ShouldLog() = false after the window rolled, want true")
+	}
+}
+
+func TestLogLimiter_SteadyFlood(t *testing.T) {
+	clk := &manualClock{now: time.Unix(0, 0)}
+	var suppressed int64
+	l := NewLogLimiter(time.Second, 2, func(key interface{}, n int64) {
+		suppressed += n
+	}, WithClock(clk))
+
+	// a message every 10ms for 10 windows
+	logged := 0
+	for i := 0; i < 1000; i++ {
+		if l.ShouldLog("flood") {
+			logged++
+		}
+		clk.advance(10 * time.Millisecond)
+	}
+	clk.advance(time.Second)
+	if logged < 10*2-2 || logged > 10*2+2 {
+		t.Errorf("logged %d of a steady flood over 10 windows, want about 2 per window", logged)
+	}
+	if suppressed != int64(1000-logged) {
+		t.Errorf("summaries told of %d suppressed, want %d", suppressed, 1000-logged)
+	}
+}
@@ -0,0 +1,95 @@
+package ehc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// compactMinKeys is the smallest peak map size worth compacting.
+const compactMinKeys = 1024
+
+// budget caps the wall time spent on maintenance work in each one-second
+// period to a fraction of that second.
+type budget struct {
+	mu       sync.Mutex
+	allowed  time.Duration
+	periodAt time.Time
+	spent    time.Duration
+}
+
+func newBudget(fraction float64) *budget {
+	return &budget{
+		allowed: time.Duration(fraction * float64(time.Second)),
+	}
+}
+
+// run runs task if there is budget left in the current period, reporting
+// whether it ran. A task may overrun the budget once; the time is charged
+// afterwards and the rest of the period's work is deferred.
+func (b *budget) run(task func()) bool {
+	now := time.Now()
+
+	b.mu.Lock()
+	if now.Sub(b.periodAt) >= time.Second {
+		b.periodAt = now
+		b.spent = 0
+	}
+	if b.spent >= b.allowed {
+		b.mu.Unlock()
+		return false
+	}
+	b.mu.Unlock()
+
+	task()
+
+	b.mu.Lock()
+	b.spent += time.Since(now)
+	b.mu.Unlock()
+	return true
+}
+
+// maintain runs a housekeeping task within the maintenance budget, if any,
+// reporting whether it ran. Deferred tasks are expected to be retried by
+// their trigger later.
+func (e *EHC) maintain(task func()) bool {
+	if e.budget == nil {
+		task()
+		return true
+	}
+	if !e.budget.run(task) {
+		atomic.AddInt64(&e.stats.maintenanceDeferred, 1)
+		return false
+	}
+	return true
+}
+
+// maybeCompactLocked rebuilds the store of a shard once it has shrunk well
+// below its peak size, since Go maps never give back the memory of deleted
+// entries. s.mu must be held exclusively.
+func (e *EHC) maybeCompactLocked(s *shard) {
+	n := s.values.Len()
+	if s.peakKeys < compactMinKeys || n > s.peakKeys/4 {
+		return
+	}
+	e.maintain(func() {
+		values := e.config.store()
+		s.values.Range(func(k interface{}, c Counter) bool {
+			values.Put(k, c)
+			return true
+		})
+		s.values = values
+		s.peakKeys = n
+		atomic.AddInt64(&e.stats.compactions, 1)
+	})
+}
+
+// WithMaintenanceBudget caps the time the EHC spends on housekeeping, such as
+// compacting its map after a spike of keys has expired, to fraction of each
+// second. Work beyond the budget is deferred until budget is available again,
+// so counter housekeeping can't starve latency-sensitive request handling.
+func WithMaintenanceBudget(fraction float64) Option {
+	return func(c *config) {
+		c.maintenanceBudget = fraction
+	}
+}
@@ -0,0 +1,54 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_Compaction(t *testing.T) {
+	// compaction is per shard, so keep them all in one, and on a manual
+	// clock none of the keys can expire before they are all counted
+	e := NewManualEHC(10*time.Millisecond, time.Unix(0, 0), WithShards(1))
+	for i := 0; i < 2*compactMinKeys; i++ {
+		e.Count(i)
+	}
+	e.Tick(20 * time.Millisecond)
+
+	if compactions := e.Stats().Compactions; compactions == 0 {
+		t.Errorf("EHC.Stats().Compactions = 0, want the map rebuilt after expiry")
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b := newBudget(0.01)
+
+	ran := b.run(func() {
+		time.Sleep(15 * time.Millisecond)
+	})
+	if !ran {
+		t.Fatalf("budget.run() refused the first task")
+	}
+	if b.run(func() {}) {
+		t.Errorf("budget.run() ran a task after the budget was exhausted")
+	}
+
+	b.mu.Lock()
+	b.periodAt = b.periodAt.Add(-time.Second)
+	b.mu.Unlock()
+	if !b.run(func() {}) {
+		t.Errorf("budget.run() refused a task in a new period")
+	}
+}
+
+func TestEHC_MaintenanceBudgetDefers(t *testing.T) {
+	e := NewEHC(10*time.Millisecond, WithShards(1), WithMaintenanceBudget(1e-9))
+	e.budget.run(func() {
+		time.Sleep(time.Millisecond)
+	})
+	if e.maintain(func() {}) {
+		t.Errorf("EHC.maintain() ran a task over budget")
+	}
+	if deferred := e.Stats().MaintenanceDeferred; deferred != 1 {
+		t.Errorf("EHC.Stats().MaintenanceDeferred = %d, want 1", deferred)
+	}
+}
@@ -0,0 +1,195 @@
+package ehc
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// WithMaxMemory puts a ceiling of about maxBytes on the memory the EHC uses
+// for its keys and their pending expirations, whatever the traffic. The EHC
+// keeps an estimate of its usage, and once that reaches the ceiling, new
+// keys, and increments of existing keys that would need an expiration of
+// their own, are counted in a fixed-size overflow sketch instead, until
+// enough counts expire. The sketch takes an eighth of maxBytes, and is
+// allocated up front.
+//
+// The sketch is a count-min sketch: the counts it holds can only be
+// overestimated, by colliding keys, and they expire between one and one and
+// a quarter windows after they were made. Value, Get and the limits of
+// Reserve and its relatives see the exact count plus the sketch's estimate,
+// while Values, Snapshot and TopN only see the exact counts. Reservations
+// still hold their keys in the map until committed or cancelled, and
+// MigrateTo carries over the exact counts only.
+//
+// It only applies to the default timer mode.
+func WithMaxMemory(maxBytes int64) Option {
+	return func(c *config) {
+		c.maxMemory = maxBytes
+	}
+}
+
+const (
+	// counterBytes is the estimated cost of a key in the map, besides
+	// the key itself.
+	counterBytes = int64(unsafe.Sizeof(counter{})) + 64
+	// retractionBytes is the estimated cost of a pending expiration,
+	// including the pointers to it from its counter and the wheel.
+	retractionBytes = int64(unsafe.Sizeof(retraction{})) + 16
+
+	// overflowDepth is the number of rows of the overflow sketch.
+	overflowDepth = 4
+	// overflowGenerations is the number of generations the overflow
+	// sketch keeps, each a quarter of the window long, so that counts
+	// outlive the window by at most a quarter of it.
+	overflowGenerations = 5
+	// overflowMinWidth is the smallest number of counters per row.
+	overflowMinWidth = 16
+)
+
+// memoryCeiling tracks the estimated memory used by an EHC against the
+// ceiling of WithMaxMemory.
+type memoryCeiling struct {
+	max int64
+	// keys is the estimated cost of the keys in the map and of the
+	// sketch, updated atomically; the expirations are counted by the
+	// wheel.
+	keys     int64
+	wheel    *wheel
+	overflow *overflowSketch
+}
+
+func newMemoryCeiling(max int64, w *wheel, window time.Duration, now time.Time, seed maphash.Seed) *memoryCeiling {
+	width := int(max / 8 / (overflowDepth * overflowGenerations * 8))
+	if width < overflowMinWidth {
+		width = overflowMinWidth
+	}
+	return &memoryCeiling{
+		max:      max,
+		keys:     int64(width) * overflowDepth * overflowGenerations * 8,
+		wheel:    w,
+		overflow: newOverflowSketch(width, window, now, seed),
+	}
+}
+
+// used returns the estimated memory in use.
+func (m *memoryCeiling) used() int64 {
+	return atomic.LoadInt64(&m.keys) + m.wheel.scheduled()*retractionBytes
+}
+
+// full reports whether the ceiling has been reached.
+func (m *memoryCeiling) full() bool {
+	return m.used() >= m.max
+}
+
+// addKey accounts for a key entering the map, or leaving it if n is
+// negative.
+func (m *memoryCeiling) addKey(key interface{}, n int64) {
+	atomic.AddInt64(&m.keys, n*keyBytes(key))
+}
+
+// keyBytes returns the estimated cost of key in the map, besides its
+// expirations.
+func keyBytes(key interface{}) int64 {
+	n := counterBytes
+	if s, ok := key.(string); ok {
+		n += int64(len(s))
+	}
+	return n
+}
+
+// overflowValue returns the count of a normalized key held in the overflow
+// sketch, if there is one. valueLock must be held.
+func (e *EHC) overflowValue(key interface{}) int64 {
+	if e.memory == nil {
+		return 0
+	}
+	return e.memory.overflow.estimate(key, e.clock.Now())
+}
+
+// overflowSketch is a count-min sketch split into generations so that its
+// counts expire.
+type overflowSketch struct {
+	seed   maphash.Seed
+	width  uint64
+	base   time.Time
+	length time.Duration
+
+	mu     sync.Mutex
+	epochs [overflowGenerations]int64
+	rows   [overflowGenerations][overflowDepth][]int64
+}
+
+func newOverflowSketch(width int, window time.Duration, now time.Time, seed maphash.Seed) *overflowSketch {
+	s := &overflowSketch{
+		seed:   seed,
+		width:  uint64(width),
+		base:   now,
+		length: window / (overflowGenerations - 1),
+	}
+	if s.length <= 0 {
+		s.length = 1
+	}
+	for g := range s.rows {
+		s.epochs[g] = -1
+		for d := range s.rows[g] {
+			s.rows[g][d] = make([]int64, width)
+		}
+	}
+	return s
+}
+
+func (s *overflowSketch) epoch(now time.Time) int64 {
+	return int64(now.Sub(s.base) / s.length)
+}
+
+// index returns the counter of key in row d.
+func (s *overflowSketch) index(h uint64, d int) uint64 {
+	lo, hi := h&0xffffffff, h>>32|1
+	return (lo + uint64(d)*hi) % s.width
+}
+
+// add counts n increments of key made at now.
+func (s *overflowSketch) add(key interface{}, n int64, now time.Time) {
+	h := maphash.Comparable(s.seed, key)
+	cur := max(s.epoch(now), 0)
+	g := cur % overflowGenerations
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.epochs[g] != cur {
+		s.epochs[g] = cur
+		for d := range s.rows[g] {
+			clear(s.rows[g][d])
+		}
+	}
+	for d := range s.rows[g] {
+		s.rows[g][d][s.index(h, d)] += n
+	}
+}
+
+// estimate returns the count of key in the generations still live at now,
+// which is never less than the true count.
+func (s *overflowSketch) estimate(key interface{}, now time.Time) int64 {
+	h := maphash.Comparable(s.seed, key)
+	cur := s.epoch(now)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var min int64 = -1
+	for d := 0; d < overflowDepth; d++ {
+		i := s.index(h, d)
+		var sum int64
+		for g, epoch := range s.epochs {
+			if epoch >= 0 && epoch <= cur && epoch > cur-overflowGenerations {
+				sum += s.rows[g][d][i]
+			}
+		}
+		if min < 0 || sum < min {
+			min = sum
+		}
+	}
+	return min
+}
@@ -0,0 +1,54 @@
+package ehc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEHC_MaxMemory(t *testing.T) {
+	const window = time.Second
+	const max = 64 << 10
+	e := NewManualEHC(window, time.Unix(0, 0), WithMaxMemory(max))
+
+	for i := 0; i < 2000; i++ {
+		e.Count(fmt.Sprint("key", i))
+		e.Tick(time.Millisecond / 4)
+	}
+	if used := e.memory.used(); used > max+counterBytes+retractionBytes+16 {
+		t.Errorf("estimated memory = %d, want at most about %d", used, max)
+	}
+	exact := e.keys()
+	if exact == 0 || exact == 2000 {
+		t.Errorf("%d keys kept exactly, want some but not all of 2000", exact)
+	}
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprint("key", i)
+		if v := e.value(key); v < 1 {
+			t.Fatalf("EHC count of %s = %d, want at least 1", key, v)
+		}
+	}
+
+	// the limits see the overflowed counts too
+	e.CountMultiple("key1999", 4)
+	if v := e.value("key1999"); v < 5 {
+		t.Errorf("EHC count of key1999 = %d, want at least 5", v)
+	}
+	if e.Allow("key1999", 5) {
+		t.Error("EHC.Allow() over the limit of an overflowed key = true")
+	}
+
+	e.Tick(window + window/4)
+	for _, key := range []string{"key0", "key1999"} {
+		if v := e.value(key); v != 0 {
+			t.Errorf("EHC count of %s = %d after expiring, want 0", key, v)
+		}
+	}
+	if n := e.keys(); n != 0 {
+		t.Errorf("%d keys left after expiring, want 0", n)
+	}
+	e.Count("again")
+	if e.lookup("again") == nil {
+		t.Error("a new key wasn't kept exactly once memory was freed")
+	}
+}
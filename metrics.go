@@ -0,0 +1,250 @@
+package ehc
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// MetricKind is the kind of value a metric has, like runtime/metrics.ValueKind.
+type MetricKind int
+
+const (
+	// MetricKindBad marks a sample of a metric that doesn't exist.
+	MetricKindBad MetricKind = iota
+	// MetricKindUint64 is a metric whose value is a uint64.
+	MetricKindUint64
+	// MetricKindFloat64 is a metric whose value is a float64.
+	MetricKindFloat64
+)
+
+// MetricDescription describes one of the metrics an EHC keeps about itself,
+// like runtime/metrics.Description.
+type MetricDescription struct {
+	// Name is the metric's name, which is stable: a path starting with
+	// /ehc/, then a colon and the unit, e.g. "/ehc/keys:keys".
+	Name string
+	// Description is an English description of the metric.
+	Description string
+	// Kind is the kind of the metric's values.
+	Kind MetricKind
+	// Cumulative is whether the metric only ever grows, so that its rate
+	// is what is usually of interest.
+	Cumulative bool
+}
+
+// MetricValue is the value of a metric, like runtime/metrics.Value.
+type MetricValue struct {
+	kind   MetricKind
+	scalar uint64
+}
+
+// Kind returns the kind of the value, which is MetricKindBad if the metric
+// doesn't exist.
+func (v MetricValue) Kind() MetricKind {
+	return v.kind
+}
+
+// Uint64 returns the value of a MetricKindUint64 metric. It panics for any
+// other kind.
+func (v MetricValue) Uint64() uint64 {
+	if v.kind != MetricKindUint64 {
+		panic("ehc: called Uint64 on a metric value of another kind")
+	}
+	return v.scalar
+}
+
+// Float64 returns the value of a MetricKindFloat64 metric. It panics for any
+// other kind.
+func (v MetricValue) Float64() float64 {
+	if v.kind != MetricKindFloat64 {
+		panic("ehc: called Float64 on a metric value of another kind")
+	}
+	return math.Float64frombits(v.scalar)
+}
+
+// MetricSample is a metric's name and, once read, its value, like
+// runtime/metrics.Sample.
+type MetricSample struct {
+	Name  string
+	Value MetricValue
+}
+
+// metric is a supported metric and how to read it.
+type metric struct {
+	MetricDescription
+	read func(e *EHC) MetricValue
+}
+
+func uint64Metric(v int64) MetricValue {
+	return MetricValue{kind: MetricKindUint64, scalar: uint64(v)}
+}
+
+func float64Metric(v float64) MetricValue {
+	return MetricValue{kind: MetricKindFloat64, scalar: math.Float64bits(v)}
+}
+
+// statMetric reads one of the EHC's stats counters.
+func statMetric(field func(s *stats) *int64) func(e *EHC) MetricValue {
+	return func(e *EHC) MetricValue {
+		return uint64Metric(atomic.LoadInt64(field(&e.stats)))
+	}
+}
+
+var metrics = []metric{{
+	MetricDescription: MetricDescription{
+		Name:        "/ehc/keys:keys",
+		Description: "Keys with a count in the window, including any lingering at zero.",
+		Kind:        MetricKindUint64,
+	},
+	read: func(e *EHC) MetricValue { return uint64Metric(int64(e.liveKeys())) },
+}, {
+	MetricDescription: MetricDescription{
+		Name:        "/ehc/expirations/pending:retractions",
+		Description: "Retractions of increments scheduled on the timing wheel. Always 0 with an ExpiryStrategy.",
+		Kind:        MetricKindUint64,
+	},
+	read: func(e *EHC) MetricValue {
+		e.valueLock.RLock()
+		w := e.wheel
+		e.valueLock.RUnlock()
+		if w == nil {
+			return uint64Metric(0)
+		}
+		return uint64Metric(w.scheduled())
+	},
+}, {
+	MetricDescription: MetricDescription{
+		Name:        "/ehc/memory/estimated:bytes",
+		Description: "Estimated memory used by the keys, their expirations and the overflow sketch, as held to WithMaxMemory. Always 0 without it.",
+		Kind:        MetricKindUint64,
+	},
+	read: func(e *EHC) MetricValue {
+		e.valueLock.RLock()
+		m := e.memory
+		e.valueLock.RUnlock()
+		if m == nil {
+			return uint64Metric(0)
+		}
+		return uint64Metric(m.used())
+	},
+}, {
+	MetricDescription: MetricDescription{
+		Name:        "/ehc/sweep/count:sweeps",
+		Description: "Firings of the timing wheel that retracted due increments.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	read: statMetric(func(s *stats) *int64 { return &s.sweeps }),
+}, {
+	MetricDescription: MetricDescription{
+		Name:        "/ehc/sweep/time:seconds",
+		Description: "Wall time spent retracting due increments when the timing wheel fired.",
+		Kind:        MetricKindFloat64,
+		Cumulative:  true,
+	},
+	read: func(e *EHC) MetricValue {
+		return float64Metric(time.Duration(atomic.LoadInt64(&e.stats.sweepNanos)).Seconds())
+	},
+}, {
+	MetricDescription: MetricDescription{
+		Name:        "/ehc/drops/rejected:calls",
+		Description: "Count calls discarded because their key was rejected. Stats.Dropped.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	read: statMetric(func(s *stats) *int64 { return &s.dropped }),
+}, {
+	MetricDescription: MetricDescription{
+		Name:        "/ehc/drops/callbacks:deliveries",
+		Description: "Callback deliveries dropped because the queue of WithCallbackWorkers was full. Stats.CallbacksDropped.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	read: statMetric(func(s *stats) *int64 { return &s.callbacksDropped }),
+}, {
+	MetricDescription: MetricDescription{
+		Name:        "/ehc/drops/totals:totals",
+		Description: "Window totals discarded unacknowledged by OnWindowCompleteAck sinks. Stats.TotalsDiscarded.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	read: statMetric(func(s *stats) *int64 { return &s.totalsDiscarded }),
+}, {
+	MetricDescription: MetricDescription{
+		Name:        "/ehc/keys/truncated:calls",
+		Description: "Count calls whose key was shortened to the maximum key size. Stats.Truncated.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	read: statMetric(func(s *stats) *int64 { return &s.truncated }),
+}, {
+	MetricDescription: MetricDescription{
+		Name:        "/ehc/bypassed:events",
+		Description: "Events for keys exempted by WithBypass. Stats.Bypassed.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	read: statMetric(func(s *stats) *int64 { return &s.bypassed }),
+}, {
+	MetricDescription: MetricDescription{
+		Name:        "/ehc/compactions:compactions",
+		Description: "Rebuilds of the map to release memory after a spike of keys expired. Stats.Compactions.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	read: statMetric(func(s *stats) *int64 { return &s.compactions }),
+}, {
+	MetricDescription: MetricDescription{
+		Name:        "/ehc/callbacks/panics:panics",
+		Description: "Panics recovered from callbacks. Stats.CallbackPanics.",
+		Kind:        MetricKindUint64,
+		Cumulative:  true,
+	},
+	read: statMetric(func(s *stats) *int64 { return &s.callbackPanics }),
+}}
+
+var metricsByName = func() map[string]*metric {
+	m := make(map[string]*metric, len(metrics))
+	for i := range metrics {
+		m[metrics[i].Name] = &metrics[i]
+	}
+	return m
+}()
+
+// AllMetrics describes every metric ReadMetrics supports, like
+// runtime/metrics.All, so that monitoring agents can discover and export
+// them without knowing them in advance. Names are never reused for another
+// meaning, though metrics may be added.
+func AllMetrics() []MetricDescription {
+	all := make([]MetricDescription, len(metrics))
+	for i, m := range metrics {
+		all[i] = m.MetricDescription
+	}
+	return all
+}
+
+// ReadMetrics fills in the value of each sample with the current value of
+// the named metric, like runtime/metrics.Read. Samples of metrics that
+// don't exist are given a value of kind MetricKindBad.
+func (e *EHC) ReadMetrics(samples []MetricSample) {
+	for i := range samples {
+		if m := metricsByName[samples[i].Name]; m != nil {
+			samples[i].Value = m.read(e)
+		} else {
+			samples[i].Value = MetricValue{}
+		}
+	}
+}
+
+// liveKeys returns the number of keys in the map, or with a count, with an
+// ExpiryStrategy.
+func (e *EHC) liveKeys() int {
+	e.valueLock.RLock()
+	if e.expiry != nil {
+		defer e.valueLock.RUnlock()
+		return len(e.expiry.Snapshot(e.now()))
+	}
+	e.valueLock.RUnlock()
+	return e.keys()
+}
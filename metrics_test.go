@@ -0,0 +1,69 @@
+package ehc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllMetrics(t *testing.T) {
+	seen := map[string]bool{}
+	for _, d := range AllMetrics() {
+		if !strings.HasPrefix(d.Name, "/ehc/") || !strings.Contains(d.Name, ":") {
+			t.Errorf("metric name %q isn't a /ehc/ path with a unit", d.Name)
+		}
+		if seen[d.Name] {
+			t.Errorf("metric %q described twice", d.Name)
+		}
+		seen[d.Name] = true
+		if d.Kind == MetricKindBad || d.Description == "" {
+			t.Errorf("metric %q has kind %v and description %q", d.Name, d.Kind, d.Description)
+		}
+	}
+}
+
+func TestEHC_ReadMetrics(t *testing.T) {
+	e := NewManualEHC(time.Second, time.Unix(0, 0), WithKeyValidator(func(key interface{}) error {
+		if key == "bad" {
+			return errors.New("bad key")
+		}
+		return nil
+	}))
+	e.Count("a")
+	e.Count("a")
+	e.Count("b")
+	e.Count("bad")
+
+	samples := []MetricSample{
+		{Name: "/ehc/keys:keys"},
+		{Name: "/ehc/expirations/pending:retractions"},
+		{Name: "/ehc/drops/rejected:calls"},
+		{Name: "/ehc/sweep/count:sweeps"},
+		{Name: "/ehc/no/such:metric"},
+	}
+	e.ReadMetrics(samples)
+	// the two counts of a, made in the same tick, share a retraction
+	for i, want := range []uint64{2, 2, 1, 0} {
+		if v := samples[i].Value.Uint64(); v != want {
+			t.Errorf("%s = %d, want %d", samples[i].Name, v, want)
+		}
+	}
+	if k := samples[4].Value.Kind(); k != MetricKindBad {
+		t.Errorf("%s has kind %v, want MetricKindBad", samples[4].Name, k)
+	}
+
+	e.Tick(2 * time.Second)
+	e.ReadMetrics(samples)
+	for i, want := range []uint64{0, 0, 1, 1} {
+		if v := samples[i].Value.Uint64(); v != want {
+			t.Errorf("%s = %d after expiring, want %d", samples[i].Name, v, want)
+		}
+	}
+
+	sweep := []MetricSample{{Name: "/ehc/sweep/time:seconds"}}
+	e.ReadMetrics(sweep)
+	if s := sweep[0].Value.Float64(); s < 0 {
+		t.Errorf("%s = %v, want at least 0", sweep[0].Name, s)
+	}
+}
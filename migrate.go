@@ -0,0 +1,192 @@
+package ehc
+
+import (
+	"runtime"
+	"time"
+)
+
+// contribution is a live increment together with the time it expires.
+type contribution struct {
+	key      interface{}
+	count    int64
+	deadline time.Time
+}
+
+// MigrateTo atomically rebuilds the EHC as though it had been created by
+// NewEHC with the same window and opts, carrying over every live count along
+// with the time remaining before it expires. This lets operators switch
+// backends or presets under changing load without losing the current window.
+//
+// The new options replace the previous ones entirely, except that the EHC
+// keeps its clock; see WithClock. Count and Values block
+// for the duration of the migration. Expiry times can only be kept as
+// precisely as the destination mode allows; moving into generation mode, for
+// example, rounds each one to a generation boundary.
+func (e *EHC) MigrateTo(opts ...Option) {
+	e.migrateTo(opts)
+	e.audit("", "MigrateTo", nil, "")
+}
+
+func (e *EHC) migrateTo(opts []Option) {
+	opts = append(opts[:len(opts):len(opts)], WithClock(e.clock))
+	fresh := newEHC(e.window, e.clock, opts...)
+	// e takes over the fresh clock, so fresh must not stop it
+	runtime.SetFinalizer(fresh, nil)
+	now := e.clock.Now()
+
+	e.valueLock.Lock()
+	defer e.valueLock.Unlock()
+
+	if e.closed {
+		fresh.Close()
+		return
+	}
+	live, reserved := e.drainLocked(now)
+	e.config = fresh.config
+	e.prof.Store(e.profiler)
+	e.storeCallSites()
+	e.storeCallbackPool()
+	e.storeGlobalTotal()
+	e.arena = fresh.arena
+	e.memory = fresh.memory
+	e.expiry = fresh.expiry
+	e.wheel = fresh.wheel
+	if e.wheel != nil {
+		e.wheel.stats = &e.stats
+		if e.keyHistory != nil {
+			e.wheel.onSweep = e.sampleKeys
+		}
+	}
+	e.adapt = fresh.adapt
+	e.budget = fresh.budget
+
+	e.stopCoarseClock()
+	runtime.SetFinalizer(e, nil)
+	e.coarse = fresh.coarse
+	if e.coarse != nil {
+		runtime.SetFinalizer(e, (*EHC).stopCoarseClock)
+	}
+	e.shards = fresh.shards
+	e.restoreLocked(live, now)
+	e.restoreReservationsLocked(reserved)
+}
+
+// contributor is implemented by the built-in strategies, which know when
+// their counts expire.
+type contributor interface {
+	// contributions returns the counts still live at now.
+	contributions(now time.Time) []contribution
+}
+
+// drainLocked cancels all pending expirations, empties the EHC, and returns
+// the increments that are still live at now along with the cost of any
+// outstanding reservations. valueLock must be held exclusively.
+func (e *EHC) drainLocked(now time.Time) ([]contribution, map[interface{}]int64) {
+	reserved := map[interface{}]int64{}
+	if e.expiry != nil {
+		e.held.mu.Lock()
+		for k, v := range e.held.cost {
+			reserved[k] = v
+		}
+		e.held.cost = nil
+		e.held.mu.Unlock()
+
+		return e.strategyContributions(now), reserved
+	}
+
+	var counters []*counter
+	e.rangeCounters(func(_ interface{}, c *counter) bool {
+		counters = append(counters, c)
+		return true
+	})
+
+	var live []contribution
+	for _, c := range counters {
+		key := c.key
+		c.mu.Lock()
+		for _, r := range c.pending {
+			r.done = true
+			if r.deadline.After(now) {
+				live = append(live, contribution{key: key, count: r.count, deadline: r.deadline})
+			}
+		}
+		c.pending = nil
+		// the live counts are added back as they are restored
+		c.addCount(-c.Value())
+		if c.reserved != 0 {
+			reserved[key] = c.reserved
+		}
+		c.retired = true
+		c.mu.Unlock()
+		e.deleteLocked(e.shardFor(key), c)
+	}
+	return live, reserved
+}
+
+// strategyContributions returns the counts of the ExpiryStrategy still live
+// at now. valueLock must be held.
+func (e *EHC) strategyContributions(now time.Time) []contribution {
+	if s, ok := e.expiry.(contributor); ok {
+		return s.contributions(now)
+	}
+	// a custom strategy can't tell when its counts expire, so they are
+	// carried over as if they had just been counted
+	var live []contribution
+	for k, n := range e.expiry.Snapshot(now) {
+		live = append(live, contribution{key: k, count: n, deadline: now.Add(e.window)})
+	}
+	return live
+}
+
+// restoreLocked schedules live increments into the EHC, skipping any that
+// have already expired. valueLock must be held exclusively.
+func (e *EHC) restoreLocked(live []contribution, now time.Time) {
+	for _, c := range live {
+		if !c.deadline.After(now) {
+			continue
+		}
+		if g, ok := e.expiry.(*generations); ok {
+			g.restore(c.key, c.count, c.deadline, now)
+			continue
+		}
+		if e.expiry != nil {
+			// backdate the increment so that it expires on time, to
+			// just before the deadline's window so that it lands in
+			// the bucket, say, that it came from
+			at := c.deadline.Add(-e.window - 1)
+			if at.After(now) {
+				at = now
+			}
+			e.expiry.Add(c.key, c.count, at)
+			continue
+		}
+		s := e.shardFor(c.key)
+		ctr := e.counterLocked(s, c.key)
+		ctr.add(c.count, c.deadline, now)
+		if ctr.retireIfEmpty() {
+			// it went to the overflow sketch of WithMaxMemory
+			e.deleteLocked(s, ctr)
+		}
+	}
+}
+
+// restoreReservationsLocked carries outstanding reservations over, so that
+// they can still be committed or cancelled. valueLock must be held
+// exclusively.
+func (e *EHC) restoreReservationsLocked(reserved map[interface{}]int64) {
+	for key, cost := range reserved {
+		if e.expiry != nil {
+			e.held.mu.Lock()
+			if e.held.cost == nil {
+				e.held.cost = map[interface{}]int64{}
+			}
+			e.held.cost[key] += cost
+			e.held.mu.Unlock()
+			continue
+		}
+		c := e.counterLocked(e.shardFor(key), key)
+		c.mu.Lock()
+		c.reserved += cost
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,54 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_MigrateTo(t *testing.T) {
+	tests := []struct {
+		name string
+		from []Option
+		to   []Option
+	}{
+		{
+			name: "timers to generations",
+			to:   []Option{WithGenerations(10)},
+		},
+		{
+			name: "generations to timers",
+			from: []Option{WithGenerations(10)},
+		},
+		{
+			name: "timers to batched timers",
+			to:   []Option{WithResolution(2 * time.Millisecond)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEHC(40*time.Millisecond, tt.from...)
+			e.CountMultiple("old", 2)
+			time.Sleep(20 * time.Millisecond)
+			e.Count("new")
+
+			e.MigrateTo(tt.to...)
+
+			values, locker := e.Values()
+			if len(values) != 2 || values["old"].Value() != 2 || values["new"].Value() != 1 {
+				t.Errorf("EHC.Values() after migration = %v, want old=2 new=1", values)
+			}
+			locker.Unlock()
+
+			// the old count keeps its original deadline
+			time.Sleep(30 * time.Millisecond)
+			values, locker = e.Values()
+			if values["old"] != nil {
+				t.Errorf("EHC.Values()[old] = %d, want expired", values["old"].Value())
+			}
+			if values["new"] == nil {
+				t.Errorf("EHC.Values()[new] expired early")
+			}
+			locker.Unlock()
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package ehc
+
+import (
+	"sort"
+	"time"
+)
+
+// MultiEHC counts keys over several windows at once, e.g. the last minute,
+// five minutes and hour, so that each Count updates them all. Every window is
+// kept by an EHC of its own, created with the same options, which can be
+// reached with Window for anything not covered here.
+type MultiEHC struct {
+	windows []time.Duration
+	ehcs    []*EHC
+}
+
+// NewEHCWindows returns a MultiEHC counting over each of windows, which are
+// sorted and deduplicated, with every EHC created as by NewEHC with opts. A
+// clock given with WithClock is shared by all of them.
+func NewEHCWindows(windows []time.Duration, opts ...Option) *MultiEHC {
+	ws := append([]time.Duration(nil), windows...)
+	sort.Slice(ws, func(i, j int) bool { return ws[i] < ws[j] })
+
+	m := &MultiEHC{}
+	for i, w := range ws {
+		if i > 0 && w == ws[i-1] {
+			continue
+		}
+		m.windows = append(m.windows, w)
+		m.ehcs = append(m.ehcs, NewEHC(w, opts...))
+	}
+	return m
+}
+
+// Windows returns the windows counted over, shortest first.
+func (m *MultiEHC) Windows() []time.Duration {
+	return append([]time.Duration(nil), m.windows...)
+}
+
+// Window returns the EHC counting over window, or nil if window isn't one of
+// the MultiEHC's.
+func (m *MultiEHC) Window(window time.Duration) *EHC {
+	for i, w := range m.windows {
+		if w == window {
+			return m.ehcs[i]
+		}
+	}
+	return nil
+}
+
+// Count increments the count of key in every window by 1.
+func (m *MultiEHC) Count(key interface{}) {
+	m.CountMultiple(key, 1)
+}
+
+// CountMultiple increments the count of key in every window by count.
+func (m *MultiEHC) CountMultiple(key interface{}, count int64) {
+	for _, e := range m.ehcs {
+		e.CountMultiple(key, count)
+	}
+}
+
+// Get returns the count of key over window, like EHC.Get, and false if
+// window isn't one of the MultiEHC's.
+func (m *MultiEHC) Get(key interface{}, window time.Duration) (int64, bool) {
+	if e := m.Window(window); e != nil {
+		return e.Get(key)
+	}
+	return 0, false
+}
+
+// Values returns a copy of the count of every key over window, like
+// EHC.Snapshot, or nil if window isn't one of the MultiEHC's.
+func (m *MultiEHC) Values(window time.Duration) map[interface{}]int64 {
+	if e := m.Window(window); e != nil {
+		return e.Snapshot()
+	}
+	return nil
+}
+
+// Close closes the EHC of every window.
+func (m *MultiEHC) Close() error {
+	for _, e := range m.ehcs {
+		e.Close()
+	}
+	return nil
+}
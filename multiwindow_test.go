@@ -0,0 +1,37 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMultiEHC(t *testing.T) {
+	clk := &manualClock{now: time.Unix(0, 0)}
+	m := NewEHCWindows([]time.Duration{time.Hour, time.Minute, 5 * time.Minute, time.Minute}, WithClock(clk))
+	defer m.Close()
+
+	if ws := m.Windows(); len(ws) != 3 || ws[0] != time.Minute || ws[2] != time.Hour {
+		t.Fatalf("MultiEHC.Windows() = %v, want [1m 5m 1h]", ws)
+	}
+
+	m.CountMultiple("k", 2)
+	clk.advance(3 * time.Minute)
+	m.Count("k")
+	clk.advance(3 * time.Minute)
+	m.Count("k")
+
+	for _, want := range []struct {
+		window time.Duration
+		count  int64
+	}{{time.Minute, 1}, {5 * time.Minute, 2}, {time.Hour, 4}} {
+		if n, _ := m.Get("k", want.window); n != want.count {
+			t.Errorf("MultiEHC.Get(k, %v) = %d, want %d", want.window, n, want.count)
+		}
+		if v := m.Values(want.window); v["k"] != want.count {
+			t.Errorf("MultiEHC.Values(%v) = %v, want k: %d", want.window, v, want.count)
+		}
+	}
+	if _, ok := m.Get("k", time.Second); ok || m.Values(time.Second) != nil || m.Window(time.Second) != nil {
+		t.Error("MultiEHC reads a window it doesn't have")
+	}
+}
@@ -0,0 +1,60 @@
+package ehc
+
+import (
+	"sync"
+	"time"
+)
+
+// Notifier coalesces events per key and invokes a callback at most once per
+// window for each key with the number of events aggregated, e.g. to turn a
+// storm of errors into a single "errors for service X: 3,214 in last 5m"
+// alert.
+type Notifier struct {
+	window time.Duration
+	notify func(key interface{}, count int64)
+
+	mu      sync.Mutex
+	pending map[interface{}]int64
+}
+
+// NewNotifier returns a Notifier that calls notify for each key that saw
+// events, one window after the first of them.
+func NewNotifier(window time.Duration, notify func(key interface{}, count int64)) *Notifier {
+	return &Notifier{
+		window:  window,
+		notify:  notify,
+		pending: map[interface{}]int64{},
+	}
+}
+
+// Notify records an event for key.
+func (n *Notifier) Notify(key interface{}) {
+	n.NotifyMultiple(key, 1)
+}
+
+// NotifyMultiple records count events for key.
+func (n *Notifier) NotifyMultiple(key interface{}, count int64) {
+	if count == 0 {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.pending[key]; !ok {
+		time.AfterFunc(n.window, func() {
+			n.flush(key)
+		})
+	}
+	n.pending[key] += count
+}
+
+// flush delivers the events aggregated for key.
+func (n *Notifier) flush(key interface{}) {
+	n.mu.Lock()
+	count := n.pending[key]
+	delete(n.pending, key)
+	n.mu.Unlock()
+
+	n.notify(key, count)
+}
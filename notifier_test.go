@@ -0,0 +1,38 @@
+package ehc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotifier(t *testing.T) {
+	var mu sync.Mutex
+	var calls []int64
+	n := NewNotifier(20*time.Millisecond, func(key interface{}, count int64) {
+		mu.Lock()
+		calls = append(calls, count)
+		mu.Unlock()
+	})
+
+	for i := 0; i < 10; i++ {
+		n.Notify("svc")
+	}
+	n.NotifyMultiple("svc", 5)
+
+	mu.Lock()
+	if len(calls) != 0 {
+		t.Errorf("notified %d times before the window elapsed, want 0", len(calls))
+	}
+	mu.Unlock()
+
+	time.Sleep(30 * time.Millisecond)
+	n.Notify("svc")
+	time.Sleep(30 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 2 || calls[0] != 15 || calls[1] != 1 {
+		t.Errorf("notifications = %v, want [15 1]", calls)
+	}
+}
@@ -0,0 +1,186 @@
+package ehc
+
+import "time"
+
+// Option configures optional behavior of an EHC. Options are passed to NewEHC.
+type Option func(*config)
+
+// config holds the optional settings of an EHC.
+type config struct {
+	// validateKey, if set, is consulted before a new key is inserted.
+	validateKey func(key interface{}) error
+
+	// maxKeySize is the byte limit for string keys, or 0 for no limit.
+	maxKeySize    int
+	keySizePolicy KeySizePolicy
+
+	// interner, if set, canonicalizes string keys on insertion.
+	interner *Interner
+
+	// arenaChunkSize enables arena storage when positive.
+	arenaChunkSize int
+
+	// generations selects coarse generation-based expiry when positive.
+	generations int
+
+	// buckets selects bucketed sliding-window expiry when positive, in
+	// compact storage if compactBuckets is set.
+	buckets        int
+	compactBuckets bool
+
+	// decay selects exponentially decaying counts, with a half-life of
+	// decayHalfLife, or derived from the window if that is 0.
+	decay         bool
+	decayHalfLife time.Duration
+
+	// expiryStrategy, if set, creates the strategy that replaces the
+	// per-key counters.
+	expiryStrategy func(window time.Duration) ExpiryStrategy
+
+	// resolution, when positive, batches timer-mode expirations into
+	// slots of this width.
+	resolution time.Duration
+
+	// wheelTick is the resolution of the timing wheel, or 0 for the
+	// default.
+	wheelTick time.Duration
+
+	// adaptive, if set, lets the EHC tune resolution itself.
+	adaptive *AdaptiveConfig
+
+	// maintenanceBudget caps housekeeping to this fraction of each
+	// second when positive.
+	maintenanceBudget float64
+
+	// coarseTick, when positive, enables a cached clock with this
+	// refresh interval.
+	coarseTick time.Duration
+
+	// globalLimit, when positive, caps the total reserved across keys.
+	globalLimit int64
+
+	// priorityShares are the fractions of limits held back for each
+	// priority class; see WithPriorityShares.
+	priorityShares []float64
+
+	// profileLabels, when positive, is the number of hot keys Do
+	// labels, found again every profileLabelsRefresh.
+	profileLabels        int
+	profileLabelsRefresh time.Duration
+
+	// auditEntries, when positive, keeps a log of that many
+	// administrative operations.
+	auditEntries int
+
+	// keyHistoryLen, when positive, keeps the number of keys for that
+	// many intervals of keyHistoryInterval.
+	keyHistoryLen      int
+	keyHistoryInterval time.Duration
+
+	// touchOnGet makes Get restart the window of a key's increments.
+	touchOnGet bool
+
+	// maxMemory, when positive, caps the estimated memory of the keys.
+	maxMemory int64
+
+	// customClock, if set, replaces the system clock.
+	customClock Clock
+
+	// profiler, if set, records Count timings.
+	profiler *Profiler
+
+	// errorClasses are tried in order by CountError.
+	errorClasses []ErrorClass
+
+	// backoffBase and backoffMax shape the delays of Backoff, or are 0
+	// for the defaults.
+	backoffBase time.Duration
+	backoffMax  time.Duration
+
+	// bypass, if set, exempts keys from counting or limits.
+	bypass     func(key interface{}) bool
+	bypassMode BypassMode
+
+	// blockOnLimit, when positive, blocks keys that exceed a limit for
+	// this long.
+	blockOnLimit time.Duration
+
+	// linger keeps zeroed keys in the map this long.
+	linger time.Duration
+
+	// subCardinalityCap is how many sub-values per key CountPair tracks
+	// exactly.
+	subCardinalityCap int
+
+	// callSites enables recording the callers of Count.
+	callSites bool
+
+	// shards is the number of shards of the map of counters, or 0 for
+	// the default.
+	shards int
+
+	// newStore, if set, creates the stores for the counters.
+	newStore func() Store
+
+	// deadLetter, if set, receives window totals that sinks couldn't
+	// keep buffered.
+	deadLetter *DeadLetter
+
+	// panicHandler, if set, is passed the panics recovered from
+	// callbacks.
+	panicHandler func(err error)
+
+	// callbackWorkers, when positive, runs callbacks on this many
+	// goroutines fed by a queue of callbackQueue deliveries.
+	callbackWorkers int
+	callbackQueue   int
+
+	// preset fills in any settings not given explicitly.
+	preset Preset
+}
+
+// WithKeyValidator installs a function that is called whenever a key that is
+// not currently in the map would be inserted. If it returns a non-nil error,
+// the increment is discarded and counted in Stats().Dropped instead. This
+// protects the map from malformed or oversized keys.
+func WithKeyValidator(validate func(key interface{}) error) Option {
+	return func(c *config) {
+		c.validateKey = validate
+	}
+}
+
+// KeySizePolicy decides what happens to keys that exceed the limit set by
+// WithMaxKeySize.
+type KeySizePolicy int
+
+const (
+	// RejectOversized discards increments for oversized keys, counting them
+	// in Stats().Dropped.
+	RejectOversized KeySizePolicy = iota
+	// TruncateOversized cuts oversized keys down to the limit, counting them
+	// in Stats().Truncated. Truncation never splits a UTF-8 sequence.
+	TruncateOversized
+)
+
+// WithMaxKeySize limits string and []byte keys to maxBytes bytes, applying
+// policy to anything longer. The check happens before any locking, so a
+// hostile client sending huge values can't balloon memory through the keys.
+//
+// Since []byte is not a valid map key, []byte keys are converted to strings
+// when this option is set.
+func WithMaxKeySize(maxBytes int, policy KeySizePolicy) Option {
+	return func(c *config) {
+		c.maxKeySize = maxBytes
+		c.keySizePolicy = policy
+	}
+}
+
+// WithResolution batches expirations in the default timer mode: deadlines are
+// rounded up to the next multiple of d, and increments of a key that land in
+// the same slot share one retraction. Counts then linger up to d past the
+// window in exchange for far less expiry bookkeeping on busy keys.
+func WithResolution(d time.Duration) Option {
+	return func(c *config) {
+		c.resolution = d
+	}
+}
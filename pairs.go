@@ -0,0 +1,310 @@
+package ehc
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// defaultSubCardinalityCap is the number of sub-values per key tracked
+// exactly when WithSubCardinalityCap isn't used.
+const defaultSubCardinalityCap = 1024
+
+// CountPair counts key, like Count, and records sub as one of the values
+// seen alongside it, e.g. the endpoint hit by an IP address. SubCardinality
+// then reports how many distinct sub-values were seen for the key within the
+// window.
+//
+// Up to the cap set by WithSubCardinalityCap, sub-values are tracked exactly,
+// each expiring one window after it was last seen. Beyond the cap, new
+// sub-values are only recorded in a fixed-size probabilistic sketch, which
+// bounds memory per key at the cost of a few percent of error.
+func (e *EHC) CountPair(key, sub interface{}) {
+	e.Count(key)
+
+	e.valueLock.RLock()
+	if e.closed || e.bypass != nil && e.bypassMode == BypassCounting && e.bypass(key) {
+		e.valueLock.RUnlock()
+		return
+	}
+	key, ok := e.normalizeKey(key)
+	e.valueLock.RUnlock()
+	if !ok {
+		return
+	}
+	if p := e.pairTracker(); p != nil {
+		p.count(key, sub)
+	}
+}
+
+// SubCardinality returns how many distinct sub-values were recorded with
+// CountPair for key within the window.
+func (e *EHC) SubCardinality(key interface{}) int64 {
+	e.valueLock.RLock()
+	key, ok := e.normalizeKey(key)
+	closed := e.closed
+	e.valueLock.RUnlock()
+	if !ok || closed {
+		return 0
+	}
+	if p := e.pairTracker(); p != nil {
+		return p.cardinality(key)
+	}
+	return 0
+}
+
+// WithSubCardinalityCap sets how many distinct sub-values per key CountPair
+// tracks exactly before switching to an approximation. It defaults to 1024.
+func WithSubCardinalityCap(n int) Option {
+	return func(c *config) {
+		c.subCardinalityCap = n
+	}
+}
+
+// pairTracker returns the pair tracker, creating it if needed, or nil if
+// the EHC was closed without one.
+func (e *EHC) pairTracker() *pairTracker {
+	e.pairsOnce.Do(func() {
+		e.valueLock.RLock()
+		limit := e.subCardinalityCap
+		e.valueLock.RUnlock()
+		if limit <= 0 {
+			limit = defaultSubCardinalityCap
+		}
+		e.pairs = newPairTracker(e.window, int64(limit), e.clock)
+	})
+	return e.pairs
+}
+
+// pairKey is what the pair tracker counts each sub-value under.
+type pairKey struct {
+	key interface{}
+	sub interface{}
+}
+
+// pairTracker counts distinct sub-values per key.
+type pairTracker struct {
+	// pairs holds one expiring counter per (key, sub) pair. Its insert
+	// and delete hooks keep the exact cardinalities up to date.
+	pairs  *EHC
+	clock  Clock
+	limit  int64
+	window time.Duration
+	seed   maphash.Seed
+
+	mu   sync.Mutex
+	keys map[interface{}]*subState
+}
+
+// subState is the per-key state of a pairTracker.
+type subState struct {
+	// exact is the number of live pairs for the key.
+	exact int64
+	// sketch approximates the sub-values seen once exact hit the cap.
+	sketch *subSketch
+}
+
+func newPairTracker(window time.Duration, limit int64, clk Clock) *pairTracker {
+	p := &pairTracker{
+		pairs:  newEHC(window, clk),
+		clock:  clk,
+		limit:  limit,
+		window: window,
+		seed:   maphash.MakeSeed(),
+		keys:   map[interface{}]*subState{},
+	}
+	p.pairs.onInsert = func(k interface{}) {
+		p.mu.Lock()
+		p.stateLocked(k.(pairKey).key).exact++
+		p.mu.Unlock()
+	}
+	p.pairs.onDelete = func(k interface{}) {
+		key := k.(pairKey).key
+		p.mu.Lock()
+		if st := p.keys[key]; st != nil {
+			st.exact--
+			p.pruneLocked(key, st, p.clock.Now())
+		}
+		p.mu.Unlock()
+	}
+	return p
+}
+
+func (p *pairTracker) stateLocked(key interface{}) *subState {
+	st := p.keys[key]
+	if st == nil {
+		st = &subState{}
+		p.keys[key] = st
+	}
+	return st
+}
+
+// pruneLocked forgets key once nothing about it is live.
+func (p *pairTracker) pruneLocked(key interface{}, st *subState, now time.Time) {
+	if st.sketch != nil && !st.sketch.live(now) {
+		st.sketch = nil
+	}
+	if st.exact <= 0 && st.sketch == nil {
+		delete(p.keys, key)
+	}
+}
+
+func (p *pairTracker) count(key, sub interface{}) {
+	pk := pairKey{key: key, sub: sub}
+	now := p.clock.Now()
+
+	p.mu.Lock()
+	st := p.stateLocked(key)
+	p.pruneLocked(key, st, now)
+	exact := st.exact
+	sketch := st.sketch
+	p.mu.Unlock()
+
+	// the hooks may take p.mu, so the pairs EHC is only ever
+	// called without holding it
+	if exact < p.limit || p.pairs.value(pk) > 0 {
+		p.pairs.Count(pk)
+		if sketch == nil {
+			return
+		}
+	} else if sketch == nil {
+		p.mu.Lock()
+		st = p.stateLocked(key)
+		if st.sketch == nil {
+			st.sketch = newSubSketch(p.window, now)
+		}
+		sketch = st.sketch
+		p.mu.Unlock()
+	}
+	sketch.add(maphash.Comparable(p.seed, sub), now)
+}
+
+func (p *pairTracker) cardinality(key interface{}) int64 {
+	now := p.clock.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := p.keys[key]
+	if st == nil {
+		return 0
+	}
+	p.pruneLocked(key, st, now)
+	n := st.exact
+	if st.sketch != nil {
+		if est := st.sketch.estimate(now); est > n {
+			n = est
+		}
+	}
+	return n
+}
+
+const (
+	// sketchPrecision is the number of hash bits choosing a register,
+	// giving a standard error of about 1.04/sqrt(1<<10), or 3%.
+	sketchPrecision   = 10
+	sketchRegisters   = 1 << sketchPrecision
+	sketchGenerations = 4
+)
+
+// subSketch is a HyperLogLog sketch split into generations so that it
+// expires: values are added to the current generation, estimates merge the
+// generations still inside the window, and values are forgotten between 3/4
+// of the window and the full window after they were last added.
+type subSketch struct {
+	mu     sync.Mutex
+	base   time.Time
+	length time.Duration
+	epochs [sketchGenerations]int64
+	regs   [sketchGenerations][sketchRegisters]uint8
+}
+
+func newSubSketch(window time.Duration, now time.Time) *subSketch {
+	s := &subSketch{
+		base:   now,
+		length: window / sketchGenerations,
+	}
+	if s.length <= 0 {
+		s.length = 1
+	}
+	for i := range s.epochs {
+		s.epochs[i] = -1
+	}
+	return s
+}
+
+func (s *subSketch) epoch(now time.Time) int64 {
+	return int64(now.Sub(s.base) / s.length)
+}
+
+func (s *subSketch) isLive(epoch, cur int64) bool {
+	return epoch >= 0 && epoch <= cur && epoch > cur-sketchGenerations
+}
+
+func (s *subSketch) add(h uint64, now time.Time) {
+	cur := s.epoch(now)
+	slot := cur % sketchGenerations
+	idx := h >> (64 - sketchPrecision)
+	rank := uint8(bits.LeadingZeros64(h<<sketchPrecision|1<<(sketchPrecision-1)) + 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.epochs[slot] != cur {
+		s.epochs[slot] = cur
+		s.regs[slot] = [sketchRegisters]uint8{}
+	}
+	if rank > s.regs[slot][idx] {
+		s.regs[slot][idx] = rank
+	}
+}
+
+// live reports whether any generation is still inside the window.
+func (s *subSketch) live(now time.Time) bool {
+	cur := s.epoch(now)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, epoch := range s.epochs {
+		if s.isLive(epoch, cur) {
+			return true
+		}
+	}
+	return false
+}
+
+// estimate returns the approximate number of distinct values in the live
+// generations.
+func (s *subSketch) estimate(now time.Time) int64 {
+	cur := s.epoch(now)
+
+	var merged [sketchRegisters]uint8
+	s.mu.Lock()
+	for g, epoch := range s.epochs {
+		if !s.isLive(epoch, cur) {
+			continue
+		}
+		for i, r := range s.regs[g] {
+			if r > merged[i] {
+				merged[i] = r
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	const m = float64(sketchRegisters)
+	sum := 0.0
+	zeros := 0
+	for _, r := range merged {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		// small range correction
+		est = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(est))
+}
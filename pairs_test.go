@@ -0,0 +1,60 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_CountPair(t *testing.T) {
+	e := NewEHC(20 * time.Millisecond)
+	e.CountPair("ip", "/a")
+	e.CountPair("ip", "/b")
+	e.CountPair("ip", "/a")
+	e.CountPair("other", "/a")
+
+	if n := e.SubCardinality("ip"); n != 2 {
+		t.Errorf("EHC.SubCardinality(ip) = %d, want 2", n)
+	}
+	if n := e.SubCardinality("other"); n != 1 {
+		t.Errorf("EHC.SubCardinality(other) = %d, want 1", n)
+	}
+	if v := e.value("ip"); v != 3 {
+		t.Errorf("EHC count of ip = %d, want 3", v)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if n := e.SubCardinality("ip"); n != 0 {
+		t.Errorf("EHC.SubCardinality(ip) = %d after the window, want 0", n)
+	}
+}
+
+func TestEHC_SubCardinalityCap(t *testing.T) {
+	e := NewEHC(time.Minute, WithSubCardinalityCap(100))
+	const distinct = 5000
+	for i := 0; i < distinct; i++ {
+		e.CountPair("ip", i)
+	}
+
+	n := e.SubCardinality("ip")
+	if n < distinct*9/10 || n > distinct*11/10 {
+		t.Errorf("EHC.SubCardinality(ip) = %d, want about %d", n, distinct)
+	}
+	if pairs := e.pairs.pairs.keys(); pairs != 100 {
+		t.Errorf("exactly tracked pairs = %d, want the cap of 100", pairs)
+	}
+}
+
+func TestEHC_SubCardinalitySketchExpires(t *testing.T) {
+	e := NewEHC(20*time.Millisecond, WithSubCardinalityCap(1))
+	for i := 0; i < 50; i++ {
+		e.CountPair("ip", i)
+	}
+	if n := e.SubCardinality("ip"); n < 40 {
+		t.Errorf("EHC.SubCardinality(ip) = %d, want about 50", n)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if n := e.SubCardinality("ip"); n != 0 {
+		t.Errorf("EHC.SubCardinality(ip) = %d after the window, want 0", n)
+	}
+}
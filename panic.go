@@ -0,0 +1,80 @@
+package ehc
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// CallbackPanic is the error reported when a callback registered with the
+// EHC panics. The panic is recovered so that it doesn't take down the
+// timers that deliver window totals and expire counts along with it.
+type CallbackPanic struct {
+	// Callback names what was called, e.g. "OnWindowComplete".
+	Callback string
+
+	// Value is the value the callback panicked with.
+	Value interface{}
+
+	// Stack is the stack trace of the panic.
+	Stack []byte
+}
+
+func (p *CallbackPanic) Error() string {
+	return fmt.Sprintf("ehc: %s callback panicked: %v", p.Callback, p.Value)
+}
+
+// Unwrap returns Value if the callback panicked with an error.
+func (p *CallbackPanic) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
+
+// WithPanicHandler installs a function that is passed a *CallbackPanic
+// whenever a callback panics. Without one, the panic is written with
+// log.Printf. Either way it is counted in Stats.CallbackPanics, and the
+// callback is treated as having failed: a WindowSinkFunc's batch is kept for
+// redelivery, and the other callbacks still run.
+func WithPanicHandler(handle func(err error)) Option {
+	return func(c *config) {
+		c.panicHandler = handle
+	}
+}
+
+// handlePanic counts a recovered callback panic and reports it.
+func (e *EHC) handlePanic(err error) {
+	atomic.AddInt64(&e.stats.callbackPanics, 1)
+
+	e.valueLock.RLock()
+	handle := e.panicHandler
+	e.valueLock.RUnlock()
+
+	if handle == nil {
+		handle = logPanic
+	}
+	handle(err)
+}
+
+// logPanic is the default panic handler.
+func logPanic(err error) {
+	p := err.(*CallbackPanic)
+	log.Printf("%v\n%s", p, p.Stack)
+}
+
+// protect runs fn, which calls the named user callback, recovering a panic
+// in it and reporting it to handle, or logging it if handle is nil. It
+// reports whether fn returned normally.
+func protect(callback string, handle func(error), fn func()) (ok bool) {
+	defer func() {
+		if ok {
+			return
+		}
+		if handle == nil {
+			handle = logPanic
+		}
+		handle(&CallbackPanic{Callback: callback, Value: recover(), Stack: debug.Stack()})
+	}()
+	fn()
+	return true
+}
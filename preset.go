@@ -0,0 +1,76 @@
+package ehc
+
+import (
+	"runtime"
+	"time"
+)
+
+// Preset is a bundle of settings suited to a common use, so that a reasonable
+// setup doesn't require understanding the internals.
+type Preset int
+
+const (
+	// Precise retracts every increment one window after it was made, to
+	// the resolution of the timing wheel (see WithWheelResolution), with
+	// the default of one shard per GOMAXPROCS. This is the default.
+	Precise Preset = iota
+
+	// Balanced batches timer expirations at 1/64 of the window, so counts
+	// may linger up to that long past the window while busy keys need far
+	// fewer pending retractions, and uses four shards per GOMAXPROCS.
+	Balanced
+
+	// HighThroughput uses generation-based expiry with 16 generations:
+	// counting is cheap and expiry is O(1), with increments retracted
+	// between 15/16 of the window and the full window. Should the timer
+	// mode be kept by other options, it uses four shards per GOMAXPROCS.
+	HighThroughput
+
+	// LowMemory uses generation-based expiry with only 4 generations,
+	// keeping no per-increment state at all, with increments retracted
+	// between 3/4 of the window and the full window. Should the timer
+	// mode be kept by other options, it uses a single shard.
+	LowMemory
+)
+
+// WithPreset applies the settings of p. Options given explicitly take
+// precedence over those chosen by the preset, whatever their order: a preset
+// only chooses the expiry mode if no option did, and only the number of
+// shards if neither WithShards nor WithStore was given.
+func WithPreset(p Preset) Option {
+	return func(c *config) {
+		c.preset = p
+	}
+}
+
+// applyPreset fills in the settings the preset chooses that were not set
+// explicitly.
+func (c *config) applyPreset(window time.Duration) {
+	shards := 0
+	switch c.preset {
+	case Balanced:
+		if !c.expiryChosen() && c.resolution == 0 {
+			c.resolution = window / 64
+		}
+		shards = 4 * runtime.GOMAXPROCS(0)
+	case HighThroughput:
+		if !c.expiryChosen() {
+			c.generations = 16
+		}
+		shards = 4 * runtime.GOMAXPROCS(0)
+	case LowMemory:
+		if !c.expiryChosen() {
+			c.generations = 4
+		}
+		shards = 1
+	}
+	if shards > 0 && c.shards == 0 && c.newStore == nil {
+		c.shards = shards
+	}
+}
+
+// expiryChosen reports whether options chose an expiry mode other than the
+// default timer mode.
+func (c *config) expiryChosen() bool {
+	return c.expiryStrategy != nil || c.generations > 0 || c.buckets > 0 || c.decay
+}
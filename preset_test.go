@@ -0,0 +1,131 @@
+package ehc
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWithPreset(t *testing.T) {
+	tests := []struct {
+		name            string
+		opts            []Option
+		wantResolution  time.Duration
+		wantGenerations int
+	}{
+		{
+			name: "precise",
+			opts: []Option{WithPreset(Precise)},
+		},
+		{
+			name:           "balanced",
+			opts:           []Option{WithPreset(Balanced)},
+			wantResolution: time.Second,
+		},
+		{
+			name:            "high throughput",
+			opts:            []Option{WithPreset(HighThroughput)},
+			wantGenerations: 16,
+		},
+		{
+			name:            "low memory",
+			opts:            []Option{WithPreset(LowMemory)},
+			wantGenerations: 4,
+		},
+		{
+			name:            "explicit options win",
+			opts:            []Option{WithGenerations(8), WithPreset(LowMemory)},
+			wantGenerations: 8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEHC(64*time.Second, tt.opts...)
+			defer e.Close()
+			if e.resolution != tt.wantResolution {
+				t.Errorf("resolution = %v, want %v", e.resolution, tt.wantResolution)
+			}
+			if e.generations != tt.wantGenerations {
+				t.Errorf("generations = %d, want %d", e.generations, tt.wantGenerations)
+			}
+		})
+	}
+}
+
+func TestWithPreset_ExplicitExpiry(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want ExpiryStrategy
+	}{
+		{"buckets", []Option{WithPreset(HighThroughput), WithBuckets(10)}, &buckets{}},
+		{"compact buckets", []Option{WithCompactBuckets(10), WithPreset(HighThroughput)}, &compact{}},
+		{"decay", []Option{WithPreset(LowMemory), WithExponentialDecay(0)}, &decay{}},
+		{"generations", []Option{WithPreset(Balanced), WithGenerations(3)}, &generations{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEHC(64*time.Second, tt.opts...)
+			defer e.Close()
+			if got, want := fmt.Sprintf("%T", e.expiry), fmt.Sprintf("%T", tt.want); got != want {
+				t.Errorf("expiry = %s, want %s", got, want)
+			}
+			if e.resolution != 0 {
+				t.Errorf("resolution = %v, want none", e.resolution)
+			}
+		})
+	}
+}
+
+func TestWithPreset_Shards(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+	tests := []struct {
+		name string
+		opts []Option
+		want int
+	}{
+		{"precise", []Option{WithPreset(Precise)}, procs},
+		{"balanced", []Option{WithPreset(Balanced)}, 4 * procs},
+		{"high throughput", []Option{WithPreset(HighThroughput)}, 4 * procs},
+		{"low memory", []Option{WithPreset(LowMemory)}, 1},
+		{"explicit shards win", []Option{WithShards(3), WithPreset(Balanced)}, 3},
+		{"store keeps one shard", []Option{WithPreset(Balanced), WithStore(NewMapStore)}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEHC(time.Minute, tt.opts...)
+			defer e.Close()
+			if n := len(e.shards); n != tt.want {
+				t.Errorf("%d shards, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestEHC_Resolution(t *testing.T) {
+	e := NewEHC(10*time.Millisecond, WithResolution(5*time.Millisecond))
+	for i := 0; i < 100; i++ {
+		e.Count("test")
+	}
+
+	values, locker := e.Values()
+	c := values["test"].(*counter)
+	c.mu.Lock()
+	pending := len(c.pending)
+	c.mu.Unlock()
+	if c.Value() != 100 {
+		t.Errorf("counter value = %d, want 100", c.Value())
+	}
+	locker.Unlock()
+	if pending > 2 {
+		t.Errorf("len(pending) = %d, want increments batched into at most 2 slots", pending)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	values, locker = e.Values()
+	if len(values) != 0 {
+		t.Errorf("EHC.Values() after window = %v, want empty", values)
+	}
+	locker.Unlock()
+}
@@ -0,0 +1,48 @@
+package ehc
+
+import "math"
+
+// WithPriorityShares sets up priority classes for ReservePriority, class 0
+// being the highest. shares[i] is the fraction of every limit held back for
+// class i and the classes above it: classes below i can't use it, while
+// class i and above can take it as well as anything left over below them.
+// With WithPriorityShares(0.2, 0.3), for example, class 0 can fill the whole
+// limit, class 1 only 80% of it, and classes 2 and below only 50%, so that
+// premium traffic for a key still gets through once lower tiers have used up
+// their part of a shared limit.
+func WithPriorityShares(shares ...float64) Option {
+	shares = append([]float64(nil), shares...)
+	return func(c *config) {
+		c.priorityShares = shares
+	}
+}
+
+// ReservePriority is Reserve for traffic of a priority class set up with
+// WithPriorityShares: the reservation only succeeds if it fits in the part
+// of limit the class may use. Classes beyond the last share are treated
+// like the lowest one, and without WithPriorityShares every class can use
+// the whole limit. With WithBlockOnLimit, only failures of class 0 block the
+// key, so that lower classes running out can't lock out the higher ones.
+func (e *EHC) ReservePriority(key interface{}, cost, limit int64, class int) (*Reservation, bool) {
+	return e.reserveClass(key, cost, limit, class)
+}
+
+// classLimit returns the part of limit that class may use. valueLock must
+// be held.
+func (e *EHC) classLimit(limit int64, class int) int64 {
+	held := 0.0
+	for i, share := range e.priorityShares {
+		if i >= class {
+			break
+		}
+		held += share
+	}
+	if held <= 0 {
+		return limit
+	}
+	if held >= 1 {
+		return 0
+	}
+	// the epsilon keeps e.g. 10*(1-0.2) from rounding down to 7
+	return int64(math.Floor(float64(limit)*(1-held) + 1e-9))
+}
@@ -0,0 +1,51 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_ReservePriority(t *testing.T) {
+	e := NewEHC(time.Minute, WithPriorityShares(0.2, 0.3), WithBlockOnLimit(time.Minute))
+
+	// class 2 and beyond can fill half of the limit
+	if _, ok := e.ReservePriority("k", 5, 10, 2); !ok {
+		t.Fatal("EHC.ReservePriority(class 2) within its share failed")
+	}
+	if _, ok := e.ReservePriority("k", 1, 10, 5); ok {
+		t.Error("EHC.ReservePriority(class 5) succeeded beyond the lowest share")
+	}
+	// class 1 can go on to 80%
+	if _, ok := e.ReservePriority("k", 3, 10, 1); !ok {
+		t.Fatal("EHC.ReservePriority(class 1) within its share failed")
+	}
+	if _, ok := e.ReservePriority("k", 1, 10, 1); ok {
+		t.Error("EHC.ReservePriority(class 1) succeeded beyond its share")
+	}
+	// and class 0 takes the rest
+	if _, ok := e.ReservePriority("k", 2, 10, 0); !ok {
+		t.Fatal("EHC.ReservePriority(class 0) within the limit failed")
+	}
+	if _, ok := e.Reserve("k", 1, 10); ok {
+		t.Error("EHC.Reserve() succeeded beyond the limit")
+	}
+	// only the last failure, of class 0, blocked the key
+	if !e.IsBlocked("k") {
+		t.Error("EHC.IsBlocked(k) = false after class 0 went over the limit")
+	}
+
+	e = NewEHC(time.Minute, WithPriorityShares(0.2, 0.3), WithBlockOnLimit(time.Minute))
+	e.CountCost("k", 5)
+	if _, ok := e.ReservePriority("k", 1, 10, 2); ok {
+		t.Error("EHC.ReservePriority(class 2) succeeded beyond its share")
+	}
+	if e.IsBlocked("k") {
+		t.Error("EHC.IsBlocked(k) = true after only class 2 went over its share")
+	}
+
+	// without shares, every class gets the whole limit
+	e = NewEHC(time.Minute)
+	if _, ok := e.ReservePriority("k", 10, 10, 3); !ok {
+		t.Error("EHC.ReservePriority() without shares failed within the limit")
+	}
+}
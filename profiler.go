@@ -0,0 +1,161 @@
+package ehc
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// Phase identifies a step of the Count path timed by a Profiler.
+type Phase int
+
+const (
+	// PhaseLock is time spent acquiring the map lock.
+	PhaseLock Phase = iota
+	// PhaseMap is time spent looking up or inserting keys.
+	PhaseMap
+	// PhaseExpiry is time spent applying an increment and registering
+	// its expiration.
+	PhaseExpiry
+
+	numPhases
+)
+
+var phaseNames = [numPhases]string{
+	PhaseLock:   "lock",
+	PhaseMap:    "map",
+	PhaseExpiry: "expiry",
+}
+
+func (p Phase) String() string {
+	if p < 0 || p >= numPhases {
+		return fmt.Sprintf("Phase(%d)", int(p))
+	}
+	return phaseNames[p]
+}
+
+// Profiler records how long each phase of Count takes, so performance
+// regressions can be localized without external tooling. Recording costs a
+// couple of clock reads per phase, so it is meant to be enabled selectively.
+type Profiler struct {
+	phases [numPhases]phaseTimings
+}
+
+type phaseTimings struct {
+	count int64
+	total int64
+	max   int64
+}
+
+// PhaseProfile summarizes the timings recorded for one phase.
+type PhaseProfile struct {
+	Phase Phase
+	Count int64
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Mean returns the average time per recorded occurrence of the phase.
+func (p PhaseProfile) Mean() time.Duration {
+	if p.Count == 0 {
+		return 0
+	}
+	return p.Total / time.Duration(p.Count)
+}
+
+// NewProfiler returns an empty Profiler.
+func NewProfiler() *Profiler {
+	return &Profiler{}
+}
+
+// start returns the time a phase begins, or the zero time if p is nil.
+func (p *Profiler) start() time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// done records a phase that began at start and returns the current time, so
+// that consecutive phases can be chained.
+func (p *Profiler) done(phase Phase, start time.Time) time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	now := time.Now()
+	d := int64(now.Sub(start))
+
+	t := &p.phases[phase]
+	atomic.AddInt64(&t.count, 1)
+	atomic.AddInt64(&t.total, d)
+	for {
+		max := atomic.LoadInt64(&t.max)
+		if d <= max || atomic.CompareAndSwapInt64(&t.max, max, d) {
+			break
+		}
+	}
+	return now
+}
+
+// Profile returns the timings recorded so far, one entry per phase.
+func (p *Profiler) Profile() []PhaseProfile {
+	profile := make([]PhaseProfile, numPhases)
+	for i := range p.phases {
+		t := &p.phases[i]
+		profile[i] = PhaseProfile{
+			Phase: Phase(i),
+			Count: atomic.LoadInt64(&t.count),
+			Total: time.Duration(atomic.LoadInt64(&t.total)),
+			Max:   time.Duration(atomic.LoadInt64(&t.max)),
+		}
+	}
+	return profile
+}
+
+// Reset discards all recorded timings.
+func (p *Profiler) Reset() {
+	for i := range p.phases {
+		t := &p.phases[i]
+		atomic.StoreInt64(&t.count, 0)
+		atomic.StoreInt64(&t.total, 0)
+		atomic.StoreInt64(&t.max, 0)
+	}
+}
+
+// WriteTo writes the profile to w as a human-readable table.
+func (p *Profiler) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "phase\tcount\ttotal\tmean\tmax")
+	for _, pp := range p.Profile() {
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t%v\n", pp.Phase, pp.Count, pp.Total, pp.Mean(), pp.Max)
+	}
+	err := tw.Flush()
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Profiler returns the profiler installed by WithProfiler, or nil.
+func (e *EHC) Profiler() *Profiler {
+	return e.prof.Load().(*Profiler)
+}
+
+// WithProfiler records per-phase timings of Count into p.
+func WithProfiler(p *Profiler) Option {
+	return func(c *config) {
+		c.profiler = p
+	}
+}
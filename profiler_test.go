@@ -0,0 +1,47 @@
+package ehc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfiler(t *testing.T) {
+	p := NewProfiler()
+	e := NewEHC(10*time.Millisecond, WithProfiler(p))
+	e.Count("a")
+	e.Count("a")
+
+	profile := p.Profile()
+	want := map[Phase]int64{
+		// the first Count takes the read lock and then the write lock
+		PhaseLock:   3,
+		PhaseMap:    3,
+		PhaseExpiry: 2,
+	}
+	for _, pp := range profile {
+		if pp.Count != want[pp.Phase] {
+			t.Errorf("phase %v recorded %d times, want %d", pp.Phase, pp.Count, want[pp.Phase])
+		}
+		if pp.Max > pp.Total {
+			t.Errorf("phase %v max %v exceeds total %v", pp.Phase, pp.Max, pp.Total)
+		}
+	}
+
+	var b strings.Builder
+	if _, err := p.WriteTo(&b); err != nil {
+		t.Fatalf("Profiler.WriteTo() error = %v", err)
+	}
+	for _, phase := range []string{"lock", "map", "expiry"} {
+		if !strings.Contains(b.String(), phase) {
+			t.Errorf("Profiler.WriteTo() output is missing phase %q:\n%s", phase, b.String())
+		}
+	}
+
+	p.Reset()
+	for _, pp := range p.Profile() {
+		if pp.Count != 0 {
+			t.Errorf("phase %v count after Reset() = %d, want 0", pp.Phase, pp.Count)
+		}
+	}
+}
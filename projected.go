@@ -0,0 +1,154 @@
+package ehc
+
+import (
+	"sort"
+	"time"
+)
+
+// ProjectedValue returns what the count of key will be at, a future instant,
+// if nothing more is counted in the meantime, given the retractions already
+// scheduled. Schedulers can use it to plan work for when a key's limit or
+// budget frees up. For instants that aren't in the future it returns the
+// current count.
+//
+// In the default timer mode it is exact, to the resolution of the expiry. An
+// ExpiryStrategy is simply asked for its value at that instant, which for
+// the built-in ones rounds it to their generations or buckets.
+func (e *EHC) ProjectedValue(key interface{}, at time.Time) int64 {
+	e.valueLock.RLock()
+	defer e.valueLock.RUnlock()
+
+	key, ok := e.normalizeKey(key)
+	if !ok {
+		return 0
+	}
+	now := e.now()
+	if at.Before(now) {
+		at = now
+	}
+	if e.expiry != nil {
+		return e.expiry.Value(key, at)
+	}
+
+	c := e.lookup(key)
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value := c.Value()
+	for _, r := range c.pending {
+		if !r.deadline.After(at) {
+			value -= r.count
+		}
+	}
+	return value
+}
+
+// WhenAllowed returns the earliest time at which Reserve(key, cost, limit)
+// would succeed if nothing more is counted or reserved in the meantime, for
+// precise backoff hints such as a Retry-After header. It is the current
+// time if the reservation would succeed now, and the zero time if it never
+// can, because cost alone exceeds limit or the key's outstanding
+// reservations leave no room for it. Blocks and WithGlobalLimit are taken
+// into account, and keys exempted with BypassLimits are always allowed.
+//
+// In the default timer mode it is exact, to the resolution of the expiry.
+// With an ExpiryStrategy it is found by searching the strategy's values at
+// future instants, to within a thousandth of the window.
+func (e *EHC) WhenAllowed(key interface{}, cost, limit int64) time.Time {
+	e.valueLock.RLock()
+	defer e.valueLock.RUnlock()
+
+	now := e.now()
+	if e.bypassed(key, BypassLimits) {
+		return now
+	}
+	key, ok := e.normalizeKey(key)
+	if !ok || cost > limit {
+		return time.Time{}
+	}
+
+	at := now
+	if e.expiry != nil {
+		at = e.expiryWhenAllowed(key, cost, limit, now)
+	} else if c := e.lookup(key); c != nil {
+		at = c.whenAllowed(cost, limit, now)
+	}
+
+	if g, _ := e.global.Load().(*globalTotal); g != nil {
+		total := g.counts.WhenAllowed(globalKey{}, cost, g.limit)
+		if total.IsZero() {
+			return total
+		}
+		if total.After(at) {
+			at = total
+		}
+	}
+
+	e.blocks.mu.Lock()
+	until, blocked := e.blocks.until[key]
+	e.blocks.mu.Unlock()
+	if blocked && until.After(at) {
+		at = until
+	}
+	return at
+}
+
+// whenAllowed returns when enough of c's count will have been retracted for
+// cost to fit under limit, which must be at least cost.
+func (c *counter) whenAllowed(cost, limit int64, now time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	excess := c.Value() + c.reserved + cost - limit
+	if excess <= 0 {
+		return now
+	}
+	pending := append([]*retraction(nil), c.pending...)
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].deadline.Before(pending[j].deadline)
+	})
+	for _, r := range pending {
+		if excess -= r.count; excess <= 0 {
+			return r.deadline
+		}
+	}
+	// only the reservations are left, which don't expire
+	return time.Time{}
+}
+
+// expiryWhenAllowed searches for when the strategy's value for a normalized
+// key will have fallen enough for cost to fit under limit. valueLock must be
+// held.
+func (e *EHC) expiryWhenAllowed(key interface{}, cost, limit int64, now time.Time) time.Time {
+	e.held.mu.Lock()
+	room := limit - cost - e.held.cost[key]
+	e.held.mu.Unlock()
+
+	fits := func(at time.Time) bool {
+		return e.expiry.Value(key, at) <= room
+	}
+	switch {
+	case fits(now):
+		return now
+	case room < 0 || !fits(now.Add(e.window)):
+		return time.Time{}
+	}
+
+	// the value only falls as time passes, so the answer is in here
+	lo, hi := now, now.Add(e.window)
+	precision := e.window / 1000
+	if precision <= 0 {
+		precision = 1
+	}
+	for hi.Sub(lo) > precision {
+		mid := lo.Add(hi.Sub(lo) / 2)
+		if fits(mid) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi
+}
@@ -0,0 +1,84 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_ProjectedValue(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithBuckets(10)}} {
+		start := time.Unix(0, 0)
+		e := NewManualEHC(10*time.Second, start, opts...)
+		e.CountMultiple("k", 3)
+		e.Tick(4 * time.Second)
+		e.CountMultiple("k", 2)
+
+		for _, c := range []struct {
+			at   time.Duration
+			want int64
+		}{
+			{0, 5},
+			{9 * time.Second, 5},
+			{11 * time.Second, 2},
+			{15 * time.Second, 0},
+		} {
+			if v := e.ProjectedValue("k", start.Add(c.at)); v != c.want {
+				t.Errorf("%d options: EHC.ProjectedValue(k, %v) = %d, want %d", len(opts), c.at, v, c.want)
+			}
+		}
+		if v := e.ProjectedValue("other", start.Add(time.Second)); v != 0 {
+			t.Errorf("EHC.ProjectedValue(other) = %d, want 0", v)
+		}
+
+		// projecting doesn't retract anything
+		e.Tick(5 * time.Second)
+		if v := e.value("k"); v != 5 {
+			t.Errorf("%d options: count = %d, want 5", len(opts), v)
+		}
+	}
+}
+
+func TestEHC_WhenAllowed(t *testing.T) {
+	for mode, opts := range [][]Option{nil, {WithBuckets(10)}} {
+		start := time.Unix(0, 0)
+		e := NewManualEHC(10*time.Second, start, opts...)
+		e.CountMultiple("k", 3)
+		e.Tick(4 * time.Second)
+		e.CountMultiple("k", 2)
+		e.Tick(time.Second)
+
+		for _, c := range []struct {
+			cost, limit int64
+			// want is per mode, as the buckets let the oldest one's
+			// count expire gradually
+			want [2]time.Time
+		}{
+			{1, 6, [2]time.Time{e.Now(), e.Now()}},
+			{1, 5, [2]time.Time{start.Add(10 * time.Second), start.Add(10 * time.Second)}},
+			{3, 5, [2]time.Time{start.Add(10 * time.Second), start.Add(10*time.Second + 2*time.Second/3)}},
+			{4, 5, [2]time.Time{start.Add(14 * time.Second), start.Add(14 * time.Second)}},
+			{6, 5, [2]time.Time{}},
+		} {
+			want := c.want[mode]
+			got := e.WhenAllowed("k", c.cost, c.limit)
+			// the buckets are searched to a thousandth of the window
+			if got.Before(want) || got.Sub(want) > 10*time.Millisecond {
+				t.Errorf("%d options: EHC.WhenAllowed(k, %d, %d) = %v, want %v", len(opts), c.cost, c.limit, got.Sub(start), want.Sub(start))
+			}
+		}
+
+		r, ok := e.Reserve("k", 1, 6)
+		if !ok {
+			t.Fatal("EHC.Reserve() failed")
+		}
+		if got := e.WhenAllowed("k", 5, 5); !got.IsZero() {
+			t.Errorf("%d options: EHC.WhenAllowed() = %v with the room reserved, want never", len(opts), got.Sub(start))
+		}
+		r.Cancel()
+
+		e.Block("k", 20*time.Second)
+		if got, want := e.WhenAllowed("k", 1, 6), start.Add(25*time.Second); !got.Equal(want) {
+			t.Errorf("%d options: EHC.WhenAllowed() = %v while blocked, want %v", len(opts), got.Sub(start), want.Sub(start))
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package ehc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Query is a compiled filter expression over keys and their counts, for
+// picking entries out of the EHC without writing Go callbacks. Expressions
+// are made of:
+//
+//	count               the count, an integer
+//	key                 the key, as a string in its fmt.Sprint form
+//	42, "ip:"           integer and string literals, in Go syntax
+//	== != < <= > >=     comparisons of two integers or two strings
+//	&& || !             logical operators, with their Go precedence
+//	( )                 grouping
+//	hasPrefix(s, p)     reports whether s begins with p
+//	hasSuffix(s, p)     reports whether s ends with p
+//	contains(s, sub)    reports whether sub is within s
+//
+// for example count > 100 && hasPrefix(key, "ip:"). Expressions are type
+// checked when compiled, and must be boolean.
+type Query struct {
+	src  string
+	root queryNode
+}
+
+// ParseQuery compiles the expression src.
+func ParseQuery(src string) (*Query, error) {
+	p := &queryParser{src: src}
+	p.next()
+	root := p.parseOr()
+	if p.err == nil && p.tok.kind != tokEOF {
+		p.fail("unexpected %s", p.tok)
+	}
+	if p.err == nil && root.kind() != kindBool {
+		p.err = fmt.Errorf("ehc: query %q is not a condition", src)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &Query{src: src, root: root}, nil
+}
+
+// String returns the expression the query was compiled from.
+func (q *Query) String() string {
+	return q.src
+}
+
+// Match reports whether key with count satisfies the query.
+func (q *Query) Match(key interface{}, count int64) bool {
+	return q.root.eval(queryEnv{key: fmt.Sprint(key), count: count}).b
+}
+
+// Query returns the count of every key that satisfies the query expression
+// src, as Snapshot would, or fails if src isn't a valid expression; see
+// Query.
+func (e *EHC) Query(src string) (map[interface{}]int64, error) {
+	q, err := ParseQuery(src)
+	if err != nil {
+		return nil, err
+	}
+	return e.Select(q), nil
+}
+
+// Select returns the count of every key that satisfies q, as Snapshot would.
+func (e *EHC) Select(q *Query) map[interface{}]int64 {
+	matches := e.Snapshot()
+	for k, v := range matches {
+		if !q.Match(k, v) {
+			delete(matches, k)
+		}
+	}
+	return matches
+}
+
+// queryKind is the type of a query expression.
+type queryKind int
+
+const (
+	kindInt queryKind = iota
+	kindString
+	kindBool
+)
+
+func (k queryKind) String() string {
+	return [...]string{"integer", "string", "boolean"}[k]
+}
+
+// queryEnv is what a query is evaluated against.
+type queryEnv struct {
+	key   string
+	count int64
+}
+
+// queryValue is the value of an expression, in the field of its kind.
+type queryValue struct {
+	i int64
+	s string
+	b bool
+}
+
+type queryNode interface {
+	kind() queryKind
+	eval(env queryEnv) queryValue
+}
+
+type (
+	queryLiteral struct {
+		k queryKind
+		v queryValue
+	}
+	queryCount struct{}
+	queryKey   struct{}
+	queryNot   struct{ x queryNode }
+	// queryLogical is && if and is set, and || otherwise.
+	queryLogical struct {
+		and  bool
+		x, y queryNode
+	}
+	queryCompare struct {
+		op   string
+		x, y queryNode
+	}
+	queryCall struct {
+		fn   func(s, t string) bool
+		s, t queryNode
+	}
+)
+
+func (n queryLiteral) kind() queryKind { return n.k }
+func (queryCount) kind() queryKind     { return kindInt }
+func (queryKey) kind() queryKind       { return kindString }
+func (queryNot) kind() queryKind       { return kindBool }
+func (queryLogical) kind() queryKind   { return kindBool }
+func (queryCompare) kind() queryKind   { return kindBool }
+func (queryCall) kind() queryKind      { return kindBool }
+
+func (n queryLiteral) eval(queryEnv) queryValue { return n.v }
+
+func (queryCount) eval(env queryEnv) queryValue { return queryValue{i: env.count} }
+
+func (queryKey) eval(env queryEnv) queryValue { return queryValue{s: env.key} }
+
+func (n queryNot) eval(env queryEnv) queryValue { return queryValue{b: !n.x.eval(env).b} }
+
+func (n queryLogical) eval(env queryEnv) queryValue {
+	x := n.x.eval(env).b
+	if x != n.and {
+		// short-circuit: false && y, or true || y
+		return queryValue{b: x}
+	}
+	return n.y.eval(env)
+}
+
+func (n queryCompare) eval(env queryEnv) queryValue {
+	x, y := n.x.eval(env), n.y.eval(env)
+	var c int
+	switch n.x.kind() {
+	case kindInt:
+		c = cmpInt(x.i, y.i)
+	case kindString:
+		c = strings.Compare(x.s, y.s)
+	case kindBool:
+		if x.b != y.b {
+			c = 1
+		}
+	}
+	var b bool
+	switch n.op {
+	case "==":
+		b = c == 0
+	case "!=":
+		b = c != 0
+	case "<":
+		b = c < 0
+	case "<=":
+		b = c <= 0
+	case ">":
+		b = c > 0
+	case ">=":
+		b = c >= 0
+	}
+	return queryValue{b: b}
+}
+
+func cmpInt(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func (n queryCall) eval(env queryEnv) queryValue {
+	return queryValue{b: n.fn(n.s.eval(env).s, n.t.eval(env).s)}
+}
+
+// queryFuncs are the functions a query can call, all of two strings.
+var queryFuncs = map[string]func(s, t string) bool{
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"contains":  strings.Contains,
+}
+
+// queryToken kinds.
+const (
+	tokEOF = iota
+	tokInt
+	tokString
+	tokIdent
+	tokOp
+)
+
+type queryToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+func (t queryToken) String() string {
+	if t.kind == tokEOF {
+		return "end of query"
+	}
+	return strconv.Quote(t.text)
+}
+
+// queryParser is a recursive descent parser of query expressions, which
+// stops at the first error.
+type queryParser struct {
+	src string
+	pos int
+	tok queryToken
+	err error
+}
+
+func (p *queryParser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("ehc: query %q at offset %d: %s", p.src, p.tok.pos, fmt.Sprintf(format, args...))
+	}
+	p.tok = queryToken{kind: tokEOF, pos: len(p.src)}
+}
+
+// next scans the next token into p.tok.
+func (p *queryParser) next() {
+	if p.err != nil {
+		return
+	}
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	start := p.pos
+	p.tok = queryToken{pos: start}
+	if p.pos == len(p.src) {
+		p.tok.kind = tokEOF
+		return
+	}
+	c := p.src[p.pos]
+	switch {
+	case c >= '0' && c <= '9':
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		p.tok.kind = tokInt
+	case isIdentByte(c):
+		for p.pos < len(p.src) && (isIdentByte(p.src[p.pos]) || p.src[p.pos] >= '0' && p.src[p.pos] <= '9') {
+			p.pos++
+		}
+		p.tok.kind = tokIdent
+	case c == '"' || c == '`':
+		prefix, err := strconv.QuotedPrefix(p.src[p.pos:])
+		if err != nil {
+			p.fail("unterminated string")
+			return
+		}
+		p.pos += len(prefix)
+		p.tok.kind = tokString
+	default:
+		p.tok.kind = tokOp
+		for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", ","} {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.pos += len(op)
+				p.tok.text = op
+				return
+			}
+		}
+		p.fail("unexpected %q", c)
+		return
+	}
+	p.tok.text = p.src[start:p.pos]
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// expect consumes the operator op.
+func (p *queryParser) expect(op string) {
+	if p.tok.kind != tokOp || p.tok.text != op {
+		p.fail("expected %q, found %s", op, p.tok)
+		return
+	}
+	p.next()
+}
+
+func (p *queryParser) isOp(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+// want checks that n is of kind k, for the operator op.
+func (p *queryParser) want(n queryNode, k queryKind, op string) {
+	if n.kind() != k {
+		p.fail("%s needs a %s, not a %s", op, k, n.kind())
+	}
+}
+
+func (p *queryParser) parseOr() queryNode {
+	x := p.parseAnd()
+	for p.isOp("||") {
+		p.next()
+		y := p.parseAnd()
+		p.want(x, kindBool, "||")
+		p.want(y, kindBool, "||")
+		x = queryLogical{x: x, y: y}
+	}
+	return x
+}
+
+func (p *queryParser) parseAnd() queryNode {
+	x := p.parseCompare()
+	for p.isOp("&&") {
+		p.next()
+		y := p.parseCompare()
+		p.want(x, kindBool, "&&")
+		p.want(y, kindBool, "&&")
+		x = queryLogical{and: true, x: x, y: y}
+	}
+	return x
+}
+
+func (p *queryParser) parseCompare() queryNode {
+	x := p.parseUnary()
+	if p.tok.kind != tokOp {
+		return x
+	}
+	switch op := p.tok.text; op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+		y := p.parseUnary()
+		if x.kind() != y.kind() {
+			p.fail("can't compare %s and %s", x.kind(), y.kind())
+		} else if x.kind() == kindBool && op != "==" && op != "!=" {
+			p.fail("can't order booleans with %s", op)
+		}
+		return queryCompare{op: op, x: x, y: y}
+	}
+	return x
+}
+
+func (p *queryParser) parseUnary() queryNode {
+	if p.isOp("!") {
+		p.next()
+		x := p.parseUnary()
+		p.want(x, kindBool, "!")
+		return queryNot{x: x}
+	}
+	return p.parseOperand()
+}
+
+func (p *queryParser) parseOperand() queryNode {
+	tok := p.tok
+	switch tok.kind {
+	case tokInt:
+		p.next()
+		i, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			p.fail("integer %s out of range", tok.text)
+		}
+		return queryLiteral{k: kindInt, v: queryValue{i: i}}
+	case tokString:
+		p.next()
+		s, _ := strconv.Unquote(tok.text)
+		return queryLiteral{k: kindString, v: queryValue{s: s}}
+	case tokIdent:
+		p.next()
+		switch tok.text {
+		case "count":
+			return queryCount{}
+		case "key":
+			return queryKey{}
+		case "true", "false":
+			return queryLiteral{k: kindBool, v: queryValue{b: tok.text == "true"}}
+		}
+		fn, ok := queryFuncs[tok.text]
+		if !ok {
+			p.fail("unknown name %s", tok)
+			return queryLiteral{}
+		}
+		p.expect("(")
+		s := p.parseOr()
+		p.expect(",")
+		t := p.parseOr()
+		p.expect(")")
+		p.want(s, kindString, tok.text)
+		p.want(t, kindString, tok.text)
+		return queryCall{fn: fn, s: s, t: t}
+	case tokOp:
+		if tok.text == "(" {
+			p.next()
+			x := p.parseOr()
+			p.expect(")")
+			return x
+		}
+	}
+	p.fail("unexpected %s", tok)
+	return queryLiteral{}
+}
@@ -0,0 +1,73 @@
+package ehc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		src   string
+		key   interface{}
+		count int64
+		want  bool
+	}{
+		{`count > 100 && hasPrefix(key, "ip:")`, "ip:1", 101, true},
+		{`count > 100 && hasPrefix(key, "ip:")`, "ip:1", 100, false},
+		{`count > 100 && hasPrefix(key, "ip:")`, "user:1", 101, false},
+		{`count >= 100 || key == "admin"`, "admin", 1, true},
+		{`!(count < 5) && !contains(key, "bot")`, "crawler", 5, true},
+		{`hasSuffix(key, ".png") || count != 3 && false`, "a.gif", 4, false},
+		{`key < "b" && count == 42`, "a", 42, true},
+		{`key == "7"`, 7, 1, true},
+		{"key == `raw\\n`", `raw\n`, 1, true},
+		{`(count > 1) == true`, "a", 2, true},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.src)
+		if err != nil {
+			t.Errorf("ParseQuery(%s) failed: %v", tt.src, err)
+			continue
+		}
+		if got := q.Match(tt.key, tt.count); got != tt.want {
+			t.Errorf("ParseQuery(%s).Match(%v, %d) = %v, want %v", tt.src, tt.key, tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryInvalid(t *testing.T) {
+	for src, want := range map[string]string{
+		``:                             "unexpected end of query",
+		`count`:                        "not a condition",
+		`count > "1"`:                  "can't compare integer and string",
+		`count > 1 &&`:                 "unexpected end of query",
+		`key && count > 1`:             "&& needs a boolean",
+		`hasPrefix(count, "a")`:        "hasPrefix needs a string",
+		`matches(key, "a")`:            "unknown name",
+		`(count > 1`:                   `expected ")"`,
+		`key == "a`:                    "unterminated string",
+		`count > 1 # comment`:          `unexpected '#'`,
+		`true < false`:                 "can't order booleans",
+		`count > 99999999999999999999`: "out of range",
+	} {
+		if _, err := ParseQuery(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseQuery(%s) = %v, want an error containing %q", src, err, want)
+		}
+	}
+}
+
+func TestEHC_Query(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	e.CountMultiple("ip:1", 150)
+	e.CountMultiple("ip:2", 50)
+	e.CountMultiple("user:1", 150)
+
+	got, err := e.Query(`count > 100 && hasPrefix(key, "ip:")`)
+	if err != nil || len(got) != 1 || got["ip:1"] != 150 {
+		t.Errorf("EHC.Query() = %v, %v, want ip:1: 150", got, err)
+	}
+	if _, err := e.Query(`count >`); err == nil {
+		t.Error("EHC.Query() of an invalid expression succeeded")
+	}
+}
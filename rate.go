@@ -0,0 +1,39 @@
+package ehc
+
+import (
+	"math"
+	"time"
+)
+
+// Rate returns the rate of key in events per second over the window: its
+// count divided by the window, or, while the EHC is younger than the window,
+// by how long it has been counting, so that rates aren't underestimated as
+// it warms up. The time counted is at least a hundredth of the window, so
+// that the first counts don't read as a burst. MigrateTo keeps the age of the
+// EHC, as it keeps its counts.
+//
+// With WithExponentialDecay, the count is divided by the mean lifetime of an
+// increment, scaled down likewise while the counts haven't had time to build
+// up, which gives the rate of a key counted at a steady rate.
+func (e *EHC) Rate(key interface{}) float64 {
+	count, _ := e.Get(key)
+	if count == 0 {
+		return 0
+	}
+	return float64(count) / e.effectiveWindow().Seconds()
+}
+
+// effectiveWindow returns the span of time the counts are spread over.
+func (e *EHC) effectiveWindow() time.Duration {
+	e.valueLock.RLock()
+	d, _ := e.expiry.(*decay)
+	elapsed := e.clock.Now().Sub(e.started)
+	e.valueLock.RUnlock()
+
+	elapsed = max(elapsed, e.window/100, 1)
+	if d != nil {
+		// a steady rate r builds up to r×lifetime×(1-e^(-t/lifetime))
+		return time.Duration(-d.lifetime * math.Expm1(-float64(elapsed)/d.lifetime))
+	}
+	return min(e.window, elapsed)
+}
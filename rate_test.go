@@ -0,0 +1,52 @@
+package ehc
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEHC_Rate(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	if r := e.Rate("a"); r != 0 {
+		t.Errorf("Rate() = %v without counts, want 0", r)
+	}
+
+	// while warming up, the rate is over the time counted so far
+	e.Tick(10 * time.Second)
+	e.CountMultiple("a", 50)
+	if r := e.Rate("a"); r != 5 {
+		t.Errorf("Rate() = %v after 10s, want 5", r)
+	}
+	e.Tick(20 * time.Second)
+	e.CountMultiple("a", 100)
+	if r := e.Rate("a"); r != 5 {
+		t.Errorf("Rate() = %v after 30s, want 5", r)
+	}
+
+	// then over the window
+	e.Tick(40 * time.Second)
+	if r := e.Rate("a"); r != 100.0/60 {
+		t.Errorf("Rate() = %v after 70s, want %v", r, 100.0/60)
+	}
+
+	// the first counts aren't taken as a burst
+	e = NewManualEHC(time.Minute, time.Unix(0, 0))
+	e.Count("a")
+	if r := e.Rate("a"); r != 1/0.6 {
+		t.Errorf("Rate() = %v at once, want %v", r, 1/0.6)
+	}
+}
+
+func TestEHC_RateDecay(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithExponentialDecay(0))
+	for i := 0; i < 600; i++ {
+		e.CountMultiple("a", 10)
+		e.Tick(time.Second)
+		if i == 29 || i == 599 {
+			if r := e.Rate("a"); math.Abs(r-10) > 0.5 {
+				t.Errorf("Rate() = %v after %ds at 10/s, want about 10", r, i+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package ehc
+
+import (
+	"math/rand"
+	"time"
+)
+
+// sampleRefreshes is how many times per window SampleKey rebuilds its table
+// at most.
+const sampleRefreshes = 16
+
+// SampleKey returns a random key, each with a probability proportional to
+// its current count, or nil if nothing is counted. Tracing the requests of
+// the keys it returns, for example, traces what is actually happening rather
+// than a uniform sample of the keys.
+//
+// Keys are drawn in constant time from a table built from a Snapshot with
+// the alias method. The table is rebuilt when it is older than a sixteenth
+// of the window, so the probabilities lag the counts by up to that.
+func (e *EHC) SampleKey() interface{} {
+	now := e.clock.Now()
+	refresh := e.window / sampleRefreshes
+
+	t, _ := e.sample.Load().(*aliasTable)
+	if t == nil || now.Sub(t.built) >= refresh {
+		e.sampleMu.Lock()
+		if t, _ = e.sample.Load().(*aliasTable); t == nil || now.Sub(t.built) >= refresh {
+			t = newAliasTable(e.Snapshot(), now)
+			e.sample.Store(t)
+		}
+		e.sampleMu.Unlock()
+	}
+	return t.draw()
+}
+
+// aliasTable draws keys with probabilities proportional to their weights,
+// using Vose's alias method: each of the n columns holds a key and an alias,
+// and a draw picks a column uniformly, then its key with the column's
+// probability and its alias otherwise.
+type aliasTable struct {
+	built time.Time
+	keys  []interface{}
+	prob  []float64
+	alias []int
+}
+
+func newAliasTable(weights map[interface{}]int64, now time.Time) *aliasTable {
+	t := &aliasTable{built: now}
+	var total int64
+	for k, w := range weights {
+		if w > 0 {
+			t.keys = append(t.keys, k)
+			total += w
+		}
+	}
+	n := len(t.keys)
+	t.prob = make([]float64, n)
+	t.alias = make([]int, n)
+
+	// scale the weights so that they average 1, then pair each column
+	// that is short of 1 with one that has more than enough to fill it
+	var small, large []int
+	for i, k := range t.keys {
+		t.prob[i] = float64(weights[k]) * float64(n) / float64(total)
+		if t.prob[i] < 1 {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+	for len(small) > 0 && len(large) > 0 {
+		s, l := small[len(small)-1], large[len(large)-1]
+		small = small[:len(small)-1]
+		t.alias[s] = l
+		t.prob[l] -= 1 - t.prob[s]
+		if t.prob[l] < 1 {
+			large = large[:len(large)-1]
+			small = append(small, l)
+		}
+	}
+	// what is left is full, up to rounding errors
+	for _, i := range append(small, large...) {
+		t.prob[i] = 1
+	}
+	return t
+}
+
+// draw returns a random key, or nil if the table is empty.
+func (t *aliasTable) draw() interface{} {
+	if len(t.keys) == 0 {
+		return nil
+	}
+	i := rand.Intn(len(t.keys))
+	if rand.Float64() < t.prob[i] {
+		return t.keys[i]
+	}
+	return t.keys[t.alias[i]]
+}
@@ -0,0 +1,57 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_SampleKey(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	if key := e.SampleKey(); key != nil {
+		t.Errorf("EHC.SampleKey() = %v with nothing counted, want nil", key)
+	}
+
+	e.Tick(time.Minute)
+	e.CountMultiple("a", 700)
+	e.CountMultiple("b", 200)
+	e.CountMultiple("c", 100)
+	draws := map[interface{}]int{}
+	for i := 0; i < 10000; i++ {
+		draws[e.SampleKey()]++
+	}
+	for key, want := range map[string]int{"a": 7000, "b": 2000, "c": 1000} {
+		if got := draws[key]; got < want-400 || got > want+400 {
+			t.Errorf("drew %s %d times in 10000, want about %d", key, got, want)
+		}
+	}
+	if len(draws) != 3 {
+		t.Errorf("drew %v, want only a, b and c", draws)
+	}
+
+	// the table is only rebuilt once it is stale
+	e.Count("d")
+	for i := 0; i < 1000; i++ {
+		if key := e.SampleKey(); key == "d" {
+			t.Fatal("EHC.SampleKey() drew a key counted since the table was built")
+		}
+	}
+	e.Tick(2 * time.Minute)
+	if key := e.SampleKey(); key != nil {
+		t.Errorf("EHC.SampleKey() = %v after the window, want nil", key)
+	}
+}
+
+func TestAliasTable(t *testing.T) {
+	// a single key, and weights that leave rounding errors
+	for _, weights := range []map[interface{}]int64{
+		{"only": 5},
+		{1: 1, 2: 1, 3: 1, 4: 1, 5: 1, 6: 1, 7: 3},
+	} {
+		table := newAliasTable(weights, time.Time{})
+		for i := 0; i < 1000; i++ {
+			if key := table.draw(); weights[key] == 0 {
+				t.Fatalf("aliasTable.draw() = %v, which isn't one of %v", key, weights)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package ehc
+
+import (
+	"hash/maphash"
+	"runtime"
+	"sync"
+)
+
+// WithShards splits the map of counters of the default timer mode into n
+// shards by the hash of their keys, each with its own lock, so that Count
+// calls on different keys, and first-seen keys in particular, rarely wait
+// for one another. It defaults to GOMAXPROCS, or to 1 with WithStore, where
+// each shard gets a store of its own.
+func WithShards(n int) Option {
+	return func(c *config) {
+		c.shards = n
+	}
+}
+
+// shard is part of the map of counters. Its mu must be held shared to look
+// up counters or change those in it, and exclusively to insert or remove
+// them. Holding valueLock exclusively, which keeps every Count out, also
+// allows both.
+type shard struct {
+	// increments, contended and lockWait, in nanoseconds, are the
+	// counters behind ShardStats, updated atomically. They are kept
+	// first so they stay aligned on 32-bit platforms.
+	increments int64
+	contended  int64
+	lockWait   int64
+
+	mu     sync.RWMutex
+	values Store
+
+	// peakKeys is the largest values has been since it was last
+	// compacted.
+	peakKeys int
+}
+
+// newShards returns empty shards as configured.
+func (c *config) newShards() []*shard {
+	n := c.shards
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+		if c.newStore != nil {
+			n = 1
+		}
+	}
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{values: c.store()}
+	}
+	return shards
+}
+
+// shardFor returns the shard of a normalized key. valueLock must be held.
+func (e *EHC) shardFor(key interface{}) *shard {
+	if len(e.shards) == 1 {
+		return e.shards[0]
+	}
+	return e.shards[maphash.Comparable(e.seed, key)%uint64(len(e.shards))]
+}
+
+// lookup returns the counter of a normalized key, or nil. valueLock must be
+// held.
+func (e *EHC) lookup(key interface{}) *counter {
+	s := e.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, _ := s.values.Get(key).(*counter)
+	return c
+}
+
+// rangeCounters calls fn for every counter, holding its shard's lock shared,
+// until fn returns false. valueLock must be held.
+func (e *EHC) rangeCounters(fn func(key interface{}, c *counter) bool) {
+	for _, s := range e.shards {
+		more := true
+		s.mu.RLock()
+		s.values.Range(func(key interface{}, c Counter) bool {
+			more = fn(key, c.(*counter))
+			return more
+		})
+		s.mu.RUnlock()
+		if !more {
+			return
+		}
+	}
+}
+
+// lockers unlocks several locks as one, in order.
+type lockers []sync.Locker
+
+func (l lockers) Lock() {
+	for i := len(l) - 1; i >= 0; i-- {
+		l[i].Lock()
+	}
+}
+
+func (l lockers) Unlock() {
+	for _, locker := range l {
+		locker.Unlock()
+	}
+}
+
+// keys returns the number of counters in the map.
+func (e *EHC) keys() int {
+	e.valueLock.RLock()
+	defer e.valueLock.RUnlock()
+
+	n := 0
+	for _, s := range e.shards {
+		s.mu.RLock()
+		n += s.values.Len()
+		s.mu.RUnlock()
+	}
+	return n
+}
@@ -0,0 +1,61 @@
+package ehc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEHC_Shards(t *testing.T) {
+	const (
+		workers = 8
+		keys    = 1000
+	)
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithShards(8))
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				e.Count(i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	used := 0
+	for _, s := range e.shards {
+		if s.values.Len() > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		t.Errorf("keys spread over %d of 8 shards", used)
+	}
+
+	values, locker := e.Values()
+	if len(values) != keys {
+		t.Errorf("EHC.Values() has %d keys, want %d", len(values), keys)
+	}
+	for k, c := range values {
+		if c.Value() != workers {
+			t.Fatalf("count of %v = %d, want %d", k, c.Value(), workers)
+		}
+	}
+	locker.Unlock()
+
+	e.MigrateTo(WithShards(3))
+	if n := e.keys(); n != keys || len(e.shards) != 3 {
+		t.Errorf("%d keys in %d shards after migrating, want %d in 3", n, len(e.shards), keys)
+	}
+	if v := e.value(7); v != workers {
+		t.Errorf("count of 7 = %d after migrating, want %d", v, workers)
+	}
+
+	e.Tick(time.Minute)
+	if n := e.keys(); n != 0 {
+		t.Errorf("%d keys left after the window, want 0", n)
+	}
+}
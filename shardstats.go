@@ -0,0 +1,97 @@
+package ehc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ShardStats describes the use of a shard of the map of counters of the
+// default timer mode (see WithShards), so that operators can spot keys
+// spreading badly over the shards. The counts are cumulative since the EHC
+// was created, or last migrated with MigrateTo; rates are the differences
+// between two calls to ShardStats over the time between them.
+type ShardStats struct {
+	// Keys is the number of keys in the shard.
+	Keys int
+	// Increments is the number of Count calls that counted a key of the
+	// shard.
+	Increments int64
+	// Contended is the number of times a Count call, or an expiration,
+	// found the shard's lock held and had to wait for it.
+	Contended int64
+	// LockWait is the time spent waiting for the shard's lock.
+	LockWait time.Duration
+}
+
+// ShardStats returns the stats of every shard, or nil with an
+// ExpiryStrategy, which has no shards.
+func (e *EHC) ShardStats() []ShardStats {
+	e.valueLock.RLock()
+	defer e.valueLock.RUnlock()
+	if e.expiry != nil {
+		return nil
+	}
+
+	stats := make([]ShardStats, len(e.shards))
+	for i, s := range e.shards {
+		s.mu.RLock()
+		stats[i].Keys = s.values.Len()
+		s.mu.RUnlock()
+		stats[i].Increments = atomic.LoadInt64(&s.increments)
+		stats[i].Contended = atomic.LoadInt64(&s.contended)
+		stats[i].LockWait = time.Duration(atomic.LoadInt64(&s.lockWait))
+	}
+	return stats
+}
+
+// HotShards returns the indexes of the shards of stats holding more than
+// factor times the mean number of keys, or having taken more than factor
+// times the mean number of increments, e.g. with a factor of 2. With a
+// hash spreading keys evenly, no shard is hot once there are many keys;
+// hot shards are down to a few very busy keys landing in them, or to
+// keys that hash alike.
+func HotShards(stats []ShardStats, factor float64) []int {
+	if len(stats) < 2 {
+		return nil
+	}
+	var keys, increments float64
+	for _, s := range stats {
+		keys += float64(s.Keys)
+		increments += float64(s.Increments)
+	}
+	keys /= float64(len(stats))
+	increments /= float64(len(stats))
+
+	var hot []int
+	for i, s := range stats {
+		if float64(s.Keys) > factor*keys || float64(s.Increments) > factor*increments {
+			hot = append(hot, i)
+		}
+	}
+	return hot
+}
+
+// rlock locks s.mu shared, accounting for any wait.
+func (s *shard) rlock() {
+	if s.mu.TryRLock() {
+		return
+	}
+	start := time.Now()
+	s.mu.RLock()
+	s.waited(start)
+}
+
+// lock locks s.mu exclusively, accounting for any wait.
+func (s *shard) lock() {
+	if s.mu.TryLock() {
+		return
+	}
+	start := time.Now()
+	s.mu.Lock()
+	s.waited(start)
+}
+
+func (s *shard) waited(start time.Time) {
+	atomic.AddInt64(&s.contended, 1)
+	atomic.AddInt64(&s.lockWait, int64(time.Since(start)))
+}
@@ -0,0 +1,55 @@
+package ehc
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEHC_ShardStats(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithShards(4))
+	for i := 0; i < 100; i++ {
+		e.Count(fmt.Sprint("key", i))
+	}
+	for i := 0; i < 1000; i++ {
+		e.Count("hot")
+	}
+
+	stats := e.ShardStats()
+	if len(stats) != 4 {
+		t.Fatalf("ShardStats() = %d shards, want 4", len(stats))
+	}
+	keys, increments := 0, int64(0)
+	for _, s := range stats {
+		keys += s.Keys
+		increments += s.Increments
+	}
+	if keys != 101 || increments != 1100 {
+		t.Errorf("ShardStats() = %d keys and %d increments, want 101 and 1100", keys, increments)
+	}
+
+	hot := e.shardFor("hot")
+	for i, s := range e.shards {
+		if s == hot {
+			if got := HotShards(stats, 2); !reflect.DeepEqual(got, []int{i}) {
+				t.Errorf("HotShards() = %v, want [%d]", got, i)
+			}
+		}
+	}
+
+	e.MigrateTo(WithGenerations(4))
+	if stats := e.ShardStats(); stats != nil {
+		t.Errorf("ShardStats() = %v with an ExpiryStrategy, want nil", stats)
+	}
+}
+
+func TestHotShards(t *testing.T) {
+	stats := []ShardStats{{Keys: 10, Increments: 10}, {Keys: 10, Increments: 10}, {Keys: 40, Increments: 10}, {Keys: 10, Increments: 10}}
+	if got := HotShards(stats, 2); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("HotShards() = %v, want [2]", got)
+	}
+	if got := HotShards(stats[:1], 2); got != nil {
+		t.Errorf("HotShards() = %v of a single shard, want none", got)
+	}
+}
@@ -0,0 +1,270 @@
+package ehc
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MarkSlot records that key was present in slot, e.g. the minute of the day
+// a device checked in. SlotCount then reports how many distinct slots were
+// marked for the key within the window. Slots must be in [0, math.MaxUint32];
+// others are ignored.
+//
+// Marks expire between 3/4 of the window and the full window after they
+// were made, as they are kept in four generations of compressed bitmaps.
+func (e *EHC) MarkSlot(key interface{}, slot int) {
+	if slot < 0 || uint64(slot) > math.MaxUint32 {
+		return
+	}
+
+	e.valueLock.RLock()
+	if e.closed || e.bypassed(key, BypassCounting) {
+		e.valueLock.RUnlock()
+		return
+	}
+	key, ok := e.normalizeKey(key)
+	e.valueLock.RUnlock()
+	if !ok {
+		atomic.AddInt64(&e.stats.dropped, 1)
+		return
+	}
+	if t := e.slotTracker(); t != nil {
+		t.mark(key, uint32(slot), e.clock.Now())
+	}
+}
+
+// SlotCount returns how many distinct slots were marked with MarkSlot for key
+// within the window.
+func (e *EHC) SlotCount(key interface{}) int {
+	e.valueLock.RLock()
+	key, ok := e.normalizeKey(key)
+	closed := e.closed
+	e.valueLock.RUnlock()
+	if !ok || closed {
+		return 0
+	}
+	if t := e.slotTracker(); t != nil {
+		return t.count(key, e.clock.Now())
+	}
+	return 0
+}
+
+// slotTracker returns the slot tracker, creating it if needed, or nil if
+// the EHC was closed without one.
+func (e *EHC) slotTracker() *slotTracker {
+	e.slotsOnce.Do(func() {
+		length := e.window / slotGenerations
+		if length <= 0 {
+			length = 1
+		}
+		e.slots = &slotTracker{
+			clock:  e.clock,
+			length: length,
+			keys:   map[interface{}]*slotSet{},
+		}
+	})
+	return e.slots
+}
+
+const slotGenerations = 4
+
+// slotTracker holds the marked slots of every key.
+type slotTracker struct {
+	clock  Clock
+	length time.Duration
+
+	mu   sync.Mutex
+	keys map[interface{}]*slotSet
+	// closed is set by Close, after which marks are ignored.
+	closed bool
+}
+
+// slotSet is the slots marked for one key, split into generations of
+// length window/slotGenerations starting at base.
+type slotSet struct {
+	base   time.Time
+	epochs [slotGenerations]int64
+	maps   [slotGenerations]bitmap
+	timer  Timer
+}
+
+func (t *slotTracker) epoch(s *slotSet, now time.Time) int64 {
+	return int64(now.Sub(s.base) / t.length)
+}
+
+func (t *slotTracker) live(s *slotSet, g int, cur int64) bool {
+	return s.maps[g] != nil && s.epochs[g] > cur-slotGenerations
+}
+
+func (t *slotTracker) mark(key interface{}, slot uint32, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	s := t.keys[key]
+	if s == nil {
+		s = &slotSet{base: now}
+		t.keys[key] = s
+		s.timer = t.clock.AfterFunc(slotGenerations*t.length, func() {
+			t.expire(key, s)
+		})
+	}
+	cur := t.epoch(s, now)
+	g := int(cur % slotGenerations)
+	if s.epochs[g] != cur || s.maps[g] == nil {
+		s.epochs[g] = cur
+		s.maps[g] = bitmap{}
+	}
+	s.maps[g].add(slot)
+}
+
+// expire removes the slots of key once none are live, checking again after
+// the newest generation ends otherwise.
+func (t *slotTracker) expire(key interface{}, s *slotSet) {
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cur := t.epoch(s, now)
+	newest := int64(-1)
+	for g := range s.maps {
+		if t.live(s, g, cur) && s.epochs[g] > newest {
+			newest = s.epochs[g]
+		}
+	}
+	if newest < 0 {
+		if t.keys[key] == s {
+			delete(t.keys, key)
+		}
+		return
+	}
+	end := s.base.Add(time.Duration(newest+slotGenerations) * t.length)
+	s.timer.Reset(end.Sub(now))
+}
+
+func (t *slotTracker) count(key interface{}, now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.keys[key]
+	if s == nil {
+		return 0
+	}
+	cur := t.epoch(s, now)
+	var live []bitmap
+	for g := range s.maps {
+		if t.live(s, g, cur) {
+			live = append(live, s.maps[g])
+		}
+	}
+	return unionCount(live)
+}
+
+// bitmap is a roaring-style compressed bitmap: values are grouped into
+// containers by their high 16 bits, and each container is a sorted array of
+// the low 16 bits while sparse, or a dense bitset once that is smaller.
+type bitmap map[uint16]*container
+
+// arrayMax is the size at which an array container is converted to a bitset,
+// as both then take 8KiB.
+const arrayMax = 4096
+
+type container struct {
+	array []uint16
+	bits  *[1024]uint64
+}
+
+func (b bitmap) add(x uint32) {
+	hi, lo := uint16(x>>16), uint16(x)
+	c := b[hi]
+	if c == nil {
+		c = &container{}
+		b[hi] = c
+	}
+	c.add(lo)
+}
+
+func (c *container) add(x uint16) {
+	if c.bits != nil {
+		c.bits[x/64] |= 1 << (x % 64)
+		return
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= x })
+	if i < len(c.array) && c.array[i] == x {
+		return
+	}
+	if len(c.array) < arrayMax {
+		c.array = append(c.array, 0)
+		copy(c.array[i+1:], c.array[i:])
+		c.array[i] = x
+		return
+	}
+	c.bits = new([1024]uint64)
+	for _, v := range c.array {
+		c.bits[v/64] |= 1 << (v % 64)
+	}
+	c.array = nil
+	c.bits[x/64] |= 1 << (x % 64)
+}
+
+func (c *container) orInto(dst *[1024]uint64) {
+	if c.bits != nil {
+		for i, w := range c.bits {
+			dst[i] |= w
+		}
+		return
+	}
+	for _, v := range c.array {
+		dst[v/64] |= 1 << (v % 64)
+	}
+}
+
+func (c *container) len() int {
+	if c.bits == nil {
+		return len(c.array)
+	}
+	n := 0
+	for _, w := range c.bits {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// unionCount returns the number of distinct values across maps.
+func unionCount(maps []bitmap) int {
+	if len(maps) == 1 {
+		n := 0
+		for _, c := range maps[0] {
+			n += c.len()
+		}
+		return n
+	}
+
+	his := map[uint16]bool{}
+	for _, m := range maps {
+		for hi := range m {
+			his[hi] = true
+		}
+	}
+	n := 0
+	var merged [1024]uint64
+	for hi := range his {
+		merged = [1024]uint64{}
+		for _, m := range maps {
+			if c := m[hi]; c != nil {
+				c.orInto(&merged)
+			}
+		}
+		for _, w := range merged {
+			n += bits.OnesCount64(w)
+		}
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_MarkSlot(t *testing.T) {
+	e := NewEHC(40 * time.Millisecond)
+	for _, slot := range []int{0, 5, 5, 1439, 1 << 20} {
+		e.MarkSlot("device", slot)
+	}
+	e.MarkSlot("device", -1)
+	e.MarkSlot("other", 7)
+
+	if n := e.SlotCount("device"); n != 4 {
+		t.Errorf("EHC.SlotCount(device) = %d, want 4", n)
+	}
+	if n := e.SlotCount("other"); n != 1 {
+		t.Errorf("EHC.SlotCount(other) = %d, want 1", n)
+	}
+	if n := e.SlotCount("missing"); n != 0 {
+		t.Errorf("EHC.SlotCount(missing) = %d, want 0", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if n := e.SlotCount("device"); n != 0 {
+		t.Errorf("EHC.SlotCount(device) = %d after the window, want 0", n)
+	}
+	e.slots.mu.Lock()
+	if n := len(e.slots.keys); n != 0 {
+		t.Errorf("tracked keys = %d after the window, want 0", n)
+	}
+	e.slots.mu.Unlock()
+}
+
+func TestEHC_MarkSlotGenerations(t *testing.T) {
+	e := NewEHC(80 * time.Millisecond)
+	e.MarkSlot("device", 1)
+	time.Sleep(40 * time.Millisecond)
+	e.MarkSlot("device", 1)
+	e.MarkSlot("device", 2)
+
+	if n := e.SlotCount("device"); n != 2 {
+		t.Errorf("EHC.SlotCount(device) = %d, want 2", n)
+	}
+
+	// the first mark has expired, the later ones haven't
+	time.Sleep(50 * time.Millisecond)
+	if n := e.SlotCount("device"); n != 2 {
+		t.Errorf("EHC.SlotCount(device) = %d, want 2", n)
+	}
+}
+
+func TestBitmap_Dense(t *testing.T) {
+	b := bitmap{}
+	for i := 0; i <= 2*arrayMax; i += 2 {
+		b.add(uint32(i))
+	}
+	b.add(70000)
+	if c := b[0]; c.bits == nil {
+		t.Errorf("container has %d array entries, want converted to a bitset", len(c.array))
+	}
+
+	other := bitmap{}
+	other.add(0)
+	other.add(1)
+	if n := unionCount([]bitmap{b, other}); n != arrayMax+3 {
+		t.Errorf("unionCount() = %d, want %d", n, arrayMax+3)
+	}
+}
@@ -0,0 +1,95 @@
+package ehc
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrBadSnapshot is returned by LoadSnapshot when its input isn't a snapshot
+// written by SaveSnapshot, or is of a later version.
+var ErrBadSnapshot = errors.New("ehc: not a supported snapshot")
+
+// snapshotMagic starts every snapshot, followed by its version.
+const (
+	snapshotMagic   = "EHC\x00"
+	snapshotVersion = 1
+)
+
+// snapshotKey is the live increments of one key in a snapshot, as parallel
+// slices of their counts and deadlines in Unix nanoseconds.
+type snapshotKey struct {
+	Key       interface{}
+	Counts    []int64
+	Deadlines []int64
+}
+
+// SaveSnapshot writes the live counts of the EHC to w in a compact binary
+// form, for LoadSnapshot to restore after a restart, so that rate limits
+// aren't silently reset. Each increment is saved with the wall-clock time it
+// expires. Outstanding reservations are left out.
+//
+// Keys are stored with encoding/gob, so keys of types other than the
+// predeclared ones must be registered with gob.Register.
+func (e *EHC) SaveSnapshot(w io.Writer) error {
+	now := e.clock.Now()
+	e.valueLock.RLock()
+	live := e.contributionsLocked(now)
+	e.valueLock.RUnlock()
+
+	index := map[interface{}]int{}
+	var keys []snapshotKey
+	for _, c := range live {
+		i, ok := index[c.key]
+		if !ok {
+			i = len(keys)
+			index[c.key] = i
+			keys = append(keys, snapshotKey{Key: c.key})
+		}
+		keys[i].Counts = append(keys[i].Counts, c.count)
+		keys[i].Deadlines = append(keys[i].Deadlines, c.deadline.UnixNano())
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+	if err := gob.NewEncoder(bw).Encode(keys); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LoadSnapshot adds the counts written by SaveSnapshot to the EHC, each
+// increment expiring at the wall-clock time it originally would have, so that
+// the time the process was down counts against them; those that expired
+// meanwhile are skipped. It fails with ErrClosed if the EHC is closed.
+func (e *EHC) LoadSnapshot(r io.Reader) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrBadSnapshot
+		}
+		return err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic || header[len(snapshotMagic)] > snapshotVersion {
+		return ErrBadSnapshot
+	}
+
+	var keys []snapshotKey
+	if err := gob.NewDecoder(br).Decode(&keys); err != nil {
+		return err
+	}
+	var live []contribution
+	for _, k := range keys {
+		if len(k.Counts) != len(k.Deadlines) {
+			return ErrBadSnapshot
+		}
+		for i, n := range k.Counts {
+			live = append(live, contribution{key: k.Key, count: n, deadline: time.Unix(0, k.Deadlines[i])})
+		}
+	}
+	return e.restore(live)
+}
@@ -0,0 +1,54 @@
+package ehc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEHC_SaveSnapshot(t *testing.T) {
+	start := time.Unix(0, 0)
+	e := NewManualEHC(time.Minute, start)
+	e.CountMultiple("a", 2)
+	e.CountMultiple(7, 5)
+	e.Tick(20 * time.Second)
+	e.Count("a")
+
+	var buf bytes.Buffer
+	if err := e.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// restarted 30 seconds later, the increments still expire when they
+	// would have
+	restored := NewManualEHC(time.Minute, start.Add(50*time.Second))
+	if err := restored.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if a, n := restored.value("a"), restored.value(7); a != 3 || n != 5 {
+		t.Errorf("restored a = %d and 7 = %d, want 3 and 5", a, n)
+	}
+	restored.Tick(10 * time.Second)
+	if a, n := restored.value("a"), restored.value(7); a != 1 || n != 0 {
+		t.Errorf("restored a = %d and 7 = %d once the first increments expired, want 1 and 0", a, n)
+	}
+
+	// increments that expired while down are skipped
+	late := NewManualEHC(time.Minute, start.Add(70*time.Second), WithBuckets(6))
+	if err := late.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if s := late.Snapshot(); len(s) != 1 || s["a"] != 1 {
+		t.Errorf("counts restored late = %v, want a: 1", s)
+	}
+}
+
+func TestEHC_LoadSnapshotInvalid(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	for _, in := range []string{"", "EHC", "not a snapshot", "EHC\x00\x09"} {
+		if err := e.LoadSnapshot(strings.NewReader(in)); err != ErrBadSnapshot {
+			t.Errorf("LoadSnapshot(%q) = %v, want ErrBadSnapshot", in, err)
+		}
+	}
+}
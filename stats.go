@@ -0,0 +1,83 @@
+package ehc
+
+import "sync/atomic"
+
+// Stats is a point-in-time summary of an EHC's own bookkeeping.
+type Stats struct {
+	// Dropped is the number of Count calls that were discarded because
+	// their key was rejected.
+	Dropped int64
+
+	// Truncated is the number of Count calls whose key was shortened to
+	// fit the configured maximum key size.
+	Truncated int64
+
+	// Bypassed is the number of events for keys exempted by WithBypass.
+	Bypassed int64
+
+	// ArenaChunks is the number of arena chunks still holding live
+	// counters. It is always zero unless WithArena is used.
+	ArenaChunks int64
+
+	// Compactions is the number of times the map was rebuilt to release
+	// memory after a spike of keys expired.
+	Compactions int64
+
+	// MaintenanceDeferred is the number of housekeeping tasks postponed
+	// because the maintenance budget was exhausted.
+	MaintenanceDeferred int64
+
+	// TotalsDiscarded is the number of window totals discarded unacknowledged
+	// because a sink registered with OnWindowCompleteAck fell too far behind.
+	TotalsDiscarded int64
+
+	// CallbackPanics is the number of panics recovered from callbacks,
+	// such as those registered with OnWindowComplete.
+	CallbackPanics int64
+
+	// CallbacksDropped is the number of callback deliveries dropped
+	// because the queue of WithCallbackWorkers was full.
+	CallbacksDropped int64
+
+	// PeakKeys is the largest number of keys in the history of
+	// WithKeyHistory. It is always zero without it.
+	PeakKeys int64
+}
+
+// stats holds the live atomic counters behind Stats.
+type stats struct {
+	dropped             int64
+	truncated           int64
+	bypassed            int64
+	compactions         int64
+	maintenanceDeferred int64
+	totalsDiscarded     int64
+	callbackPanics      int64
+	callbacksDropped    int64
+	// sweeps and sweepNanos count the firings of the timing wheel and
+	// the time they took; they are read through ReadMetrics.
+	sweeps     int64
+	sweepNanos int64
+}
+
+// Stats returns a snapshot of the EHC's internal counters.
+func (e *EHC) Stats() Stats {
+	s := Stats{
+		Dropped:   atomic.LoadInt64(&e.stats.dropped),
+		Truncated: atomic.LoadInt64(&e.stats.truncated),
+		Bypassed:  atomic.LoadInt64(&e.stats.bypassed),
+
+		Compactions:         atomic.LoadInt64(&e.stats.compactions),
+		MaintenanceDeferred: atomic.LoadInt64(&e.stats.maintenanceDeferred),
+		TotalsDiscarded:     atomic.LoadInt64(&e.stats.totalsDiscarded),
+		CallbackPanics:      atomic.LoadInt64(&e.stats.callbackPanics),
+		CallbacksDropped:    atomic.LoadInt64(&e.stats.callbacksDropped),
+	}
+	if e.keyHistory != nil {
+		s.PeakKeys = int64(e.keyHistory.peak())
+	}
+	if e.arena != nil {
+		s.ArenaChunks = atomic.LoadInt64(&e.arena.chunks)
+	}
+	return s
+}
@@ -0,0 +1,230 @@
+package ehc
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+// Store holds the counters of the default mode, keyed by normalized key, so
+// that the counting and expiry logic doesn't depend on how they are stored.
+//
+// The EHC serializes access to its store: Get, Len and Range are called under
+// its read lock and may run concurrently with each other, while Put and
+// Delete are called under its write lock and run alone. A store needs no
+// locking of its own for that.
+//
+// Stores are in-process: the counters they hold are live objects carrying
+// their own locks and pending expirations, not counts that could be written
+// out. Keeping counts elsewhere, as in Redis, is done with an ExpiryStrategy
+// instead; see WithExpiryStrategy and the ehcredis package.
+type Store interface {
+	// Get returns the counter stored for key, or nil.
+	Get(key interface{}) Counter
+
+	// Put stores c for key, which has no counter yet.
+	Put(key interface{}, c Counter)
+
+	// Delete removes the counter stored for key.
+	Delete(key interface{})
+
+	// Len returns the number of counters stored.
+	Len() int
+
+	// Range calls fn for every counter until it returns false.
+	Range(fn func(key interface{}, c Counter) bool)
+
+	// IterateBatch returns a batch of about n entries starting at
+	// cursor, and the cursor of the next batch, like Redis' SCAN: the
+	// first call passes cursor 0, and a returned cursor of 0 means the
+	// iteration is complete. n is only a hint, so that stores can batch
+	// however suits them, such as a shard at a time. Every entry stored
+	// for the whole iteration is returned exactly once.
+	IterateBatch(cursor uint64, n int) ([]StoreEntry, uint64)
+}
+
+// StoreEntry is a key and its counter, as returned by Store.IterateBatch.
+type StoreEntry struct {
+	Key     interface{}
+	Counter Counter
+}
+
+// rangeBatch implements IterateBatch for stores that can't resume an
+// iteration, by returning everything in one batch.
+func rangeBatch(s Store) ([]StoreEntry, uint64) {
+	entries := make([]StoreEntry, 0, s.Len())
+	s.Range(func(k interface{}, c Counter) bool {
+		entries = append(entries, StoreEntry{k, c})
+		return true
+	})
+	return entries, 0
+}
+
+// WithStore keeps the counters in stores created by newStore, instead of a
+// plain map. The EHC creates a fresh store whenever it compacts or migrates.
+//
+// It only applies to the default timer mode.
+func WithStore(newStore func() Store) Option {
+	return func(c *config) {
+		c.newStore = newStore
+	}
+}
+
+// store returns an empty store as configured.
+func (c *config) store() Store {
+	if c.newStore != nil {
+		return c.newStore()
+	}
+	return NewMapStore()
+}
+
+// NewMapStore returns a Store backed by a single map, which is the default.
+func NewMapStore() Store {
+	return mapStore{}
+}
+
+type mapStore map[interface{}]Counter
+
+func (s mapStore) Get(key interface{}) Counter {
+	return s[key]
+}
+
+func (s mapStore) Put(key interface{}, c Counter) {
+	s[key] = c
+}
+
+func (s mapStore) Delete(key interface{}) {
+	delete(s, key)
+}
+
+func (s mapStore) Len() int {
+	return len(s)
+}
+
+func (s mapStore) Range(fn func(key interface{}, c Counter) bool) {
+	for k, c := range s {
+		if !fn(k, c) {
+			return
+		}
+	}
+}
+
+func (s mapStore) IterateBatch(uint64, int) ([]StoreEntry, uint64) {
+	return rangeBatch(s)
+}
+
+// NewSyncMapStore returns a Store backed by a sync.Map, which suits maps
+// whose keys are mostly read and rarely replaced: its reads never contend on
+// shared cache lines.
+func NewSyncMapStore() Store {
+	return &syncMapStore{}
+}
+
+type syncMapStore struct {
+	// n is kept first so that it stays aligned on 32-bit platforms.
+	n int64
+	m sync.Map
+}
+
+func (s *syncMapStore) Get(key interface{}) Counter {
+	if c, ok := s.m.Load(key); ok {
+		return c.(Counter)
+	}
+	return nil
+}
+
+func (s *syncMapStore) Put(key interface{}, c Counter) {
+	s.m.Store(key, c)
+	atomic.AddInt64(&s.n, 1)
+}
+
+func (s *syncMapStore) Delete(key interface{}) {
+	if _, ok := s.m.LoadAndDelete(key); ok {
+		atomic.AddInt64(&s.n, -1)
+	}
+}
+
+func (s *syncMapStore) Len() int {
+	return int(atomic.LoadInt64(&s.n))
+}
+
+func (s *syncMapStore) Range(fn func(key interface{}, c Counter) bool) {
+	s.m.Range(func(k, c interface{}) bool {
+		return fn(k, c.(Counter))
+	})
+}
+
+func (s *syncMapStore) IterateBatch(uint64, int) ([]StoreEntry, uint64) {
+	return rangeBatch(s)
+}
+
+// NewShardedStore returns a Store that spreads the counters over n maps by
+// the hash of their keys. Each map grows, and has to be rehashed, separately,
+// so a very large key set never stalls the EHC on one huge rehash. Keys must
+// be comparable without panicking, which every valid map key is.
+func NewShardedStore(n int) Store {
+	if n < 1 {
+		n = 1
+	}
+	s := &shardedStore{seed: maphash.MakeSeed(), shards: make([]map[interface{}]Counter, n)}
+	for i := range s.shards {
+		s.shards[i] = map[interface{}]Counter{}
+	}
+	return s
+}
+
+type shardedStore struct {
+	seed   maphash.Seed
+	shards []map[interface{}]Counter
+	n      int
+}
+
+func (s *shardedStore) shard(key interface{}) map[interface{}]Counter {
+	return s.shards[maphash.Comparable(s.seed, key)%uint64(len(s.shards))]
+}
+
+func (s *shardedStore) Get(key interface{}) Counter {
+	return s.shard(key)[key]
+}
+
+func (s *shardedStore) Put(key interface{}, c Counter) {
+	s.shard(key)[key] = c
+	s.n++
+}
+
+func (s *shardedStore) Delete(key interface{}) {
+	shard := s.shard(key)
+	if _, ok := shard[key]; ok {
+		delete(shard, key)
+		s.n--
+	}
+}
+
+func (s *shardedStore) Len() int {
+	return s.n
+}
+
+func (s *shardedStore) Range(fn func(key interface{}, c Counter) bool) {
+	for _, shard := range s.shards {
+		for k, c := range shard {
+			if !fn(k, c) {
+				return
+			}
+		}
+	}
+}
+
+// IterateBatch returns whole shards, starting at the shard numbered cursor,
+// until the batch holds at least n entries.
+func (s *shardedStore) IterateBatch(cursor uint64, n int) ([]StoreEntry, uint64) {
+	var entries []StoreEntry
+	for i := cursor; i < uint64(len(s.shards)); i++ {
+		for k, c := range s.shards[i] {
+			entries = append(entries, StoreEntry{k, c})
+		}
+		if len(entries) >= n && i+1 < uint64(len(s.shards)) {
+			return entries, i + 1
+		}
+	}
+	return entries, 0
+}
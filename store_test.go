@@ -0,0 +1,99 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_Stores(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func() Store
+	}{
+		{"map", NewMapStore},
+		{"syncmap", NewSyncMapStore},
+		{"sharded", func() Store { return NewShardedStore(4) }},
+	}
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			e := NewManualEHC(time.Minute, time.Unix(0, 0), WithStore(s.store))
+			for i := 0; i < 10; i++ {
+				e.CountMultiple(i, int64(i+1))
+			}
+			if v := e.value(3); v != 4 {
+				t.Errorf("EHC count of 3 = %d, want 4", v)
+			}
+			if n := e.keys(); n != 10 {
+				t.Errorf("Store.Len() = %d, want 10", n)
+			}
+
+			values, locker := e.Values()
+			if len(values) != 10 || values[9].Value() != 10 {
+				t.Errorf("EHC.Values() = %v, want 10 keys", values)
+			}
+			locker.Unlock()
+
+			e.MigrateTo(WithStore(s.store))
+			e.Tick(time.Minute)
+			if n := e.keys(); n != 0 {
+				t.Errorf("Store.Len() = %d after the window, want 0", n)
+			}
+		})
+	}
+}
+
+func TestShardedStore(t *testing.T) {
+	s := NewShardedStore(3)
+	for i := 0; i < 100; i++ {
+		s.Put(i, fixedCounter(i))
+	}
+	s.Delete(5)
+	s.Delete(5)
+	if n := s.Len(); n != 99 {
+		t.Errorf("Len() = %d, want 99", n)
+	}
+	if c := s.Get(7); c == nil || c.Value() != 7 {
+		t.Errorf("Get(7) = %v, want 7", c)
+	}
+	seen := 0
+	s.Range(func(interface{}, Counter) bool {
+		seen++
+		return seen < 10
+	})
+	if seen != 10 {
+		t.Errorf("Range() visited %d counters after stopping at 10", seen)
+	}
+}
+
+func TestStore_IterateBatch(t *testing.T) {
+	for _, s := range []Store{NewMapStore(), NewSyncMapStore(), NewShardedStore(8)} {
+		for i := 0; i < 100; i++ {
+			s.Put(i, fixedCounter(i))
+		}
+
+		seen := map[interface{}]int{}
+		batches := 0
+		for cursor := uint64(0); ; {
+			var batch []StoreEntry
+			batch, cursor = s.IterateBatch(cursor, 10)
+			batches++
+			for _, entry := range batch {
+				seen[entry.Key]++
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+		if len(seen) != 100 {
+			t.Errorf("%T.IterateBatch() returned %d keys, want 100", s, len(seen))
+		}
+		for k, n := range seen {
+			if n != 1 {
+				t.Errorf("%T.IterateBatch() returned %v %d times", s, k, n)
+			}
+		}
+		if _, sharded := s.(*shardedStore); sharded && batches < 2 {
+			t.Errorf("%T.IterateBatch() returned everything in one batch", s)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package ehc
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ThresholdFunc is told that the count of key has reached a threshold, and
+// what the count was then.
+type ThresholdFunc func(key interface{}, value int64)
+
+// OnThreshold registers fn to be told whenever the count of any key reaches
+// threshold. It is told once per crossing: the key is re-armed when its
+// count falls back below threshold, which is noticed within a sixteenth of
+// the window, as counts expire in the background, and fn is told again the
+// next time it gets there. fn runs like the other callbacks; see
+// WithCallbackWorkers and WithPanicHandler.
+func (e *EHC) OnThreshold(threshold int64, fn ThresholdFunc) {
+	t := e.thresholdTracker()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	watches, _ := t.watches.Load().([]*thresholdWatch)
+	t.watches.Store(append(watches[:len(watches):len(watches)], &thresholdWatch{
+		threshold: threshold,
+		fn:        fn,
+	}))
+}
+
+func (e *EHC) thresholdTracker() *thresholds {
+	e.thresholdsOnce.Do(func() {
+		e.thresholds.Store(&thresholds{})
+	})
+	return e.thresholds.Load().(*thresholds)
+}
+
+// thresholds tracks the thresholds registered with OnThreshold. Counting
+// only reads them, without locking, unless a key crosses a threshold.
+type thresholds struct {
+	// watches is the []*thresholdWatch registered, replaced whole
+	// under mu.
+	watches atomic.Value
+
+	// mu guards the timer that checks the crossed keys for falling
+	// back, which is armed while there may be any.
+	mu    sync.Mutex
+	timer Timer
+	armed bool
+}
+
+// thresholdWatch is one threshold, and the keys at or over it.
+type thresholdWatch struct {
+	threshold int64
+	fn        ThresholdFunc
+	// crossed holds the keys at or over the threshold. Counting only
+	// adds keys, as counts only fall as they expire, so it is only the
+	// timer that removes them.
+	crossed sync.Map
+}
+
+// countedThresholds checks whether a count just made for a normalized key
+// took it to any threshold.
+func (e *EHC) countedThresholds(key interface{}) {
+	t, _ := e.thresholds.Load().(*thresholds)
+	if t == nil {
+		return
+	}
+	watches, _ := t.watches.Load().([]*thresholdWatch)
+	if len(watches) == 0 {
+		return
+	}
+	value := e.value(key)
+	var fns []ThresholdFunc
+	for _, w := range watches {
+		if value >= w.threshold && w.cross(key) {
+			fns = append(fns, w.fn)
+		}
+	}
+	e.crossed(t, key, value, fns)
+}
+
+// cross marks key as at or over the threshold, reporting whether it wasn't
+// already.
+func (w *thresholdWatch) cross(key interface{}) bool {
+	if _, ok := w.crossed.Load(key); ok {
+		return false
+	}
+	_, loaded := w.crossed.LoadOrStore(key, struct{}{})
+	return !loaded
+}
+
+// crossed tells fns that key crossed their thresholds at value, and arms the
+// timer to notice it falling back.
+func (e *EHC) crossed(t *thresholds, key interface{}, value int64, fns []ThresholdFunc) {
+	if len(fns) == 0 {
+		return
+	}
+	t.mu.Lock()
+	e.armThresholdsLocked(t)
+	t.mu.Unlock()
+
+	e.submitCallback(func() {
+		for _, fn := range fns {
+			protect("OnThreshold", e.handlePanic, func() {
+				fn(key, value)
+			})
+		}
+	})
+}
+
+// armThresholdsLocked schedules the next check for keys falling back below
+// their thresholds, unless one is already scheduled. t.mu must be held.
+func (e *EHC) armThresholdsLocked(t *thresholds) {
+	if t.armed {
+		return
+	}
+	t.armed = true
+	d := e.window / budgetChecks
+	if d <= 0 {
+		d = e.window
+	}
+	if t.timer == nil {
+		t.timer = e.clock.AfterFunc(d, func() { e.rearmThresholds(t) })
+	} else {
+		t.timer.Reset(d)
+	}
+}
+
+// rearmThresholds re-arms every crossed key that fell back below its
+// threshold.
+func (e *EHC) rearmThresholds(t *thresholds) {
+	t.mu.Lock()
+	t.armed = false
+	t.mu.Unlock()
+
+	watches, _ := t.watches.Load().([]*thresholdWatch)
+	more := false
+	for _, w := range watches {
+		w.crossed.Range(func(key, _ interface{}) bool {
+			if e.value(key) >= w.threshold {
+				more = true
+				return true
+			}
+			w.crossed.Delete(key)
+			// a count racing with the read above may have found the
+			// key still crossed, so look again now that it isn't
+			if value := e.value(key); value >= w.threshold && w.cross(key) {
+				e.crossed(t, key, value, []ThresholdFunc{w.fn})
+			}
+			return true
+		})
+	}
+	if more {
+		t.mu.Lock()
+		e.armThresholdsLocked(t)
+		t.mu.Unlock()
+	}
+}
+
+func (t *thresholds) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.armed = false
+	watches, _ := t.watches.Load().([]*thresholdWatch)
+	for _, w := range watches {
+		w.crossed.Clear()
+	}
+}
@@ -0,0 +1,92 @@
+package ehc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type thresholdEvent struct {
+	key   interface{}
+	value int64
+}
+
+func TestEHC_OnThreshold(t *testing.T) {
+	const window = 16 * time.Second
+	e := NewManualEHC(window, time.Unix(0, 0))
+	var events []thresholdEvent
+	e.OnThreshold(3, func(key interface{}, value int64) {
+		events = append(events, thresholdEvent{key, value})
+	})
+
+	e.CountMultiple("a", 2)
+	if len(events) != 0 {
+		t.Fatalf("events = %v below the threshold, want none", events)
+	}
+	e.Tick(4 * time.Second)
+	e.Count("a")
+	e.Count("a")
+	e.Count("a")
+	e.CountMultiple("b", 5)
+	want := []thresholdEvent{{"a", 3}, {"b", 5}}
+	if len(events) != 2 || events[0] != want[0] || events[1] != want[1] {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+
+	// the first counts of a expire, but it stays at the threshold
+	e.Tick(window - 4*time.Second)
+	e.Tick(time.Second)
+	e.Count("a")
+	if len(events) != 2 {
+		t.Fatalf("events = %v without a new crossing, want 2", events)
+	}
+
+	// once everything expires and the keys are re-armed, crossing again
+	// is told again
+	e.Tick(window)
+	e.CountMultiple("a", 3)
+	if len(events) != 3 || events[2] != (thresholdEvent{"a", 3}) {
+		t.Fatalf("events = %v, want a crossing again", events)
+	}
+
+	e.Close()
+	e.Count("b")
+	if len(events) != 3 {
+		t.Errorf("events = %v after Close, want no more", events)
+	}
+}
+
+func TestEHC_OnThresholdConcurrent(t *testing.T) {
+	e := NewEHC(time.Hour)
+	defer e.Close()
+	var mu sync.Mutex
+	told := map[interface{}]int{}
+	e.OnThreshold(50, func(key interface{}, value int64) {
+		mu.Lock()
+		told[key]++
+		mu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				e.Count(i % 10)
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(told) != 10 {
+		t.Errorf("told of %d keys, want 10", len(told))
+	}
+	for key, n := range told {
+		if n != 1 {
+			t.Errorf("told of %v %d times, want once", key, n)
+		}
+	}
+}
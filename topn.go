@@ -0,0 +1,71 @@
+package ehc
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// KeyCount is a key together with its count.
+type KeyCount struct {
+	Key   interface{}
+	Count int64
+}
+
+// TopN returns the n keys with the highest counts, highest first, for "top
+// talkers" dashboards and abuse detection. It makes a single pass over the
+// keys under the read lock, keeping only the n highest so far, so it costs
+// far less than copying and sorting Values. Keys with a count of zero are
+// left out, and ties are broken arbitrarily.
+func (e *EHC) TopN(n int) []KeyCount {
+	if n <= 0 {
+		return nil
+	}
+	top := make(topHeap, 0, n)
+	offer := func(key interface{}, count int64) {
+		switch {
+		case count <= 0:
+		case len(top) < n:
+			heap.Push(&top, KeyCount{key, count})
+		case count > top[0].Count:
+			top[0] = KeyCount{key, count}
+			heap.Fix(&top, 0)
+		}
+	}
+
+	e.valueLock.RLock()
+	if e.expiry != nil {
+		for key, count := range e.expiry.Snapshot(e.now()) {
+			offer(key, count)
+		}
+	} else {
+		e.rangeCounters(func(key interface{}, c *counter) bool {
+			offer(key, c.Value())
+			return true
+		})
+	}
+	e.valueLock.RUnlock()
+
+	sort.Slice(top, func(i, j int) bool {
+		return top[i].Count > top[j].Count
+	})
+	return top
+}
+
+// topHeap is a min-heap of counts, so that the lowest of the highest counts
+// seen so far is the one to replace.
+type topHeap []KeyCount
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h topHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *topHeap) Push(x interface{}) {
+	*h = append(*h, x.(KeyCount))
+}
+
+func (h *topHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
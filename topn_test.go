@@ -0,0 +1,37 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_TopN(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithShards(4)}, {WithGenerations(4)}} {
+		e := NewEHC(time.Minute, opts...)
+		for i := 1; i <= 100; i++ {
+			e.CountMultiple(i, int64(i))
+		}
+
+		got := e.TopN(3)
+		if len(got) != 3 || got[0] != (KeyCount{100, 100}) || got[1] != (KeyCount{99, 99}) || got[2] != (KeyCount{98, 98}) {
+			t.Errorf("%d options: EHC.TopN(3) = %v, want 100, 99 and 98", len(opts), got)
+		}
+		if got := e.TopN(1000); len(got) != 100 || got[99] != (KeyCount{1, 1}) {
+			t.Errorf("%d options: EHC.TopN(1000) has %d keys, want all 100, lowest last", len(opts), len(got))
+		}
+		if got := e.TopN(0); got != nil {
+			t.Errorf("%d options: EHC.TopN(0) = %v, want nil", len(opts), got)
+		}
+	}
+}
+
+func BenchmarkEHC_TopN(b *testing.B) {
+	e := NewEHC(time.Minute)
+	for i := 0; i < 10000; i++ {
+		e.CountMultiple(i, int64(i%100))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.TopN(10)
+	}
+}
@@ -0,0 +1,30 @@
+package ehc
+
+import "sync/atomic"
+
+// Total returns the sum of the counts of every key, as for a check on the
+// throughput of the whole system. In the default timer mode it is kept up to
+// date as keys are counted and their counts expire, so reading it is O(1),
+// unlike summing Snapshot; it leaves out, like Snapshot, the counts held in
+// the overflow sketch of WithMaxMemory. With an ExpiryStrategy it is the sum
+// of the strategy's Snapshot.
+func (e *EHC) Total() int64 {
+	e.valueLock.RLock()
+	defer e.valueLock.RUnlock()
+
+	if e.expiry != nil {
+		var total int64
+		for _, n := range e.expiry.Snapshot(e.now()) {
+			total += n
+		}
+		return total
+	}
+	return atomic.LoadInt64(&e.total)
+}
+
+// addCount adds n to the count of c and to the total of its EHC, returning
+// the new count.
+func (c *counter) addCount(n int64) int64 {
+	atomic.AddInt64(&c.parent.total, n)
+	return atomic.AddInt64(&c.count, n)
+}
@@ -0,0 +1,71 @@
+package ehc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEHC_Total(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"timer", nil},
+		{"generations", []Option{WithGenerations(4)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewManualEHC(time.Minute, time.Unix(0, 0), tt.opts...)
+			e.CountMultiple("a", 3)
+			e.Tick(30 * time.Second)
+			e.CountMultiple("b", 2)
+			e.Count("c")
+			if got := e.Total(); got != 6 {
+				t.Errorf("Total() = %d, want 6", got)
+			}
+
+			e.Expire("c")
+			if got := e.Total(); got != 5 {
+				t.Errorf("Total() = %d after Expire, want 5", got)
+			}
+			e.Tick(30 * time.Second)
+			if got := e.Total(); got != 2 {
+				t.Errorf("Total() = %d once a expired, want 2", got)
+			}
+
+			// carried over by migrations both ways
+			e.MigrateTo(WithBuckets(4))
+			e.MigrateTo()
+			if got := e.Total(); got != 2 {
+				t.Errorf("Total() = %d after MigrateTo, want 2", got)
+			}
+			e.Tick(30 * time.Second)
+			if got := e.Total(); got != 0 {
+				t.Errorf("Total() = %d once everything expired, want 0", got)
+			}
+		})
+	}
+}
+
+func TestEHC_TotalConcurrent(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				e.Count(i % (g + 1))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	var sum int64
+	for _, n := range e.Snapshot() {
+		sum += n
+	}
+	if got := e.Total(); got != 8000 || sum != 8000 {
+		t.Errorf("Total() = %d, and Snapshot sums to %d, want 8000", got, sum)
+	}
+}
@@ -0,0 +1,121 @@
+package ehc
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Trend is a key's growth between the two halves of the window.
+type Trend struct {
+	Key interface{}
+
+	// Current is the key's count over the most recent half of the
+	// window, and Previous its count over the half before that.
+	Current, Previous int64
+
+	// Growth is (Current+1)/(Previous+1), so that keys that were
+	// absent rank by their current count rather than dividing by zero,
+	// and a handful of events on a quiet key doesn't outrank a busy key
+	// that doubled.
+	Growth float64
+}
+
+// Trending returns the k keys whose counts grew the most from the previous
+// half of the window to the current one, fastest growing first. Unlike
+// ranking by count, this surfaces keys that have just become hot even while
+// steady heavyweights still dominate the totals. Keys not counted in the
+// current half are never trending.
+//
+// It is supported in the default timer mode and in generation and bucket
+// modes, where the halves are rounded to whole generations or buckets; with a
+// custom ExpiryStrategy it returns nil.
+func (e *EHC) Trending(k int) []Trend {
+	e.valueLock.RLock()
+	cur, prev := e.halvesLocked(e.now())
+	e.valueLock.RUnlock()
+
+	trends := make([]Trend, 0, len(cur))
+	for key, n := range cur {
+		if n <= 0 {
+			continue
+		}
+		p := prev[key]
+		trends = append(trends, Trend{
+			Key:      key,
+			Current:  n,
+			Previous: p,
+			Growth:   float64(n+1) / float64(p+1),
+		})
+	}
+	sort.Slice(trends, func(i, j int) bool {
+		if trends[i].Growth != trends[j].Growth {
+			return trends[i].Growth > trends[j].Growth
+		}
+		return trends[i].Current > trends[j].Current
+	})
+	if len(trends) > k {
+		trends = trends[:k]
+	}
+	return trends
+}
+
+// halver is implemented by the built-in strategies, which can tell when
+// their counts were made.
+type halver interface {
+	// halves adds every key's count made in the current half of the
+	// window at now to cur, and the rest to prev.
+	halves(now time.Time, cur, prev map[interface{}]int64)
+}
+
+// halvesLocked splits every key's count into the current and the previous
+// half of the window. valueLock must be held.
+func (e *EHC) halvesLocked(now time.Time) (cur, prev map[interface{}]int64) {
+	cur, prev = map[interface{}]int64{}, map[interface{}]int64{}
+	if e.expiry != nil {
+		if s, ok := e.expiry.(halver); ok {
+			s.halves(now, cur, prev)
+		}
+		return cur, prev
+	}
+
+	// increments of the current half expire in more than half a window
+	split := now.Add(e.window / 2)
+	e.rangeCounters(func(key interface{}, c *counter) bool {
+		c.mu.Lock()
+		for _, r := range c.pending {
+			if r.deadline.After(split) {
+				cur[key] += r.count
+			} else {
+				prev[key] += r.count
+			}
+		}
+		c.mu.Unlock()
+		return true
+	})
+	return cur, prev
+}
+
+// halves adds every key's count in the generations starting in the current
+// half of the window to cur, and the rest to prev.
+func (g *generations) halves(now time.Time, cur, prev map[interface{}]int64) {
+	epoch := g.epoch(now)
+	n := int64(len(g.slots))
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for i := range g.slots {
+		slot := &g.slots[i]
+		if !g.live(slot.epoch, epoch) {
+			continue
+		}
+		into := prev
+		if age := epoch - slot.epoch; age < n/2 || n == 1 {
+			into = cur
+		}
+		for k, p := range slot.counts {
+			into[k] += atomic.LoadInt64(p)
+		}
+	}
+}
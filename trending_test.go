@@ -0,0 +1,38 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_Trending(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithGenerations(4)}, {WithBuckets(4)}} {
+		e := NewManualEHC(time.Minute, time.Unix(0, 0), opts...)
+		e.CountMultiple("steady", 100)
+		e.CountMultiple("fading", 20)
+		e.Tick(30 * time.Second)
+		e.CountMultiple("steady", 100)
+		e.CountMultiple("new", 10)
+		e.CountMultiple("doubling", 4)
+		e.Tick(15 * time.Second)
+		e.CountMultiple("doubling", 4)
+
+		got := e.Trending(2)
+		if len(got) != 2 || got[0].Key != "new" || got[1].Key != "doubling" {
+			t.Fatalf("EHC.Trending(2) = %+v, want new then doubling", got)
+		}
+		if got[0].Current != 10 || got[0].Previous != 0 || got[0].Growth != 11 {
+			t.Errorf("EHC.Trending(2)[0] = %+v, want 10 from 0", got[0])
+		}
+
+		all := e.Trending(10)
+		for _, trend := range all {
+			if trend.Key == "fading" {
+				t.Errorf("EHC.Trending() includes %+v, which wasn't counted lately", trend)
+			}
+		}
+		if len(all) != 3 {
+			t.Errorf("EHC.Trending(10) = %+v, want 3 keys", all)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package ehc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Map is an EHC whose keys all have type K, so that passing a key of the
+// wrong type is caught at compile time, e.g. NewMap[string](time.Minute).
+// It wraps an EHC, which EHC returns for the features that aren't repeated
+// here.
+//
+// Map only types the keys at compile time. The keys are still converted to
+// interface values and stored as in the EHC, so counting allocates and
+// costs just the same, as BenchmarkMap_Count shows.
+type Map[K comparable] struct {
+	e *EHC
+}
+
+// NewMap returns a Map counting over window, configured as by NewEHC.
+func NewMap[K comparable](window time.Duration, opts ...Option) *Map[K] {
+	return &Map[K]{e: NewEHC(window, opts...)}
+}
+
+// EHC returns the EHC underlying m. Counts made through it under keys not
+// of type K are left out of m.Values, m.Snapshot and m.Keys.
+func (m *Map[K]) EHC() *EHC {
+	return m.e
+}
+
+// Window returns the measurement window the Map was created with.
+func (m *Map[K]) Window() time.Duration {
+	return m.e.Window()
+}
+
+// Count increments the counter mapped to key by 1.
+func (m *Map[K]) Count(key K) {
+	m.e.CountMultiple(key, 1)
+}
+
+// CountMultiple is like EHC.CountMultiple.
+func (m *Map[K]) CountMultiple(key K, count int64) {
+	m.e.CountMultiple(key, count)
+}
+
+// CountCost is like EHC.CountCost.
+func (m *Map[K]) CountCost(key K, cost int64) {
+	m.e.CountCost(key, cost)
+}
+
+// Reserve is like EHC.Reserve.
+func (m *Map[K]) Reserve(key K, cost, limit int64) (*Reservation, bool) {
+	return m.e.Reserve(key, cost, limit)
+}
+
+// Allow is like EHC.Allow.
+func (m *Map[K]) Allow(key K, limit int64) bool {
+	return m.e.Allow(key, limit)
+}
+
+// AllowN is like EHC.AllowN.
+func (m *Map[K]) AllowN(key K, n, limit int64) bool {
+	return m.e.AllowN(key, n, limit)
+}
+
+// ReservePriority is like EHC.ReservePriority.
+func (m *Map[K]) ReservePriority(key K, cost, limit int64, class int) (*Reservation, bool) {
+	return m.e.ReservePriority(key, cost, limit, class)
+}
+
+// WaitAllow is like EHC.WaitAllow.
+func (m *Map[K]) WaitAllow(ctx context.Context, key K, limit int64) error {
+	return m.e.WaitAllow(ctx, key, limit)
+}
+
+// Value returns the current count of key.
+func (m *Map[K]) Value(key K) int64 {
+	m.e.valueLock.RLock()
+	k, ok := m.e.normalizeKey(key)
+	m.e.valueLock.RUnlock()
+	if !ok {
+		return 0
+	}
+	return m.e.value(k)
+}
+
+// Get is like EHC.Get.
+func (m *Map[K]) Get(key K) (int64, bool) {
+	return m.e.Get(key)
+}
+
+// Values is like EHC.Values, except that the map is always a copy, holding
+// only the keys of type K: keys of other types, counted through EHC, are
+// silently left out.
+func (m *Map[K]) Values() (map[K]Counter, sync.Locker) {
+	values, locker := m.e.Values()
+	typed := make(map[K]Counter, len(values))
+	for k, c := range values {
+		if k, ok := k.(K); ok {
+			typed[k] = c
+		}
+	}
+	return typed, locker
+}
+
+// Snapshot is like EHC.Snapshot, holding only the keys of type K, and
+// silently leaving out those of other types, as Values does.
+func (m *Map[K]) Snapshot() map[K]int64 {
+	counts := m.e.Snapshot()
+	typed := make(map[K]int64, len(counts))
+	for k, n := range counts {
+		if k, ok := k.(K); ok {
+			typed[k] = n
+		}
+	}
+	return typed
+}
+
+// Keys is like EHC.Keys, holding only the keys of type K, and silently
+// leaving out those of other types, as Values does.
+func (m *Map[K]) Keys() []K {
+	var typed []K
+	for _, k := range m.e.Keys() {
+		if k, ok := k.(K); ok {
+			typed = append(typed, k)
+		}
+	}
+	return typed
+}
+
+// Block is like EHC.Block.
+func (m *Map[K]) Block(key K, d time.Duration) {
+	m.e.Block(key, d)
+}
+
+// Unblock is like EHC.Unblock.
+func (m *Map[K]) Unblock(key K) {
+	m.e.Unblock(key)
+}
+
+// IsBlocked is like EHC.IsBlocked.
+func (m *Map[K]) IsBlocked(key K) bool {
+	return m.e.IsBlocked(key)
+}
@@ -0,0 +1,57 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMap(t *testing.T) {
+	m := NewMap[string](20*time.Millisecond, WithMaxKeySize(4, TruncateOversized))
+	m.Count("a")
+	m.CountMultiple("a", 2)
+	m.Count("longer")
+	m.EHC().Count(42)
+
+	if v := m.Value("a"); v != 3 {
+		t.Errorf("Map.Value(a) = %d, want 3", v)
+	}
+	if v := m.Value("longer"); v != 1 {
+		t.Errorf("Map.Value(longer) = %d, want 1 under its truncated key", v)
+	}
+
+	values, locker := m.Values()
+	if len(values) != 2 || values["a"].Value() != 3 || values["long"].Value() != 1 {
+		t.Errorf("Map.Values() has %d keys, want a and long only", len(values))
+	}
+	locker.Unlock()
+	if snapshot := m.Snapshot(); len(snapshot) != 2 || snapshot["a"] != 3 || snapshot["long"] != 1 {
+		t.Errorf("Map.Snapshot() = %v, want a and long only", snapshot)
+	}
+	if keys := m.Keys(); len(keys) != 2 || keys[0]+keys[1] != "along" && keys[0]+keys[1] != "longa" {
+		t.Errorf("Map.Keys() = %v, want a and long only", keys)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if v := m.Value("a"); v != 0 {
+		t.Errorf("Map.Value(a) = %d after the window, want 0", v)
+	}
+}
+
+// BenchmarkMap_Count and BenchmarkMap_CountEHC show that counting through a
+// Map allocates as much as through the EHC, as its keys are boxed all the
+// same.
+func BenchmarkMap_Count(b *testing.B) {
+	m := NewMap[int](10 * time.Millisecond)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.Count(1000 + i%10)
+	}
+}
+
+func BenchmarkMap_CountEHC(b *testing.B) {
+	e := NewEHC(10 * time.Millisecond)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.Count(1000 + i%10)
+	}
+}
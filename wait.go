@@ -0,0 +1,90 @@
+package ehc
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNeverAllowed is returned by WaitAllow when the key can never get
+	// below the limit, because the limit is below one or the key's
+	// outstanding reservations take it all.
+	ErrNeverAllowed = errors.New("ehc: key can never get below the limit")
+	// ErrClosed is returned by WaitAllow once the EHC is closed.
+	ErrClosed = errors.New("ehc: EHC is closed")
+)
+
+// WaitAllow blocks until the count of key is below limit and then counts
+// it, like the Wait of a token bucket rate limiter. Counting is atomic with
+// the check, as with Reserve, so concurrent waiters can never push the key
+// over the limit between them.
+//
+// It doesn't poll: it sleeps until the time WhenAllowed gives, when enough of
+// the key's increments are scheduled to expire, and tries again then, in
+// case the key was counted in the meantime. It returns the context's error
+// if the context ends first, ErrClosed if the EHC is closed, and
+// ErrNeverAllowed if waiting can't help. Blocked keys wait for their block to
+// run out, and keys exempted with BypassLimits never wait. Waiters don't
+// queue, so under contention any of them may go next; AdmissionQueue admits
+// them in order.
+func (e *EHC) WaitAllow(ctx context.Context, key interface{}, limit int64) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if r, ok := e.Reserve(key, 1, limit); ok {
+			r.Commit()
+			return nil
+		}
+		at, err := e.retryAt(key, limit)
+		if err != nil {
+			return err
+		}
+		if err := e.sleepUntil(ctx, at); err != nil {
+			return err
+		}
+	}
+}
+
+// retryAt returns when WaitAllow should try counting key again.
+func (e *EHC) retryAt(key interface{}, limit int64) (time.Time, error) {
+	at := e.WhenAllowed(key, 1, limit)
+
+	e.valueLock.RLock()
+	closed, w := e.closed, e.wheel
+	e.valueLock.RUnlock()
+
+	switch {
+	case closed:
+		return time.Time{}, ErrClosed
+	case at.IsZero():
+		return time.Time{}, ErrNeverAllowed
+	}
+	if now := e.clock.Now(); !at.After(now) {
+		// the retractions it counts on are due, but a wheel, of the
+		// EHC or of its global total, only fires them on its next tick
+		tick := defaultWheelTick
+		if w != nil {
+			tick = w.tick
+		}
+		return roundUp(now.Add(1), tick), nil
+	}
+	return at, nil
+}
+
+// sleepUntil waits on the EHC's clock until at, the end of ctx, or Close,
+// whichever comes first.
+func (e *EHC) sleepUntil(ctx context.Context, at time.Time) error {
+	t := e.clock.NewTimer(at.Sub(e.clock.Now()))
+	defer t.Stop()
+
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-e.done:
+		return ErrClosed
+	}
+}
@@ -0,0 +1,102 @@
+package ehc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEHC_WaitAllow(t *testing.T) {
+	const window = 100 * time.Millisecond
+	for name, opts := range map[string][]Option{
+		"timer":   nil,
+		"buckets": {WithBuckets(10)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := NewEHC(window, opts...)
+			e.CountMultiple("k", 2)
+
+			start := time.Now()
+			if err := e.WaitAllow(context.Background(), "k", 2); err != nil {
+				t.Fatalf("EHC.WaitAllow() = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed < window/2 || elapsed > 2*window {
+				t.Errorf("EHC.WaitAllow() took %v, want about %v", elapsed, window)
+			}
+			if v := e.value("k"); v > 2 {
+				t.Errorf("EHC count of k = %d after WaitAllow, want at most 2", v)
+			}
+
+			// under the limit, it counts straight away
+			e.Count("other")
+			start = time.Now()
+			if err := e.WaitAllow(context.Background(), "other", 2); err != nil {
+				t.Fatalf("EHC.WaitAllow(other) = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > window/2 {
+				t.Errorf("EHC.WaitAllow(other) took %v, want no wait", elapsed)
+			}
+			if v := e.value("other"); v != 2 {
+				t.Errorf("EHC count of other = %d, want 2", v)
+			}
+		})
+	}
+}
+
+func TestEHC_WaitAllowConcurrent(t *testing.T) {
+	const window = 50 * time.Millisecond
+	e := NewEHC(window)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 9; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := e.WaitAllow(context.Background(), "k", 3); err != nil {
+				t.Errorf("EHC.WaitAllow() = %v", err)
+			}
+			if v := e.value("k"); v > 3 {
+				t.Errorf("EHC count of k = %d, want at most 3", v)
+			}
+		}()
+	}
+	start := time.Now()
+	wg.Wait()
+	// three rounds of three, each waiting for the previous to expire
+	if elapsed := time.Since(start); elapsed < 2*window {
+		t.Errorf("9 waiters with a limit of 3 took %v, want at least %v", elapsed, 2*window)
+	}
+}
+
+func TestEHC_WaitAllowErrors(t *testing.T) {
+	e := NewEHC(time.Minute)
+	e.Count("k")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := e.WaitAllow(ctx, "k", 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("EHC.WaitAllow() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if v := e.value("k"); v != 1 {
+		t.Errorf("EHC count of k = %d after a failed wait, want 1", v)
+	}
+
+	if err := e.WaitAllow(context.Background(), "k", 0); err != ErrNeverAllowed {
+		t.Errorf("EHC.WaitAllow(limit 0) = %v, want %v", err, ErrNeverAllowed)
+	}
+
+	errc := make(chan error)
+	go func() {
+		errc <- e.WaitAllow(context.Background(), "k", 1)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	e.Close()
+	if err := <-errc; err != ErrClosed {
+		t.Errorf("EHC.WaitAllow() = %v when closed, want %v", err, ErrClosed)
+	}
+	if err := e.WaitAllow(context.Background(), "k", 1); err != ErrClosed {
+		t.Errorf("EHC.WaitAllow() = %v after Close, want %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,251 @@
+package ehc
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultWheelTick is the wheel resolution used unless WithWheelResolution
+// says otherwise.
+const defaultWheelTick = time.Millisecond
+
+// WithWheelResolution sets the tick of the timing wheel that retracts
+// increments in the default timer mode. Expirations are scheduled on the
+// wheel rather than with a runtime timer each, and deadlines are rounded up
+// to a multiple of d so that each tick's retractions fire as one batch, and
+// increments of a key within a tick share one. A coarser tick means fewer
+// wakeups and less memory for the same load, at the cost of counts lingering
+// up to d past the window. It defaults to one millisecond.
+func WithWheelResolution(d time.Duration) Option {
+	return func(c *config) {
+		c.wheelTick = d
+	}
+}
+
+const (
+	// wheelBits is the number of bits of a tick number each level of the
+	// wheel covers, so that each level has 1<<wheelBits slots.
+	wheelBits  = 6
+	wheelSlots = 1 << wheelBits
+	// wheelLevels is enough levels to cover any tick number.
+	wheelLevels = (63 + wheelBits - 1) / wheelBits
+)
+
+// wheel is a hierarchical timing wheel scheduling retractions in batches of
+// one tick. Level l holds the retractions due in the current block of
+// wheelSlots<<(wheelBits*l) ticks, but not in the current block of the level
+// below, in the slot of their tick number's l-th group of wheelBits bits.
+// Scheduling is O(1), and a single clock timer is armed for the earliest
+// occupied slot, so an idle wheel costs nothing.
+type wheel struct {
+	// armed and pending are kept first so that their 64-bit atomics
+	// stay aligned on 32-bit platforms.
+	//
+	// armed is the tick number the timer is set for, or -1 if it isn't.
+	armed int64
+	// pending is the number of retractions in the levels. It is
+	// changed under mu, but atomically, so that it can be read without.
+	pending int64
+
+	tick  time.Duration
+	clock Clock
+
+	mu sync.Mutex
+	// cur is the tick number up to which retractions have been fired.
+	cur    int64
+	levels [wheelLevels]*wheelLevel
+	timer  Timer
+
+	// stats, if set, records the sweeps of the wheel's retractions.
+	stats *stats
+	// onSweep, if set, is called before every sweep.
+	onSweep func()
+}
+
+// wheelLevel is one level of a wheel.
+type wheelLevel struct {
+	// occupied has bit i set if slots[i] is non-empty.
+	occupied uint64
+	slots    [wheelSlots][]*retraction
+}
+
+func newWheel(tick time.Duration, clk Clock) *wheel {
+	if tick <= 0 {
+		tick = defaultWheelTick
+	}
+	return &wheel{
+		tick:  tick,
+		clock: clk,
+		cur:   clk.Now().UnixNano() / int64(tick),
+		armed: -1,
+	}
+}
+
+// tickOf returns the number of the first tick at or after t.
+func (w *wheel) tickOf(t time.Time) int64 {
+	return roundUp(t, w.tick).UnixNano() / int64(w.tick)
+}
+
+// schedule arranges for r.counter to retract r once r.deadline has passed.
+func (w *wheel) schedule(r *retraction) {
+	at := w.tickOf(r.deadline)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if at <= w.cur {
+		// already due; the next firing picks it up
+		at = w.cur + 1
+	}
+	w.insertLocked(r, at)
+	atomic.AddInt64(&w.pending, 1)
+	if w.armed < 0 || at < w.armed {
+		w.armLocked(at)
+	}
+}
+
+// insertLocked places r, due at tick at, which must be after w.cur, in the
+// slot for it.
+func (w *wheel) insertLocked(r *retraction, at int64) {
+	r.tick = at
+	level := (bits.Len64(uint64(at^w.cur)) - 1) / wheelBits
+	slot := at >> (wheelBits * level) & (wheelSlots - 1)
+
+	l := w.levels[level]
+	if l == nil {
+		l = &wheelLevel{}
+		w.levels[level] = l
+	}
+	r.level = uint8(level)
+	r.index = int32(len(l.slots[slot]))
+	l.slots[slot] = append(l.slots[slot], r)
+	l.occupied |= 1 << slot
+}
+
+// cancel takes r, which must be done, off the wheel, so that superseded
+// retractions neither take up memory nor count as scheduled until they
+// would have fired. Retractions already taken off to fire are left alone.
+func (w *wheel) cancel(r *retraction) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if r.index < 0 {
+		return
+	}
+
+	// the slot r went into, at its level, is where it still is, as
+	// retractions only move down when their slot is reached
+	l := w.levels[r.level]
+	slot := r.tick >> (wheelBits * int(r.level)) & (wheelSlots - 1)
+	rs := l.slots[slot]
+	last := rs[len(rs)-1]
+	rs[r.index], last.index = last, r.index
+	rs[len(rs)-1] = nil
+	l.slots[slot] = rs[:len(rs)-1]
+	if len(rs) == 1 {
+		l.slots[slot] = nil
+		l.occupied &^= 1 << slot
+	}
+	r.index = -1
+	atomic.AddInt64(&w.pending, -1)
+}
+
+// armLocked sets the timer to fire at tick at.
+func (w *wheel) armLocked(at int64) {
+	w.armed = at
+	d := time.Unix(0, at*int64(w.tick)).Sub(w.clock.Now())
+	if w.timer == nil {
+		w.timer = w.clock.AfterFunc(d, w.fire)
+	} else {
+		w.timer.Reset(d)
+	}
+}
+
+// fire retracts everything that has come due and rearms the timer.
+func (w *wheel) fire() {
+	// a retraction due at a tick has its deadline at or before it
+	now := w.clock.Now().UnixNano() / int64(w.tick)
+
+	w.mu.Lock()
+	due := w.advanceLocked(now)
+	w.armed = -1
+	if next, _, ok := w.nextLocked(); ok {
+		w.armLocked(next)
+	}
+	atomic.AddInt64(&w.pending, -int64(len(due)))
+	w.mu.Unlock()
+
+	if w.onSweep != nil {
+		w.onSweep()
+	}
+	start := time.Now()
+	for _, r := range due {
+		r.counter.retract(r)
+	}
+	if w.stats != nil {
+		atomic.AddInt64(&w.stats.sweeps, 1)
+		atomic.AddInt64(&w.stats.sweepNanos, int64(time.Since(start)))
+	}
+}
+
+// scheduled returns the number of retractions waiting to fire.
+func (w *wheel) scheduled() int64 {
+	return atomic.LoadInt64(&w.pending)
+}
+
+// advanceLocked moves w.cur forward to tick now, returning the retractions
+// due by then.
+func (w *wheel) advanceLocked(now int64) []*retraction {
+	var due []*retraction
+	for {
+		next, level, ok := w.nextLocked()
+		if !ok || next > now {
+			if now > w.cur {
+				w.cur = now
+			}
+			return due
+		}
+
+		// every level below the earliest occupied one is empty, so
+		// moving to the start of its slot loses nothing; the slot's
+		// retractions are then either due or belong further down
+		l := w.levels[level]
+		slot := next >> (wheelBits * level) & (wheelSlots - 1)
+		rs := l.slots[slot]
+		l.slots[slot] = nil
+		l.occupied &^= 1 << slot
+
+		w.cur = next
+		for _, r := range rs {
+			if r.tick <= next {
+				r.index = -1
+				due = append(due, r)
+			} else {
+				w.insertLocked(r, r.tick)
+			}
+		}
+	}
+}
+
+// nextLocked returns the start of the earliest occupied slot, if any, which
+// is no later than any retraction in it, and its level.
+func (w *wheel) nextLocked() (next int64, level int, ok bool) {
+	for level, l := range w.levels {
+		if l == nil || l.occupied == 0 {
+			continue
+		}
+		shift := uint(wheelBits * level)
+		digit := w.cur >> shift & (wheelSlots - 1)
+		// slots at or before the current digit are empty, as their
+		// retractions would already have come due or moved down
+		later := l.occupied &^ (1<<(digit+1) - 1)
+		if later == 0 {
+			continue
+		}
+		slot := int64(bits.TrailingZeros64(later))
+		block := w.cur >> (shift + wheelBits) << (shift + wheelBits)
+		return block | slot<<shift, level, true
+	}
+	return 0, 0, false
+}
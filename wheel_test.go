@@ -0,0 +1,103 @@
+package ehc
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestWheel_Model(t *testing.T) {
+	const window = 10 * time.Hour
+	e := NewManualEHC(window, time.Unix(0, 0))
+	rng := rand.New(rand.NewSource(1))
+
+	type inc struct {
+		key      int
+		deadline time.Time
+	}
+	var live []inc
+	for step := 0; step < 2000; step++ {
+		key := rng.Intn(4)
+		e.Count(key)
+		live = append(live, inc{key, e.Now().Add(window)})
+
+		// steps from a millisecond to over an hour cross every level
+		// the window reaches
+		e.Tick(time.Duration(rng.Int63n(int64(time.Duration(1) << uint(rng.Intn(43))))))
+		kept := live[:0]
+		want := map[int]int64{}
+		for _, i := range live {
+			if i.deadline.After(e.Now()) {
+				kept = append(kept, i)
+				want[i.key]++
+			}
+		}
+		live = kept
+
+		for key := 0; key < 4; key++ {
+			if v := e.value(key); v != want[key] {
+				t.Fatalf("step %d at %v: count of %d = %d, want %d", step, e.Now().Sub(time.Unix(0, 0)), key, v, want[key])
+			}
+		}
+	}
+}
+
+func TestWheel_Resolution(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithWheelResolution(time.Second))
+	e.Tick(100 * time.Millisecond)
+	e.Count("k")
+	e.Tick(500 * time.Millisecond)
+	e.Count("k")
+
+	// both increments are due by the end of the same second
+	e.Tick(time.Minute - 600*time.Millisecond + 999*time.Millisecond)
+	if v := e.value("k"); v != 2 {
+		t.Errorf("count = %d just before the tick ends, want 2", v)
+	}
+	e.Tick(time.Millisecond)
+	if v := e.value("k"); v != 0 {
+		t.Errorf("count = %d at the end of the tick, want 0", v)
+	}
+}
+
+func TestWheel_Cancel(t *testing.T) {
+	const window = 10 * time.Hour
+	e := NewManualEHC(window, time.Unix(0, 0), WithTouchOnGet())
+	rng := rand.New(rand.NewSource(1))
+
+	// deadlines of each key's live increments
+	live := map[int][]time.Time{}
+	for step := 0; step < 2000; step++ {
+		key := rng.Intn(4)
+		if rng.Intn(2) == 0 {
+			e.Count(key)
+			live[key] = append(live[key], e.Now().Add(window))
+		} else {
+			// reading moves the key's retractions, wherever they are
+			// on the wheel, to a window from now
+			e.Get(key)
+			for i := range live[key] {
+				live[key][i] = e.Now().Add(window)
+			}
+		}
+
+		e.Tick(time.Duration(rng.Int63n(int64(time.Duration(1) << uint(rng.Intn(43))))))
+		var total int64
+		for key := 0; key < 4; key++ {
+			kept := live[key][:0]
+			for _, d := range live[key] {
+				if d.After(e.Now()) {
+					kept = append(kept, d)
+				}
+			}
+			live[key] = kept
+			total += int64(len(kept))
+			if v := e.value(key); v != int64(len(kept)) {
+				t.Fatalf("step %d: count of %d = %d, want %d", step, key, v, len(kept))
+			}
+		}
+		if n := e.wheel.scheduled(); n > total {
+			t.Fatalf("step %d: %d retractions scheduled for %d live increments", step, n, total)
+		}
+	}
+}
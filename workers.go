@@ -0,0 +1,106 @@
+package ehc
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// WithCallbackWorkers runs callbacks, such as those registered with
+// OnWindowComplete and OnWindowCompleteAck, on up to n goroutines fed by a
+// queue of up to queue deliveries, rather than on the timers that trigger
+// them, so a slow callback can't hold up those timers. Each delivery is the
+// totals of one window, or a redelivery to one sink. When the queue is full,
+// deliveries are dropped and counted in Stats.CallbacksDropped.
+//
+// With more than one worker, callbacks may run concurrently and windows may
+// be delivered out of order; a single worker keeps them in order. Workers
+// only run while there is something queued.
+func WithCallbackWorkers(n, queue int) Option {
+	if queue < 1 {
+		queue = 1
+	}
+	return func(c *config) {
+		c.callbackWorkers = n
+		c.callbackQueue = queue
+	}
+}
+
+// storeCallbackPool installs the callback pool as configured, keeping the
+// current one across migrations that don't resize it.
+func (e *EHC) storeCallbackPool() {
+	pool, _ := e.workers.Load().(*callbackPool)
+	switch {
+	case e.callbackWorkers <= 0:
+		pool = nil
+	case pool == nil || pool.workers != e.callbackWorkers || cap(pool.jobs) != e.callbackQueue:
+		pool = newCallbackPool(e.callbackWorkers, e.callbackQueue, &e.stats.callbacksDropped)
+	}
+	e.workers.Store(pool)
+}
+
+// submitCallback runs job, which calls user callbacks, on the callback
+// pool, or directly if there is none.
+func (e *EHC) submitCallback(job func()) {
+	if pool, _ := e.workers.Load().(*callbackPool); pool != nil {
+		pool.submit(job)
+		return
+	}
+	job()
+}
+
+// callbackPool runs jobs on a bounded number of goroutines, which exit when
+// the queue is empty so that an idle EHC needs no goroutines.
+type callbackPool struct {
+	workers int
+	jobs    chan func()
+	dropped *int64
+
+	mu      sync.Mutex
+	running int
+}
+
+func newCallbackPool(workers, queue int, dropped *int64) *callbackPool {
+	return &callbackPool{
+		workers: workers,
+		jobs:    make(chan func(), queue),
+		dropped: dropped,
+	}
+}
+
+// submit queues job, or drops it if the queue is full.
+func (p *callbackPool) submit(job func()) {
+	select {
+	case p.jobs <- job:
+	default:
+		atomic.AddInt64(p.dropped, 1)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running < p.workers {
+		p.running++
+		go p.work()
+	}
+}
+
+func (p *callbackPool) work() {
+	for {
+		select {
+		case job := <-p.jobs:
+			job()
+			continue
+		default:
+		}
+
+		// submit queues before checking running, so a job queued
+		// after this check gets a worker of its own
+		p.mu.Lock()
+		if len(p.jobs) == 0 {
+			p.running--
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+	}
+}
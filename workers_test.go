@@ -0,0 +1,68 @@
+package ehc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEHC_CallbackWorkers(t *testing.T) {
+	e := NewManualEHC(time.Minute, time.Unix(0, 0), WithCallbackWorkers(1, 1))
+
+	started := make(chan int64)
+	release := make(chan struct{})
+	e.OnWindowComplete(func(key interface{}, count int64, start, end time.Time) {
+		started <- count
+		<-release
+	})
+
+	// the first window's delivery blocks the only worker, the second
+	// waits in the queue and the third is dropped, without holding up
+	// the clock
+	for i := int64(1); i <= 3; i++ {
+		e.CountMultiple("a", i)
+		e.Tick(time.Minute)
+		if i == 1 {
+			if n := <-started; n != 1 {
+				t.Fatalf("first delivery got %d, want 1", n)
+			}
+		}
+	}
+	if n := e.Stats().CallbacksDropped; n != 1 {
+		t.Errorf("Stats.CallbacksDropped = %d, want 1", n)
+	}
+
+	release <- struct{}{}
+	if n := <-started; n != 2 {
+		t.Errorf("second delivery got %d, want 2", n)
+	}
+	close(release)
+}
+
+func TestCallbackPool_Idle(t *testing.T) {
+	var dropped int64
+	p := newCallbackPool(4, 100, &dropped)
+	done := make(chan struct{}, 100)
+	for i := 0; i < 100; i++ {
+		p.submit(func() { done <- struct{}{} })
+	}
+	for i := 0; i < 100; i++ {
+		<-done
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		p.mu.Lock()
+		running := p.running
+		p.mu.Unlock()
+		if running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d workers still running with nothing queued", running)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if dropped != 0 {
+		t.Errorf("dropped %d jobs, want 0", dropped)
+	}
+}